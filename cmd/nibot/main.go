@@ -117,6 +117,34 @@ func main() {
 		log.Fatalf("Failed to initialize workspace: %v", err)
 	}
 
+	// nibot config lint：检查 data/ 下的配置文件，有 error 时以非零状态退出
+	if args := flag.Args(); len(args) >= 2 && args[0] == "config" && args[1] == "lint" {
+		issues := agent.LintConfig(workspace)
+		for _, is := range issues {
+			fmt.Println(is.String())
+		}
+		if len(issues) == 0 {
+			fmt.Println("✅ 配置检查通过")
+		}
+		if agent.ConfigIssuesHaveErrors(issues) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// nibot mcp-serve：以 stdio MCP 服务器运行，stdout 只能输出协议消息
+	if args := flag.Args(); len(args) > 0 && args[0] == "mcp-serve" {
+		if err := agent.EnsureConfig(workspace, false, os.Stderr); err != nil {
+			log.Fatalf("Failed to initialize config: %v", err)
+		}
+		cfg := agent.LoadConfig(workspace)
+		log.Printf("Serving MCP over stdio (workspace: %s)", workspace)
+		if err := agent.ServeMCP(workspace, cfg.Policy, os.Stdin, os.Stdout); err != nil {
+			log.Fatalf("MCP server stopped: %v", err)
+		}
+		return
+	}
+
 	// Initialize health monitor
 	healthPort := 0
 	if portStr, ok := os.LookupEnv("NIBOT_HEALTH_PORT"); ok {
@@ -126,6 +154,7 @@ func main() {
 	}
 	healthMonitor := agent.NewHealthMonitor(healthPort)
 	defer healthMonitor.Shutdown()
+	defer agent.CloseMCPServers()
 
 	interactive := stdinIsTerminal() && len(cmds) == 0
 	if err := agent.EnsureConfig(workspace, interactive, os.Stdout); err != nil {
@@ -133,6 +162,11 @@ func main() {
 	}
 
 	cfg := agent.LoadConfig(workspace)
+	for _, is := range agent.LintConfig(workspace) {
+		if is.Level != "hint" {
+			log.Printf("Config %s (run `nibot config lint` for details)", is)
+		}
+	}
 	log.Printf("Loaded Config: Provider=%s, Model=%s, LogLevel=%s", cfg.Provider, cfg.ModelName, cfg.LogLevel)
 	if cfg.APIKey == "" && cfg.Provider != "ollama" {
 		log.Printf("Warning: No API Key provided for %s", cfg.Provider)
@@ -213,6 +247,11 @@ func main() {
 		time.Sleep(2 * time.Second)
 	}
 
+	// 定时任务在各渠道注册好发送方式之后再启动；一次性执行命令时不启动
+	if len(cmds) == 0 {
+		agent.StartScheduler(workspace, cfg, systemPrompt)
+	}
+
 	if len(cmds) > 0 {
 		var b bytes.Buffer
 		for _, c := range cmds {
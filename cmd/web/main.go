@@ -1,6 +1,9 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -40,6 +43,80 @@ type ChatResponse struct {
 	Type      string `json:"type"`
 	Content   string `json:"content"`
 	Timestamp string `json:"timestamp"`
+	// Approval 仅在 Type 为 "approval" 时出现：需要用户在弹窗中确认的工具调用
+	Approval *agent.ApprovalRequest `json:"approval,omitempty"`
+}
+
+// wsPeer 是某个会话的 WebSocket 连接；审批请求和回复可能在不同时刻写入，需要加锁。
+type wsPeer struct {
+	conn *websocket.Conn
+	mu   sync.Mutex
+}
+
+func (p *wsPeer) write(v any) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.conn.WriteJSON(v)
+}
+
+// wsPeers 按浏览器令牌（webToken）记录 WebSocket 连接。
+var (
+	wsPeersMutex sync.Mutex
+	wsPeers      = make(map[string]*wsPeer)
+)
+
+// webInbox 暂存浏览器未连接时送达的定时任务结果（按 webTarget），下次 WebSocket 连接时发送。
+var webInbox = make(map[string][]ChatResponse)
+
+// webTarget 是浏览器在 Origin 和定时任务中的标识：令牌的哈希，令牌本身不写进数据库和模型可见的输出。
+func webTarget(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
+// deliverWebSchedule 把定时任务结果推送给创建它的浏览器；浏览器未连接时暂存，连接后发送。
+func deliverWebSchedule(target, text string) error {
+	msg := ChatResponse{Type: "schedule", Content: text, Timestamp: time.Now().Format(time.RFC3339)}
+	wsPeersMutex.Lock()
+	defer wsPeersMutex.Unlock()
+	for token, peer := range wsPeers {
+		if webTarget(token) == target {
+			return peer.write(msg)
+		}
+	}
+	webInbox[target] = append(webInbox[target], msg)
+	log.Printf("Scheduled result for web:%s queued until the browser connects", target)
+	return nil
+}
+
+type ApprovalDecisionRequest struct {
+	ID       string `json:"id"`
+	Decision string `json:"decision"`
+}
+
+const webTokenCookie = "nibot_web_token"
+
+// webToken 返回浏览器的令牌：服务端生成，放在 HttpOnly Cookie 中，没有时签发一个（Set-Cookie 写入 h）。
+// 审批只认这个令牌；页面传来的 session_id 由客户端自己决定，任何人都可以冒用。
+func webToken(h http.Header, r *http.Request) string {
+	if c, err := r.Cookie(webTokenCookie); err == nil && len(c.Value) == 32 {
+		if _, err := hex.DecodeString(c.Value); err == nil {
+			return c.Value
+		}
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	token := hex.EncodeToString(b)
+	h.Add("Set-Cookie", (&http.Cookie{
+		Name:     webTokenCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}).String())
+	return token
 }
 
 type SkillStatus struct {
@@ -94,7 +171,13 @@ func main() {
 	log.Printf("   Provider: %s, Model: %s", globalConfig.Provider, globalConfig.ModelName)
 	configMutex.RUnlock()
 
+	agent.RegisterScheduleDeliverer("web", deliverWebSchedule)
 	agent.StartWeeklyLearning(workspace, policy)
+	if systemPrompt, err := agent.ConstructSystemPrompt(workspace); err == nil {
+		configMutex.RLock()
+		agent.StartScheduler(workspace, globalConfig, systemPrompt)
+		configMutex.RUnlock()
+	}
 
 	// 设置静态文件服务
 	fs := http.FileServer(http.Dir("./web/static"))
@@ -105,8 +188,11 @@ func main() {
 	http.HandleFunc("/api/config", configHandler)
 	http.HandleFunc("/api/skills", skillsHandler)
 	http.HandleFunc("/api/skills/toggle", skillToggleHandler)
+	http.HandleFunc("/api/artifacts/", artifactHandler)
+	http.HandleFunc("/api/approvals", approvalsHandler)
 	http.HandleFunc("/ws", websocketHandler)
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		webToken(w.Header(), r)
 		http.ServeFile(w, r, "./web/templates/index.html")
 	})
 
@@ -134,7 +220,7 @@ func chatHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 处理用户消息
-	response := processMessage(req.Message, req.SessionID)
+	response := processMessage(req.Message, req.SessionID, webToken(w.Header(), r))
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
@@ -183,7 +269,10 @@ func configHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Update Policy
+		// Update Policy（界面不编辑 [[rules]]，没有传回时保留原有规则）
+		if newCfg.Policy.Rules == nil {
+			newCfg.Policy.Rules = globalConfig.Policy.Rules
+		}
 		if err := agent.SaveToolPolicy(workspace, newCfg.Policy); err != nil {
 			configMutex.Unlock()
 			http.Error(w, "Failed to save policy: "+err.Error(), http.StatusInternalServerError)
@@ -323,36 +412,144 @@ func skillToggleHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
 
+// artifactHandler 提供工具产物下载：/api/artifacts/<id>/<file>
+func artifactHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cwd, _ := os.Getwd()
+	workspace := filepath.Join(cwd, "workspace")
+
+	rest := strings.TrimPrefix(r.URL.Path, "/api/artifacts/")
+	id, name, ok := strings.Cut(rest, "/")
+	if !ok {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	path, err := agent.ArtifactPath(workspace, id, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(path)))
+	http.ServeFile(w, r, path)
+}
+
 func websocketHandler(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	header := http.Header{}
+	token := webToken(header, r)
+	conn, err := upgrader.Upgrade(w, r, header)
 	if err != nil {
 		log.Printf("WebSocket upgrade failed: %v", err)
 		return
 	}
 	defer conn.Close()
 
+	peer := &wsPeer{conn: conn}
+	wsPeersMutex.Lock()
+	wsPeers[token] = peer
+	queued := webInbox[webTarget(token)]
+	delete(webInbox, webTarget(token))
+	wsPeersMutex.Unlock()
+	for _, msg := range queued {
+		if err := peer.write(msg); err != nil {
+			log.Printf("Failed to send a queued scheduled result: %v", err)
+		}
+	}
+	defer func() {
+		wsPeersMutex.Lock()
+		if wsPeers[token] == peer {
+			delete(wsPeers, token)
+		}
+		wsPeersMutex.Unlock()
+	}()
+
 	for {
 		var msg ChatRequest
 		err := conn.ReadJSON(&msg)
 		if err != nil {
 			break
 		}
-
 		// 实时处理消息
-		response := processMessage(msg.Message, msg.SessionID)
+		response := processMessage(msg.Message, msg.SessionID, token)
 
-		if err := conn.WriteJSON(response); err != nil {
+		if err := peer.write(response); err != nil {
 			break
 		}
 	}
 }
 
-func processMessage(message, sessionID string) ChatResponse {
+// approvalsHandler：GET 列出本浏览器等待中的审批（HTTP 模式下页面轮询）；
+// POST {"id","decision"} 对审批做出决定（allow / session / deny）。两者都只认 webToken。
+func approvalsHandler(w http.ResponseWriter, r *http.Request) {
+	c, err := r.Cookie(webTokenCookie)
+	if err != nil || c.Value == "" {
+		http.Error(w, "missing session cookie, reload the page", http.StatusUnauthorized)
+		return
+	}
+	token := c.Value
+	switch r.Method {
+	case "GET":
+		pending := agent.PendingApprovals(token)
+		if pending == nil {
+			pending = []agent.ApprovalRequest{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pending)
+	case "POST":
+		var req ApprovalDecisionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if _, err := agent.ResolveApproval(req.ID, req.Decision, token); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// webApprover 通过 WebSocket 推送审批请求，页面弹窗确认后调用 /api/approvals；
+// 没有 WebSocket 连接时（HTTP 模式）页面在等待回复期间轮询 /api/approvals，
+// 推送失败时同样只能等轮询，超时仍未确认则按拒绝处理。
+func webApprover(client *agent.LLMClient, token string) *agent.RemoteApprover {
+	return &agent.RemoteApprover{
+		Owner:   token,
+		Session: client.ExecSession(),
+		Send: func(req agent.ApprovalRequest) error {
+			wsPeersMutex.Lock()
+			peer := wsPeers[token]
+			wsPeersMutex.Unlock()
+			if peer == nil {
+				log.Printf("Approval %s for %s: no WebSocket for this browser, waiting for /api/approvals polling", req.ID, req.Tool)
+				return nil
+			}
+			if err := peer.write(ChatResponse{Type: "approval", Approval: &req, Timestamp: time.Now().Format(time.RFC3339)}); err != nil {
+				log.Printf("Approval %s for %s: push failed (%v), waiting for /api/approvals polling", req.ID, req.Tool, err)
+			}
+			return nil
+		},
+	}
+}
+
+// processMessage 处理一条消息；会话按浏览器令牌和 session_id 区分，别的浏览器猜到 session_id 也拿不到这个会话。
+func processMessage(message, sessionID, token string) ChatResponse {
+	key := token + "/" + sessionID
 	cwd, _ := os.Getwd()
 	workspace := filepath.Join(cwd, "workspace")
 
 	sessionsMutex.Lock()
-	client, ok := sessions[sessionID]
+	client, ok := sessions[key]
 	if !ok {
 		systemPrompt, err := agent.ConstructSystemPrompt(workspace)
 		if err != nil {
@@ -365,7 +562,7 @@ func processMessage(message, sessionID string) ChatResponse {
 		configMutex.RUnlock()
 
 		client = agent.NewLLMClient(cfg, workspace, systemPrompt, sessionManager)
-		sessions[sessionID] = client
+		sessions[key] = client
 	}
 	sessionsMutex.Unlock()
 
@@ -373,6 +570,15 @@ func processMessage(message, sessionID string) ChatResponse {
 	configMutex.RLock()
 	client.Config = globalConfig
 	configMutex.RUnlock()
+	// 没有令牌时无法确定由谁审批，需要审批的调用被拒绝
+	if token != "" {
+		client.Approver = webApprover(client, token)
+		// Web 是单独的渠道：policy.toml 中 channel = "cli" 的规则不作用于网页，
+		// 定时任务只归创建它的浏览器所有，结果也推送回这个浏览器
+		client.Origin = "web:" + webTarget(token)
+	} else {
+		client.Origin = "web:anonymous"
+	}
 
 	// Execute Chat
 	responseContent, err := client.Chat(message)
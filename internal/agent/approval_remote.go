@@ -0,0 +1,198 @@
+package agent
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 远程审批：Telegram、飞书和 Web 界面没有终端可以输入 y/n。RemoteApprover 把审批请求交给渠道发出
+// （Telegram 按钮、飞书卡片、Web 弹窗），当前这一轮对话阻塞等待，直到用户点了按钮（ResolveApproval）
+// 或超时（NIBOT_APPROVAL_TIMEOUT_SECONDS，默认 300 秒，超时按拒绝处理）。
+// 没有配置审批方式的对话（Approver 为 nil），需要审批的调用一律拒绝，见 ExecuteCalls。
+
+// ApprovalRequest 是一次等待远程确认的审批。
+type ApprovalRequest struct {
+	ID   string `json:"id"`
+	Tool string `json:"tool"`
+	// Args 是脱敏、截断后的参数预览
+	Args    string    `json:"args"`
+	Expires time.Time `json:"expires"`
+	// Owner 是唯一可以做出决定的人（Telegram / 飞书用户 ID，或 Web 服务端签发的会话令牌）
+	Owner string `json:"-"`
+}
+
+// 远程审批的决定：允许一次、本次对话中该工具不再询问、拒绝。
+const (
+	ApprovalAllow   = "allow"
+	ApprovalSession = "session"
+	ApprovalDeny    = "deny"
+)
+
+// RemoteApprover 实现 Approver：通过 Send 发出审批请求并等待决定。
+type RemoteApprover struct {
+	// Send 发出审批请求；返回错误时按拒绝处理。
+	Send func(ApprovalRequest) error
+	// Owner 见 ApprovalRequest.Owner
+	Owner string
+	// Session 是对话的 ExecContext.Session（LLMClient.ExecSession），用于“本次对话允许”
+	Session string
+	// Timeout 为 0 时使用 NIBOT_APPROVAL_TIMEOUT_SECONDS
+	Timeout  time.Duration
+	Logger   *os.File
+	LogLevel string
+}
+
+func approvalTimeout() time.Duration {
+	return time.Duration(parseIntEnv("NIBOT_APPROVAL_TIMEOUT_SECONDS", 300, 10, 24*3600)) * time.Second
+}
+
+func (a *RemoteApprover) Approve(call ExecCall) bool {
+	timeout := a.Timeout
+	if timeout <= 0 {
+		timeout = approvalTimeout()
+	}
+	req := ApprovalRequest{
+		ID:      newApprovalID(),
+		Tool:    call.Tool,
+		Args:    redactSecrets(previewArgs(call.ArgsRaw)),
+		Expires: time.Now().Add(timeout),
+		Owner:   a.Owner,
+	}
+	done := defaultApprovalBroker.add(req)
+	defer defaultApprovalBroker.remove(req.ID)
+
+	if a.Send != nil {
+		if err := a.Send(req); err != nil {
+			log.Printf("approval request for %s could not be sent: %v", call.Tool, err)
+			writeAuditAutoApproval(a.Logger, a.LogLevel, call, false, "approval request could not be sent")
+			return false
+		}
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case decision := <-done:
+		if decision == ApprovalSession {
+			allowToolForSession(a.Session, call.Tool)
+		}
+		approved := decision == ApprovalAllow || decision == ApprovalSession
+		writeAuditApproval(a.Logger, a.LogLevel, call, approved)
+		return approved
+	case <-timer.C:
+		writeAuditAutoApproval(a.Logger, a.LogLevel, call, false, fmt.Sprintf("no answer within %s", timeout))
+		return false
+	}
+}
+
+func newApprovalID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+type pendingApproval struct {
+	req  ApprovalRequest
+	done chan string
+}
+
+type approvalBroker struct {
+	mu      sync.Mutex
+	pending map[string]*pendingApproval
+}
+
+var defaultApprovalBroker = &approvalBroker{pending: map[string]*pendingApproval{}}
+
+func (b *approvalBroker) add(req ApprovalRequest) <-chan string {
+	p := &pendingApproval{req: req, done: make(chan string, 1)}
+	b.mu.Lock()
+	b.pending[req.ID] = p
+	b.mu.Unlock()
+	return p.done
+}
+
+func (b *approvalBroker) remove(id string) {
+	b.mu.Lock()
+	delete(b.pending, id)
+	b.mu.Unlock()
+}
+
+// ResolveApproval 对等待中的审批做出决定；by 必须是请求的 Owner。
+func ResolveApproval(id, decision, by string) (ApprovalRequest, error) {
+	switch decision {
+	case ApprovalAllow, ApprovalSession, ApprovalDeny:
+	default:
+		return ApprovalRequest{}, fmt.Errorf("decision must be allow, session or deny, got %q", decision)
+	}
+	b := defaultApprovalBroker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p, ok := b.pending[strings.TrimSpace(id)]
+	if !ok {
+		return ApprovalRequest{}, fmt.Errorf("approval %s has expired or was already answered", id)
+	}
+	if p.req.Owner != "" && p.req.Owner != by {
+		return p.req, fmt.Errorf("only the user who started the conversation can answer this approval")
+	}
+	delete(b.pending, p.req.ID)
+	p.done <- decision
+	return p.req, nil
+}
+
+// PendingApprovals 返回 owner 等待中的审批，供无法推送的渠道（Web 的 HTTP 模式）轮询。
+func PendingApprovals(owner string) []ApprovalRequest {
+	b := defaultApprovalBroker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []ApprovalRequest
+	for _, p := range b.pending {
+		if p.req.Owner == owner {
+			out = append(out, p.req)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Expires.Before(out[j].Expires) })
+	return out
+}
+
+// approvalPromptText 是发给用户的审批请求正文（Telegram 消息）。
+func approvalPromptText(req ApprovalRequest) string {
+	text := "🔐 需要审批：" + req.Tool
+	if req.Args != "" {
+		text += "\n参数：" + req.Args
+	}
+	return text + fmt.Sprintf("\n%s 前未确认将自动拒绝。", req.Expires.Format("15:04:05"))
+}
+
+// approvalDecisionLabel 是审批完成后替换按钮的说明。
+func approvalDecisionLabel(req ApprovalRequest, decision string) string {
+	switch decision {
+	case ApprovalAllow:
+		return "✅ 已允许（仅此一次）"
+	case ApprovalSession:
+		return "✅ 已允许，本次会话中 " + req.Tool + " 不再询问"
+	default:
+		return "❌ 已拒绝"
+	}
+}
+
+// parseApprovalCallback 解析按钮回传的 "approval:<id>:<decision>"。
+func parseApprovalCallback(data string) (id, decision string, ok bool) {
+	rest, ok := strings.CutPrefix(data, "approval:")
+	if !ok {
+		return "", "", false
+	}
+	id, decision, ok = strings.Cut(rest, ":")
+	return id, decision, ok && id != ""
+}
+
+func approvalCallbackData(id, decision string) string {
+	return "approval:" + id + ":" + decision
+}
@@ -0,0 +1,125 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecuteCalls_NoApproverDeniesByDefault(t *testing.T) {
+	t.Setenv("NIBOT_AUTO_APPROVE", "")
+	t.Setenv("NIBOT_APPROVALS_FILE", filepath.Join(t.TempDir(), "approvals.json"))
+	ws := t.TempDir()
+	call := ExecCall{Tool: "fs.write", ArgsRaw: `{"path":"memory/a.md","content":"x"}`}
+	ctx := ExecContext{Workspace: ws, Policy: DefaultToolPolicy(), Origin: "telegram:1"}
+
+	res := ExecuteCalls(ctx, []ExecCall{call}, nil)
+	if res[0].OK || res[0].Error != "approval unavailable" || !strings.Contains(res[0].Reason, "require_approval_fs_write = true; nobody can approve") {
+		t.Fatalf("expected a denial without an approver, got %+v", res[0])
+	}
+	if out := formatToolResults(res); !strings.Contains(out, "  policy: require_approval_fs_write = true; nobody can approve") {
+		t.Fatalf("the model should see why the call was denied:\n%s", out)
+	}
+	if _, err := os.Stat(filepath.Join(ws, "memory", "a.md")); err == nil {
+		t.Fatalf("the file must not be written")
+	}
+
+	// approvals.json 中的记录和 NIBOT_AUTO_APPROVE 仍然生效
+	if _, err := AddApprovalGrant(ws, ApprovalGrant{Action: "allow", Tool: "fs.write", Key: "path", Pattern: "memory/*"}); err != nil {
+		t.Fatal(err)
+	}
+	if res := ExecuteCalls(ctx, []ExecCall{call}, nil); !res[0].OK {
+		t.Fatalf("expected a remembered approval, got %+v", res[0])
+	}
+	t.Setenv("NIBOT_AUTO_APPROVE", "true")
+	if res := ExecuteCalls(ctx, []ExecCall{{Tool: "fs.write", ArgsRaw: `{"path":"skills/b.md","content":"x"}`}}, nil); !res[0].OK {
+		t.Fatalf("expected NIBOT_AUTO_APPROVE to allow, got %+v", res[0])
+	}
+}
+
+func TestRemoteApprover_WaitsForOwner(t *testing.T) {
+	t.Setenv("NIBOT_AUTO_APPROVE", "")
+	ws := t.TempDir()
+	logPath := filepath.Join(ws, "audit.log")
+	logger, err := os.Create(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	sent := make(chan ApprovalRequest, 4)
+	approver := &RemoteApprover{
+		Owner:   "42",
+		Session: "tg_42",
+		Timeout: 5 * time.Second,
+		Logger:  logger,
+		Send: func(req ApprovalRequest) error {
+			sent <- req
+			return nil
+		},
+	}
+	go func() {
+		req := <-sent
+		if !strings.Contains(approvalPromptText(req), "🔐 需要审批：fs.write\n参数：") {
+			t.Errorf("unexpected prompt: %q", approvalPromptText(req))
+		}
+		if got := PendingApprovals("42"); len(got) != 1 || got[0].ID != req.ID {
+			t.Errorf("expected one pending approval, got %v", got)
+		}
+		if _, err := ResolveApproval(req.ID, ApprovalAllow, "7"); err == nil {
+			t.Errorf("another user must not answer the approval")
+		}
+		id, decision, ok := parseApprovalCallback(approvalCallbackData(req.ID, ApprovalSession))
+		if !ok {
+			t.Errorf("cannot parse callback data")
+		}
+		if _, err := ResolveApproval(id, decision, "42"); err != nil {
+			t.Errorf("resolve: %v", err)
+		}
+	}()
+
+	ctx := ExecContext{Workspace: ws, Policy: DefaultToolPolicy(), Session: "tg_42", Logger: logger}
+	defer forgetSessionApprovals("tg_42")
+	write := func(p string) ExecCall {
+		return ExecCall{Tool: "fs.write", ArgsRaw: `{"path":"` + p + `","content":"x"}`}
+	}
+	if res := ExecuteCalls(ctx, []ExecCall{write("memory/a.md")}, approver); !res[0].OK {
+		t.Fatalf("expected the call to be approved, got %+v", res[0])
+	}
+	// 选择了“本次会话允许”，同一会话中不再发出请求
+	if res := ExecuteCalls(ctx, []ExecCall{write("memory/b.md")}, approver); !res[0].OK || len(sent) != 0 {
+		t.Fatalf("expected a session approval, got %+v (sent %d)", res[0], len(sent))
+	}
+	if PendingApprovals("42") != nil {
+		t.Fatalf("answered approvals must not stay pending")
+	}
+
+	// 超时按拒绝处理
+	approver.Session = "other"
+	approver.Timeout = 50 * time.Millisecond
+	ctx.Session = "other"
+	res := ExecuteCalls(ctx, []ExecCall{write("memory/c.md")}, approver)
+	if res[0].OK || res[0].Error != "denied by user" {
+		t.Fatalf("expected a timeout denial, got %+v", res[0])
+	}
+	req := <-sent
+	if _, err := ResolveApproval(req.ID, ApprovalAllow, "42"); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Fatalf("expected an expired approval, got %v", err)
+	}
+
+	b, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`approval allow tool=fs.write args="{\"path\":\"memory/a.md\",\"content\":\"x\"}"` + "\n",
+		`approval allow tool=fs.write args="{\"path\":\"memory/b.md\",\"content\":\"x\"}" auto="allowed for this session"`,
+		`approval deny tool=fs.write args="{\"path\":\"memory/c.md\",\"content\":\"x\"}" auto="no answer within 50ms"`,
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Fatalf("missing audit line %q in:\n%s", want, b)
+		}
+	}
+}
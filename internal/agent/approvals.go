@@ -0,0 +1,315 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 审批记忆：策略决定 ask 的调用在请求审批前，先查本次对话允许过的工具，再查 approvals.json
+// 中“总是允许 / 总是拒绝”的记录（位置见 approvalsPath）。记录只对需要审批的调用生效，不会放行策略禁止的调用；
+// 命中记录时不再询问，并在审计日志中写明是哪条记录做出的决定。
+//
+//	{"grants": [
+//	  {"id": 1, "action": "allow", "tool": "runtime.exec", "key": "command", "pattern": "git status*"},
+//	  {"id": 2, "action": "allow", "tool": "memory.store", "args": "{\"content\":\"...\"}"},
+//	  {"id": 3, "action": "deny",  "tool": "runtime.exec", "key": "command", "pattern": "rm *"}
+//	]}
+//
+// args 是规范化后的 JSON（键按字母排序），只匹配完全相同的调用；pattern 的语法与 policy.toml 规则相同，
+// 匹配 key 指定的参数（command / path / skill / script）。key 为空的 deny 记录表示拒绝该工具的所有调用；
+// 不允许 key 为空的 allow 记录，免得一次选择就永久放行某个工具的任意调用。
+
+// ApprovalGrant 是一条持久的审批记录。
+type ApprovalGrant struct {
+	ID      int       `json:"id"`
+	Action  string    `json:"action"`
+	Tool    string    `json:"tool"`
+	Args    string    `json:"args,omitempty"`
+	Key     string    `json:"key,omitempty"`
+	Pattern string    `json:"pattern,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+func (g ApprovalGrant) String() string {
+	s := fmt.Sprintf("#%d always %s %s", g.ID, g.Action, g.Tool)
+	switch {
+	case g.Args != "":
+		s += " args=" + redactSecrets(previewArgs(g.Args))
+	case g.Key != "":
+		s += " " + g.Key + "=" + g.Pattern
+	default:
+		s += " (any arguments)"
+	}
+	return s
+}
+
+// rule 把记录转换为一条策略规则，复用规则的匹配语义（allow 要求所有值匹配、带控制符的命令不放行）。
+func (g ApprovalGrant) rule() PolicyRule {
+	r := PolicyRule{Action: g.Action, Tools: []string{g.Tool}}
+	if g.Pattern == "" {
+		return r
+	}
+	switch g.Key {
+	case "path":
+		r.Paths = []string{g.Pattern}
+	case "command":
+		r.Commands = []string{g.Pattern}
+	case "skill":
+		r.Skills = []string{g.Pattern}
+	case "script":
+		r.Scripts = []string{g.Pattern}
+	}
+	return r
+}
+
+func (g ApprovalGrant) matches(call ExecCall) bool {
+	if g.Args != "" {
+		return g.rule().matchesTool(call.Tool) && approvalArgs(call.ArgsRaw) == g.Args
+	}
+	return g.rule().matches(PolicyRequest{Tool: call.Tool, ArgsRaw: call.ArgsRaw})
+}
+
+func (g ApprovalGrant) validate() error {
+	if g.Action != "allow" && g.Action != "deny" {
+		return fmt.Errorf("action must be allow or deny, got %q", g.Action)
+	}
+	if strings.TrimSpace(g.Tool) == "" {
+		return fmt.Errorf("tool is required")
+	}
+	if g.Pattern == "" {
+		if g.Action == "allow" && g.Args == "" {
+			return fmt.Errorf("an allow entry needs args or a key and pattern")
+		}
+		return nil
+	}
+	switch g.Key {
+	case "path", "command", "skill", "script":
+	default:
+		return fmt.Errorf("key must be path, command, skill or script, got %q", g.Key)
+	}
+	_, err := compilePolicyPattern(g.Pattern)
+	return err
+}
+
+// approvalsPath 返回保存审批记录的文件：默认 data/approvals.json，与 policy.toml 放在一起；
+// NIBOT_APPROVALS_FILE 可以指定工作区之外的位置（工作区对 fs.write 和 runtime.exec 可写）。
+func approvalsPath(workspace string) (string, error) {
+	if p := strings.TrimSpace(os.Getenv("NIBOT_APPROVALS_FILE")); p != "" {
+		return p, nil
+	}
+	return filepath.Join(workspace, "data", "approvals.json"), nil
+}
+
+// ApprovalsFile 返回工作区的审批记录文件，供 approvals 命令显示。
+func ApprovalsFile(workspace string) string {
+	p, err := approvalsPath(workspace)
+	if err != nil {
+		return err.Error()
+	}
+	return p
+}
+
+// approvalsMu 串行化 approvals.json 的读改写。
+var approvalsMu sync.Mutex
+
+// LoadApprovalGrants 读取审批记录；文件不存在时返回空列表。
+func LoadApprovalGrants(workspace string) ([]ApprovalGrant, error) {
+	approvalsMu.Lock()
+	defer approvalsMu.Unlock()
+	return loadApprovalGrants(workspace)
+}
+
+func loadApprovalGrants(workspace string) ([]ApprovalGrant, error) {
+	p, err := approvalsPath(workspace)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var v struct {
+		Grants []ApprovalGrant `json:"grants"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", p, err)
+	}
+	return v.Grants, nil
+}
+
+func saveApprovalGrants(workspace string, grants []ApprovalGrant) error {
+	p, err := approvalsPath(workspace)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	if grants == nil {
+		grants = []ApprovalGrant{}
+	}
+	b, _ := json.MarshalIndent(map[string]any{"grants": grants}, "", "  ")
+	return os.WriteFile(p, append(b, '\n'), 0o600)
+}
+
+// AddApprovalGrant 追加一条记录并返回带编号的记录。
+func AddApprovalGrant(workspace string, g ApprovalGrant) (ApprovalGrant, error) {
+	if g.Args != "" {
+		g.Args = approvalArgs(g.Args)
+	}
+	if err := g.validate(); err != nil {
+		return g, err
+	}
+	approvalsMu.Lock()
+	defer approvalsMu.Unlock()
+	grants, err := loadApprovalGrants(workspace)
+	if err != nil {
+		return g, err
+	}
+	for _, old := range grants {
+		if old.ID >= g.ID {
+			g.ID = old.ID + 1
+		}
+	}
+	if g.ID == 0 {
+		g.ID = 1
+	}
+	if g.Created.IsZero() {
+		g.Created = time.Now()
+	}
+	return g, saveApprovalGrants(workspace, append(grants, g))
+}
+
+// RevokeApprovalGrant 删除编号为 id 的记录。
+func RevokeApprovalGrant(workspace string, id int) (ApprovalGrant, error) {
+	approvalsMu.Lock()
+	defer approvalsMu.Unlock()
+	grants, err := loadApprovalGrants(workspace)
+	if err != nil {
+		return ApprovalGrant{}, err
+	}
+	for i, g := range grants {
+		if g.ID == id {
+			return g, saveApprovalGrants(workspace, append(grants[:i:i], grants[i+1:]...))
+		}
+	}
+	return ApprovalGrant{}, fmt.Errorf("no approval #%d", id)
+}
+
+// findApprovalGrant 返回第一条匹配调用的记录；deny 记录优先于 allow 记录。
+func findApprovalGrant(workspace string, call ExecCall) (ApprovalGrant, bool) {
+	grants, err := LoadApprovalGrants(workspace)
+	if err != nil || len(grants) == 0 {
+		return ApprovalGrant{}, false
+	}
+	sort.SliceStable(grants, func(i, j int) bool {
+		return grants[i].Action == "deny" && grants[j].Action != "deny"
+	})
+	for _, g := range grants {
+		if g.validate() == nil && g.matches(call) {
+			return g, true
+		}
+	}
+	return ApprovalGrant{}, false
+}
+
+// approvalArgs 把 JSON 参数规范化（去掉空白、键按字母排序，比 canonicalArgs 更严格），不是 JSON 时原样返回。
+func approvalArgs(raw string) string {
+	raw = strings.TrimSpace(raw)
+	var v any
+	if err := json.Unmarshal([]byte(raw), &v); err != nil {
+		return raw
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return raw
+	}
+	return string(b)
+}
+
+// suggestApprovalPattern 为“总是允许类似调用”给出默认的参数和模式：
+// 命令取前两个词（git status*），路径取所在目录（memory/*），技能取技能名。
+// 参数中没有这些值时返回空，此时只能选择“总是允许这一次完全相同的调用”。
+func suggestApprovalPattern(call ExecCall) (key, pattern string) {
+	args, opaque := policyArgsFor(call.ArgsRaw)
+	if opaque {
+		return "", ""
+	}
+	if cmds := args["command"]; len(cmds) == 1 {
+		fields := strings.Fields(cmds[0])
+		if len(fields) > 2 {
+			fields = fields[:2]
+		}
+		return "command", strings.Join(fields, " ") + "*"
+	}
+	if skills := args["skill"]; len(skills) == 1 {
+		return "skill", skills[0]
+	}
+	if paths := args["path"]; len(paths) > 0 {
+		dir := path.Dir(paths[0])
+		for _, p := range paths[1:] {
+			for dir != "." && dir != "/" && !strings.HasPrefix(p, dir+"/") {
+				dir = path.Dir(dir)
+			}
+		}
+		if dir == "." {
+			return "path", "*"
+		}
+		return "path", strings.TrimSuffix(dir, "/") + "/*"
+	}
+	return "", ""
+}
+
+// sessionApprovals 记录每个对话（ExecContext.Session）中选择“本次对话允许”的工具，进程退出后失效。
+var sessionApprovals = struct {
+	sync.Mutex
+	tools map[string]map[string]bool
+}{tools: map[string]map[string]bool{}}
+
+func allowToolForSession(session, tool string) {
+	if strings.TrimSpace(session) == "" {
+		return
+	}
+	sessionApprovals.Lock()
+	defer sessionApprovals.Unlock()
+	if sessionApprovals.tools[session] == nil {
+		sessionApprovals.tools[session] = map[string]bool{}
+	}
+	sessionApprovals.tools[session][tool] = true
+}
+
+func sessionAllowsTool(session, tool string) bool {
+	if strings.TrimSpace(session) == "" {
+		return false
+	}
+	sessionApprovals.Lock()
+	defer sessionApprovals.Unlock()
+	return sessionApprovals.tools[session][tool]
+}
+
+func forgetSessionApprovals(session string) {
+	sessionApprovals.Lock()
+	defer sessionApprovals.Unlock()
+	delete(sessionApprovals.tools, session)
+}
+
+// rememberedApproval 查找已有的审批决定：source 说明来源，写入审计日志和拒绝原因。
+func rememberedApproval(ctx ExecContext, call ExecCall) (approved bool, source string, ok bool) {
+	if g, found := findApprovalGrant(ctx.Workspace, call); found {
+		return g.Action == "allow", "approvals.json " + g.String(), true
+	}
+	if sessionAllowsTool(ctx.Session, call.Tool) {
+		return true, "allowed for this session", true
+	}
+	return false, "", false
+}
@@ -0,0 +1,168 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApprovalGrants_RememberedDecisions(t *testing.T) {
+	t.Setenv("NIBOT_AUTO_APPROVE", "")
+	t.Setenv("NIBOT_APPROVALS_FILE", filepath.Join(t.TempDir(), "approvals.json"))
+	ws := t.TempDir()
+	logPath := filepath.Join(ws, "audit.log")
+	logger, err := os.Create(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	policy := DefaultToolPolicy()
+	policy.Rules = []PolicyRule{{Action: "ask", Tools: []string{"fs.write"}}}
+	ctx := ExecContext{Workspace: ws, Policy: policy, Session: "test", Logger: logger, LogLevel: "full"}
+	write := func(p string) ExecCall {
+		return ExecCall{Tool: "fs.write", ArgsRaw: `{"path":"` + p + `","content":"x"}`}
+	}
+
+	// 选择 p 并接受建议的模式 memory/*：之后同目录的写入不再询问
+	var out strings.Builder
+	cli := &cliApprover{scanner: bufio.NewScanner(strings.NewReader("p\n\n")), out: &out, workspace: ws, session: "test"}
+	if res := ExecuteCalls(ctx, []ExecCall{write("memory/a.md")}, cli); !res[0].OK {
+		t.Fatalf("expected the first write to be approved: %+v\n%s", res[0], out.String())
+	}
+	grants, err := LoadApprovalGrants(ws)
+	if err != nil || len(grants) != 1 || grants[0].String() != "#1 always allow fs.write path=memory/*" {
+		t.Fatalf("unexpected grants: %v %v", grants, err)
+	}
+
+	asked := &countingApprover{}
+	if res := ExecuteCalls(ctx, []ExecCall{write("memory/b.md"), write("skills/c.md")}, asked); !res[0].OK || !res[1].OK {
+		t.Fatalf("unexpected results: %+v", res)
+	}
+	if asked.n != 1 {
+		t.Fatalf("only the write outside memory/ should ask, asked %d times", asked.n)
+	}
+
+	// “总是拒绝”优先于“总是允许”
+	if _, err := AddApprovalGrant(ws, ApprovalGrant{Action: "deny", Tool: "fs.write", Args: `{ "content":"x", "path":"memory/b.md" }`}); err != nil {
+		t.Fatal(err)
+	}
+	res := ExecuteCalls(ctx, []ExecCall{write("memory/b.md")}, asked)
+	if res[0].OK || res[0].Error != "denied by user" || !strings.Contains(res[0].Reason, "approvals.json #2 always deny fs.write") {
+		t.Fatalf("expected a remembered denial, got %+v", res[0])
+	}
+
+	if _, err := RevokeApprovalGrant(ws, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RevokeApprovalGrant(ws, 1); err == nil {
+		t.Fatalf("revoking twice should fail")
+	}
+	if ExecuteCalls(ctx, []ExecCall{write("memory/d.md")}, asked); asked.n != 2 {
+		t.Fatalf("a revoked grant must ask again, asked %d times", asked.n)
+	}
+
+	// “本次对话允许”只对同一个 Session 生效
+	cli = &cliApprover{scanner: bufio.NewScanner(strings.NewReader("s\n")), out: &out, workspace: ws, session: "test"}
+	ExecuteCalls(ctx, []ExecCall{write("skills/e.md")}, cli)
+	ExecuteCalls(ctx, []ExecCall{write("skills/f.md")}, asked)
+	other := ctx
+	other.Session = "other"
+	ExecuteCalls(other, []ExecCall{write("skills/g.md")}, asked)
+	if asked.n != 3 {
+		t.Fatalf("session approval should cover only its own session, asked %d times", asked.n)
+	}
+	forgetSessionApprovals("test")
+
+	b, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`approval allow tool=fs.write args="{\"path\":\"memory/b.md\",\"content\":\"x\"}" auto="approvals.json #1 always allow fs.write path=memory/*"`,
+		`approval deny tool=fs.write args="{\"path\":\"memory/b.md\",\"content\":\"x\"}" auto="approvals.json #2 always deny fs.write args={\"content\":\"x\",\"path\":\"memory/b.md\"}"`,
+		`approval allow tool=fs.write args="{\"path\":\"skills/f.md\",\"content\":\"x\"}" auto="allowed for this session"`,
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Fatalf("missing audit line %q in:\n%s", want, b)
+		}
+	}
+}
+
+func TestApprovalGrants_PatternsDoNotAllowChainedCommands(t *testing.T) {
+	g := ApprovalGrant{Action: "allow", Tool: "runtime.exec", Key: "command", Pattern: "git status*"}
+	if !g.matches(ExecCall{Tool: "runtime.exec", ArgsRaw: `{"command":"git status --short"}`}) {
+		t.Fatalf("expected the pattern to match")
+	}
+	if g.matches(ExecCall{Tool: "runtime.exec", ArgsRaw: `{"command":"git status; rm -rf x"}`}) {
+		t.Fatalf("an allow pattern must not match chained commands")
+	}
+	if key, pattern := suggestApprovalPattern(ExecCall{Tool: "runtime.exec", ArgsRaw: `{"command":"git log -1 --stat"}`}); key != "command" || pattern != "git log*" {
+		t.Fatalf("unexpected suggestion %s=%s", key, pattern)
+	}
+	if key, pattern := suggestApprovalPattern(ExecCall{Tool: "memory.store", ArgsRaw: `{"content":"x"}`}); key != "" || pattern != "" {
+		t.Fatalf("tools without path/command have no pattern, got %s=%s", key, pattern)
+	}
+	for _, cmd := range []string{"git status && rm -rf x", "git status | sh", "git status $(rm -rf x)", "git status\nrm -rf x"} {
+		raw, _ := json.Marshal(map[string]string{"command": cmd})
+		if g.matches(ExecCall{Tool: "runtime.exec", ArgsRaw: string(raw)}) {
+			t.Fatalf("an allow pattern must not match %q", cmd)
+		}
+	}
+}
+
+func TestApprovalGrants_NoPatternGrantForAnyArguments(t *testing.T) {
+	t.Setenv("NIBOT_AUTO_APPROVE", "")
+	t.Setenv("NIBOT_APPROVALS_FILE", filepath.Join(t.TempDir(), "approvals.json"))
+	ws := t.TempDir()
+	if _, err := AddApprovalGrant(ws, ApprovalGrant{Action: "allow", Tool: "memory.store"}); err == nil {
+		t.Fatalf("an allow entry without args or pattern must be refused")
+	}
+
+	// memory.store 没有可匹配的参数：p 被拒绝，改选 a 只保存完全相同的调用
+	policy := DefaultToolPolicy()
+	policy.Rules = []PolicyRule{{Action: "ask", Tools: []string{"memory.store"}}}
+	ctx := ExecContext{Workspace: ws, Policy: policy, Session: "test"}
+	var out strings.Builder
+	cli := &cliApprover{scanner: bufio.NewScanner(strings.NewReader("p\na\n")), out: &out, workspace: ws, session: "test"}
+	call := ExecCall{Tool: "memory.store", ArgsRaw: `{"content":"x"}`}
+	if res := ExecuteCalls(ctx, []ExecCall{call}, cli); res[0].Error == "denied by user" {
+		t.Fatalf("expected the call to be approved: %+v\n%s", res[0], out.String())
+	}
+	if strings.Contains(out.String(), "p = always allow") || !strings.Contains(out.String(), "(y/n/s/a/d): ") || !strings.Contains(out.String(), "use a to always allow this exact call") {
+		t.Fatalf("p should not be offered:\n%s", out.String())
+	}
+	grants, err := LoadApprovalGrants(ws)
+	if err != nil || len(grants) != 1 || grants[0].Args != `{"content":"x"}` {
+		t.Fatalf("expected an exact-args grant, got %v %v", grants, err)
+	}
+	asked := &countingApprover{}
+	ExecuteCalls(ctx, []ExecCall{{Tool: "memory.store", ArgsRaw: `{"content":"y"}`}}, asked)
+	if asked.n != 1 {
+		t.Fatalf("other arguments must still ask, asked %d times", asked.n)
+	}
+}
+
+func TestApprovalGrants_StoredInDataDir(t *testing.T) {
+	t.Setenv("NIBOT_APPROVALS_FILE", "")
+	ws := t.TempDir()
+	if _, err := AddApprovalGrant(ws, ApprovalGrant{Action: "deny", Tool: "runtime.exec"}); err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(ws, "data", "approvals.json")
+	if p := ApprovalsFile(ws); p != want {
+		t.Fatalf("approvals file = %s, want %s", p, want)
+	}
+	if grants, err := LoadApprovalGrants(ws); err != nil || len(grants) != 1 {
+		t.Fatalf("expected the grant back from %s, got %v %v", want, grants, err)
+	}
+
+	// NIBOT_APPROVALS_FILE 指定其他位置时不再读取 data/approvals.json
+	t.Setenv("NIBOT_APPROVALS_FILE", filepath.Join(t.TempDir(), "approvals.json"))
+	if grants, err := LoadApprovalGrants(ws); err != nil || len(grants) != 0 {
+		t.Fatalf("expected no grants at NIBOT_APPROVALS_FILE, got %v %v", grants, err)
+	}
+}
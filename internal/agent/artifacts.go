@@ -0,0 +1,78 @@
+package agent
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 工具产物统一存放在 workspace/artifacts/<id>/ 下，后续轮次可用 fs.read 读取，Web 端通过 /api/artifacts/ 下载。
+const artifactsDirName = "artifacts"
+
+func newArtifactID(prefix string) string {
+	b := make([]byte, 3)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%s_%s_%s", prefix, time.Now().Format("20060102_150405"), hex.EncodeToString(b))
+}
+
+func isValidArtifactID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, r := range id {
+		if !(r == '_' || r == '-' || (r >= '0' && r <= '9') || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')) {
+			return false
+		}
+	}
+	return true
+}
+
+func artifactDir(workspace, id string) string {
+	return filepath.Join(workspace, artifactsDirName, id)
+}
+
+// ArtifactPath 把 artifact id 与其中的相对文件名解析为绝对路径，拒绝路径穿越。
+func ArtifactPath(workspace, id, name string) (string, error) {
+	if !isValidArtifactID(id) {
+		return "", fmt.Errorf("invalid artifact id")
+	}
+	name = filepath.ToSlash(strings.TrimSpace(name))
+	if name == "" || strings.Contains(name, "\x00") || strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("invalid artifact file")
+	}
+	for _, seg := range strings.Split(name, "/") {
+		if seg == ".." {
+			return "", fmt.Errorf("path traversal is not allowed")
+		}
+	}
+	return resolveWorkspacePath(workspace, artifactsDirName+"/"+id+"/"+name)
+}
+
+type artifactFile struct {
+	Rel  string
+	Size int64
+}
+
+func listArtifactFiles(workspace, id string) ([]artifactFile, error) {
+	root := artifactDir(workspace, id)
+	var out []artifactFile
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(workspace, p)
+		if err != nil {
+			return err
+		}
+		out = append(out, artifactFile{Rel: filepath.ToSlash(rel), Size: info.Size()})
+		return nil
+	})
+	return out, err
+}
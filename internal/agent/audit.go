@@ -8,13 +8,22 @@ import (
 )
 
 func writeAuditApproval(logger *os.File, logLevel string, call ExecCall, approved bool) {
+	writeAuditAutoApproval(logger, logLevel, call, approved, "")
+}
+
+// writeAuditAutoApproval 记录未询问用户的审批决定；source 说明由谁决定（approvals.json 记录、本次对话允许等）。
+func writeAuditAutoApproval(logger *os.File, logLevel string, call ExecCall, approved bool, source string) {
 	ts := time.Now().Format("2006-01-02 15:04:05")
 	argsPreview := redactSecrets(previewArgs(call.ArgsRaw))
 	decision := "deny"
 	if approved {
 		decision = "allow"
 	}
-	line := fmt.Sprintf("- %s approval %s tool=%s args=%q\n", ts, decision, call.Tool, argsPreview)
+	line := fmt.Sprintf("- %s approval %s tool=%s args=%q", ts, decision, call.Tool, argsPreview)
+	if source != "" {
+		line += fmt.Sprintf(" auto=%q", source)
+	}
+	line += "\n"
 	writeLog(logger, ensureAuditHeader(logLevel))
 	writeLog(logger, line)
 }
@@ -36,12 +45,16 @@ func writeAuditToolResults(logger *os.File, logLevel string, calls []ExecCall, r
 		outBytes := len([]byte(strings.TrimSpace(r.Output)))
 		argsPreview := redactSecrets(previewArgs(call.ArgsRaw))
 
+		policy := ""
+		if r.Reason != "" {
+			policy = fmt.Sprintf(" policy=%q", r.Reason)
+		}
 		if normalizeLogLevel(logLevel) == "meta" {
-			writeLog(logger, fmt.Sprintf("- %s tool=%s ok=%v args_bytes=%d output_bytes=%d error=%q\n",
-				ts, call.Tool, r.OK, len([]byte(strings.TrimSpace(call.ArgsRaw))), outBytes, errPreview))
+			writeLog(logger, fmt.Sprintf("- %s tool=%s ok=%v args_bytes=%d output_bytes=%d error=%q%s\n",
+				ts, call.Tool, r.OK, len([]byte(strings.TrimSpace(call.ArgsRaw))), outBytes, errPreview, policy))
 		} else {
-			writeLog(logger, fmt.Sprintf("- %s tool=%s ok=%v args=%q output_bytes=%d error=%q\n",
-				ts, call.Tool, r.OK, argsPreview, outBytes, errPreview))
+			writeLog(logger, fmt.Sprintf("- %s tool=%s ok=%v args=%q output_bytes=%d error=%q%s\n",
+				ts, call.Tool, r.OK, argsPreview, outBytes, errPreview, policy))
 		}
 	}
 }
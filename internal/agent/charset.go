@@ -0,0 +1,188 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// 文本编码名称：fs.read 报告检测结果，fs.write 的 encoding 参数也使用这些名称。
+const (
+	charsetUTF8    = "utf-8"
+	charsetUTF8BOM = "utf-8-bom"
+	charsetUTF16LE = "utf-16le"
+	charsetUTF16BE = "utf-16be"
+	charsetGBK     = "gbk"
+	charsetGB18030 = "gb18030"
+)
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// normalizeCharsetName 把常见写法统一为上面的名称，未知编码返回错误。
+func normalizeCharsetName(name string) (string, error) {
+	n := strings.ToLower(strings.TrimSpace(name))
+	n = strings.ReplaceAll(n, "_", "-")
+	switch n {
+	case "", "utf-8", "utf8":
+		return charsetUTF8, nil
+	case "utf-8-bom", "utf8-bom", "utf-8-sig", "utf8bom":
+		return charsetUTF8BOM, nil
+	case "utf-16le", "utf16le", "utf-16", "utf16", "ucs-2":
+		return charsetUTF16LE, nil
+	case "utf-16be", "utf16be":
+		return charsetUTF16BE, nil
+	case "gbk", "gb2312", "cp936", "windows-936":
+		return charsetGBK, nil
+	case "gb18030":
+		return charsetGB18030, nil
+	}
+	return "", fmt.Errorf("unsupported encoding: %s (use utf-8, utf-8-bom, utf-16le, utf-16be, gbk, gb18030)", name)
+}
+
+func charsetEncoding(name string) encoding.Encoding {
+	switch name {
+	case charsetUTF16LE:
+		return unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
+	case charsetUTF16BE:
+		return unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM)
+	case charsetGBK:
+		return simplifiedchinese.GBK
+	case charsetGB18030:
+		return simplifiedchinese.GB18030
+	}
+	return nil
+}
+
+// looksLikeUTF16 在没有 BOM 时根据奇偶位置上 0 字节的比例判断 UTF-16（主要是 ASCII 为主的文本）。
+func looksLikeUTF16(b []byte) string {
+	if len(b) > 4096 {
+		b = b[:4096]
+	}
+	pairs := len(b) / 2
+	if pairs < 4 {
+		return ""
+	}
+	evenZero, oddZero := 0, 0
+	for i := 0; i+1 < len(b); i += 2 {
+		if b[i] == 0 {
+			evenZero++
+		}
+		if b[i+1] == 0 {
+			oddZero++
+		}
+	}
+	switch {
+	case oddZero*10 >= pairs*4 && evenZero*20 < pairs:
+		return charsetUTF16LE
+	case evenZero*10 >= pairs*4 && oddZero*20 < pairs:
+		return charsetUTF16BE
+	}
+	return ""
+}
+
+// decodeText 检测编码并转换为 UTF-8。partial 表示 b 是被截断的前缀（末尾可能是半个字符）。
+// 无法识别的内容（例如二进制文件）原样返回，编码为空串。
+func decodeText(b []byte, partial bool) (string, string) {
+	switch {
+	case bytes.HasPrefix(b, bomUTF8):
+		return string(trimPartialUTF8(b[len(bomUTF8):], partial)), charsetUTF8BOM
+	case bytes.HasPrefix(b, bomUTF16LE):
+		return decodeWith(b[2:], charsetUTF16LE, partial), charsetUTF16LE
+	case bytes.HasPrefix(b, bomUTF16BE):
+		return decodeWith(b[2:], charsetUTF16BE, partial), charsetUTF16BE
+	}
+	// 0 字节在 UTF-8 中合法，所以先排除无 BOM 的 UTF-16 和二进制内容。
+	if bytes.IndexByte(b, 0) >= 0 {
+		if enc := looksLikeUTF16(b); enc != "" {
+			if s := decodeWith(b, enc, partial); !strings.ContainsRune(s, utf8.RuneError) && !hasControlChars(s) {
+				return s, enc
+			}
+		}
+		return string(b), ""
+	}
+	if utf8.Valid(trimPartialUTF8(b, partial)) {
+		return string(b), charsetUTF8
+	}
+	for _, enc := range []string{charsetGBK, charsetGB18030} {
+		s := decodeWith(b, enc, partial)
+		if s != "" && !strings.ContainsRune(s, utf8.RuneError) && !hasControlChars(s) {
+			return s, enc
+		}
+	}
+	return string(b), ""
+}
+
+func decodeWith(b []byte, enc string, partial bool) string {
+	out, err := charsetEncoding(enc).NewDecoder().Bytes(b)
+	if err != nil {
+		return ""
+	}
+	s := string(out)
+	if partial {
+		s = strings.TrimRight(s, string(utf8.RuneError))
+	}
+	return s
+}
+
+func trimPartialUTF8(b []byte, partial bool) []byte {
+	if !partial {
+		return b
+	}
+	for i := 0; i < 3 && len(b) > 0; i++ {
+		r, size := utf8.DecodeLastRune(b)
+		if r != utf8.RuneError || size != 1 {
+			break
+		}
+		b = b[:len(b)-1]
+	}
+	return b
+}
+
+func hasControlChars(s string) bool {
+	for _, r := range s {
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' && r != '\f' {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeText 把 UTF-8 文本转换为目标编码；withBOM 为 true 时为 UTF-8 BOM / UTF-16 写入字节序标记。
+func encodeText(s string, enc string, withBOM bool) ([]byte, error) {
+	var bom []byte
+	switch enc {
+	case charsetUTF8:
+		return []byte(s), nil
+	case charsetUTF8BOM:
+		if withBOM {
+			bom = bomUTF8
+		}
+		return append(append([]byte{}, bom...), s...), nil
+	case charsetUTF16LE:
+		if withBOM {
+			bom = bomUTF16LE
+		}
+	case charsetUTF16BE:
+		if withBOM {
+			bom = bomUTF16BE
+		}
+	}
+	e := charsetEncoding(enc)
+	if e == nil {
+		return nil, fmt.Errorf("unsupported encoding: %s", enc)
+	}
+	out, err := e.NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		return nil, fmt.Errorf("content cannot be represented in %s: %w", enc, err)
+	}
+	return append(append([]byte{}, bom...), out...), nil
+}
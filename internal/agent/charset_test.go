@@ -0,0 +1,128 @@
+package agent
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+func gbkBytes(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := simplifiedchinese.GBK.NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDecodeText_DetectsEncodings(t *testing.T) {
+	cases := []struct {
+		name string
+		in   []byte
+		enc  string
+		want string
+	}{
+		{"utf8", []byte("你好 world"), charsetUTF8, "你好 world"},
+		{"utf8 bom", append([]byte{0xEF, 0xBB, 0xBF}, "你好"...), charsetUTF8BOM, "你好"},
+		{"utf16le bom", []byte{0xFF, 0xFE, 'h', 0, 'i', 0, 0x60, 0x4F}, charsetUTF16LE, "hi你"},
+		{"utf16be bom", []byte{0xFE, 0xFF, 0, 'h', 0, 'i'}, charsetUTF16BE, "hi"},
+		{"utf16le no bom", []byte{'a', 0, 'b', 0, 'c', 0, '\n', 0}, charsetUTF16LE, "abc\n"},
+		{"gbk", gbkBytes(t, "中文内容，测试 GBK\n"), charsetGBK, "中文内容，测试 GBK\n"},
+		{"binary", []byte{0x00, 0x01, 0xFF, 0xFE, 0x80}, "", "\x00\x01\xff\xfe\x80"},
+	}
+	for _, c := range cases {
+		got, enc := decodeText(c.in, false)
+		if enc != c.enc || got != c.want {
+			t.Errorf("%s: got (%q, %q), want (%q, %q)", c.name, got, enc, c.want, c.enc)
+		}
+	}
+
+	gb18030, err := simplifiedchinese.GB18030.NewEncoder().Bytes([]byte("表情😀"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, enc := decodeText(gb18030, false); enc != charsetGB18030 || got != "表情😀" {
+		t.Fatalf("gb18030: got (%q, %q)", got, enc)
+	}
+
+	// 截断在半个 GBK 字符处时仍应识别为 GBK
+	b := gbkBytes(t, "中文中文")
+	if got, enc := decodeText(b[:len(b)-1], true); enc != charsetGBK || got != "中文中" {
+		t.Fatalf("partial gbk: got (%q, %q)", got, enc)
+	}
+}
+
+func TestFSRead_ConvertsGBK(t *testing.T) {
+	ws := t.TempDir()
+	if err := os.WriteFile(filepath.Join(ws, "notes.txt"), gbkBytes(t, "会议纪要\n第二行"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := toolFSRead(ExecContext{Workspace: ws, Policy: DefaultToolPolicy()}, `{"path":"notes.txt"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "[encoding: gbk, converted to UTF-8]\n会议纪要\n第二行" {
+		t.Fatalf("unexpected output: %q", out)
+	}
+}
+
+func TestFSWrite_KeepEncoding(t *testing.T) {
+	ws := t.TempDir()
+	p := filepath.Join(ws, "memory", "gbk.md")
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, gbkBytes(t, "第一行"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := ExecContext{Workspace: ws, Policy: DefaultToolPolicy()}
+
+	out, err := toolFSWrite(ctx, `{"path":"memory/gbk.md","content":"第二行","encoding":"keep"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "encoding gbk") {
+		t.Fatalf("expected encoding note, got %q", out)
+	}
+	b, _ := os.ReadFile(p)
+	if !bytes.Equal(b, gbkBytes(t, "第一行\n第二行")) {
+		t.Fatalf("file not kept in GBK: %x", b)
+	}
+
+	if _, err := toolFSWrite(ctx, `{"path":"memory/gbk.md","content":"😀","encoding":"keep"}`); err == nil || !strings.Contains(err.Error(), "cannot be represented in gbk") {
+		t.Fatalf("expected encode error, got %v", err)
+	}
+
+	if _, err := toolFSWrite(ctx, `{"path":"memory/u16.txt","content":"hi","mode":"overwrite","encoding":"utf-16le"}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := toolFSWrite(ctx, `{"path":"memory/u16.txt","content":"yo","encoding":"keep"}`); err != nil {
+		t.Fatal(err)
+	}
+	b, _ = os.ReadFile(filepath.Join(ws, "memory", "u16.txt"))
+	if !bytes.Equal(b, []byte{0xFF, 0xFE, 'h', 0, 'i', 0, '\n', 0, 'y', 0, 'o', 0}) {
+		t.Fatalf("unexpected utf-16 file: %x", b)
+	}
+
+	if _, err := toolFSWrite(ctx, `{"path":"memory/x.md","content":"a","encoding":"latin9"}`); err == nil {
+		t.Fatalf("expected unsupported encoding error")
+	}
+}
+
+func TestDataQuery_GBKCSV(t *testing.T) {
+	ws := t.TempDir()
+	if err := os.WriteFile(filepath.Join(ws, "orders.csv"), gbkBytes(t, "城市,金额\n北京,10\n上海,5\n北京,1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := toolDataQuery(ExecContext{Workspace: ws, Policy: DefaultToolPolicy()}, `{"files":["orders.csv"],"sql":"SELECT 城市, SUM(金额) FROM orders GROUP BY 城市 ORDER BY 2 DESC"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "| 北京 | 11 |") {
+		t.Fatalf("unexpected output: %q", out)
+	}
+}
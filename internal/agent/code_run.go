@@ -0,0 +1,255 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+type codeRunArgs struct {
+	Language       string   `json:"language"`
+	Code           string   `json:"code"`
+	Files          []string `json:"files"`
+	TimeoutSeconds int      `json:"timeoutSeconds"`
+}
+
+func codeRunMaxArtifactBytes() int64 {
+	return int64(parseIntEnv("NIBOT_CODE_MAX_ARTIFACT_MB", 20, 1, 1024)) * 1024 * 1024
+}
+
+const codeRunMaxArtifacts = 50
+
+func pythonBin() string {
+	if v := strings.TrimSpace(os.Getenv("NIBOT_PYTHON_BIN")); v != "" {
+		return v
+	}
+	if runtime.GOOS == "windows" {
+		return "python"
+	}
+	return "python3"
+}
+
+func codeRunArgv(language string) (argv []string, source string, err error) {
+	switch strings.ToLower(strings.TrimSpace(language)) {
+	case "python", "python3", "py":
+		return []string{pythonBin(), "main.py"}, "main.py", nil
+	case "sh", "shell":
+		if runtime.GOOS == "windows" {
+			return nil, "", fmt.Errorf("code.run language sh is not supported on windows")
+		}
+		return []string{"sh", "main.sh"}, "main.sh", nil
+	case "bash":
+		if runtime.GOOS == "windows" {
+			return nil, "", fmt.Errorf("code.run language bash is not supported on windows")
+		}
+		return []string{"bash", "main.sh"}, "main.sh", nil
+	default:
+		return nil, "", fmt.Errorf("code.run unsupported language: %s (use python or sh)", language)
+	}
+}
+
+// codeRunShellLang 返回 shell 语言对应的解析语法；其他语言返回 false。
+func codeRunShellLang(language string) (syntax.LangVariant, bool) {
+	switch strings.ToLower(strings.TrimSpace(language)) {
+	case "sh", "shell":
+		return syntax.LangPOSIX, true
+	case "bash":
+		return syntax.LangBash, true
+	}
+	return 0, false
+}
+
+// toolCodeRun 在独立临时目录中运行一段代码：files 中的工作区文件会先复制进去，
+// 运行结束后目录里新产生的文件作为产物保存到 artifacts/<id>/。
+func toolCodeRun(ctx ExecContext, argsRaw string) (string, error) {
+	if !strings.HasPrefix(strings.TrimSpace(argsRaw), "{") {
+		return "", fmt.Errorf("code.run requires JSON args: {\"language\":\"python\",\"code\":\"...\",\"files\":[\"data/x.csv\"]}")
+	}
+	var a codeRunArgs
+	if err := json.Unmarshal([]byte(argsRaw), &a); err != nil {
+		return "", fmt.Errorf("invalid JSON args for code.run: %w", err)
+	}
+	if strings.TrimSpace(a.Code) == "" {
+		return "", fmt.Errorf("code.run requires code")
+	}
+	if os.Getenv("NIBOT_ENABLE_EXEC") != "1" {
+		return "", fmt.Errorf("code.run disabled (set NIBOT_ENABLE_EXEC=1 to enable)")
+	}
+	argv, source, err := codeRunArgv(a.Language)
+	if err != nil {
+		return "", err
+	}
+	timeout := time.Duration(a.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 60 * time.Second
+	}
+	if timeout > 10*time.Minute {
+		timeout = 10 * time.Minute
+	}
+
+	tmp, err := os.MkdirTemp("", "nibot-code-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	inputs := map[string]bool{source: true}
+	for _, f := range a.Files {
+		rel := normalizeWorkspaceRelPath(f)
+		src, err := resolveWorkspacePath(ctx.Workspace, rel)
+		if err != nil {
+			return "", fmt.Errorf("code.run input %s: %w", f, err)
+		}
+		clean := filepath.Clean(filepath.FromSlash(rel))
+		if err := copyFileLimited(src, filepath.Join(tmp, clean), codeRunMaxArtifactBytes()); err != nil {
+			return "", fmt.Errorf("code.run input %s: %w", f, err)
+		}
+		inputs[filepath.ToSlash(clean)] = true
+	}
+	if lang, ok := codeRunShellLang(a.Language); ok {
+		// shell 代码和 runtime.exec 的命令一样检查，否则 allowed_runtime_prefixes 可以经 code.run 绕过
+		if err := ctx.Policy.checkShellScript(tmp, a.Code, lang); err != nil {
+			return "", fmt.Errorf("code.run %s code denied by policy: %v", a.Language, err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(tmp, source), []byte(a.Code), 0o644); err != nil {
+		return "", err
+	}
+
+	runCtx := ctx
+	runCtx.Workspace = tmp
+	cmd, err := sandboxedCommand(runCtx, argv, childEnvFor(ctx, "code.run", nil, nil))
+	if err != nil {
+		return "", err
+	}
+	release := acquireExecSlot()
+	defer release()
+
+	maxOut := execMaxOutputBytes()
+	stdout := newSpillBuffer(maxOut)
+	stderr := newSpillBuffer(maxOut)
+	defer stdout.Close()
+	defer stderr.Close()
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	runErr := runWithLimits(cmd, timeout, execRlimitsFromEnv())
+
+	out := strings.TrimSpace(stdout.String())
+	er := strings.TrimSpace(stderr.String())
+	if runErr != nil {
+		if er == "" {
+			er = runErr.Error()
+		} else {
+			er = er + "\n" + runErr.Error()
+		}
+	}
+	result := appendNote(formatExecOutput(out, er), saveExecOverflow(ctx, stdout, stderr))
+
+	id, files, note, err := saveCodeRunArtifacts(ctx.Workspace, tmp, inputs)
+	if err != nil {
+		result += "\n\n[artifacts not saved: " + err.Error() + "]"
+	} else if len(files) > 0 {
+		var sb strings.Builder
+		sb.WriteString(fmt.Sprintf("\n\nARTIFACTS (id=%s):\n", id))
+		for _, f := range files {
+			sb.WriteString(fmt.Sprintf("- %s (%d bytes)\n", f.Rel, f.Size))
+		}
+		if note != "" {
+			sb.WriteString(note + "\n")
+		}
+		result += strings.TrimRight(sb.String(), "\n")
+	}
+
+	if runErr != nil {
+		return result, fmt.Errorf("code.run failed")
+	}
+	return result, nil
+}
+
+func saveCodeRunArtifacts(workspace, tmp string, inputs map[string]bool) (string, []artifactFile, string, error) {
+	maxBytes := codeRunMaxArtifactBytes()
+	var produced []string
+	skipped := 0
+	err := filepath.Walk(tmp, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == "__pycache__" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(tmp, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if inputs[rel] {
+			return nil
+		}
+		if info.Size() > maxBytes || len(produced) >= codeRunMaxArtifacts {
+			skipped++
+			return nil
+		}
+		produced = append(produced, rel)
+		return nil
+	})
+	if err != nil || len(produced) == 0 {
+		return "", nil, "", err
+	}
+
+	id := newArtifactID("code")
+	dstRoot := artifactDir(workspace, id)
+	for _, rel := range produced {
+		if err := copyFileLimited(filepath.Join(tmp, filepath.FromSlash(rel)), filepath.Join(dstRoot, filepath.FromSlash(rel)), maxBytes); err != nil {
+			return "", nil, "", err
+		}
+	}
+	files, err := listArtifactFiles(workspace, id)
+	note := ""
+	if skipped > 0 {
+		note = fmt.Sprintf("(%d files skipped: over %d MB or more than %d files)", skipped, maxBytes/1024/1024, codeRunMaxArtifacts)
+	}
+	return id, files, note, err
+}
+
+func copyFileLimited(src, dst string, maxBytes int64) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("is a directory")
+	}
+	if info.Size() > maxBytes {
+		return fmt.Errorf("file too large (%d bytes)", info.Size())
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, io.LimitReader(in, maxBytes)); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -1,7 +1,6 @@
 package agent
 
 import (
-	"bufio"
 	"os"
 	"path/filepath"
 	"strings"
@@ -118,118 +117,13 @@ func defaultBaseURL(provider string) string {
 	}
 }
 
+// readConfigYAML / readConfigToml 读取配置文件；有语法错误时沿用上一次成功读取的内容（见 keepLastGood）。
 func readConfigYAML(path string) (Config, bool) {
-	f, err := os.Open(path)
-	if err != nil {
-		return Config{}, false
-	}
-	defer f.Close()
-
-	var cfg Config
-	inLLM := false
-
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		raw := scanner.Text()
-		line := strings.TrimRight(raw, "\r\n")
-		trim := strings.TrimSpace(line)
-		if trim == "" {
-			continue
-		}
-		if strings.HasPrefix(trim, "#") {
-			continue
-		}
-
-		if strings.HasSuffix(trim, ":") && !strings.Contains(trim, " ") {
-			section := strings.TrimSuffix(trim, ":")
-			inLLM = strings.ToLower(strings.TrimSpace(section)) == "llm"
-			continue
-		}
-		if !inLLM {
-			continue
-		}
-
-		if !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t") {
-			continue
-		}
-		kv := strings.TrimSpace(line)
-		parts := strings.SplitN(kv, ":", 2)
-		if len(parts) != 2 {
-			continue
-		}
-		key := strings.TrimSpace(parts[0])
-		val := strings.TrimSpace(parts[1])
-		val = strings.Trim(val, "\"")
-		val = strings.Trim(val, "'")
-		val = strings.Trim(val, "`")
-
-		switch key {
-		case "provider":
-			cfg.Provider = val
-		case "base_url":
-			cfg.BaseURL = val
-		case "api_key":
-			cfg.APIKey = val
-		case "model":
-			cfg.ModelName = val
-		case "log_level":
-			cfg.LogLevel = val
-		}
-	}
-
-	if cfg.Provider == "" && cfg.BaseURL == "" && cfg.APIKey == "" && cfg.ModelName == "" && cfg.LogLevel == "" {
-		return Config{}, false
-	}
-	return cfg, true
+	cfg, issues, ok := parseConfigYAML(path)
+	return keepLastGood(path, cfg, issues, ok)
 }
 
 func readConfigToml(path string) (Config, bool) {
-	f, err := os.Open(path)
-	if err != nil {
-		return Config{}, false
-	}
-	defer f.Close()
-
-	var cfg Config
-
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
-			continue
-		}
-		if strings.HasPrefix(line, "#") {
-			continue
-		}
-		if strings.HasPrefix(line, "[") {
-			continue
-		}
-
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) != 2 {
-			continue
-		}
-		key := strings.TrimSpace(parts[0])
-		val := strings.TrimSpace(parts[1])
-		val = strings.Trim(val, "\"")
-		val = strings.Trim(val, "'")
-
-		switch key {
-		case "provider":
-			cfg.Provider = val
-		case "base_url":
-			cfg.BaseURL = val
-		case "api_key":
-			cfg.APIKey = val
-		case "model":
-			cfg.ModelName = val
-		case "log_level":
-			cfg.LogLevel = val
-		}
-	}
-
-	if cfg.Provider == "" && cfg.BaseURL == "" && cfg.APIKey == "" && cfg.ModelName == "" && cfg.LogLevel == "" {
-		return Config{}, false
-	}
-	return cfg, true
+	cfg, issues, ok := parseConfigToml(path)
+	return keepLastGood(path, cfg, issues, ok)
 }
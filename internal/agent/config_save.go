@@ -1,12 +1,16 @@
 package agent
 
 import (
-	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
 )
 
+// 保存时如果文件已存在，只替换对应键的值（保留行尾注释），其余内容和用户写的注释原样保留；
+// 文件不存在时才生成完整的新文件。
+
 // SaveConfig writes the configuration to workspace/data/config.toml
 func SaveConfig(workspace string, cfg Config) error {
 	path := filepath.Join(workspace, "data", "config.toml")
@@ -16,27 +20,29 @@ func SaveConfig(workspace string, cfg Config) error {
 		return err
 	}
 
-	var sb strings.Builder
-	sb.WriteString("# Ni Bot Configuration\n")
-	sb.WriteString("# Generated by Web Interface\n\n")
-
-	if cfg.Provider != "" {
-		sb.WriteString(fmt.Sprintf("provider = \"%s\"\n", cfg.Provider))
-	}
-	if cfg.BaseURL != "" {
-		sb.WriteString(fmt.Sprintf("base_url = \"%s\"\n", cfg.BaseURL))
-	}
-	if cfg.ModelName != "" {
-		sb.WriteString(fmt.Sprintf("model = \"%s\"\n", cfg.ModelName))
-	}
-	if cfg.APIKey != "" {
-		sb.WriteString(fmt.Sprintf("api_key = \"%s\"\n", cfg.APIKey))
-	}
-	if cfg.LogLevel != "" {
-		sb.WriteString(fmt.Sprintf("log_level = \"%s\"\n", cfg.LogLevel))
+	var entries []tomlAssignment
+	for _, f := range configFields {
+		v := strings.TrimSpace(*f.Field(&cfg))
+		if v != "" {
+			v = strconv.Quote(v)
+		}
+		entries = append(entries, tomlAssignment{Key: f.Key, Value: v})
 	}
 
-	return os.WriteFile(path, []byte(sb.String()), 0644)
+	src, err := os.ReadFile(path)
+	if err != nil {
+		var sb strings.Builder
+		sb.WriteString("# Ni Bot Configuration\n")
+		sb.WriteString("# Generated by Web Interface\n\n")
+		for _, e := range entries {
+			if e.Value != "" {
+				sb.WriteString(e.Key + " = " + e.Value + "\n")
+			}
+		}
+		return os.WriteFile(path, []byte(sb.String()), 0644)
+	}
+	out := patchTomlKeys(string(src), map[string]bool{"": true, "llm": true}, entries)
+	return os.WriteFile(path, []byte(out), 0644)
 }
 
 // SaveToolPolicy writes the policy to workspace/data/policy.toml
@@ -48,37 +54,179 @@ func SaveToolPolicy(workspace string, p ToolPolicy) error {
 		return err
 	}
 
-	var sb strings.Builder
-	sb.WriteString("# Ni Bot Policy Configuration\n")
-	sb.WriteString("# Generated by Web Interface\n\n")
+	var entries []tomlAssignment
+	for _, f := range policyFields {
+		v := ""
+		if f.Bool != nil {
+			v = strconv.FormatBool(*f.Bool(&p))
+		} else if list := *f.List(&p); len(list) > 0 {
+			v = tomlStringArray(list)
+		}
+		entries = append(entries, tomlAssignment{Key: f.Key, Value: v})
+	}
+
+	src, err := os.ReadFile(path)
+	if err != nil {
+		var sb strings.Builder
+		sb.WriteString("# Ni Bot Policy Configuration\n")
+		sb.WriteString("# Generated by Web Interface\n")
+		for i, f := range policyFields {
+			if f.Section != "" {
+				sb.WriteString("\n# " + f.Section + "\n")
+			}
+			if entries[i].Value != "" {
+				sb.WriteString(f.Key + " = " + entries[i].Value + "\n")
+			}
+		}
+		// [[rules]] 必须写在所有顶层键之后
+		if len(p.Rules) > 0 {
+			sb.WriteString("\n" + policyRulesComment)
+			sb.WriteString(formatPolicyRules(p.Rules))
+		}
+		return os.WriteFile(path, []byte(sb.String()), 0644)
+	}
+
+	out := patchTomlKeys(string(src), map[string]bool{"": true}, entries)
+	// 规则没有变化时保留原文（含注释），否则整体重写规则部分
+	if existing, _, _ := parsePolicyFile(path); !sameRules(existing.Rules, p.Rules) {
+		out = replacePolicyRules(out, p.Rules)
+	}
+	return os.WriteFile(path, []byte(out), 0644)
+}
 
-	// Global Switches
-	sb.WriteString(fmt.Sprintf("allow_fs_write = \"%t\"\n", p.AllowFSWrite))
-	sb.WriteString(fmt.Sprintf("allow_runtime_exec = \"%t\"\n", p.AllowRuntimeExec))
-	sb.WriteString(fmt.Sprintf("allow_skill_exec = \"%t\"\n", p.AllowSkillExec))
-	sb.WriteString(fmt.Sprintf("allow_skill_install = \"%t\"\n", p.AllowSkillInstall))
-	sb.WriteString(fmt.Sprintf("allow_memory = \"%t\"\n", p.AllowMemory))
+const policyRulesComment = "# Ordered Rules (first match wins)"
 
-	sb.WriteString("\n# Approval Requirements\n")
-	sb.WriteString(fmt.Sprintf("require_approval_fs_write = \"%t\"\n", p.RequireFSWrite))
-	sb.WriteString(fmt.Sprintf("require_approval_runtime_exec = \"%t\"\n", p.RequireRuntimeExec))
-	sb.WriteString(fmt.Sprintf("require_approval_skill_exec = \"%t\"\n", p.RequireSkillExec))
-	sb.WriteString(fmt.Sprintf("require_approval_skill_install = \"%t\"\n", p.RequireSkillInstall))
-	sb.WriteString(fmt.Sprintf("require_approval_memory = \"%t\"\n", p.RequireMemory))
+func sameRules(a, b []PolicyRule) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
 
-	// Lists
-	if len(p.AllowedRuntimePrefixes) > 0 {
-		sb.WriteString(fmt.Sprintf("allowed_runtime_prefixes = \"%s\"\n", strings.Join(p.AllowedRuntimePrefixes, ",")))
+// replacePolicyRules 删除已有的 [[rules]] 表，把 rules 追加到文件末尾。
+func replacePolicyRules(src string, rules []PolicyRule) string {
+	var out []string
+	inRules := false
+	for _, line := range strings.Split(src, "\n") {
+		code, _, _ := tomlSplitComment(strings.TrimSpace(line))
+		if name, array, ok := tomlTableHeader(code); ok {
+			inRules = array && name == "rules"
+			if inRules {
+				for len(out) > 0 && (strings.TrimSpace(out[len(out)-1]) == "" || strings.TrimSpace(out[len(out)-1]) == policyRulesComment) {
+					out = out[:len(out)-1]
+				}
+				continue
+			}
+		}
+		if !inRules {
+			out = append(out, line)
+		}
 	}
-	if len(p.AllowedWritePrefixes) > 0 {
-		sb.WriteString(fmt.Sprintf("allowed_write_prefixes = \"%s\"\n", strings.Join(p.AllowedWritePrefixes, ",")))
+	for len(out) > 0 && strings.TrimSpace(out[len(out)-1]) == "" {
+		out = out[:len(out)-1]
 	}
-	if len(p.AllowedSkillNames) > 0 {
-		sb.WriteString(fmt.Sprintf("allowed_skill_names = \"%s\"\n", strings.Join(p.AllowedSkillNames, ",")))
+	text := strings.Join(out, "\n") + "\n"
+	if len(rules) > 0 {
+		text += "\n" + policyRulesComment + formatPolicyRules(rules)
 	}
-	if len(p.AllowedSkillScripts) > 0 {
-		sb.WriteString(fmt.Sprintf("allowed_skill_scripts = \"%s\"\n", strings.Join(p.AllowedSkillScripts, ",")))
+	return text
+}
+
+// tomlAssignment 是要写入的一个键；Value 是编码好的 TOML 值，为空表示删除该键。
+type tomlAssignment struct {
+	Key   string
+	Value string
+}
+
+func tomlStringArray(list []string) string {
+	quoted := make([]string, len(list))
+	for i, v := range list {
+		quoted[i] = strconv.Quote(v)
 	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
 
-	return os.WriteFile(path, []byte(sb.String()), 0644)
+// patchTomlKeys 更新 tables（"" 表示顶层）中的键：已有的键原地替换值并保留行尾注释，
+// 重复出现的键和值为空的键被删除，文件中没有的键追加到这些表的最后一个键之后。
+func patchTomlKeys(src string, tables map[string]bool, entries []tomlAssignment) string {
+	want := map[string]string{}
+	for _, e := range entries {
+		want[e.Key] = e.Value
+	}
+	done := map[string]bool{}
+	lines := strings.Split(src, "\n")
+	var out []string
+	inTable := tables[""]
+	lastKey, firstHeader := -1, -1
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		code, comment, _ := tomlSplitComment(strings.TrimSpace(line))
+		if name, array, ok := tomlTableHeader(code); ok {
+			inTable = !array && tables[name]
+			if firstHeader < 0 {
+				firstHeader = len(out)
+			}
+			out = append(out, line)
+			continue
+		}
+		k, v, ok := strings.Cut(code, "=")
+		if !ok {
+			out = append(out, line)
+			continue
+		}
+		// 跨行数组作为一个整体处理
+		end := i
+		_, _, depth := tomlSplitComment(v)
+		for depth > 0 && end+1 < len(lines) {
+			end++
+			_, _, d := tomlSplitComment(strings.TrimSpace(lines[end]))
+			depth += d
+		}
+		key := tomlKeyName(k)
+		val, managed := want[key]
+		if !inTable || !managed {
+			out = append(out, lines[i:end+1]...)
+		} else if !done[key] && val != "" {
+			indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+			if end > i {
+				comment = ""
+			}
+			out = append(out, indent+strings.TrimSpace(k)+" = "+val+comment)
+		}
+		if inTable && managed {
+			done[key] = true
+		}
+		if inTable {
+			lastKey = len(out)
+		}
+		i = end
+	}
+
+	var missing []string
+	for _, e := range entries {
+		if !done[e.Key] && e.Value != "" {
+			missing = append(missing, e.Key+" = "+e.Value)
+		}
+	}
+	if len(missing) == 0 {
+		return strings.Join(out, "\n")
+	}
+	at := lastKey
+	switch {
+	case at >= 0:
+	case firstHeader >= 0 && tables[""]:
+		// 写在第一个表之前，并跳过紧贴表头的注释
+		at = firstHeader
+		for at > 0 && strings.HasPrefix(strings.TrimSpace(out[at-1]), "#") {
+			at--
+		}
+		missing = append(missing, "")
+	default:
+		at = len(out)
+		if at > 0 && out[at-1] == "" {
+			at--
+		}
+	}
+	out = append(out[:at], append(missing, out[at:]...)...)
+	return strings.Join(out, "\n")
 }
@@ -0,0 +1,630 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// 配置文件的解析与检查：config.yaml / config.toml / policy.toml 用真正的 YAML / TOML 解析器读取，
+// 再按下面的字段表校验。未知的键、类型错误和语法错误都带行号报告（nibot config lint 和启动日志），
+// 而不是像以前一样被静默忽略。
+
+// ConfigIssue 是配置检查发现的一个问题；Line 为 0 表示无法定位到具体行。
+type ConfigIssue struct {
+	File    string
+	Line    int
+	Level   string // error / warn / hint
+	Message string
+}
+
+func (i ConfigIssue) String() string {
+	loc := i.File
+	if i.Line > 0 {
+		loc = fmt.Sprintf("%s:%d", i.File, i.Line)
+	}
+	return fmt.Sprintf("%s: %s: %s", loc, i.Level, i.Message)
+}
+
+// configField 描述 config.yaml（llm: 下）和 config.toml（顶层或 [llm]）中的一个键。
+type configField struct {
+	Key   string
+	Field func(*Config) *string
+}
+
+var configFields = []configField{
+	{Key: "provider", Field: func(c *Config) *string { return &c.Provider }},
+	{Key: "base_url", Field: func(c *Config) *string { return &c.BaseURL }},
+	{Key: "model", Field: func(c *Config) *string { return &c.ModelName }},
+	{Key: "api_key", Field: func(c *Config) *string { return &c.APIKey }},
+	{Key: "log_level", Field: func(c *Config) *string { return &c.LogLevel }},
+}
+
+var policyRuleKeys = []string{"action", "tool", "tools", "path", "paths", "command", "commands", "skill", "skills", "script", "scripts", "channel", "channels", "user", "users", "reason"}
+
+func findConfigField(key string) *configField {
+	for i := range configFields {
+		if configFields[i].Key == key {
+			return &configFields[i]
+		}
+	}
+	return nil
+}
+
+func configFieldKeys() []string {
+	keys := make([]string, len(configFields))
+	for i, f := range configFields {
+		keys[i] = f.Key
+	}
+	return keys
+}
+
+func policyFieldKeys() []string {
+	keys := make([]string, 0, len(policyFields)+1)
+	for _, f := range policyFields {
+		keys = append(keys, f.Key)
+	}
+	return append(keys, "rules")
+}
+
+func configDisplayName(path string) string {
+	return "data/" + filepath.Base(path)
+}
+
+// LintConfig 检查工作区 data/ 下的配置文件，每个文件内的问题按行号排列；文件不存在不算问题。
+func LintConfig(workspace string) []ConfigIssue {
+	data := filepath.Join(workspace, "data")
+	var issues []ConfigIssue
+	_, yamlIssues, _ := parseConfigYAML(filepath.Join(data, "config.yaml"))
+	issues = append(issues, yamlIssues...)
+	_, tomlIssues, _ := parseConfigToml(filepath.Join(data, "config.toml"))
+	issues = append(issues, tomlIssues...)
+	_, policyIssues, _ := parsePolicyFile(filepath.Join(data, "policy.toml"))
+	issues = append(issues, policyIssues...)
+	// 其余 TOML 文件由各自的模块读取，这里只检查语法
+	for _, name := range []string{"mcp.toml", "notify.toml", "openapi.toml"} {
+		b, err := os.ReadFile(filepath.Join(data, name))
+		if err != nil {
+			continue
+		}
+		var raw map[string]any
+		if _, err := toml.Decode(string(b), &raw); err != nil {
+			issues = append(issues, tomlSyntaxIssue("data/"+name, err))
+		}
+	}
+	return issues
+}
+
+// ConfigIssuesHaveErrors 判断问题中是否有 error 级别的。
+func ConfigIssuesHaveErrors(issues []ConfigIssue) bool {
+	for _, is := range issues {
+		if is.Level == "error" {
+			return true
+		}
+	}
+	return false
+}
+
+// ---- policy.toml ----
+
+// parsePolicyFile 用 TOML 解析器读取 policy.toml，返回写出的键和检查发现的问题。
+// 有语法错误时不返回任何键（见 readPolicyToml）。
+func parsePolicyFile(path string) (policyFile, []ConfigIssue, bool) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return policyFile{}, nil, false
+	}
+	src := string(b)
+	name := configDisplayName(path)
+	var raw map[string]any
+	if _, err := toml.Decode(src, &raw); err != nil {
+		return policyFile{}, []ConfigIssue{tomlSyntaxIssue(name, err)}, false
+	}
+
+	pf := policyFile{Bools: map[string]bool{}, Lists: map[string][]string{}}
+	lines := tomlKeyLines(src)
+	srcLines := strings.Split(src, "\n")
+	var issues []ConfigIssue
+	add := func(line int, level, format string, args ...any) {
+		issues = append(issues, ConfigIssue{File: name, Line: line, Level: level, Message: fmt.Sprintf(format, args...)})
+	}
+	for _, key := range keysByLine(raw, lines, "") {
+		line := lines[key]
+		val := raw[key]
+		if key == "rules" {
+			tables, ok := val.([]map[string]any)
+			if !ok {
+				add(line, "error", "rules must be written as [[rules]] tables")
+				continue
+			}
+			for i, t := range tables {
+				rule, ruleIssues := decodePolicyRule(name, i, t, lines, srcLines)
+				pf.Rules = append(pf.Rules, rule)
+				issues = append(issues, ruleIssues...)
+			}
+			continue
+		}
+		f := findPolicyField(key)
+		if f == nil {
+			if _, isTable := val.(map[string]any); isTable {
+				add(line, "warn", "unknown table [%s] is ignored", key)
+			} else {
+				add(line, "warn", "unknown key %q is ignored%s", key, didYouMean(key, policyFieldKeys()))
+			}
+			continue
+		}
+		if f.Bool != nil {
+			switch v := val.(type) {
+			case bool:
+				pf.Bools[key] = v
+			case string:
+				bv, ok := parseStrictBool(v)
+				if !ok {
+					add(line, "error", "%s must be true or false, got %q", key, v)
+					continue
+				}
+				pf.Bools[key] = bv
+				add(line, "hint", "%s is a quoted string; write %s = %t", key, key, bv)
+			default:
+				add(line, "error", "%s must be true or false, got %s", key, tomlTypeName(val))
+			}
+			continue
+		}
+		list, ok := tomlStringList(val)
+		if !ok {
+			add(line, "error", "%s must be a list of strings, got %s", key, tomlTypeName(val))
+			continue
+		}
+		pf.Lists[key] = list
+	}
+	return pf, issues, !pf.empty()
+}
+
+// decodePolicyRule 把一个 [[rules]] 表转换为规则；无法识别的键原样记入 Unknown，使规则按 deny 处理。
+func decodePolicyRule(name string, i int, t map[string]any, lines map[string]int, srcLines []string) (PolicyRule, []ConfigIssue) {
+	var rule PolicyRule
+	var issues []ConfigIssue
+	prefix := fmt.Sprintf("rules[%d]", i)
+	for _, key := range keysByLine(t, lines, prefix+".") {
+		line := lines[prefix+"."+key]
+		values, ok := tomlStringList(t[key])
+		var err error
+		if !ok {
+			err = fmt.Errorf("%s must be a string or a list of strings, got %s", key, tomlTypeName(t[key]))
+		} else if err = rule.setRuleValues(key, values); err != nil && !containsString(policyRuleKeys, key) {
+			err = fmt.Errorf("unknown key %q%s", key, didYouMean(key, policyRuleKeys))
+		}
+		if err == nil {
+			continue
+		}
+		text := fmt.Sprintf("%s = %v", key, t[key])
+		if line > 0 && line <= len(srcLines) {
+			text = strings.TrimSpace(srcLines[line-1])
+		}
+		rule.Unknown = append(rule.Unknown, text)
+		issues = append(issues, ConfigIssue{File: name, Line: line, Level: "error", Message: fmt.Sprintf("rule #%d: %v; the rule denies every tool it matches", i+1, err)})
+	}
+	if len(rule.Unknown) == 0 {
+		if err := rule.validate(); err != nil {
+			issues = append(issues, ConfigIssue{File: name, Line: lines[prefix], Level: "error", Message: fmt.Sprintf("rule #%d is invalid: %v; the rule denies every tool it matches", i+1, err)})
+		}
+	}
+	return rule, issues
+}
+
+// ---- config.toml / config.yaml ----
+
+// parseConfigToml 读取 config.toml；键可以写在顶层或 [llm] 表中。
+func parseConfigToml(path string) (Config, []ConfigIssue, bool) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, nil, false
+	}
+	src := string(b)
+	name := configDisplayName(path)
+	var raw map[string]any
+	if _, err := toml.Decode(src, &raw); err != nil {
+		return Config{}, []ConfigIssue{tomlSyntaxIssue(name, err)}, false
+	}
+
+	var cfg Config
+	var issues []ConfigIssue
+	lines := tomlKeyLines(src)
+	var set func(m map[string]any, prefix string)
+	set = func(m map[string]any, prefix string) {
+		for _, key := range keysByLine(m, lines, prefix) {
+			line := lines[prefix+key]
+			val := m[key]
+			if sub, isTable := val.(map[string]any); isTable && prefix == "" && key == "llm" {
+				set(sub, "llm.")
+				continue
+			}
+			f := findConfigField(key)
+			if f == nil {
+				issues = append(issues, ConfigIssue{File: name, Line: line, Level: "warn", Message: fmt.Sprintf("unknown key %q is ignored%s", prefix+key, didYouMean(key, configFieldKeys()))})
+				continue
+			}
+			s, ok := val.(string)
+			if !ok {
+				issues = append(issues, ConfigIssue{File: name, Line: line, Level: "error", Message: fmt.Sprintf("%s must be a string, got %s", key, tomlTypeName(val))})
+				continue
+			}
+			*f.Field(&cfg) = s
+			issues = append(issues, checkConfigValue(name, line, key, s)...)
+		}
+	}
+	set(raw, "")
+	return cfg, issues, configHasValues(cfg)
+}
+
+// parseConfigYAML 读取 config.yaml；键写在 llm: 下（也接受顶层）。
+func parseConfigYAML(path string) (Config, []ConfigIssue, bool) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, nil, false
+	}
+	name := configDisplayName(path)
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		msg := strings.TrimPrefix(err.Error(), "yaml: ")
+		issue := ConfigIssue{File: name, Level: "error"}
+		if m := yamlErrorLine.FindStringSubmatch(msg); m != nil {
+			issue.Line, _ = strconv.Atoi(m[1])
+			msg = strings.TrimPrefix(msg, m[0])
+		}
+		issue.Message = "syntax error: " + msg
+		return Config{}, []ConfigIssue{issue}, false
+	}
+	if len(doc.Content) == 0 {
+		return Config{}, nil, false
+	}
+
+	var cfg Config
+	var issues []ConfigIssue
+	add := func(line int, level, format string, args ...any) {
+		issues = append(issues, ConfigIssue{File: name, Line: line, Level: level, Message: fmt.Sprintf(format, args...)})
+	}
+	var set func(m *yaml.Node, prefix string)
+	set = func(m *yaml.Node, prefix string) {
+		if m.Kind != yaml.MappingNode {
+			add(m.Line, "error", "expected a mapping of keys, got %s", yamlKindName(m))
+			return
+		}
+		for i := 0; i+1 < len(m.Content); i += 2 {
+			k, v := m.Content[i], m.Content[i+1]
+			if prefix == "" && k.Value == "llm" {
+				set(v, "llm.")
+				continue
+			}
+			f := findConfigField(k.Value)
+			if f == nil {
+				add(k.Line, "warn", "unknown key %q is ignored%s", prefix+k.Value, didYouMean(k.Value, configFieldKeys()))
+				continue
+			}
+			if v.Kind != yaml.ScalarNode {
+				add(v.Line, "error", "%s must be a string, got %s", k.Value, yamlKindName(v))
+				continue
+			}
+			*f.Field(&cfg) = v.Value
+			issues = append(issues, checkConfigValue(name, v.Line, k.Value, v.Value)...)
+		}
+	}
+	set(doc.Content[0], "")
+	return cfg, issues, configHasValues(cfg)
+}
+
+var yamlErrorLine = regexp.MustCompile(`^line (\d+): `)
+
+func checkConfigValue(name string, line int, key, val string) []ConfigIssue {
+	if key == "log_level" && strings.TrimSpace(val) != "" {
+		switch strings.ToLower(strings.TrimSpace(val)) {
+		case "full", "meta":
+		default:
+			return []ConfigIssue{{File: name, Line: line, Level: "warn", Message: fmt.Sprintf("log_level should be full or meta, got %q", val)}}
+		}
+	}
+	return nil
+}
+
+func configHasValues(cfg Config) bool {
+	for _, f := range configFields {
+		if *f.Field(&cfg) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func yamlKindName(n *yaml.Node) string {
+	switch n.Kind {
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.SequenceNode:
+		return "a list"
+	case yaml.AliasNode:
+		return "an alias"
+	}
+	return "a value"
+}
+
+// ---- TOML helpers ----
+
+// syntaxIssue 返回问题中的语法错误。
+func syntaxIssue(issues []ConfigIssue) (ConfigIssue, bool) {
+	for _, is := range issues {
+		if is.Level == "error" && strings.HasPrefix(is.Message, "syntax error") {
+			return is, true
+		}
+	}
+	return ConfigIssue{}, false
+}
+
+// lastGoodConfigs 记录每个配置文件上一次成功解析的结果（按路径）。文件有语法错误时不做尽力解析：
+// 半份策略可能恰好漏掉一条 deny 规则，沿用上一次的完整内容更安全；本进程中没有成功读取过时使用默认值。
+var lastGoodConfigs sync.Map
+
+// reportedSyntaxErrors 避免同一个语法错误在每次加载时重复写日志。
+var reportedSyntaxErrors sync.Map
+
+type lastGoodConfig[T any] struct {
+	v  T
+	ok bool
+}
+
+// keepLastGood 在 issues 中有语法错误时报告错误并返回上一次成功的结果；否则记住 v 并原样返回。
+func keepLastGood[T any](path string, v T, issues []ConfigIssue, ok bool) (T, bool) {
+	is, bad := syntaxIssue(issues)
+	if !bad {
+		lastGoodConfigs.Store(path, lastGoodConfig[T]{v, ok})
+		reportedSyntaxErrors.Delete(path)
+		return v, ok
+	}
+	last, found := lastGoodConfigs.Load(path)
+	if prev, loaded := reportedSyntaxErrors.Swap(path, is.String()); !loaded || prev != is.String() {
+		what := "using defaults"
+		if found {
+			what = "keeping the last valid version"
+		}
+		log.Printf("Config %s; the file is ignored, %s (run `nibot config lint` for details)", is, what)
+	}
+	if found {
+		good := last.(lastGoodConfig[T])
+		return good.v, good.ok
+	}
+	var zero T
+	return zero, false
+}
+
+func tomlSyntaxIssue(name string, err error) ConfigIssue {
+	var perr toml.ParseError
+	if errors.As(err, &perr) {
+		return ConfigIssue{File: name, Line: perr.Position.Line, Level: "error", Message: "syntax error: " + perr.Message}
+	}
+	return ConfigIssue{File: name, Level: "error", Message: err.Error()}
+}
+
+// tomlStringList 接受字符串（按逗号分隔，兼容旧写法 "a,b"）或字符串数组。
+func tomlStringList(v any) ([]string, bool) {
+	switch v := v.(type) {
+	case string:
+		return splitCSV(v), true
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, it := range v {
+			s, ok := it.(string)
+			if !ok {
+				return nil, false
+			}
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, s)
+			}
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+// tomlList 是 mcp.toml 等文件中的字符串列表，同样接受逗号分隔的字符串。
+type tomlList []string
+
+func (l *tomlList) UnmarshalTOML(v any) error {
+	list, ok := tomlStringList(v)
+	if !ok {
+		return fmt.Errorf("must be a string or a list of strings, got %s", tomlTypeName(v))
+	}
+	*l = list
+	return nil
+}
+
+// decodeDataToml 用 TOML 解析器把 data/ 下的文件解码到 v；文件不存在时返回 os.ErrNotExist，
+// 语法错误带上文件名和行号。
+func decodeDataToml(path string, v any) error {
+	_, err := toml.DecodeFile(path, v)
+	if err == nil || errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return errors.New(tomlSyntaxIssue(configDisplayName(path), err).String())
+}
+
+func tomlTypeName(v any) string {
+	switch v.(type) {
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	case int64, float64:
+		return "a number"
+	case []any, []map[string]any:
+		return "a list"
+	case map[string]any:
+		return "a table"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// parseStrictBool 只接受明确的布尔写法，用于兼容旧版本保存的 "true" / "false"。
+func parseStrictBool(s string) (bool, bool) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "1", "true", "yes", "y", "on":
+		return true, true
+	case "0", "false", "no", "n", "off":
+		return false, true
+	}
+	return false, false
+}
+
+// tomlKeyLines 记录每个键所在的行号（从 1 开始），键名形如 allow_git、llm.model、rules[0]、rules[0].action。
+// BurntSushi/toml 不提供键的位置，这里按行扫描；跨行数组的后续行会被跳过。
+func tomlKeyLines(src string) map[string]int {
+	out := map[string]int{}
+	prefix := ""
+	arrays := map[string]int{}
+	depth := 0
+	for i, line := range strings.Split(src, "\n") {
+		code, _, d := tomlSplitComment(strings.TrimSpace(line))
+		if depth > 0 {
+			depth += d
+			continue
+		}
+		if code == "" {
+			continue
+		}
+		if name, array, ok := tomlTableHeader(code); ok {
+			if array {
+				prefix = fmt.Sprintf("%s[%d]", name, arrays[name])
+				arrays[name]++
+			} else {
+				prefix = name
+			}
+			out[prefix] = i + 1
+			prefix += "."
+			continue
+		}
+		k, v, ok := strings.Cut(code, "=")
+		if !ok {
+			continue
+		}
+		if _, seen := out[prefix+tomlKeyName(k)]; !seen {
+			out[prefix+tomlKeyName(k)] = i + 1
+		}
+		_, _, depth = tomlSplitComment(v)
+	}
+	return out
+}
+
+// tomlTableHeader 解析 [name] / [[name]] 表头。
+func tomlTableHeader(code string) (name string, array, ok bool) {
+	switch {
+	case strings.HasPrefix(code, "[["):
+		end := strings.Index(code, "]]")
+		if end < 0 {
+			return "", false, false
+		}
+		return tomlKeyName(code[2:end]), true, true
+	case strings.HasPrefix(code, "["):
+		end := strings.LastIndex(code, "]")
+		if end < 0 {
+			return "", false, false
+		}
+		return tomlKeyName(code[1:end]), false, true
+	}
+	return "", false, false
+}
+
+func tomlKeyName(k string) string {
+	parts := strings.Split(k, ".")
+	for i, p := range parts {
+		parts[i] = strings.Trim(strings.TrimSpace(p), "\"'")
+	}
+	return strings.Join(parts, ".")
+}
+
+// tomlSplitComment 把一行拆成代码和行尾注释（含注释前的空白），并返回代码中 [ 与 ] 的数量差；引号内的字符不计。
+func tomlSplitComment(s string) (code, comment string, depth int) {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case c == '#':
+			start := i
+			for start > 0 && (s[start-1] == ' ' || s[start-1] == '\t') {
+				start--
+			}
+			return s[:start], s[start:], depth
+		}
+	}
+	return s, "", depth
+}
+
+// keysByLine 按在文件中出现的顺序返回 m 的键，使问题按行号排列。
+func keysByLine(m map[string]any, lines map[string]int, prefix string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		li, lj := lines[prefix+keys[i]], lines[prefix+keys[j]]
+		if li != lj {
+			return li < lj
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// didYouMean 在已知键中找拼写最接近的一个，返回 ` (did you mean "x"?)` 或空串。
+func didYouMean(key string, known []string) string {
+	best, bestDist := "", 0
+	for _, k := range known {
+		d := editDistance(strings.ToLower(key), k)
+		if best == "" || d < bestDist {
+			best, bestDist = k, d
+		}
+	}
+	if best == "" || bestDist > 2 || bestDist == 2 && len(best) < 5 {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean %q?)", best)
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,226 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func lintLines(issues []ConfigIssue) string {
+	var lines []string
+	for _, is := range issues {
+		lines = append(lines, is.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestLintConfig_PolicySchema(t *testing.T) {
+	ws := t.TempDir()
+	writeDataFile(t, ws, "data/policy.toml", `# 手写的策略
+allow_fs_write = "false"
+alow_git = false
+allow_mcp = 1
+allowed_runtime_prefixes = [
+  "go",  # Go 工具链
+  "git",
+]
+allowed_write_prefixes = "memory/,skills/"
+
+[[rules]]
+action = "allow"
+tool = "runtime.exec"
+comand = "git status*"
+`)
+
+	all := lintLines(LintConfig(ws))
+	for _, want := range []string{
+		`data/policy.toml:2: hint: allow_fs_write is a quoted string; write allow_fs_write = false`,
+		`data/policy.toml:3: warn: unknown key "alow_git" is ignored (did you mean "allow_git"?)`,
+		`data/policy.toml:4: error: allow_mcp must be true or false, got a number`,
+		`data/policy.toml:14: error: rule #1: unknown key "comand" (did you mean "command"?)`,
+	} {
+		if !strings.Contains(all, want) {
+			t.Fatalf("missing issue %q in:\n%s", want, all)
+		}
+	}
+
+	p := LoadToolPolicy(ws)
+	if p.AllowFSWrite || !p.AllowGit || !p.AllowMCP {
+		t.Fatalf("unexpected switches: fs_write=%v git=%v mcp=%v", p.AllowFSWrite, p.AllowGit, p.AllowMCP)
+	}
+	if strings.Join(p.AllowedRuntimePrefixes, ",") != "go,git" || strings.Join(p.AllowedWritePrefixes, ",") != "memory/,skills/" {
+		t.Fatalf("unexpected lists: %v %v", p.AllowedRuntimePrefixes, p.AllowedWritePrefixes)
+	}
+	if d := p.Decide(PolicyRequest{Tool: "runtime.exec", ArgsRaw: `{"command":"rm -rf x"}`}); d.Action != "deny" {
+		t.Fatalf("a misspelled rule key must fail closed: %+v", d)
+	}
+}
+
+func TestLintConfig_SyntaxErrors(t *testing.T) {
+	ws := t.TempDir()
+	writeDataFile(t, ws, "data/policy.toml", "allow_git = false\nallow_runtime_exec = [\"go\"\n")
+	writeDataFile(t, ws, "data/config.yaml", "llm:\n  provider: \"ollama\"\n  model: [qwen\n")
+	writeDataFile(t, ws, "data/mcp.toml", "[servers.fs\ncommand = \"x\"\n")
+
+	issues := LintConfig(ws)
+	all := lintLines(issues)
+	for _, want := range []string{"data/policy.toml:2: error: syntax error", "data/config.yaml:2: error: syntax error", "data/mcp.toml:2: error: syntax error"} {
+		if !strings.Contains(all, want) {
+			t.Fatalf("missing issue %q in:\n%s", want, all)
+		}
+	}
+	if !ConfigIssuesHaveErrors(issues) {
+		t.Fatalf("syntax errors must be reported as errors")
+	}
+	// 有语法错误时不做尽力解析：本进程没有成功读取过这些文件，使用默认值
+	if !LoadToolPolicy(ws).AllowGit {
+		t.Fatalf("a policy with a syntax error must not be applied partially")
+	}
+	t.Setenv("LLM_PROVIDER", "")
+	if cfg := LoadConfig(ws); cfg.Provider != "deepseek" {
+		t.Fatalf("a config with a syntax error must not be applied partially, got provider %q", cfg.Provider)
+	}
+}
+
+func TestLoadConfig_SyntaxErrorKeepsLastValidVersion(t *testing.T) {
+	t.Setenv("LLM_PROVIDER", "")
+	ws := t.TempDir()
+	writeDataFile(t, ws, "data/policy.toml", "allow_git = false\n\n[[rules]]\naction = \"deny\"\ntools = \"fs.write\"\n")
+	writeDataFile(t, ws, "data/config.yaml", "llm:\n  provider: \"ollama\"\n")
+	if p := LoadToolPolicy(ws); p.AllowGit || len(p.Rules) != 1 {
+		t.Fatalf("unexpected policy: allow_git=%v rules=%v", p.AllowGit, p.Rules)
+	}
+	if cfg := LoadConfig(ws); cfg.Provider != "ollama" {
+		t.Fatalf("unexpected provider: %q", cfg.Provider)
+	}
+
+	// 编辑时写坏了文件：仍按上一次成功读取的内容执行，deny 规则不会因为半份文件而丢失
+	writeDataFile(t, ws, "data/policy.toml", "allow_git = true\n[[rules]\naction = \"deny\"\n")
+	writeDataFile(t, ws, "data/config.yaml", "llm:\n  provider: [openai\n")
+	p := LoadToolPolicy(ws)
+	if p.AllowGit || len(p.Rules) != 1 {
+		t.Fatalf("expected the last valid policy, got allow_git=%v rules=%v", p.AllowGit, p.Rules)
+	}
+	if d := p.Decide(PolicyRequest{Tool: "fs.write", ArgsRaw: `{"path":"a.md","content":"x"}`}); d.Action != "deny" {
+		t.Fatalf("the deny rule must still apply: %+v", d)
+	}
+	if cfg := LoadConfig(ws); cfg.Provider != "ollama" {
+		t.Fatalf("expected the last valid config, got provider %q", cfg.Provider)
+	}
+	if all := lintLines(LintConfig(ws)); !strings.Contains(all, "data/policy.toml:3: error: syntax error") {
+		t.Fatalf("the syntax error must still be reported:\n%s", all)
+	}
+}
+
+func TestLoadConfig_TomlTableAndUnknownKeys(t *testing.T) {
+	for _, k := range []string{"LLM_PROVIDER", "LLM_BASE_URL", "LLM_MODEL_NAME", "LLM_API_KEY", "NIBOT_LOG_LEVEL"} {
+		t.Setenv(k, "")
+	}
+	ws := t.TempDir()
+	writeDataFile(t, ws, "data/config.toml", "[llm]\nprovider = \"ollama\"\nmodle = \"qwen\"\nlog_level = \"verbose\"\n")
+	writeDataFile(t, ws, "data/config.yaml", "llm:\n  model: \"qwen2.5:14b\"\n  api-key: \"x\"\n")
+
+	cfg := LoadConfig(ws)
+	if cfg.Provider != "ollama" || cfg.ModelName != "qwen2.5:14b" || cfg.LogLevel != "verbose" {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+	all := lintLines(LintConfig(ws))
+	for _, want := range []string{
+		`data/config.yaml:3: warn: unknown key "llm.api-key" is ignored (did you mean "api_key"?)`,
+		`data/config.toml:3: warn: unknown key "llm.modle" is ignored (did you mean "model"?)`,
+		`data/config.toml:4: warn: log_level should be full or meta, got "verbose"`,
+	} {
+		if !strings.Contains(all, want) {
+			t.Fatalf("missing issue %q in:\n%s", want, all)
+		}
+	}
+}
+
+func TestSaveToolPolicy_PreservesComments(t *testing.T) {
+	ws := t.TempDir()
+	original := `# 团队共用的策略，改动前先在群里说一声
+allow_fs_write = "true"
+allow_git = true  # 需要 git.commit
+allowed_runtime_prefixes = [
+  "go",
+  "git",
+]
+
+# 只读的 git 命令不审批
+[[rules]]
+action = "allow"  # 放行
+tool = "runtime.exec"
+command = "git status*"
+`
+	writeDataFile(t, ws, "data/policy.toml", original)
+
+	p := LoadToolPolicy(ws)
+	p.AllowGit = false
+	p.AllowedRuntimePrefixes = []string{"go"}
+	p.AllowedHTTPDomains = []string{"api.example.com"}
+	if err := SaveToolPolicy(ws, p); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(ws, "data", "policy.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := string(b)
+	for _, want := range []string{
+		"# 团队共用的策略，改动前先在群里说一声\nallow_fs_write = true\n",
+		"allow_git = false  # 需要 git.commit\n",
+		"allowed_runtime_prefixes = [\"go\"]\n",
+		"allowed_http_domains = [\"api.example.com\"]\n",
+		"# 只读的 git 命令不审批\n[[rules]]\naction = \"allow\"  # 放行\n",
+	} {
+		if !strings.Contains(saved, want) {
+			t.Fatalf("missing %q in saved policy:\n%s", want, saved)
+		}
+	}
+	if strings.Contains(saved, "\"true\"") || strings.Contains(saved, "\"false\"") {
+		t.Fatalf("booleans must not be quoted:\n%s", saved)
+	}
+	if issues := LintConfig(ws); len(issues) != 0 {
+		t.Fatalf("saved policy should lint cleanly:\n%s", lintLines(issues))
+	}
+	if reloaded := LoadToolPolicy(ws); reloaded.AllowGit || strings.Join(reloaded.AllowedRuntimePrefixes, ",") != "go" || len(reloaded.Rules) != 1 {
+		t.Fatalf("unexpected reloaded policy: %+v", reloaded)
+	}
+
+	// 保存两次结果不变；规则改动时只重写规则部分
+	if err := SaveToolPolicy(ws, LoadToolPolicy(ws)); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(ws, "data", "policy.toml")); string(b) != saved {
+		t.Fatalf("saving an unchanged policy must not modify the file:\n%s", b)
+	}
+	p = LoadToolPolicy(ws)
+	p.Rules = append(p.Rules, PolicyRule{Action: "deny", Tools: []string{"fs.write"}, Paths: []string{"memory/facts.md"}})
+	if err := SaveToolPolicy(ws, p); err != nil {
+		t.Fatal(err)
+	}
+	b, _ = os.ReadFile(filepath.Join(ws, "data", "policy.toml"))
+	if !strings.HasPrefix(string(b), "# 团队共用的策略") || !strings.Contains(string(b), "path = \"memory/facts.md\"") {
+		t.Fatalf("unexpected policy after rule change:\n%s", b)
+	}
+	if rules := LoadToolPolicy(ws).Rules; len(rules) != 2 || rules[1].String() != "deny tool=fs.write path=memory/facts.md" {
+		t.Fatalf("unexpected rules: %v", rules)
+	}
+}
+
+func TestSaveConfig_UpdatesInPlace(t *testing.T) {
+	ws := t.TempDir()
+	writeDataFile(t, ws, "data/config.toml", "# 本地 ollama\n[llm]\nprovider = \"ollama\" # 不要改\nmodel = \"qwen\"\n")
+	if err := SaveConfig(ws, Config{Provider: "ollama", ModelName: "qwen2.5:7b", LogLevel: "meta"}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(ws, "data", "config.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# 本地 ollama\n[llm]\nprovider = \"ollama\" # 不要改\nmodel = \"qwen2.5:7b\"\nlog_level = \"meta\"\n"
+	if string(b) != want {
+		t.Fatalf("unexpected config.toml:\n%s", b)
+	}
+}
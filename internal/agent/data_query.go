@@ -0,0 +1,604 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type dataQueryArgs struct {
+	Files []string `json:"files"`
+	SQL   string   `json:"sql"`
+	Limit int      `json:"limit"`
+}
+
+type dataTable struct {
+	Name    string
+	Source  string
+	Columns []string
+	Types   []string
+	Rows    [][]any
+}
+
+func dataQueryMaxRows() int {
+	return parseIntEnv("NIBOT_DATA_MAX_ROWS", 200000, 100, 5000000)
+}
+
+const (
+	dataQueryMaxFileBytes = 64 * 1024 * 1024
+	dataQueryMaxCellRunes = 200
+	dataQueryMaxOutBytes  = 32 * 1024
+)
+
+// toolDataQuery 把工作区中的 CSV/TSV/JSONL/JSON 载入内存 SQLite，再执行只读 SQL。
+// 每个文件对应一张表，表名取文件名（非字母数字替换为下划线）。sql 为空时只返回表结构。
+func toolDataQuery(ctx ExecContext, argsRaw string) (string, error) {
+	if !strings.HasPrefix(strings.TrimSpace(argsRaw), "{") {
+		return "", fmt.Errorf("data.query requires JSON args: {\"files\":[\"data/sales.csv\"],\"sql\":\"SELECT ...\"}")
+	}
+	var a dataQueryArgs
+	if err := json.Unmarshal([]byte(argsRaw), &a); err != nil {
+		return "", fmt.Errorf("invalid JSON args for data.query: %w", err)
+	}
+	if len(a.Files) == 0 {
+		return "", fmt.Errorf("data.query requires files")
+	}
+	if a.Limit <= 0 {
+		a.Limit = 50
+	}
+	if a.Limit > 500 {
+		a.Limit = 500
+	}
+	query := strings.TrimSpace(a.SQL)
+	if query != "" {
+		q, err := checkReadOnlySQL(query)
+		if err != nil {
+			return "", err
+		}
+		query = q
+	}
+
+	var tables []*dataTable
+	used := map[string]bool{}
+	for _, f := range a.Files {
+		abs, err := resolveWorkspacePath(ctx.Workspace, f)
+		if err != nil {
+			return "", fmt.Errorf("data.query %s: %w", f, err)
+		}
+		t, err := loadDataTable(abs)
+		if err != nil {
+			return "", fmt.Errorf("data.query %s: %w", f, err)
+		}
+		t.Source = normalizeWorkspaceRelPath(f)
+		base := t.Name
+		for i := 2; used[t.Name]; i++ {
+			t.Name = fmt.Sprintf("%s_%d", base, i)
+		}
+		used[t.Name] = true
+		tables = append(tables, t)
+	}
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+	// :memory: 数据库按连接隔离，必须固定为单连接。
+	db.SetMaxOpenConns(1)
+
+	for _, t := range tables {
+		if err := insertDataTable(db, t); err != nil {
+			return "", fmt.Errorf("data.query load %s: %w", t.Source, err)
+		}
+	}
+	if _, err := db.Exec("PRAGMA query_only = ON"); err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	sb.WriteString("TABLES:\n")
+	for _, t := range tables {
+		var cols []string
+		for i, c := range t.Columns {
+			cols = append(cols, c+" "+t.Types[i])
+		}
+		sb.WriteString(fmt.Sprintf("- %s (%s, %d rows): %s\n", t.Name, t.Source, len(t.Rows), strings.Join(cols, ", ")))
+	}
+	if query == "" {
+		return strings.TrimRight(sb.String(), "\n"), nil
+	}
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return strings.TrimRight(sb.String(), "\n"), fmt.Errorf("data.query SQL error: %w", err)
+	}
+	defer rows.Close()
+	table, err := formatRowsMarkdown(rows, a.Limit)
+	if err != nil {
+		return strings.TrimRight(sb.String(), "\n"), fmt.Errorf("data.query SQL error: %w", err)
+	}
+	sb.WriteString("\n")
+	sb.WriteString(table)
+	return sb.String(), nil
+}
+
+// checkReadOnlySQL 只允许单条 SELECT / WITH 语句（另有 PRAGMA query_only 兜底）。
+func checkReadOnlySQL(q string) (string, error) {
+	q = strings.TrimSpace(q)
+	stmt := strings.TrimRight(q, "; \t\r\n")
+	inSingle, inDouble := false, false
+	for _, r := range stmt {
+		switch {
+		case r == '\'' && !inDouble:
+			inSingle = !inSingle
+		case r == '"' && !inSingle:
+			inDouble = !inDouble
+		case r == ';' && !inSingle && !inDouble:
+			return "", fmt.Errorf("data.query accepts a single SQL statement")
+		}
+	}
+	words := strings.FieldsFunc(stripSQLComments(stmt), func(r rune) bool { return !unicode.IsLetter(r) })
+	if len(words) == 0 {
+		return "", fmt.Errorf("data.query requires a SQL statement")
+	}
+	if first := strings.ToUpper(words[0]); first != "SELECT" && first != "WITH" {
+		return "", fmt.Errorf("data.query only allows read-only SELECT/WITH queries")
+	}
+	return stmt, nil
+}
+
+func stripSQLComments(s string) string {
+	for {
+		s = strings.TrimSpace(s)
+		switch {
+		case strings.HasPrefix(s, "--"):
+			if i := strings.Index(s, "\n"); i >= 0 {
+				s = s[i+1:]
+				continue
+			}
+			return ""
+		case strings.HasPrefix(s, "/*"):
+			if i := strings.Index(s, "*/"); i >= 0 {
+				s = s[i+2:]
+				continue
+			}
+			return ""
+		}
+		return s
+	}
+}
+
+func loadDataTable(abs string) (*dataTable, error) {
+	info, err := os.Stat(abs)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > dataQueryMaxFileBytes {
+		return nil, fmt.Errorf("file too large (%d bytes, max %d)", info.Size(), dataQueryMaxFileBytes)
+	}
+	b, err := os.ReadFile(abs)
+	if err != nil {
+		return nil, err
+	}
+	// Excel 导出的 CSV 常见 GBK / UTF-16，统一转成 UTF-8 再解析。
+	text, _ := decodeText(b, false)
+	b = []byte(text)
+
+	name := sqlTableName(strings.TrimSuffix(filepath.Base(abs), filepath.Ext(abs)))
+	switch strings.ToLower(filepath.Ext(abs)) {
+	case ".jsonl", ".ndjson":
+		return loadJSONLinesTable(name, b)
+	case ".json":
+		return loadJSONArrayTable(name, b)
+	case ".tsv":
+		return loadCSVTable(name, b, '\t')
+	default:
+		return loadCSVTable(name, b, sniffCSVDelimiter(b))
+	}
+}
+
+func sqlTableName(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		if r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			sb.WriteRune(r)
+		} else {
+			sb.WriteRune('_')
+		}
+	}
+	out := strings.Trim(sb.String(), "_")
+	if out == "" {
+		out = "t"
+	}
+	if unicode.IsDigit([]rune(out)[0]) {
+		out = "t_" + out
+	}
+	return out
+}
+
+func sniffCSVDelimiter(b []byte) rune {
+	line := b
+	if i := bytes.IndexByte(b, '\n'); i >= 0 {
+		line = b[:i]
+	}
+	best, bestN := ',', 0
+	for _, d := range []rune{',', ';', '\t', '|'} {
+		if n := strings.Count(string(line), string(d)); n > bestN {
+			best, bestN = d, n
+		}
+	}
+	return best
+}
+
+func loadCSVTable(name string, b []byte, delim rune) (*dataTable, error) {
+	r := csv.NewReader(bytes.NewReader(b))
+	r.Comma = delim
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	var records [][]string
+	maxRows := dataQueryMaxRows()
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+		if len(records) > maxRows {
+			return nil, fmt.Errorf("too many rows (max %d, set NIBOT_DATA_MAX_ROWS)", maxRows)
+		}
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("empty file")
+	}
+	width := 0
+	for _, rec := range records {
+		if len(rec) > width {
+			width = len(rec)
+		}
+	}
+
+	var header []string
+	data := records
+	if csvHasHeader(records) {
+		header = records[0]
+		data = records[1:]
+	}
+	cols := uniqueColumnNames(header, width)
+	raw := make([][]string, len(data))
+	for i, rec := range data {
+		row := make([]string, width)
+		copy(row, rec)
+		raw[i] = row
+	}
+	return buildTypedTable(name, cols, raw), nil
+}
+
+// csvHasHeader：第一行没有数字，而其余行中至少有一列是数字时视为表头；
+// 全是文本的表格默认第一行为表头（导出的表格基本都带表头）。
+func csvHasHeader(records [][]string) bool {
+	if len(records) < 2 {
+		return true
+	}
+	first := records[0]
+	for _, v := range first {
+		if _, ok := parseNumber(v); ok {
+			return false
+		}
+	}
+	seen := map[string]bool{}
+	for _, v := range first {
+		v = strings.TrimSpace(v)
+		if v != "" && seen[v] {
+			return false
+		}
+		seen[v] = true
+	}
+	return true
+}
+
+func uniqueColumnNames(header []string, width int) []string {
+	cols := make([]string, width)
+	used := map[string]bool{}
+	for i := 0; i < width; i++ {
+		c := ""
+		if i < len(header) {
+			c = strings.TrimSpace(header[i])
+		}
+		if c == "" {
+			c = fmt.Sprintf("c%d", i+1)
+		}
+		base := c
+		for n := 2; used[strings.ToLower(c)]; n++ {
+			c = fmt.Sprintf("%s_%d", base, n)
+		}
+		used[strings.ToLower(c)] = true
+		cols[i] = c
+	}
+	return cols
+}
+
+func parseNumber(s string) (any, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, false
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, true
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, true
+	}
+	return nil, false
+}
+
+func buildTypedTable(name string, cols []string, raw [][]string) *dataTable {
+	types := make([]string, len(cols))
+	for c := range cols {
+		typ := ""
+		for _, row := range raw {
+			v := strings.TrimSpace(row[c])
+			if v == "" {
+				continue
+			}
+			if _, err := strconv.ParseInt(v, 10, 64); err == nil {
+				if typ == "" {
+					typ = "INTEGER"
+				}
+				continue
+			}
+			if _, err := strconv.ParseFloat(v, 64); err == nil {
+				if typ == "" || typ == "INTEGER" {
+					typ = "REAL"
+				}
+				continue
+			}
+			typ = "TEXT"
+			break
+		}
+		if typ == "" {
+			typ = "TEXT"
+		}
+		types[c] = typ
+	}
+
+	rows := make([][]any, len(raw))
+	for i, row := range raw {
+		vals := make([]any, len(cols))
+		for c := range cols {
+			v := row[c]
+			switch {
+			case strings.TrimSpace(v) == "":
+				vals[c] = nil
+			case types[c] == "INTEGER":
+				vals[c], _ = strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			case types[c] == "REAL":
+				vals[c], _ = strconv.ParseFloat(strings.TrimSpace(v), 64)
+			default:
+				vals[c] = v
+			}
+		}
+		rows[i] = vals
+	}
+	return &dataTable{Name: name, Columns: cols, Types: types, Rows: rows}
+}
+
+func loadJSONLinesTable(name string, b []byte) (*dataTable, error) {
+	var objs []map[string]any
+	var order []string
+	seen := map[string]bool{}
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	lineNo := 0
+	maxRows := dataQueryMaxRows()
+	for sc.Scan() {
+		lineNo++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		var m map[string]any
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		objs = append(objs, m)
+		if len(objs) > maxRows {
+			return nil, fmt.Errorf("too many rows (max %d, set NIBOT_DATA_MAX_ROWS)", maxRows)
+		}
+		var keys []string
+		for k := range m {
+			if !seen[k] {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			seen[k] = true
+			order = append(order, k)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return jsonObjectsTable(name, objs, order)
+}
+
+func loadJSONArrayTable(name string, b []byte) (*dataTable, error) {
+	var objs []map[string]any
+	if err := json.Unmarshal(b, &objs); err != nil {
+		// 也接受 JSON Lines 内容但扩展名为 .json 的文件
+		return loadJSONLinesTable(name, b)
+	}
+	var order []string
+	seen := map[string]bool{}
+	for _, m := range objs {
+		var keys []string
+		for k := range m {
+			if !seen[k] {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			seen[k] = true
+			order = append(order, k)
+		}
+	}
+	return jsonObjectsTable(name, objs, order)
+}
+
+func jsonObjectsTable(name string, objs []map[string]any, keys []string) (*dataTable, error) {
+	if len(objs) == 0 || len(keys) == 0 {
+		return nil, fmt.Errorf("no JSON objects found")
+	}
+	cols := uniqueColumnNames(keys, len(keys))
+	raw := make([][]string, len(objs))
+	for i, m := range objs {
+		row := make([]string, len(keys))
+		for c, k := range keys {
+			switch v := m[k].(type) {
+			case nil:
+				row[c] = ""
+			case string:
+				row[c] = v
+			case float64:
+				row[c] = strconv.FormatFloat(v, 'f', -1, 64)
+			case bool:
+				if v {
+					row[c] = "1"
+				} else {
+					row[c] = "0"
+				}
+			default:
+				enc, _ := json.Marshal(v)
+				row[c] = string(enc)
+			}
+		}
+		raw[i] = row
+	}
+	return buildTypedTable(name, cols, raw), nil
+}
+
+func quoteSQLIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+func insertDataTable(db *sql.DB, t *dataTable) error {
+	var defs []string
+	for i, c := range t.Columns {
+		defs = append(defs, quoteSQLIdent(c)+" "+t.Types[i])
+	}
+	if _, err := db.Exec(fmt.Sprintf("CREATE TABLE %s (%s)", quoteSQLIdent(t.Name), strings.Join(defs, ", "))); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	ph := strings.TrimSuffix(strings.Repeat("?,", len(t.Columns)), ",")
+	stmt, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s VALUES (%s)", quoteSQLIdent(t.Name), ph))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, row := range t.Rows {
+		if _, err := stmt.Exec(row...); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func formatRowsMarkdown(rows *sql.Rows, limit int) (string, error) {
+	cols, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	sb.WriteString("| " + strings.Join(escapeMarkdownCells(cols), " | ") + " |\n")
+	sb.WriteString("|" + strings.Repeat(" --- |", len(cols)) + "\n")
+
+	total, shown := 0, 0
+	truncatedBytes := false
+	vals := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	for rows.Next() {
+		total++
+		if shown >= limit || truncatedBytes {
+			if total > 100000 {
+				break
+			}
+			continue
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return "", err
+		}
+		cells := make([]string, len(cols))
+		for i, v := range vals {
+			cells[i] = formatSQLValue(v)
+		}
+		line := "| " + strings.Join(escapeMarkdownCells(cells), " | ") + " |\n"
+		if sb.Len()+len(line) > dataQueryMaxOutBytes {
+			truncatedBytes = true
+			continue
+		}
+		sb.WriteString(line)
+		shown++
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	switch {
+	case total > 100000:
+		sb.WriteString(fmt.Sprintf("\n(showing %d of more than 100000 rows)", shown))
+	case shown < total:
+		sb.WriteString(fmt.Sprintf("\n(showing %d of %d rows)", shown, total))
+	default:
+		sb.WriteString(fmt.Sprintf("\n(%d rows)", total))
+	}
+	return sb.String(), nil
+}
+
+func formatSQLValue(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		return string(x)
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+func escapeMarkdownCells(cells []string) []string {
+	out := make([]string, len(cells))
+	for i, c := range cells {
+		c = strings.ReplaceAll(c, "\r\n", " ")
+		c = strings.ReplaceAll(c, "\n", " ")
+		c = strings.ReplaceAll(c, "|", `\|`)
+		if r := []rune(c); len(r) > dataQueryMaxCellRunes {
+			c = string(r[:dataQueryMaxCellRunes]) + "…"
+		}
+		out[i] = c
+	}
+	return out
+}
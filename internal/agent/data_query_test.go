@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeDataFile(t *testing.T, ws, rel, content string) {
+	t.Helper()
+	p := filepath.Join(ws, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDataQuery_CSVAggregate(t *testing.T) {
+	ws := t.TempDir()
+	writeDataFile(t, ws, "data/sales-2024.csv", "\xef\xbb\xbfregion;amount;note\nnorth;10;a\nsouth;2.5;\nnorth;5;x|y\n")
+	ctx := ExecContext{Workspace: ws, Policy: DefaultToolPolicy()}
+
+	out, err := toolDataQuery(ctx, `{"files":["data/sales-2024.csv"]}`)
+	if err != nil {
+		t.Fatalf("schema listing failed: %v", err)
+	}
+	if !strings.Contains(out, "sales_2024") || !strings.Contains(out, "amount REAL") || !strings.Contains(out, "region TEXT") {
+		t.Fatalf("unexpected schema: %q", out)
+	}
+
+	out, err = toolDataQuery(ctx, `{"files":["data/sales-2024.csv"],"sql":"SELECT region, SUM(amount) AS total, COUNT(note) AS notes FROM sales_2024 GROUP BY region ORDER BY region"}`)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if !strings.Contains(out, "| north | 15 | 2 |") || !strings.Contains(out, "| south | 2.5 | 0 |") || !strings.Contains(out, "(2 rows)") {
+		t.Fatalf("unexpected result: %q", out)
+	}
+
+	out, err = toolDataQuery(ctx, `{"files":["data/sales-2024.csv"],"sql":"SELECT note FROM sales_2024 WHERE note LIKE 'x%'"}`)
+	if err != nil || !strings.Contains(out, `x\|y`) {
+		t.Fatalf("expected escaped pipe, got out=%q err=%v", out, err)
+	}
+}
+
+func TestDataQuery_HeaderlessAndLimit(t *testing.T) {
+	ws := t.TempDir()
+	var sb strings.Builder
+	for i := 1; i <= 20; i++ {
+		sb.WriteString("1,2,3\n")
+	}
+	writeDataFile(t, ws, "nums.csv", sb.String())
+	ctx := ExecContext{Workspace: ws, Policy: DefaultToolPolicy()}
+
+	out, err := toolDataQuery(ctx, `{"files":["nums.csv"],"sql":"SELECT c1, c3 FROM nums","limit":5}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "| c1 | c3 |") || !strings.Contains(out, "(showing 5 of 20 rows)") {
+		t.Fatalf("unexpected output: %q", out)
+	}
+}
+
+func TestDataQuery_JSONLines(t *testing.T) {
+	ws := t.TempDir()
+	writeDataFile(t, ws, "events.jsonl", `{"user":"a","n":1,"tags":["x"]}
+{"user":"b","n":2,"ok":true}
+
+{"user":"a","n":3}
+`)
+	ctx := ExecContext{Workspace: ws, Policy: DefaultToolPolicy()}
+
+	out, err := toolDataQuery(ctx, `{"files":["events.jsonl"],"sql":"SELECT user, SUM(n) FROM events GROUP BY user ORDER BY user"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "| a | 4 |") || !strings.Contains(out, "| b | 2 |") {
+		t.Fatalf("unexpected output: %q", out)
+	}
+	out, err = toolDataQuery(ctx, `{"files":["events.jsonl"],"sql":"SELECT tags FROM events WHERE tags IS NOT NULL"}`)
+	if err != nil || !strings.Contains(out, `["x"]`) {
+		t.Fatalf("expected nested JSON text, got out=%q err=%v", out, err)
+	}
+}
+
+func TestDataQuery_ReadOnly(t *testing.T) {
+	ws := t.TempDir()
+	writeDataFile(t, ws, "t.csv", "a,b\n1,2\n")
+	ctx := ExecContext{Workspace: ws, Policy: DefaultToolPolicy()}
+
+	for _, q := range []string{
+		"DELETE FROM t",
+		"SELECT 1; DROP TABLE t",
+		"ATTACH DATABASE 'x.db' AS x",
+		"/* hi */ INSERT INTO t VALUES (3, 4)",
+	} {
+		if _, err := toolDataQuery(ctx, `{"files":["t.csv"],"sql":`+jsonString(q)+`}`); err == nil {
+			t.Fatalf("expected %q to be rejected", q)
+		}
+	}
+	if _, err := toolDataQuery(ctx, `{"files":["t.csv"],"sql":"WITH x AS (SELECT a FROM t) SELECT ';' FROM x;"}`); err != nil {
+		t.Fatalf("expected WITH query to be allowed: %v", err)
+	}
+	if _, err := toolDataQuery(ctx, `{"files":["../outside.csv"],"sql":"SELECT 1"}`); err == nil {
+		t.Fatalf("expected path outside workspace to be rejected")
+	}
+}
+
+func jsonString(s string) string {
+	return `"` + strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), `"`, `\"`) + `"`
+}
@@ -0,0 +1,199 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// agent.delegate 把一个子任务交给子代理：子代理使用同一个模型配置（可换模型）和系统提示，
+// 但有独立的对话历史、只读的工具策略以及更小的迭代 / token 预算，父对话只拿到它的最终总结。
+// 子代理的完整记录写入 logs/delegate/，路径附在工具输出里，随工具结果一起进入会话日志。
+
+type delegateArgs struct {
+	Task      string `json:"task"`
+	Context   string `json:"context"`
+	Model     string `json:"model"`
+	MaxIters  int    `json:"max_iters"`
+	MaxTokens int    `json:"max_tokens"`
+}
+
+func delegateMaxIters() int {
+	return parseIntEnv("NIBOT_DELEGATE_MAX_ITERS", 4, 1, 20)
+}
+
+func delegateMaxTokens() int {
+	return parseIntEnv("NIBOT_DELEGATE_MAX_TOKENS", 30000, 1000, 1000000)
+}
+
+// delegatePolicy 在父对话策略的基础上禁用所有写操作；子代理因此也不能再委派。
+func delegatePolicy(p ToolPolicy) ToolPolicy {
+	if !p.Loaded {
+		p = DefaultToolPolicy()
+	}
+	p.ReadOnly = true
+	return p
+}
+
+const delegateSystemNote = "## Sub-agent mode\n" +
+	"You are a sub-agent working on one task delegated by another agent. You only have read-only tools and a small budget. " +
+	"Look up only what the task needs, then reply with a concise, self-contained summary: findings, relevant file paths, and anything you could not verify. " +
+	"Do not ask questions back; your final reply is returned to the delegating agent as-is.\n"
+
+const delegateWrapUp = "Your tool budget for this task is used up. Do not call any more tools. Reply now with your summary of what you found so far and what is still unknown."
+
+var delegateSeq atomic.Int64
+
+func toolAgentDelegate(ctx ExecContext, argsRaw string) (string, error) {
+	if !strings.HasPrefix(strings.TrimSpace(argsRaw), "{") {
+		return "", fmt.Errorf("agent.delegate requires JSON args: {\"task\":\"...\"}")
+	}
+	var a delegateArgs
+	if err := json.Unmarshal([]byte(argsRaw), &a); err != nil {
+		return "", fmt.Errorf("invalid JSON args for agent.delegate: %w", err)
+	}
+	a.Task = strings.TrimSpace(a.Task)
+	if a.Task == "" {
+		return "", fmt.Errorf("task is required")
+	}
+	parent := ctx.Client
+	if parent == nil {
+		return "", fmt.Errorf("agent.delegate is only available inside a conversation")
+	}
+
+	iters := delegateMaxIters()
+	if a.MaxIters > 0 && a.MaxIters < iters {
+		iters = a.MaxIters
+	}
+	budget := delegateMaxTokens()
+	if a.MaxTokens > 0 && a.MaxTokens < budget {
+		budget = a.MaxTokens
+	}
+
+	cfg := parent.Config
+	cfg.Policy = delegatePolicy(ctx.Policy)
+	if m := firstNonEmpty(strings.TrimSpace(a.Model), strings.TrimSpace(os.Getenv("NIBOT_DELEGATE_MODEL"))); m != "" {
+		cfg.ModelName = m
+	}
+	parent.mu.RLock()
+	systemMsg := parent.SystemMsg
+	parent.mu.RUnlock()
+
+	child := NewLLMClient(cfg, ctx.Workspace, strings.TrimRight(systemMsg, "\n")+"\n\n"+delegateSystemNote, nil)
+	child.MaxToolIters = iters
+	child.TokenBudget = budget
+	child.Origin = ctx.Origin
+
+	prompt := a.Task
+	if c := strings.TrimSpace(a.Context); c != "" {
+		prompt += "\n\nContext from the delegating agent:\n" + c
+	}
+	started := time.Now()
+	summary, err := child.Chat(prompt)
+	if err == nil && len(child.pendingToolCalls) > 0 {
+		// 预算用完时还有未执行的调用：再请求一次不带工具的总结
+		child.pendingToolCalls = nil
+		child.History = append(child.History, Message{Role: "user", Content: delegateWrapUp})
+		summary, err = child.chatTurn(child.SystemMsg, delegateWrapUp)
+		if err == nil {
+			child.History = append(child.History, Message{Role: "assistant", Content: redactSecrets(summary)})
+		}
+	}
+
+	calls := 0
+	for _, m := range child.History {
+		if m.Role == "assistant" {
+			calls++
+		}
+	}
+	stats := fmt.Sprintf("model %s, %d model call(s), ~%d/%d tokens, %s", firstNonEmpty(cfg.ModelName, "(default)"), calls, child.tokensUsed, budget, time.Since(started).Round(time.Millisecond))
+	transcript, terr := writeDelegateTranscript(ctx, a, child, stats, err)
+	if terr != nil {
+		transcript = "(not written: " + terr.Error() + ")"
+	}
+	if err != nil {
+		return "", fmt.Errorf("sub-agent failed: %v (transcript: %s)", err, transcript)
+	}
+	summary = strings.TrimSpace(redactSecrets(summary))
+	if summary == "" {
+		summary = "(the sub-agent returned an empty summary)"
+	}
+	return fmt.Sprintf("%s\n\n[sub-agent: %s; transcript: %s]", summary, stats, transcript), nil
+}
+
+// writeDelegateTranscript 把子代理的完整对话写成 Markdown，格式与会话日志一致；log level 为 meta 时只记录长度。
+func writeDelegateTranscript(ctx ExecContext, a delegateArgs, child *LLMClient, stats string, runErr error) (string, error) {
+	dir := filepath.Join(ctx.Workspace, "logs", "delegate")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	parent := firstNonEmpty(slugify(ctx.Session), "session")
+	name := fmt.Sprintf("%s_%s_%d.md", time.Now().Format("20060102_150405"), parent, delegateSeq.Add(1))
+	meta := normalizeLogLevel(ctx.LogLevel) == "meta"
+	body := func(s string) string {
+		if meta {
+			return fmt.Sprintf("(%d bytes)", len(s))
+		}
+		return s
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# Delegated Task\n\n**Parent session**: %s\n**Stats**: %s\n**Tool iterations**: %d\n\n", firstNonEmpty(ctx.Session, "-"), stats, child.MaxToolIters)
+	fmt.Fprintf(&sb, "## Task\n\n%s\n", body(redactSecrets(a.Task)))
+	if strings.TrimSpace(a.Context) != "" {
+		fmt.Fprintf(&sb, "\n## Context\n\n%s\n", body(redactSecrets(a.Context)))
+	}
+	sb.WriteString("\n---\n")
+	for _, m := range child.History {
+		switch {
+		case m.Role == "assistant":
+			fmt.Fprintf(&sb, "\n### Sub-agent:\n%s\n", body(m.Content))
+		case strings.HasPrefix(m.Content, "TOOL_RESULTS:"):
+			fmt.Fprintf(&sb, "\n### Tool Results\n%s\n", body(m.Content))
+		default:
+			fmt.Fprintf(&sb, "\n### User:\n%s\n", body(m.Content))
+		}
+	}
+	if runErr != nil {
+		fmt.Fprintf(&sb, "\n**Error**: %v\n", runErr)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(sb.String()), 0o644); err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(filepath.Join("logs", "delegate", name)), nil
+}
+
+// countTokens 累计一次模型调用消耗的 token：接口返回了 usage 就用它，否则按输入输出长度估算。
+func (c *LLMClient) countTokens(messages []Message, response string) {
+	if c.lastUsage > 0 {
+		c.tokensUsed += c.lastUsage
+		return
+	}
+	n := estimateTokens(response)
+	for _, m := range messages {
+		n += estimateTokens(m.Content) + 4
+	}
+	c.tokensUsed += n
+}
+
+func (c *LLMClient) tokenBudgetSpent() bool {
+	return c.TokenBudget > 0 && c.tokensUsed >= c.TokenBudget
+}
+
+// estimateTokens 粗略估算：ASCII 约 4 字节一个 token，其他字符（如中文）按一字一个 token。
+func estimateTokens(s string) int {
+	ascii, other := 0, 0
+	for _, r := range s {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return (ascii+3)/4 + other
+}
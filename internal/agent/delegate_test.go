@@ -0,0 +1,145 @@
+package agent
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// delegateChatServer 按请求顺序返回 replies，并记录每次请求使用的模型和最后一条消息。
+func delegateChatServer(t *testing.T, replies []string) (*httptest.Server, *[]openAIRequest) {
+	t.Helper()
+	var mu sync.Mutex
+	var reqs []openAIRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		var req openAIRequest
+		_ = json.Unmarshal(b, &req)
+		mu.Lock()
+		reqs = append(reqs, req)
+		content := "done"
+		if len(reqs) <= len(replies) {
+			content = replies[len(reqs)-1]
+		}
+		mu.Unlock()
+		out, _ := json.Marshal(map[string]any{
+			"choices": []any{map[string]any{"message": map[string]any{"role": "assistant", "content": content}}},
+			"usage":   map[string]any{"total_tokens": 1500},
+		})
+		_, _ = w.Write(out)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &reqs
+}
+
+func TestAgentDelegate_ReturnsSummaryOnly(t *testing.T) {
+	ws := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(ws, "memory"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(ws, "memory", "notes.md"), []byte("deploy with make release"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	srv, reqs := delegateChatServer(t, []string{
+		`[EXEC:agent.delegate {"task":"How do we deploy?","model":"small-model"}]`,
+		`[EXEC:fs.read {"path":"memory/notes.md"}]`,
+		`[EXEC:fs.write {"path":"memory/x.md","content":"x"}]`,
+		"Deploy with make release (memory/notes.md).",
+		"done",
+	})
+	c := NewLLMClient(Config{Provider: "openai", BaseURL: srv.URL, APIKey: "x", ModelName: "big-model"}, ws, "sys", nil)
+
+	out, err := c.Chat("find out how we deploy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "done" || len(*reqs) != 5 {
+		t.Fatalf("unexpected result %q after %d requests", out, len(*reqs))
+	}
+	models := []string{}
+	for _, r := range *reqs {
+		models = append(models, r.Model)
+	}
+	if strings.Join(models, ",") != "big-model,small-model,small-model,small-model,big-model" {
+		t.Fatalf("unexpected models: %v", models)
+	}
+	if !strings.Contains((*reqs)[1].Messages[0].Content, "Sub-agent mode") || len((*reqs)[1].Messages) != 2 {
+		t.Fatalf("child should start with its own history: %+v", (*reqs)[1].Messages)
+	}
+
+	var toolOut string
+	for _, m := range c.History {
+		if strings.Contains(m.Content, "memory/notes.md\"}]") {
+			t.Fatalf("child transcript leaked into parent history: %q", m.Content)
+		}
+		if strings.HasPrefix(m.Content, "TOOL_RESULTS") {
+			toolOut = m.Content
+		}
+	}
+	if !strings.Contains(toolOut, "Deploy with make release") || !strings.Contains(toolOut, "transcript: logs/delegate/") {
+		t.Fatalf("unexpected tool output: %q", toolOut)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(ws, "logs", "delegate", "*.md"))
+	if len(files) != 1 {
+		t.Fatalf("expected one transcript, got %v", files)
+	}
+	b, _ := os.ReadFile(files[0])
+	if !strings.Contains(string(b), "How do we deploy?") || !strings.Contains(string(b), "deploy with make release") || !strings.Contains(string(b), "disabled by policy") {
+		t.Fatalf("unexpected transcript:\n%s", b)
+	}
+	if _, err := os.Stat(filepath.Join(ws, "memory", "x.md")); err == nil {
+		t.Fatalf("sub-agent must not write files")
+	}
+}
+
+func TestAgentDelegate_BudgetAndPolicy(t *testing.T) {
+	ws := t.TempDir()
+	srv, reqs := delegateChatServer(t, []string{
+		`[EXEC:fs.read {"path":"a.md"}]`,
+		`[EXEC:fs.read {"path":"b.md"}]`,
+		"Partial summary: a.md is missing.",
+	})
+	parent := NewLLMClient(Config{Provider: "openai", BaseURL: srv.URL, APIKey: "x", ModelName: "m"}, ws, "sys", nil)
+	ctx := parent.execContext()
+
+	// 每次调用 1500 token，预算 2000：第二次调用后停止，再请求一次总结
+	out, err := toolAgentDelegate(ctx, `{"task":"check files","max_tokens":2000,"max_iters":10}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*reqs) != 3 || !strings.HasPrefix(out, "Partial summary: a.md is missing.") || !strings.Contains(out, "~4500/2000 tokens") {
+		t.Fatalf("unexpected result after %d requests: %q", len(*reqs), out)
+	}
+	last := (*reqs)[2].Messages
+	if last[len(last)-1].Content != delegateWrapUp {
+		t.Fatalf("expected a wrap-up request, got %q", last[len(last)-1].Content)
+	}
+
+	p := delegatePolicy(DefaultToolPolicy())
+	for _, tool := range []string{"fs.write", "runtime.exec", "memory.store", "git.commit", "schedule.create", "notify.send", "agent.delegate"} {
+		if p.AllowsTool(tool) {
+			t.Errorf("%s should be disabled for sub-agents", tool)
+		}
+	}
+	for _, tool := range []string{"fs.read", "memory.recall", "git.diff", "data.query"} {
+		if !p.AllowsTool(tool) {
+			t.Errorf("%s should stay available for sub-agents", tool)
+		}
+	}
+
+	ctx.Policy = DefaultToolPolicy()
+	ctx.Policy.AllowDelegate = false
+	if res := ExecuteCalls(ctx, []ExecCall{{Tool: "agent.delegate", ArgsRaw: `{"task":"x"}`}}, nil); res[0].Error != "disabled by policy" {
+		t.Fatalf("expected policy denial: %+v", res)
+	}
+	if _, err := toolAgentDelegate(ExecContext{Workspace: ws}, `{"task":"x"}`); err == nil {
+		t.Fatalf("expected error without a parent conversation")
+	}
+}
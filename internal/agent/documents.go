@@ -0,0 +1,552 @@
+package agent
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// fs.read 遇到 PDF / DOCX / XLSX 时不返回原始字节，而是抽取其中的文本。
+// 仅依赖标准库：DOCX/XLSX 解析 zip 中的 XML，PDF 使用下面的简易解析器（支持 FlateDecode 与 ToUnicode）。
+
+const (
+	docMaxFileBytes  = 50 * 1024 * 1024
+	docMaxEntryBytes = 64 * 1024 * 1024
+)
+
+type docExtractOptions struct {
+	Pages string
+	Sheet string
+}
+
+// rawArgString 允许参数既可以写成字符串也可以写成数字，例如 "pages":2 或 "pages":"1-3"。
+func rawArgString(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return strings.TrimSpace(s)
+	}
+	return strings.TrimSpace(string(raw))
+}
+
+// documentKind 根据文件头判断文档类型，返回 "pdf" / "docx" / "xlsx"，普通文件返回空串。
+func documentKind(abs string, head []byte) string {
+	if bytes.HasPrefix(head, []byte("%PDF-")) {
+		return "pdf"
+	}
+	if !bytes.HasPrefix(head, []byte("PK\x03\x04")) {
+		return ""
+	}
+	zr, err := zip.OpenReader(abs)
+	if err != nil {
+		return ""
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		switch f.Name {
+		case "word/document.xml":
+			return "docx"
+		case "xl/workbook.xml":
+			return "xlsx"
+		}
+	}
+	return ""
+}
+
+func extractDocumentText(abs string, kind string, opt docExtractOptions) (string, error) {
+	info, err := os.Stat(abs)
+	if err != nil {
+		return "", err
+	}
+	if info.Size() > docMaxFileBytes {
+		return "", fmt.Errorf("document too large (%d bytes, max %d)", info.Size(), docMaxFileBytes)
+	}
+	var header, text string
+	switch kind {
+	case "pdf":
+		header, text, err = extractPDFText(abs, opt.Pages)
+	case "docx":
+		header, text, err = extractDOCXText(abs)
+		if opt.Pages != "" {
+			header += ", pages ignored: docx has no fixed page layout"
+		}
+	case "xlsx":
+		header, text, err = extractXLSXText(abs, opt.Sheet)
+	default:
+		return "", fmt.Errorf("unsupported document type")
+	}
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(text) == "" {
+		if kind == "pdf" {
+			return "", fmt.Errorf("no text found (scanned or image-only PDF?)")
+		}
+		return "", fmt.Errorf("no text found")
+	}
+	return "[" + header + "]\n" + text, nil
+}
+
+func readZipEntry(zr *zip.ReadCloser, name string) ([]byte, error) {
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		b, err := io.ReadAll(io.LimitReader(rc, docMaxEntryBytes+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(b) > docMaxEntryBytes {
+			return nil, fmt.Errorf("%s too large after decompression", name)
+		}
+		return b, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+func extractDOCXText(abs string) (string, string, error) {
+	zr, err := zip.OpenReader(abs)
+	if err != nil {
+		return "", "", err
+	}
+	defer zr.Close()
+	b, err := readZipEntry(zr, "word/document.xml")
+	if err != nil {
+		return "", "", err
+	}
+
+	var sb strings.Builder
+	dec := xml.NewDecoder(bytes.NewReader(b))
+	inText, cellDepth := false, 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", "", fmt.Errorf("invalid word/document.xml: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				sb.WriteString("\t")
+			case "br", "cr":
+				sb.WriteString("\n")
+			case "tc":
+				cellDepth++
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				if cellDepth > 0 {
+					sb.WriteString(" ")
+				} else {
+					sb.WriteString("\n")
+				}
+			case "tc":
+				cellDepth--
+				sb.WriteString("\t")
+			case "tr":
+				sb.WriteString("\n")
+			}
+		case xml.CharData:
+			if inText {
+				sb.Write(t)
+			}
+		}
+	}
+	return "docx", cleanExtractedText(sb.String()), nil
+}
+
+type xlsxSheet struct {
+	Name string
+	Path string
+}
+
+func extractXLSXText(abs string, sheetSel string) (string, string, error) {
+	zr, err := zip.OpenReader(abs)
+	if err != nil {
+		return "", "", err
+	}
+	defer zr.Close()
+
+	sheets, err := xlsxSheets(zr)
+	if err != nil {
+		return "", "", err
+	}
+	if len(sheets) == 0 {
+		return "", "", fmt.Errorf("workbook has no sheets")
+	}
+	selected, err := selectXLSXSheets(sheets, sheetSel)
+	if err != nil {
+		return "", "", err
+	}
+	var shared []string
+	if b, err := readZipEntry(zr, "xl/sharedStrings.xml"); err == nil {
+		shared = xlsxSharedStrings(b)
+	}
+
+	var names []string
+	var sb strings.Builder
+	for _, s := range selected {
+		names = append(names, s.Name)
+		b, err := readZipEntry(zr, s.Path)
+		if err != nil {
+			return "", "", fmt.Errorf("sheet %s: %w", s.Name, err)
+		}
+		sb.WriteString("## Sheet: " + s.Name + "\n")
+		sb.WriteString(xlsxSheetText(b, shared))
+		sb.WriteString("\n")
+	}
+	var all []string
+	for _, s := range sheets {
+		all = append(all, s.Name)
+	}
+	header := fmt.Sprintf("xlsx: sheets %s of %s", strings.Join(names, ", "), strings.Join(all, ", "))
+	return header, strings.TrimRight(sb.String(), "\n"), nil
+}
+
+func xlsxSheets(zr *zip.ReadCloser) ([]xlsxSheet, error) {
+	wb, err := readZipEntry(zr, "xl/workbook.xml")
+	if err != nil {
+		return nil, err
+	}
+	targets := map[string]string{}
+	if rels, err := readZipEntry(zr, "xl/_rels/workbook.xml.rels"); err == nil {
+		var r struct {
+			Rels []struct {
+				ID     string `xml:"Id,attr"`
+				Target string `xml:"Target,attr"`
+			} `xml:"Relationship"`
+		}
+		if xml.Unmarshal(rels, &r) == nil {
+			for _, rel := range r.Rels {
+				t := strings.TrimPrefix(rel.Target, "/")
+				if !strings.HasPrefix(t, "xl/") {
+					t = "xl/" + t
+				}
+				targets[rel.ID] = t
+			}
+		}
+	}
+
+	var w struct {
+		Sheets []struct {
+			Name  string     `xml:"name,attr"`
+			Attrs []xml.Attr `xml:",any,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := xml.Unmarshal(wb, &w); err != nil {
+		return nil, fmt.Errorf("invalid xl/workbook.xml: %w", err)
+	}
+	var out []xlsxSheet
+	for i, s := range w.Sheets {
+		path := ""
+		for _, a := range s.Attrs {
+			if a.Name.Local == "id" {
+				path = targets[a.Value]
+			}
+		}
+		if path == "" {
+			path = fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1)
+		}
+		out = append(out, xlsxSheet{Name: s.Name, Path: path})
+	}
+	return out, nil
+}
+
+// selectXLSXSheets 支持按名称（不区分大小写）或从 1 开始的序号选择，多个用逗号分隔。
+func selectXLSXSheets(sheets []xlsxSheet, sel string) ([]xlsxSheet, error) {
+	if strings.TrimSpace(sel) == "" {
+		return sheets, nil
+	}
+	var out []xlsxSheet
+	for _, part := range splitCSV(sel) {
+		found := false
+		for _, s := range sheets {
+			if strings.EqualFold(s.Name, part) {
+				out = append(out, s)
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		if n, err := strconv.Atoi(part); err == nil && n >= 1 && n <= len(sheets) {
+			out = append(out, sheets[n-1])
+			continue
+		}
+		var names []string
+		for _, s := range sheets {
+			names = append(names, s.Name)
+		}
+		return nil, fmt.Errorf("sheet not found: %s (available: %s)", part, strings.Join(names, ", "))
+	}
+	return out, nil
+}
+
+func xlsxSharedStrings(b []byte) []string {
+	var out []string
+	var sb strings.Builder
+	dec := xml.NewDecoder(bytes.NewReader(b))
+	inText, phonetic := false, 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "si":
+				sb.Reset()
+			case "t":
+				inText = true
+			case "rPh":
+				phonetic++
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "si":
+				out = append(out, sb.String())
+			case "t":
+				inText = false
+			case "rPh":
+				phonetic--
+			}
+		case xml.CharData:
+			if inText && phonetic == 0 {
+				sb.Write(t)
+			}
+		}
+	}
+	return out
+}
+
+// xlsxColumnIndex 把 "C12" 这样的单元格引用转换为从 0 开始的列号。
+func xlsxColumnIndex(ref string) int {
+	n := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		n = n*26 + int(r-'A') + 1
+	}
+	return n - 1
+}
+
+func xlsxSheetText(b []byte, shared []string) string {
+	var sb strings.Builder
+	dec := xml.NewDecoder(bytes.NewReader(b))
+	var row []string
+	var cellType, cellRef string
+	var value strings.Builder
+	inValue := false
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "row":
+				row = row[:0]
+			case "c":
+				cellType, cellRef = "", ""
+				for _, a := range t.Attr {
+					switch a.Name.Local {
+					case "t":
+						cellType = a.Value
+					case "r":
+						cellRef = a.Value
+					}
+				}
+				value.Reset()
+			case "v", "t":
+				inValue = true
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "v", "t":
+				inValue = false
+			case "c":
+				v := value.String()
+				switch cellType {
+				case "s":
+					if i, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && i >= 0 && i < len(shared) {
+						v = shared[i]
+					}
+				case "b":
+					if strings.TrimSpace(v) == "1" {
+						v = "TRUE"
+					} else {
+						v = "FALSE"
+					}
+				}
+				col := len(row)
+				if cellRef != "" {
+					if c := xlsxColumnIndex(cellRef); c >= len(row) && c < 16384 {
+						col = c
+					}
+				}
+				for len(row) < col {
+					row = append(row, "")
+				}
+				row = append(row, strings.ReplaceAll(strings.ReplaceAll(v, "\t", " "), "\n", " "))
+			case "row":
+				line := strings.TrimRight(strings.Join(row, "\t"), "\t")
+				if strings.TrimSpace(line) != "" {
+					sb.WriteString(line + "\n")
+				}
+			}
+		case xml.CharData:
+			if inValue {
+				value.Write(t)
+			}
+		}
+	}
+	return sb.String()
+}
+
+func extractPDFText(abs string, pageSpec string) (string, string, error) {
+	b, err := os.ReadFile(abs)
+	if err != nil {
+		return "", "", err
+	}
+	doc := parsePDF(b)
+	if doc.encrypted() {
+		return "", "", fmt.Errorf("encrypted PDF is not supported")
+	}
+	pages := doc.pages()
+	if len(pages) == 0 {
+		return "", "", fmt.Errorf("no pages found (damaged PDF?)")
+	}
+	nums, err := parsePageSpec(pageSpec, len(pages))
+	if err != nil {
+		return "", "", err
+	}
+
+	var sb strings.Builder
+	for _, n := range nums {
+		text := doc.pageText(pages[n-1])
+		if len(nums) > 1 {
+			sb.WriteString(fmt.Sprintf("--- page %d ---\n", n))
+		}
+		sb.WriteString(text)
+		sb.WriteString("\n")
+	}
+	header := fmt.Sprintf("pdf: %d pages", len(pages))
+	if strings.TrimSpace(pageSpec) != "" {
+		header = fmt.Sprintf("pdf: pages %s of %d", strings.TrimSpace(pageSpec), len(pages))
+	}
+	out := sb.String()
+	if !pdfHasText(out) {
+		out = ""
+	}
+	return header, strings.TrimRight(out, "\n"), nil
+}
+
+func pdfHasText(s string) bool {
+	for _, line := range strings.Split(s, "\n") {
+		if strings.HasPrefix(line, "--- page ") {
+			continue
+		}
+		if strings.TrimSpace(line) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePageSpec 解析 "1-3,5" 形式的页码（从 1 开始），空串表示全部页。
+func parsePageSpec(spec string, total int) ([]int, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		out := make([]int, total)
+		for i := range out {
+			out[i] = i + 1
+		}
+		return out, nil
+	}
+	seen := map[int]bool{}
+	var out []int
+	for _, part := range splitCSV(spec) {
+		lo, hi := part, part
+		if a, b, ok := strings.Cut(part, "-"); ok {
+			lo, hi = strings.TrimSpace(a), strings.TrimSpace(b)
+		}
+		from, err1 := strconv.Atoi(lo)
+		to, err2 := strconv.Atoi(hi)
+		if hi == "" {
+			to, err2 = total, nil
+		}
+		if err1 != nil || err2 != nil || from < 1 || to < from {
+			return nil, fmt.Errorf("invalid pages: %q (use e.g. \"1-3,5\")", spec)
+		}
+		if to > total {
+			return nil, fmt.Errorf("pages out of range: %q (document has %d pages)", spec, total)
+		}
+		for p := from; p <= to; p++ {
+			if !seen[p] {
+				seen[p] = true
+				out = append(out, p)
+			}
+		}
+	}
+	sort.Ints(out)
+	return out, nil
+}
+
+// cleanExtractedText 去掉行尾空白并合并连续空行。
+func cleanExtractedText(s string) string {
+	lines := strings.Split(normalizeNewlines(s), "\n")
+	var out []string
+	blank := 0
+	for _, l := range lines {
+		l = strings.TrimRight(l, " \t")
+		if strings.TrimSpace(l) == "" {
+			blank++
+			if blank > 1 {
+				continue
+			}
+			l = ""
+		} else {
+			blank = 0
+		}
+		out = append(out, l)
+	}
+	return strings.Trim(strings.Join(out, "\n"), "\n")
+}
+
+// truncateUTF8 按字节截断，但不切断多字节字符。
+func truncateUTF8(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
+}
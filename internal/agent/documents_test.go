@@ -0,0 +1,199 @@
+package agent
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// buildTestPDF 生成一个最小 PDF：objs[i] 为第 i+1 号对象的内容，streams 中的对象以 FlateDecode 流写入。
+func buildTestPDF(objs []string, streams map[int]string, trailerExtra string) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.5\n")
+	for i, body := range objs {
+		num := i + 1
+		fmt.Fprintf(&b, "%d 0 obj\n", num)
+		if content, ok := streams[num]; ok {
+			var z bytes.Buffer
+			zw := zlib.NewWriter(&z)
+			zw.Write([]byte(content))
+			zw.Close()
+			fmt.Fprintf(&b, "<< %s /Length %d /Filter /FlateDecode >>\nstream\n", body, z.Len())
+			b.Write(z.Bytes())
+			b.WriteString("\nendstream\n")
+		} else {
+			b.WriteString(body + "\n")
+		}
+		b.WriteString("endobj\n")
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R %s >>\n%%%%EOF\n", len(objs)+1, trailerExtra)
+	return b.Bytes()
+}
+
+func writeTestZip(t *testing.T, path string, files map[string]string) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFSRead_PDFPages(t *testing.T) {
+	ws := t.TempDir()
+	pdf := buildTestPDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 /Resources << /Font << /F1 5 0 R >> >> >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 6 0 R >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 7 0 R >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+		"",
+		"",
+	}, map[int]string{
+		6: "BT /F1 12 Tf 72 720 Td (Quarterly report) Tj 0 -14 Td [(Revenue ) -300 (grew \\(12%\\))] TJ ET",
+		7: "BT /F1 12 Tf 72 720 Td (Second page) Tj ET",
+	}, "")
+	if err := os.WriteFile(filepath.Join(ws, "report.pdf"), pdf, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := ExecContext{Workspace: ws, Policy: DefaultToolPolicy()}
+
+	out, err := toolFSRead(ctx, `{"path":"report.pdf"}`)
+	if err != nil {
+		t.Fatalf("fs.read pdf failed: %v", err)
+	}
+	if !strings.Contains(out, "[pdf: 2 pages]") || !strings.Contains(out, "Quarterly report\nRevenue grew (12%)") || !strings.Contains(out, "--- page 2 ---\nSecond page") {
+		t.Fatalf("unexpected pdf text: %q", out)
+	}
+
+	out, err = toolFSRead(ctx, `{"path":"report.pdf","pages":2}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "Quarterly") || !strings.Contains(out, "Second page") {
+		t.Fatalf("page selection ignored: %q", out)
+	}
+	if _, err := toolFSRead(ctx, `{"path":"report.pdf","pages":"3-4"}`); err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Fatalf("expected out of range error, got %v", err)
+	}
+}
+
+func TestFSRead_PDFToUnicode(t *testing.T) {
+	ws := t.TempDir()
+	cmap := "/CIDInit /ProcSet findresource begin 12 dict begin begincmap\n" +
+		"1 begincodespacerange <0000> <FFFF> endcodespacerange\n" +
+		"2 beginbfchar <0001> <4F60> <0002> <597D> endbfchar\n" +
+		"1 beginbfrange <0010> <0012> <0041> endbfrange\n" +
+		"endcmap CMapName currentdict /CMap defineresource pop end end"
+	pdf := buildTestPDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /Resources << /Font << /F1 4 0 R >> >> /Contents 5 0 R >>",
+		"<< /Type /Font /Subtype /Type0 /BaseFont /SimSun /Encoding /Identity-H /ToUnicode 6 0 R >>",
+		"",
+		"",
+	}, map[int]string{
+		5: "BT /F1 12 Tf 1 0 0 1 72 720 Tm <00010002> Tj 1 0 0 1 72 700 Tm <001000110012> Tj ET",
+		6: cmap,
+	}, "")
+	if err := os.WriteFile(filepath.Join(ws, "cn.pdf"), pdf, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := toolFSRead(ExecContext{Workspace: ws, Policy: DefaultToolPolicy()}, `{"path":"cn.pdf"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "你好\nABC") {
+		t.Fatalf("unexpected text: %q", out)
+	}
+}
+
+func TestFSRead_PDFWithoutTextReportsClearly(t *testing.T) {
+	ws := t.TempDir()
+	pdf := buildTestPDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 4 0 R >>",
+		"",
+	}, map[int]string{4: "q 100 0 0 100 0 0 cm /Im1 Do Q"}, "")
+	os.WriteFile(filepath.Join(ws, "scan.pdf"), pdf, 0o644)
+	enc := buildTestPDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [] /Count 0 >>",
+	}, nil, "/Encrypt << /Filter /Standard >>")
+	os.WriteFile(filepath.Join(ws, "enc.pdf"), enc, 0o644)
+	ctx := ExecContext{Workspace: ws, Policy: DefaultToolPolicy()}
+
+	if _, err := toolFSRead(ctx, `{"path":"scan.pdf"}`); err == nil || !strings.Contains(err.Error(), "could not extract text") || !strings.Contains(err.Error(), "image-only") {
+		t.Fatalf("expected clear extraction error, got %v", err)
+	}
+	if _, err := toolFSRead(ctx, `{"path":"enc.pdf"}`); err == nil || !strings.Contains(err.Error(), "encrypted") {
+		t.Fatalf("expected encrypted error, got %v", err)
+	}
+}
+
+func TestFSRead_DOCX(t *testing.T) {
+	ws := t.TempDir()
+	doc := `<?xml version="1.0" encoding="UTF-8"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>
+<w:p><w:r><w:t>Meeting notes</w:t></w:r></w:p>
+<w:p><w:r><w:t xml:space="preserve">Owner: </w:t></w:r><w:r><w:t>Alice</w:t></w:r></w:p>
+<w:tbl><w:tr><w:tc><w:p><w:r><w:t>Item</w:t></w:r></w:p></w:tc><w:tc><w:p><w:r><w:t>Due</w:t></w:r></w:p></w:tc></w:tr></w:tbl>
+</w:body></w:document>`
+	writeTestZip(t, filepath.Join(ws, "notes.docx"), map[string]string{"word/document.xml": doc, "[Content_Types].xml": "<Types/>"})
+
+	out, err := toolFSRead(ExecContext{Workspace: ws, Policy: DefaultToolPolicy()}, `{"path":"notes.docx"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "[docx]") || !strings.Contains(out, "Meeting notes\nOwner: Alice") || !strings.Contains(out, "Item") || !strings.Contains(out, "Due") {
+		t.Fatalf("unexpected docx text: %q", out)
+	}
+}
+
+func TestFSRead_XLSXSheets(t *testing.T) {
+	ws := t.TempDir()
+	writeTestZip(t, filepath.Join(ws, "book.xlsx"), map[string]string{
+		"xl/workbook.xml": `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="Sales" sheetId="1" r:id="rId1"/><sheet name="Notes" sheetId="2" r:id="rId2"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships><Relationship Id="rId1" Target="worksheets/sheet1.xml"/><Relationship Id="rId2" Target="/xl/worksheets/other.xml"/></Relationships>`,
+		"xl/sharedStrings.xml":       `<sst><si><t>region</t></si><si><t>amount</t></si><si><r><t>no</t></r><r><t>rth</t></r></si></sst>`,
+		"xl/worksheets/sheet1.xml": `<worksheet><sheetData>
+<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c></row>
+<row r="2"><c r="A2" t="s"><v>2</v></c><c r="C2"><v>42.5</v></c></row>
+</sheetData></worksheet>`,
+		"xl/worksheets/other.xml": `<worksheet><sheetData><row r="1"><c r="A1" t="inlineStr"><is><t>remember</t></is></c><c r="B1" t="b"><v>1</v></c></row></sheetData></worksheet>`,
+	})
+	ctx := ExecContext{Workspace: ws, Policy: DefaultToolPolicy()}
+
+	out, err := toolFSRead(ctx, `{"path":"book.xlsx"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "## Sheet: Sales\nregion\tamount\nnorth\t\t42.5") || !strings.Contains(out, "## Sheet: Notes\nremember\tTRUE") {
+		t.Fatalf("unexpected xlsx text: %q", out)
+	}
+
+	out, err = toolFSRead(ctx, `{"path":"book.xlsx","sheet":"notes"}`)
+	if err != nil || strings.Contains(out, "Sales\n") || !strings.Contains(out, "remember") {
+		t.Fatalf("sheet selection failed: out=%q err=%v", out, err)
+	}
+	if _, err := toolFSRead(ctx, `{"path":"book.xlsx","sheet":"Missing"}`); err == nil || !strings.Contains(err.Error(), "available: Sales, Notes") {
+		t.Fatalf("expected sheet not found error, got %v", err)
+	}
+}
@@ -0,0 +1,254 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 子进程默认只继承这些变量；其余变量需要在 policy.toml 的 exec_env_passthrough 中放行，
+// 或由技能 manifest 的 env 声明；密钥还需要 policy.toml 的 skill_secrets 放行（见 grantedSkillSecrets）。
+var defaultExecEnvAllowlist = []string{
+	"PATH", "HOME", "USER", "LOGNAME", "SHELL", "LANG", "LANGUAGE", "LC_*", "TZ", "TERM", "TMPDIR", "TEMP", "TMP",
+	"SYSTEMROOT", "WINDIR", "COMSPEC", "PATHEXT", "USERPROFILE", "APPDATA", "LOCALAPPDATA", "PROGRAMDATA",
+	"PROGRAMFILES", "PROGRAMFILES(X86)", "NUMBER_OF_PROCESSORS", "PROCESSOR_ARCHITECTURE", "OS",
+}
+
+var knownSecretEnvNames = map[string]bool{
+	"LLM_API_KEY":        true,
+	"OPENAI_API_KEY":     true,
+	"TELEGRAM_BOT_TOKEN": true,
+	"FEISHU_APP_ID":      true,
+	"FEISHU_APP_SECRET":  true,
+	"GITHUB_TOKEN":       true,
+	"GH_TOKEN":           true,
+}
+
+// isSecretEnvName 判断变量是否按密钥处理：密钥只能由技能 manifest 的 secrets 显式申请并经 skill_secrets 放行，
+// 通用放行列表（默认列表、exec_env_passthrough、manifest env）对它们无效。
+func isSecretEnvName(name string) bool {
+	up := strings.ToUpper(name)
+	if knownSecretEnvNames[up] {
+		return true
+	}
+	for _, s := range []string{"TOKEN", "SECRET", "PASSWORD", "PASSWD", "API_KEY", "APIKEY", "PRIVATE_KEY", "CREDENTIAL"} {
+		if strings.Contains(up, s) {
+			return true
+		}
+	}
+	return strings.HasSuffix(up, "_KEY")
+}
+
+func envNameMatches(patterns []string, name string) bool {
+	up := strings.ToUpper(name)
+	for _, p := range patterns {
+		p = strings.ToUpper(strings.TrimSpace(p))
+		if p == "" {
+			continue
+		}
+		if strings.HasSuffix(p, "*") {
+			if strings.HasPrefix(up, strings.TrimSuffix(p, "*")) {
+				return true
+			}
+			continue
+		}
+		if up == p {
+			return true
+		}
+	}
+	return false
+}
+
+func execEnvInheritAll() bool {
+	return strings.TrimSpace(os.Getenv("NIBOT_EXEC_ENV_INHERIT")) == "1"
+}
+
+// buildChildEnv 从当前进程环境中挑出允许传给子进程的变量，返回 env 以及传递的变量名（用于审计）。
+func buildChildEnv(p ToolPolicy, declared []string, secrets []string) ([]string, []string) {
+	parent := os.Environ()
+	if execEnvInheritAll() {
+		var names []string
+		for _, kv := range parent {
+			if name, _, ok := strings.Cut(kv, "="); ok && name != "" {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		return parent, names
+	}
+
+	var env, names []string
+	for _, kv := range parent {
+		name, _, ok := strings.Cut(kv, "=")
+		if !ok || name == "" {
+			continue
+		}
+		allowed := false
+		if isSecretEnvName(name) {
+			allowed = envNameMatches(secrets, name)
+		} else if strings.HasPrefix(strings.ToUpper(name), "NIBOT_") {
+			allowed = envNameMatches(p.ExecEnvPassthrough, name) || envNameMatches(declared, name)
+		} else {
+			allowed = envNameMatches(defaultExecEnvAllowlist, name) || envNameMatches(p.ExecEnvPassthrough, name) || envNameMatches(declared, name)
+		}
+		if allowed {
+			env = append(env, kv)
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return env, names
+}
+
+// grantedSkillSecrets 返回 manifest 申请的密钥中 policy.toml 的 skill_secrets 为该技能放行的部分，
+// 以及被拒绝的申请。
+func grantedSkillSecrets(p ToolPolicy, skill string, requested []string) (granted, refused []string) {
+	var allowed []string
+	for _, entry := range p.SkillSecrets {
+		name, pattern, ok := strings.Cut(entry, ":")
+		name = strings.TrimSpace(name)
+		if ok && (name == "*" || strings.EqualFold(name, skill)) {
+			allowed = append(allowed, strings.TrimSpace(pattern))
+		}
+	}
+	for _, r := range requested {
+		if envNameMatches(allowed, r) {
+			granted = append(granted, r)
+		} else {
+			refused = append(refused, r)
+		}
+	}
+	return granted, refused
+}
+
+var envVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// exactEnvNames 从技能 manifest 的 env / secrets 中挑出确切的变量名：skills/ 对模型可写，
+// 写 `*` 或 `前缀*` 就能拿回被清理掉的整个环境，所以 manifest 不支持通配，rejected 是被拒绝的条目。
+func exactEnvNames(names []string) (exact, rejected []string) {
+	for _, n := range names {
+		if envVarName.MatchString(n) {
+			exact = append(exact, n)
+		} else {
+			rejected = append(rejected, n)
+		}
+	}
+	return exact, rejected
+}
+
+func writeAuditEnv(logger *os.File, logLevel string, tool string, names []string) {
+	if logger == nil {
+		return
+	}
+	ts := time.Now().Format("2006-01-02 15:04:05")
+	writeLog(logger, ensureAuditHeader(logLevel))
+	writeLog(logger, fmt.Sprintf("- %s env tool=%s vars=%s\n", ts, tool, strings.Join(names, ",")))
+}
+
+// appendEnvAudit 把不属于某次工具调用的子进程（MCP stdio 服务器）的变量名写入 tool_audits（tool 为 exec.env）；
+// 只在启用 SQLite 存储时写入，工具调用的变量名随该次调用的审计记录一起保存（见 ToolResult.Env）。
+func appendEnvAudit(workspace, session, tool string, names []string) {
+	if strings.TrimSpace(workspace) == "" || !sqliteStorageEnabled() {
+		return
+	}
+	if names == nil {
+		names = []string{}
+	}
+	args, _ := json.Marshal(map[string]any{"tool": tool})
+	call := ExecCall{Tool: "exec.env", ArgsRaw: string(args)}
+	if err := appendToolAudit(workspace, session, call, ToolResult{Tool: call.Tool, OK: true, Env: names}); err != nil {
+		log.Printf("env audit for %s failed: %v", tool, err)
+	}
+}
+
+// childEnvFor 构造子进程环境，把变量名（不记录值）写入审计日志并记到本次调用的 ToolResult.Env。
+func childEnvFor(ctx ExecContext, tool string, declared []string, secrets []string) []string {
+	env, names := buildChildEnv(ctx.Policy, declared, secrets)
+	writeAuditEnv(ctx.Logger, ctx.LogLevel, tool, names)
+	if ctx.envNames != nil {
+		for _, n := range names {
+			if !containsString(*ctx.envNames, n) {
+				*ctx.envNames = append(*ctx.envNames, n)
+			}
+		}
+		sort.Strings(*ctx.envNames)
+	}
+	return env
+}
+
+// loadSkillEnvDecl 读取技能 manifest 中声明的 env（普通变量）与 secrets（密钥变量）。
+// 支持 skill.json / manifest.json 的数组或逗号分隔字符串、skill.yaml 与 SKILL.md front matter 中的 `env: A, B`。
+func loadSkillEnvDecl(skillDir string) (env []string, secrets []string) {
+	for _, name := range []string{"skill.json", "manifest.json", "skill.manifest.json"} {
+		b, err := os.ReadFile(filepath.Join(skillDir, name))
+		if err != nil {
+			continue
+		}
+		var m struct {
+			Env     json.RawMessage `json:"env"`
+			Secrets json.RawMessage `json:"secrets"`
+		}
+		if json.Unmarshal(b, &m) != nil {
+			continue
+		}
+		return parseEnvNameList(m.Env), parseEnvNameList(m.Secrets)
+	}
+	for _, name := range []string{"skill.yaml", "skill.yml", "manifest.yaml", "manifest.yml"} {
+		b, err := os.ReadFile(filepath.Join(skillDir, name))
+		if err != nil {
+			continue
+		}
+		m := parseFlatYAML(string(b))
+		return splitEnvNames(m["env"]), splitEnvNames(m["secrets"])
+	}
+	for _, name := range []string{"SKILL.md", "skill.md"} {
+		b, err := os.ReadFile(filepath.Join(skillDir, name))
+		if err != nil {
+			continue
+		}
+		m := parseFlatYAML(skillFrontmatter(string(b)))
+		return splitEnvNames(m["env"]), splitEnvNames(m["secrets"])
+	}
+	return nil, nil
+}
+
+func parseEnvNameList(raw json.RawMessage) []string {
+	if len(raw) == 0 {
+		return nil
+	}
+	var list []string
+	if json.Unmarshal(raw, &list) == nil {
+		return splitEnvNames(strings.Join(list, ","))
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return splitEnvNames(s)
+	}
+	return nil
+}
+
+func splitEnvNames(s string) []string {
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(s, "[")
+	s = strings.TrimSuffix(s, "]")
+	return splitCSV(s)
+}
+
+func skillFrontmatter(content string) string {
+	lines := strings.Split(normalizeNewlines(content), "\n")
+	if len(lines) == 0 || strings.TrimSpace(lines[0]) != "---" {
+		return ""
+	}
+	for i := 1; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == "---" {
+			return strings.Join(lines[1:i], "\n")
+		}
+	}
+	return ""
+}
@@ -0,0 +1,214 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestBuildChildEnv_ScrubsSecrets(t *testing.T) {
+	t.Setenv("NIBOT_EXEC_ENV_INHERIT", "")
+	t.Setenv("LLM_API_KEY", "sk-test")
+	t.Setenv("TELEGRAM_BOT_TOKEN", "tg")
+	t.Setenv("FEISHU_APP_SECRET", "fs")
+	t.Setenv("GITHUB_TOKEN", "gh")
+	t.Setenv("MY_TOOL_HOME", "/opt/tool")
+	t.Setenv("LC_ALL", "C")
+
+	env, names := buildChildEnv(DefaultToolPolicy(), nil, nil)
+	joined := strings.Join(env, "\n")
+	for _, leaked := range []string{"sk-test", "LLM_API_KEY", "TELEGRAM_BOT_TOKEN", "FEISHU_APP_SECRET", "GITHUB_TOKEN", "MY_TOOL_HOME", "NIBOT_EXEC_ENV_INHERIT"} {
+		if strings.Contains(joined, leaked) {
+			t.Fatalf("unexpected %s in child env: %v", leaked, names)
+		}
+	}
+	if !strings.Contains(joined, "LC_ALL=C") {
+		t.Fatalf("expected LC_* to pass, got %v", names)
+	}
+
+	p := DefaultToolPolicy()
+	p.ExecEnvPassthrough = []string{"MY_TOOL_*", "GITHUB_TOKEN"}
+	env, _ = buildChildEnv(p, nil, nil)
+	joined = strings.Join(env, "\n")
+	if !strings.Contains(joined, "MY_TOOL_HOME=/opt/tool") {
+		t.Fatalf("expected passthrough var")
+	}
+	if strings.Contains(joined, "GITHUB_TOKEN") {
+		t.Fatalf("secrets must not pass via policy passthrough")
+	}
+
+	env, names = buildChildEnv(DefaultToolPolicy(), []string{"MY_TOOL_HOME"}, []string{"GITHUB_TOKEN"})
+	joined = strings.Join(env, "\n")
+	if !strings.Contains(joined, "GITHUB_TOKEN=gh") || !strings.Contains(joined, "MY_TOOL_HOME=/opt/tool") || strings.Contains(joined, "LLM_API_KEY") {
+		t.Fatalf("unexpected env for declared skill vars: %v", names)
+	}
+}
+
+func TestRuntimeExec_DoesNotLeakAPIKey(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	t.Setenv("NIBOT_ENABLE_EXEC", "1")
+	t.Setenv("LLM_API_KEY", "sk-should-not-leak")
+	ctx := ExecContext{Workspace: t.TempDir(), Policy: DefaultToolPolicy()}
+	out, err := toolRuntimeExec(ctx, `{"command":"env"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "sk-should-not-leak") {
+		t.Fatalf("API key leaked to child: %s", out)
+	}
+}
+
+func TestSkillExec_ManifestSecretsAndAudit(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	t.Setenv("NIBOT_ENABLE_SKILLS", "1")
+	t.Setenv("GITHUB_TOKEN", "gh-secret")
+	t.Setenv("LLM_API_KEY", "sk-secret")
+	t.Setenv("SKILL_REGION", "cn")
+
+	ws := t.TempDir()
+	skillDir := filepath.Join(ws, "skills", "gh")
+	if err := os.MkdirAll(filepath.Join(skillDir, "scripts"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	manifest := `{"name":"gh","env":["SKILL_REGION"],"secrets":"GITHUB_TOKEN"}`
+	if err := os.WriteFile(filepath.Join(skillDir, "skill.json"), []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	script := "echo token=$GITHUB_TOKEN region=$SKILL_REGION key=$LLM_API_KEY\n"
+	if err := os.WriteFile(filepath.Join(skillDir, "scripts", "run.sh"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	logPath := filepath.Join(ws, "audit.md")
+	logger, err := os.Create(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	// 只在 manifest 中声明 secrets 不够：skills/ 对模型可写，需要 policy.toml 的 skill_secrets 放行
+	ctx := ExecContext{Workspace: ws, Policy: DefaultToolPolicy(), Session: "conv_env"}
+	out, err := toolSkillExec(ctx, `{"skill":"gh","script":"run.sh"}`)
+	if err != nil {
+		t.Fatalf("skill.exec failed: %v", err)
+	}
+	if !strings.Contains(out, "token= region=cn key=") {
+		t.Fatalf("secrets must not be injected without skill_secrets: %q", out)
+	}
+
+	ctx.Logger = logger
+	ctx.Policy.SkillSecrets = []string{"other:GITHUB_TOKEN", "gh:GITHUB_TOKEN"}
+	out, err = toolSkillExec(ctx, `{"skill":"gh","script":"run.sh"}`)
+	if err != nil {
+		t.Fatalf("skill.exec failed: %v", err)
+	}
+	if !strings.Contains(out, "token=gh-secret region=cn key=") || strings.Contains(out, "sk-secret") {
+		t.Fatalf("unexpected skill output: %q", out)
+	}
+
+	b, _ := os.ReadFile(logPath)
+	log := string(b)
+	if !strings.Contains(log, "env tool=skill.exec") || !strings.Contains(log, "GITHUB_TOKEN") || strings.Contains(log, "gh-secret") {
+		t.Fatalf("unexpected audit log: %q", log)
+	}
+
+	// 启动子进程本身不写数据库；变量名记在该次调用的结果里，随调用的审计记录一起保存
+	if _, err := os.Stat(filepath.Join(ws, "data", "nibot.db")); !os.IsNotExist(err) {
+		t.Fatalf("spawning a child process must not open data/nibot.db: %v", err)
+	}
+	t.Setenv("NIBOT_AUTO_APPROVE", "true")
+	ctx.Logger = nil
+	res := ExecuteCalls(ctx, []ExecCall{{Tool: "skill.exec", ArgsRaw: `{"skill":"gh","script":"run.sh"}`}}, nil)
+	if len(res) != 1 || !res[0].OK {
+		t.Fatalf("skill.exec failed: %+v", res)
+	}
+	env := strings.Join(res[0].Env, ",")
+	if !strings.Contains(env, "GITHUB_TOKEN") || !strings.Contains(env, "SKILL_REGION") || strings.Contains(env, "LLM_API_KEY") {
+		t.Fatalf("unexpected env names on the result: %v", res[0].Env)
+	}
+
+	t.Setenv("NIBOT_STORAGE", "sqlite")
+	store, err := OpenSQLiteStore(ws)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.db.Close()
+	if err := store.InsertToolAudit("conv_env", []ExecCall{{Tool: "skill.exec"}}, res); err != nil {
+		t.Fatal(err)
+	}
+	var audited string
+	if err := store.db.QueryRow(`select env from tool_audits where session_id = 'conv_env'`).Scan(&audited); err != nil {
+		t.Fatal(err)
+	}
+	if audited != env {
+		t.Fatalf("tool_audits.env = %q, want %q", audited, env)
+	}
+}
+
+func TestGrantedSkillSecrets(t *testing.T) {
+	p := DefaultToolPolicy()
+	p.SkillSecrets = []string{"gh:GITHUB_TOKEN", "*:SHARED_TOKEN", "cloud:CLOUD_*"}
+	granted, refused := grantedSkillSecrets(p, "gh", []string{"GITHUB_TOKEN", "SHARED_TOKEN", "LLM_API_KEY", "GH_*"})
+	if strings.Join(granted, ",") != "GITHUB_TOKEN,SHARED_TOKEN" || strings.Join(refused, ",") != "LLM_API_KEY,GH_*" {
+		t.Fatalf("unexpected grants: %v refused %v", granted, refused)
+	}
+	granted, refused = grantedSkillSecrets(p, "cloud", []string{"CLOUD_AK", "OTHER_KEY"})
+	if strings.Join(granted, ",") != "CLOUD_AK" || strings.Join(refused, ",") != "OTHER_KEY" {
+		t.Fatalf("unexpected grants for a skill_secrets pattern: %v refused %v", granted, refused)
+	}
+}
+
+func TestSkillExec_ManifestEnvRejectsWildcards(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	t.Setenv("NIBOT_ENABLE_SKILLS", "1")
+	t.Setenv("NIBOT_EXEC_ENV_INHERIT", "")
+	t.Setenv("MY_TOOL_HOME", "/opt/tool")
+	t.Setenv("SKILL_REGION", "cn")
+
+	ws := t.TempDir()
+	skillDir := filepath.Join(ws, "skills", "greedy")
+	if err := os.MkdirAll(filepath.Join(skillDir, "scripts"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	manifest := `{"name":"greedy","env":["*","MY_*","SKILL_REGION"],"secrets":["*"]}`
+	if err := os.WriteFile(filepath.Join(skillDir, "skill.json"), []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(skillDir, "scripts", "run.sh"), []byte("echo home=$MY_TOOL_HOME region=$SKILL_REGION\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	ctx := ExecContext{Workspace: ws, Policy: DefaultToolPolicy()}
+	ctx.Policy.SkillSecrets = []string{"*:*"}
+	out, err := toolSkillExec(ctx, `{"skill":"greedy","script":"run.sh"}`)
+	if err != nil {
+		t.Fatalf("skill.exec failed: %v", err)
+	}
+	if !strings.Contains(out, "home= region=cn") {
+		t.Fatalf("manifest wildcards must not pass variables: %q", out)
+	}
+
+	exact, rejected := exactEnvNames([]string{"GH_HOST", "*", "MY_*", "A-B"})
+	if strings.Join(exact, ",") != "GH_HOST" || strings.Join(rejected, ",") != "*,MY_*,A-B" {
+		t.Fatalf("unexpected split: %v / %v", exact, rejected)
+	}
+}
+
+func TestLoadSkillEnvDecl_SkillMDFrontmatter(t *testing.T) {
+	dir := t.TempDir()
+	md := "---\nname: demo\nenv: [A_VAR, B_VAR]\nsecrets: API_TOKEN\n---\n# Demo\n"
+	if err := os.WriteFile(filepath.Join(dir, "SKILL.md"), []byte(md), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	env, secrets := loadSkillEnvDecl(dir)
+	if strings.Join(env, ",") != "A_VAR,B_VAR" || strings.Join(secrets, ",") != "API_TOKEN" {
+		t.Fatalf("unexpected decl: env=%v secrets=%v", env, secrets)
+	}
+}
@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"io"
 	"os"
 	"strconv"
 	"strings"
@@ -53,6 +54,11 @@ type cappedBuffer struct {
 	max       int
 	buf       []byte
 	truncated bool
+
+	// spill 为 true 时，超出 max 的部分写入临时文件（最多 artifactMaxBytes），供 saveTo 保存为 artifact。
+	spill     bool
+	spillFile *os.File
+	spilled   int64
 }
 
 func newCappedBuffer(max int) *cappedBuffer {
@@ -62,12 +68,20 @@ func newCappedBuffer(max int) *cappedBuffer {
 	return &cappedBuffer{max: max, buf: make([]byte, 0, minInt(max, 4096))}
 }
 
+// newSpillBuffer 与 newCappedBuffer 相同，但不丢弃超出部分；用完后必须调用 Close。
+func newSpillBuffer(max int) *cappedBuffer {
+	b := newCappedBuffer(max)
+	b.spill = true
+	return b
+}
+
 func (b *cappedBuffer) Write(p []byte) (int, error) {
 	if len(p) == 0 {
 		return 0, nil
 	}
 	if len(b.buf) >= b.max {
 		b.truncated = true
+		b.spillWrite(p)
 		return len(p), nil
 	}
 	remain := b.max - len(b.buf)
@@ -77,9 +91,69 @@ func (b *cappedBuffer) Write(p []byte) (int, error) {
 	}
 	b.buf = append(b.buf, p[:remain]...)
 	b.truncated = true
+	b.spillWrite(p[remain:])
 	return len(p), nil
 }
 
+func (b *cappedBuffer) spillWrite(p []byte) {
+	if !b.spill {
+		return
+	}
+	limit := artifactMaxBytes() - int64(b.max)
+	if b.spilled >= limit {
+		return
+	}
+	if b.spillFile == nil {
+		f, err := os.CreateTemp("", "nibot-output-*")
+		if err != nil {
+			b.spill = false
+			return
+		}
+		b.spillFile = f
+	}
+	if int64(len(p)) > limit-b.spilled {
+		p = p[:limit-b.spilled]
+	}
+	n, _ := b.spillFile.Write(p)
+	b.spilled += int64(n)
+}
+
+// Len 返回捕获到的总字节数（含溢出到临时文件的部分）。
+func (b *cappedBuffer) Len() int64 {
+	return int64(len(b.buf)) + b.spilled
+}
+
+// saveTo 把完整输出（内存部分 + 溢出部分）写到 path。
+func (b *cappedBuffer) saveTo(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(b.buf); err != nil {
+		return err
+	}
+	if b.spillFile != nil {
+		if _, err := b.spillFile.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, b.spillFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *cappedBuffer) Close() error {
+	if b.spillFile == nil {
+		return nil
+	}
+	name := b.spillFile.Name()
+	b.spillFile.Close()
+	b.spillFile = nil
+	return os.Remove(name)
+}
+
 func (b *cappedBuffer) String() string {
 	s := string(b.buf)
 	if b.truncated {
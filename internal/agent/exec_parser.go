@@ -0,0 +1,325 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// 工具调用标签的语法（模型回复中的 [EXEC:...]）：
+//
+//	tag    = "[EXEC:" tool [ sp args ] "]"
+//	tool   = letter *( letter / digit / "." / "_" / "-" )
+//	args   = json / plain
+//	json   = JSON 对象或数组，可以跨多行；结束后允许空白，然后必须是 "]"
+//	plain  = 同一行内到匹配的 "]" 为止的文本（双引号内和成对的 [] 中的 "]" 不算结束）
+//
+// 以下位置的标签只是示例，不会执行：
+//   - ``` 或 ~~~ 围起来的代码块（未闭合时一直到文本结尾）
+//   - `行内代码`
+//   - 以 > 开头的引用行
+//   - 用反斜杠转义的 \[EXEC:...]
+//
+// "[EXEC:" 后面不是字母时（例如 "[EXEC:...]"）视为普通文字。其余格式错误的标签不会执行，
+// 而是作为 ExecParseError 返回，由调用方反馈给模型；之后从标签所在行的下一行继续扫描。
+
+const execTagPrefix = "[EXEC:"
+
+// ExecParseError 描述一个无法解析的 [EXEC:] 标签。
+type ExecParseError struct {
+	Line    int    // 标签所在行（从 1 开始）
+	Offset  int    // 标签在文本中的字节偏移
+	Tool    string // 已识别出的工具名，可能为空
+	Snippet string // 标签开头的一段原文
+	Msg     string
+}
+
+func (e ExecParseError) Error() string {
+	return fmt.Sprintf("line %d: %s: %s", e.Line, e.Snippet, e.Msg)
+}
+
+// ParseExecCalls 按上面的语法提取工具调用，并返回格式错误的标签。
+func ParseExecCalls(text string) ([]ExecCall, []ExecParseError) {
+	var calls []ExecCall
+	var errs []ExecParseError
+
+	for i := 0; i < len(text); {
+		if i == 0 || text[i-1] == '\n' {
+			if end, ok := skipFencedBlock(text, i); ok {
+				i = end
+				continue
+			}
+			if isBlockquoteLine(text, i) {
+				i = nextLineStart(text, i)
+				continue
+			}
+		}
+
+		switch text[i] {
+		case '\\':
+			if i+1 < len(text) && text[i+1] != '\n' {
+				i += 2
+				continue
+			}
+		case '`':
+			i = skipInlineCode(text, i)
+			continue
+		case '[':
+			if strings.HasPrefix(text[i:], execTagPrefix) {
+				call, end, perr := parseExecTag(text, i)
+				if perr != nil {
+					errs = append(errs, *perr)
+				} else if call.Tool != "" {
+					calls = append(calls, call)
+				}
+				i = end
+				continue
+			}
+		}
+		i++
+	}
+	return calls, errs
+}
+
+// parseExecTag 解析从 start 开始的标签，返回调用、继续扫描的位置，以及可能的错误。
+// 返回空调用且无错误表示这不是标签。
+func parseExecTag(text string, start int) (ExecCall, int, *ExecParseError) {
+	j := start + len(execTagPrefix)
+	if j >= len(text) || !isASCIILetter(text[j]) {
+		return ExecCall{}, j, nil
+	}
+
+	toolStart := j
+	for j < len(text) && isToolNameByte(text[j]) {
+		j++
+	}
+	tool := text[toolStart:j]
+	fail := func(format string, a ...any) (ExecCall, int, *ExecParseError) {
+		return ExecCall{}, nextLineStart(text, start), &ExecParseError{
+			Line:    strings.Count(text[:start], "\n") + 1,
+			Offset:  start,
+			Tool:    tool,
+			Snippet: execTagSnippet(text[start:]),
+			Msg:     fmt.Sprintf(format, a...),
+		}
+	}
+
+	if j >= len(text) {
+		return fail("missing closing ]")
+	}
+	switch c := text[j]; {
+	case c == ']':
+		return ExecCall{Tool: tool}, j + 1, nil
+	case c == '{':
+	case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+	default:
+		return fail("invalid character %q in tool name", rune(c))
+	}
+
+	for j < len(text) && (text[j] == ' ' || text[j] == '\t') {
+		j++
+	}
+	k := skipSpace(text, j)
+	if k < len(text) && (text[k] == '{' || text[k] == '[') {
+		end, msg := scanJSONValue(text, k)
+		if msg != "" {
+			return fail("%s", msg)
+		}
+		args := text[k:end]
+		if err := json.Unmarshal([]byte(args), new(json.RawMessage)); err != nil {
+			return fail("invalid JSON args: %v", err)
+		}
+		end = skipSpace(text, end)
+		if end >= len(text) {
+			return fail("missing closing ] after JSON args")
+		}
+		if text[end] != ']' {
+			return fail("expected ] after JSON args, found %q", rune(text[end]))
+		}
+		return ExecCall{Tool: tool, ArgsRaw: args}, end + 1, nil
+	}
+	if k < len(text) && text[k] == ']' {
+		return ExecCall{Tool: tool}, k + 1, nil
+	}
+
+	// 纯文本参数只能在同一行内
+	depth := 0
+	inQuote := false
+	for p := j; p < len(text); p++ {
+		switch c := text[p]; {
+		case c == '\n':
+			return fail("missing closing ] (plain-text args must end on the same line; use JSON for multi-line args)")
+		case inQuote:
+			if c == '\\' {
+				p++
+			} else if c == '"' {
+				inQuote = false
+			}
+		case c == '"':
+			inQuote = true
+		case c == '[':
+			depth++
+		case c == ']':
+			if depth == 0 {
+				return ExecCall{Tool: tool, ArgsRaw: strings.TrimSpace(text[j:p])}, p + 1, nil
+			}
+			depth--
+		}
+	}
+	return fail("missing closing ]")
+}
+
+// scanJSONValue 找到从 start 开始的 JSON 对象/数组的结尾（括号配对、字符串与转义），不校验其余语法。
+func scanJSONValue(text string, start int) (int, string) {
+	var stack []byte
+	inString := false
+	for p := start; p < len(text); p++ {
+		c := text[p]
+		if inString {
+			if c == '\\' {
+				p++
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{':
+			stack = append(stack, '}')
+		case '[':
+			stack = append(stack, ']')
+		case '}', ']':
+			if stack[len(stack)-1] != c {
+				return 0, fmt.Sprintf("unbalanced brackets in JSON args: expected %q, found %q", rune(stack[len(stack)-1]), rune(c))
+			}
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				return p + 1, ""
+			}
+		}
+	}
+	if inString {
+		return 0, "unterminated string in JSON args"
+	}
+	return 0, fmt.Sprintf("unterminated JSON args: %d unclosed bracket(s)", len(stack))
+}
+
+// skipFencedBlock 若 lineStart 处是 ``` 或 ~~~ 开头的代码块，返回代码块之后的位置。
+func skipFencedBlock(text string, lineStart int) (int, bool) {
+	p := lineStart
+	for n := 0; n < 3 && p < len(text) && text[p] == ' '; n++ {
+		p++
+	}
+	if p >= len(text) || (text[p] != '`' && text[p] != '~') {
+		return 0, false
+	}
+	fence := text[p]
+	n := 0
+	for p+n < len(text) && text[p+n] == fence {
+		n++
+	}
+	if n < 3 {
+		return 0, false
+	}
+	next := nextLineStart(text, p)
+	if fence == '`' && strings.IndexByte(text[p+n:next], '`') >= 0 {
+		return 0, false
+	}
+
+	for q := next; q < len(text); q = nextLineStart(text, q) {
+		line := strings.TrimRight(text[q:nextLineStart(text, q)], "\r\n")
+		trimmed := strings.TrimLeft(line, " ")
+		if len(line)-len(trimmed) > 3 {
+			continue
+		}
+		run := len(trimmed) - len(strings.TrimLeft(trimmed, string(fence)))
+		if run >= n && strings.TrimSpace(trimmed[run:]) == "" {
+			return nextLineStart(text, q), true
+		}
+	}
+	return len(text), true
+}
+
+// skipInlineCode 跳过由 N 个反引号包围的行内代码；没有配对的反引号按普通字符处理。行内代码不跨越空行。
+func skipInlineCode(text string, start int) int {
+	n := 0
+	for start+n < len(text) && text[start+n] == '`' {
+		n++
+	}
+	p := start + n
+	limit := len(text)
+	if k := strings.Index(text[p:], "\n\n"); k >= 0 {
+		limit = p + k
+	}
+	for p < limit {
+		k := strings.IndexByte(text[p:limit], '`')
+		if k < 0 {
+			break
+		}
+		p += k
+		m := 0
+		for p+m < limit && text[p+m] == '`' {
+			m++
+		}
+		if m == n {
+			return p + m
+		}
+		p += m
+	}
+	return start + n
+}
+
+func isBlockquoteLine(text string, lineStart int) bool {
+	p := lineStart
+	for n := 0; n < 3 && p < len(text) && text[p] == ' '; n++ {
+		p++
+	}
+	return p < len(text) && text[p] == '>'
+}
+
+func nextLineStart(text string, p int) int {
+	if k := strings.IndexByte(text[p:], '\n'); k >= 0 {
+		return p + k + 1
+	}
+	return len(text)
+}
+
+func skipSpace(text string, p int) int {
+	for p < len(text) && (text[p] == ' ' || text[p] == '\t' || text[p] == '\r' || text[p] == '\n') {
+		p++
+	}
+	return p
+}
+
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isToolNameByte(c byte) bool {
+	return isASCIILetter(c) || (c >= '0' && c <= '9') || c == '.' || c == '_' || c == '-'
+}
+
+func execTagSnippet(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	if len(s) > 60 {
+		s = truncateUTF8(s, 60) + "..."
+	}
+	return s
+}
+
+// parseErrorResults 把解析错误转换为工具结果，和正常结果一起回灌给模型。
+func parseErrorResults(errs []ExecParseError) []ToolResult {
+	var out []ToolResult
+	for _, e := range errs {
+		tool := e.Tool
+		if tool == "" {
+			tool = "EXEC"
+		}
+		out = append(out, ToolResult{Tool: tool, OK: false, Error: "tag not executed: " + e.Error() + "; fix the tag and send it again"})
+	}
+	return out
+}
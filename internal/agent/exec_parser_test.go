@@ -0,0 +1,148 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseExecCalls_IgnoresExamples(t *testing.T) {
+	text := "You can read files like this:\n\n" +
+		"```\n[EXEC:fs.read {\"path\":\"example.md\"}]\n```\n\n" +
+		"~~~~text\n[EXEC:runtime.exec {\"command\":\"rm -rf x\"}]\n~~~~\n" +
+		"or inline: `[EXEC:fs.read {\"path\":\"inline.md\"}]` and ``[EXEC:job.kill {}]``.\n" +
+		"> quoted: [EXEC:fs.write {\"path\":\"memory/q.md\",\"content\":\"x\"}]\n" +
+		"Escaped: \\[EXEC:memory.stats {}] and prose about [EXEC:...] tags.\n" +
+		"Now for real:\n[EXEC:fs.read {\"path\":\"memory/facts.md\"}]"
+	calls, errs := ParseExecCalls(text)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(calls) != 1 || calls[0].Tool != "fs.read" || calls[0].ArgsRaw != `{"path":"memory/facts.md"}` {
+		t.Fatalf("unexpected calls: %+v", calls)
+	}
+
+	// 未闭合的代码块一直延续到文本结尾
+	if calls, _ := ParseExecCalls("```go\n[EXEC:fs.read {}]\n"); len(calls) != 0 {
+		t.Fatalf("expected unclosed fence to hide tags, got %+v", calls)
+	}
+	// 双反斜杠是转义的反斜杠本身，标签照常执行
+	if calls, _ := ParseExecCalls(`\\[EXEC:memory.stats {}]`); len(calls) != 1 {
+		t.Fatalf("expected tag after escaped backslash, got %+v", calls)
+	}
+}
+
+func TestParseExecCalls_MultiLineJSONAndPlainArgs(t *testing.T) {
+	text := "[EXEC:code.run {\n  \"language\": \"python\",\n  \"code\": \"print([1, 2])\\n```\"\n}\n]\n" +
+		"[EXEC:memory.stats]\n" +
+		"[EXEC:file_read memory/[draft] notes.md]\n" +
+		"[EXEC:fs.read{\"path\":\"a.md\"}]"
+	calls, errs := ParseExecCalls(text)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(calls) != 4 {
+		t.Fatalf("expected 4 calls, got %+v", calls)
+	}
+	var a struct{ Language, Code string }
+	if err := json.Unmarshal([]byte(calls[0].ArgsRaw), &a); err != nil || a.Code != "print([1, 2])\n```" {
+		t.Fatalf("unexpected multi-line args: %q (%v)", calls[0].ArgsRaw, err)
+	}
+	if calls[1].Tool != "memory.stats" || calls[1].ArgsRaw != "" {
+		t.Fatalf("unexpected call: %+v", calls[1])
+	}
+	if calls[2].Tool != "file_read" || calls[2].ArgsRaw != "memory/[draft] notes.md" {
+		t.Fatalf("unexpected plain args: %+v", calls[2])
+	}
+	if calls[3].Tool != "fs.read" || calls[3].ArgsRaw != `{"path":"a.md"}` {
+		t.Fatalf("unexpected call: %+v", calls[3])
+	}
+}
+
+func TestParseExecCalls_ReportsErrors(t *testing.T) {
+	cases := []struct {
+		text string
+		want string
+	}{
+		{`[EXEC:fs.read {"path":"a.md"]`, "unbalanced brackets"},
+		{`[EXEC:fs.write {"path":"a.md","content":"oops}]`, "unterminated string"},
+		{"[EXEC:fs.write {\"path\":\"a.md\",\n\"content\":{\"x\":1}", "unterminated JSON args"},
+		{`[EXEC:fs.read {"path":"a.md",}]`, "invalid JSON args"},
+		{`[EXEC:fs.read {"path":"a.md"} please]`, "expected ] after JSON args"},
+		{`[EXEC:fs.read:x {}]`, "invalid character"},
+		{"[EXEC:runtime.exec ls -la\nmore text", "missing closing ]"},
+	}
+	for _, c := range cases {
+		calls, errs := ParseExecCalls("intro\n" + c.text)
+		if len(calls) != 0 || len(errs) != 1 || !strings.Contains(errs[0].Msg, c.want) {
+			t.Errorf("%q: calls=%+v errs=%v, want error containing %q", c.text, calls, errs, c.want)
+			continue
+		}
+		if errs[0].Line != 2 || errs[0].Offset != len("intro\n") {
+			t.Errorf("%q: unexpected position %+v", c.text, errs[0])
+		}
+	}
+
+	// 出错后从下一行继续扫描
+	calls, errs := ParseExecCalls("[EXEC:fs.read {\"path\":\"a.md\"]\n[EXEC:fs.read {\"path\":\"b.md\"}]")
+	if len(errs) != 1 || errs[0].Tool != "fs.read" || len(calls) != 1 || calls[0].ArgsRaw != `{"path":"b.md"}` {
+		t.Fatalf("expected recovery on next line: calls=%+v errs=%v", calls, errs)
+	}
+	res := parseErrorResults(errs)
+	if len(res) != 1 || res[0].OK || res[0].Tool != "fs.read" || !strings.Contains(res[0].Error, "line 1") {
+		t.Fatalf("unexpected parse error result: %+v", res)
+	}
+}
+
+func FuzzParseExecCalls(f *testing.F) {
+	seeds := []string{
+		`[EXEC:fs.read {"path":"memory/facts.md"}]`,
+		`[EXEC:skill.exec {"skill":"weather","args":["Beijing"]}]`,
+		"[EXEC:code.run {\n\"code\":\"x = [1]\"\n}\n]",
+		"```\n[EXEC:fs.read {}]\n```\n[EXEC:memory.stats]",
+		"`[EXEC:a]` \\[EXEC:b] > [EXEC:c]\n> [EXEC:d]",
+		`[EXEC:fs.write {"content":"] } [ {"}]`,
+		`[EXEC:x {"a":[}]`,
+		`[EXEC:file_read a [b] "c]" d]`,
+		"[EXEC:",
+		"[EXEC:x\n",
+		"~~~\n~~\n```\n~~~",
+	}
+	for _, s := range seeds {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, text string) {
+		calls, errs := ParseExecCalls(text)
+		for _, c := range calls {
+			if c.Tool == "" || !isASCIILetter(c.Tool[0]) {
+				t.Fatalf("invalid tool name %q", c.Tool)
+			}
+			for i := 0; i < len(c.Tool); i++ {
+				if !isToolNameByte(c.Tool[i]) {
+					t.Fatalf("invalid tool name %q", c.Tool)
+				}
+			}
+			a := c.ArgsRaw
+			if a == "" || (a[0] != '{' && a[0] != '[') {
+				continue
+			}
+			if !json.Valid([]byte(a)) {
+				t.Fatalf("invalid JSON args accepted: %q", a)
+			}
+			// JSON 参数重新生成标签后应解析回同一个调用
+			again, errs := ParseExecCalls(fmt.Sprintf("[EXEC:%s %s]", c.Tool, a))
+			if len(errs) != 0 || len(again) != 1 || again[0] != c {
+				t.Fatalf("round trip of %+v failed: %+v %v", c, again, errs)
+			}
+		}
+		for _, e := range errs {
+			if e.Line < 1 || e.Offset < 0 || e.Offset >= len(text) || !strings.HasPrefix(text[e.Offset:], execTagPrefix) {
+				t.Fatalf("bad error position %+v for %q", e, text)
+			}
+			if e.Error() == "" {
+				t.Fatalf("empty error message")
+			}
+		}
+	})
+}
@@ -0,0 +1,47 @@
+package agent
+
+import (
+	"os/exec"
+)
+
+// execRlimits 是子进程的资源上限，0 表示不限制（沿用父进程的限制）。仅 Linux 生效。
+type execRlimits struct {
+	CPUSeconds     uint64
+	AddressSpaceMB uint64
+	FileSizeMB     uint64
+	OpenFiles      uint64
+	Processes      uint64
+}
+
+func execRlimitsFromEnv() execRlimits {
+	return execRlimits{
+		CPUSeconds:     uint64(parseIntEnv("NIBOT_EXEC_RLIMIT_CPU_SECONDS", 0, 0, 24*3600)),
+		AddressSpaceMB: uint64(parseIntEnv("NIBOT_EXEC_RLIMIT_AS_MB", 0, 0, 1024*1024)),
+		FileSizeMB:     uint64(parseIntEnv("NIBOT_EXEC_RLIMIT_FSIZE_MB", 0, 0, 1024*1024)),
+		OpenFiles:      uint64(parseIntEnv("NIBOT_EXEC_RLIMIT_NOFILE", 0, 0, 1<<20)),
+		Processes:      uint64(parseIntEnv("NIBOT_EXEC_RLIMIT_NPROC", 0, 0, 1<<20)),
+	}
+}
+
+func (l execRlimits) empty() bool {
+	return l == execRlimits{}
+}
+
+// startCommand 以独立进程组启动 cmd，并在真正执行目标程序前应用 rlimits。
+// 之后可以用 killProcessTree 连同孙进程一起终止。
+func startCommand(cmd *exec.Cmd, limits execRlimits) error {
+	setProcessGroup(cmd)
+	apply, err := prepareRlimits(cmd, limits)
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if err := apply(cmd.Process.Pid); err != nil {
+		killProcessTree(cmd)
+		_ = cmd.Wait()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func processGone(pid string) bool {
+	b, err := os.ReadFile(filepath.Join("/proc", pid, "stat"))
+	if err != nil {
+		return true
+	}
+	// 进程已被 kill 但未被 init 回收时会停留在 Z 状态。
+	fields := strings.Fields(string(b[strings.LastIndex(string(b), ")")+1:]))
+	return len(fields) > 0 && (fields[0] == "Z" || fields[0] == "X")
+}
+
+func TestRuntimeExec_TimeoutKillsProcessGroup(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("checks /proc")
+	}
+	t.Setenv("NIBOT_ENABLE_EXEC", "1")
+	ws := t.TempDir()
+	ctx := ExecContext{Workspace: ws, Policy: DefaultToolPolicy()}
+
+	start := time.Now()
+	_, err := toolRuntimeExec(ctx, `{"command":"sleep 30 & echo $! > child.pid; wait","timeoutSeconds":1}`)
+	if err == nil {
+		t.Fatalf("expected timeout error")
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Fatalf("timeout took too long: %s", time.Since(start))
+	}
+	b, err := os.ReadFile(filepath.Join(ws, "child.pid"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid := strings.TrimSpace(string(b))
+	deadline := time.Now().Add(3 * time.Second)
+	for !processGone(pid) {
+		if time.Now().After(deadline) {
+			t.Fatalf("grandchild %s still running after timeout", pid)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestRuntimeExec_AppliesRlimits(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("rlimits are linux only")
+	}
+	t.Setenv("NIBOT_ENABLE_EXEC", "1")
+	t.Setenv("NIBOT_EXEC_RLIMIT_NOFILE", "64")
+	t.Setenv("NIBOT_EXEC_RLIMIT_CPU_SECONDS", "7")
+	ctx := ExecContext{Workspace: t.TempDir(), Policy: DefaultToolPolicy()}
+
+	out, err := toolRuntimeExec(ctx, `{"command":"ulimit -n; ulimit -t"}`)
+	if err != nil {
+		t.Fatalf("runtime.exec failed: %v (%s)", err, out)
+	}
+	lines := strings.Fields(out)
+	if len(lines) != 2 || lines[0] != "64" || lines[1] != "7" {
+		t.Fatalf("unexpected limits: %q", out)
+	}
+}
+
+func TestExecRlimitsFromEnv_DefaultsToEmpty(t *testing.T) {
+	for _, k := range []string{"NIBOT_EXEC_RLIMIT_CPU_SECONDS", "NIBOT_EXEC_RLIMIT_AS_MB", "NIBOT_EXEC_RLIMIT_FSIZE_MB", "NIBOT_EXEC_RLIMIT_NOFILE", "NIBOT_EXEC_RLIMIT_NPROC"} {
+		t.Setenv(k, "")
+	}
+	if !execRlimitsFromEnv().empty() {
+		t.Fatalf("expected no limits by default")
+	}
+	t.Setenv("NIBOT_EXEC_RLIMIT_AS_MB", "512")
+	if got := execRlimitsFromEnv().AddressSpaceMB; got != 512 {
+		t.Fatalf("unexpected AS limit: %d", got)
+	}
+}
@@ -0,0 +1,63 @@
+//go:build linux
+
+package agent
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// prepareRlimits 用一个 sh 闸门包住原命令：子进程先阻塞在 fd 上等待，
+// 父进程对其 prlimit 之后再放行 exec，保证目标程序从第一条指令起就受限。
+func prepareRlimits(cmd *exec.Cmd, limits execRlimits) (func(pid int) error, error) {
+	if limits.empty() {
+		return func(int) error { return nil }, nil
+	}
+	if cmd.Err != nil {
+		return nil, cmd.Err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	fd := 3 + len(cmd.ExtraFiles)
+	gate := fmt.Sprintf(`read _ <&%d; exec "$@" %d<&-`, fd, fd)
+	cmd.ExtraFiles = append(cmd.ExtraFiles, r)
+	target := cmd.Path
+	cmd.Args = append([]string{"sh", "-c", gate, "nibot-rlimit", target}, cmd.Args[1:]...)
+	cmd.Path = "/bin/sh"
+
+	return func(pid int) error {
+		defer w.Close()
+		r.Close()
+		for _, l := range []struct {
+			res  int
+			name string
+			val  uint64
+		}{
+			{unix.RLIMIT_CPU, "cpu", limits.CPUSeconds},
+			{unix.RLIMIT_AS, "as", limits.AddressSpaceMB * 1024 * 1024},
+			{unix.RLIMIT_FSIZE, "fsize", limits.FileSizeMB * 1024 * 1024},
+			{unix.RLIMIT_NOFILE, "nofile", limits.OpenFiles},
+			{unix.RLIMIT_NPROC, "nproc", limits.Processes},
+		} {
+			if l.val == 0 {
+				continue
+			}
+			rl := unix.Rlimit{Cur: l.val, Max: l.val}
+			var cur unix.Rlimit
+			if err := unix.Prlimit(pid, l.res, nil, &cur); err == nil && cur.Max != unix.RLIM_INFINITY && cur.Max < l.val {
+				rl = unix.Rlimit{Cur: cur.Max, Max: cur.Max}
+			}
+			if err := unix.Prlimit(pid, l.res, &rl, nil); err != nil {
+				return fmt.Errorf("set rlimit %s=%s: %w", l.name, strconv.FormatUint(l.val, 10), err)
+			}
+		}
+		_, err := w.Write([]byte("\n"))
+		return err
+	}, nil
+}
@@ -0,0 +1,393 @@
+package agent
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// runtime.exec / shell.session / job.start 的命令以及 code.run 的 sh / bash 代码交给 shell 执行，只看第一个词挡不住
+// `git status && curl ... | sh`。这里把命令解析为 shell 语法树，逐个检查管道、列表、子 shell、
+// 命令替换和函数体中的每个简单命令（与实际执行的 sh 一致，按 POSIX 语法解析）：
+//   - 配置了 allowed_runtime_prefixes 时，每个命令名都必须在其中（或是 cd / echo 等无副作用的内建命令），
+//     命令名不能来自变量或命令替换，也不能通过 PATH、LD_PRELOAD 等变量改变实际执行的程序；
+//   - 重定向的目标必须在工作区内（/dev/null 等除外），含变量的目标无法静态检查，直接拒绝；
+//     cd 到工作区外只允许单独使用，否则之后按相对路径的读写都会落到工作区外；
+//   - 始终拒绝 shutdown、mkfs、rm -r / 等明显破坏性的命令。
+// Windows 上命令交给 PowerShell，仍只检查第一个词。
+
+// shellSafeBuiltins 不产生副作用（或副作用另有检查），不需要写进 allowed_runtime_prefixes。
+var shellSafeBuiltins = map[string]bool{
+	"cd": true, "echo": true, "printf": true, "pwd": true, "true": true, "false": true,
+	"test": true, "[": true, ":": true, "exit": true,
+}
+
+// shellSensitiveVars 会改变后续命令实际执行的程序或 shell 的解析方式。
+var shellSensitiveVars = map[string]bool{
+	"PATH": true, "LD_PRELOAD": true, "LD_LIBRARY_PATH": true, "DYLD_INSERT_LIBRARIES": true,
+	"DYLD_LIBRARY_PATH": true, "BASH_ENV": true, "ENV": true, "IFS": true, "SHELLOPTS": true,
+}
+
+// shellDeviceTargets 是允许的工作区外重定向目标。
+var shellDeviceTargets = map[string]bool{"/dev/null": true, "/dev/stdin": true, "/dev/stdout": true, "/dev/stderr": true}
+
+// CheckRuntimeCommand 按策略检查要交给 shell 执行的命令；返回 nil 表示允许，否则说明拒绝原因。
+// workspace 是命令的工作目录，用于判断重定向和 cd 的目标是否在工作区内。
+func (p ToolPolicy) CheckRuntimeCommand(workspace, command string) error {
+	if strings.TrimSpace(command) == "" {
+		return fmt.Errorf("empty command")
+	}
+	if runtime.GOOS == "windows" {
+		return p.checkFirstCommandToken(command)
+	}
+	// runtime.exec / job.start 用 sh -lc 执行，shell.session 用 sh，因此按 POSIX sh 的语法解析
+	return p.checkShellScript(workspace, command, syntax.LangPOSIX)
+}
+
+// checkShellScript 按 lang 的语法解析并检查一段 shell 脚本；code.run 的 bash 代码按 bash 语法解析。
+func (p ToolPolicy) checkShellScript(workspace, command string, lang syntax.LangVariant) error {
+	file, err := syntax.NewParser(syntax.Variant(lang)).Parse(strings.NewReader(command), "")
+	if err != nil {
+		return fmt.Errorf("cannot parse command: %v", err)
+	}
+	if abs, err := filepath.Abs(workspace); err == nil {
+		workspace = abs
+	}
+	c := shellChecker{policy: p, workspace: workspace, restricted: len(p.AllowedRuntimePrefixes) > 0}
+	syntax.Walk(file, func(node syntax.Node) bool {
+		// 返回 false 只停止进入子节点，兄弟节点仍会被访问，所以只保留第一个拒绝原因
+		var err error
+		switch n := node.(type) {
+		case *syntax.Stmt:
+			if n.Cmd == nil && len(n.Redirs) > 0 {
+				// 只有重定向的语句（> x）也会在当前目录写文件
+				c.calls++
+			}
+		case *syntax.CallExpr:
+			err = c.checkCall(n)
+		case *syntax.Redirect:
+			err = c.checkRedirect(n)
+		case *syntax.Assign:
+			if c.restricted && n.Name != nil && shellSensitiveVars[n.Name.Value] {
+				err = fmt.Errorf("assigning %s is not allowed when allowed_runtime_prefixes is set", n.Name.Value)
+			}
+		}
+		if err != nil && c.err == nil {
+			c.err = err
+		}
+		return c.err == nil
+	})
+	// 单独的 cd 不会读写任何东西（shell.session 会把离开工作区的 cwd 复位），
+	// 但之后的命令会在工作区外按相对路径读写
+	if c.err == nil && c.cdErr != nil && c.calls > 1 {
+		c.err = c.cdErr
+	}
+	return c.err
+}
+
+// checkFirstCommandToken 是 Windows 上的检查：只看第一个词是否在 allowed_runtime_prefixes 中。
+func (p ToolPolicy) checkFirstCommandToken(command string) error {
+	if len(p.AllowedRuntimePrefixes) == 0 {
+		return nil
+	}
+	tokens := splitCommandLine(command)
+	if len(tokens) == 0 {
+		return fmt.Errorf("empty command")
+	}
+	if !p.allowsCommandName(tokens[0]) {
+		return fmt.Errorf("%s is not in allowed_runtime_prefixes", tokens[0])
+	}
+	return nil
+}
+
+func (p ToolPolicy) allowsCommandName(name string) bool {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, pref := range p.AllowedRuntimePrefixes {
+		pref = strings.ToLower(strings.TrimSpace(pref))
+		if pref != "" && name == pref {
+			return true
+		}
+	}
+	return false
+}
+
+type shellChecker struct {
+	policy     ToolPolicy
+	workspace  string
+	restricted bool
+	calls      int
+	cdErr      error
+	err        error
+}
+
+func (c *shellChecker) checkCall(n *syntax.CallExpr) error {
+	if len(n.Args) == 0 {
+		// 只有变量赋值，赋值本身在 *syntax.Assign 中检查
+		return nil
+	}
+	c.calls++
+	name, static := shellWord(n.Args[0])
+	if !static {
+		if c.restricted {
+			return fmt.Errorf("command name %s is not a literal", shellSource(n.Args[0]))
+		}
+	} else if c.restricted && (name == "export" || name == "readonly") {
+		// POSIX sh 中 export 是普通命令，赋值写在参数里
+		for _, w := range n.Args[1:] {
+			lit, _ := shellWord(w)
+			if v, _, _ := strings.Cut(lit, "="); shellSensitiveVars[v] {
+				return fmt.Errorf("assigning %s is not allowed when allowed_runtime_prefixes is set", v)
+			}
+		}
+	} else if c.restricted && !shellSafeBuiltins[name] && !c.policy.allowsCommandName(name) {
+		return fmt.Errorf("%s is not in allowed_runtime_prefixes", name)
+	}
+
+	args := make([]string, 0, len(n.Args))
+	for _, w := range n.Args {
+		lit, _ := shellWord(w)
+		args = append(args, lit)
+	}
+	if c.restricted {
+		if err := c.checkWrapped(n.Args); err != nil {
+			return err
+		}
+	}
+	if reason := dangerousShellCommand(c.workspace, args); reason != "" {
+		return fmt.Errorf("%s", reason)
+	}
+	if name == "cd" {
+		var target *syntax.Word
+		for _, w := range n.Args[1:] {
+			if lit, _ := shellWord(w); lit == "-L" || lit == "-P" || lit == "--" {
+				continue
+			}
+			target = w
+			break
+		}
+		if target == nil {
+			c.cdErr = fmt.Errorf("cd without a directory leaves the workspace")
+		} else if err := c.checkPath(target, "cd"); err != nil && c.cdErr == nil {
+			c.cdErr = err
+		}
+	}
+	return nil
+}
+
+// checkWrapped 检查 env / timeout / xargs 等包装命令实际执行的命令是否在 allowed_runtime_prefixes 中。
+// 包装命令后面第一个不是选项、变量赋值或数字（时长）的词被当作命令名；
+// 选项带参数时（timeout -s KILL 5 x）会把参数当作命令名，宁可拒绝也不放过。
+func (c *shellChecker) checkWrapped(words []*syntax.Word) error {
+	for len(words) > 0 {
+		name, _ := shellWord(words[0])
+		if !shellWrapperCommands[strings.ToLower(filepath.Base(name))] {
+			return nil
+		}
+		words = words[1:]
+		for len(words) > 0 {
+			lit, static := shellWord(words[0])
+			if !static {
+				return fmt.Errorf("command run by %s is not a literal: %s", name, shellSource(words[0]))
+			}
+			if strings.HasPrefix(lit, "-") || strings.Contains(lit, "=") || isShellDuration(lit) {
+				words = words[1:]
+				continue
+			}
+			if !shellSafeBuiltins[lit] && !c.policy.allowsCommandName(lit) {
+				return fmt.Errorf("%s (run by %s) is not in allowed_runtime_prefixes", lit, name)
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// isShellDuration 匹配 timeout / sleep 的时长参数：5、1.5、10s、2m。
+func isShellDuration(s string) bool {
+	s = strings.TrimRight(s, "smhd")
+	return s != "" && strings.Trim(s, "0123456789.") == ""
+}
+
+func (c *shellChecker) checkRedirect(r *syntax.Redirect) error {
+	switch r.Op {
+	case syntax.Hdoc, syntax.DashHdoc, syntax.WordHdoc:
+		return nil
+	case syntax.DplIn, syntax.DplOut:
+		// >&2、<&0、>&- 复制或关闭文件描述符；bash 中 >& file 同 &> file
+		if lit, static := shellWord(r.Word); static && (lit == "-" || isDigitsString(lit)) {
+			return nil
+		}
+	}
+	if r.Word == nil {
+		return nil
+	}
+	return c.checkPath(r.Word, "redirection")
+}
+
+func (c *shellChecker) checkPath(w *syntax.Word, what string) error {
+	p, static := shellWord(w)
+	if !static {
+		return fmt.Errorf("%s target %s cannot be checked (variables, globs and ~ are not allowed)", what, shellSource(w))
+	}
+	if what == "redirection" && shellDeviceTargets[p] {
+		return nil
+	}
+	if p == "-" || !pathStaysInWorkspace(c.workspace, p) {
+		return fmt.Errorf("%s target %q is outside the workspace", what, p)
+	}
+	return nil
+}
+
+// pathStaysInWorkspace 判断 p 是否在工作区内。相对路径按当前目录解析，而当前目录可能是工作区的任意子目录
+// （cd 或 shell.session 中之前的命令），所以只要求它不经 .. 回到起点之上。
+func pathStaysInWorkspace(workspace, p string) bool {
+	if filepath.IsAbs(p) {
+		rel, err := filepath.Rel(workspace, filepath.Clean(p))
+		return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+	}
+	depth := 0
+	for _, part := range strings.Split(filepath.ToSlash(p), "/") {
+		switch part {
+		case "", ".":
+		case "..":
+			depth--
+			if depth < 0 {
+				return false
+			}
+		default:
+			depth++
+		}
+	}
+	return true
+}
+
+var shellWrapperCommands = map[string]bool{
+	"sudo": true, "doas": true, "env": true, "nice": true, "nohup": true, "timeout": true,
+	"time": true, "command": true, "exec": true, "xargs": true, "stdbuf": true, "chroot": true,
+}
+
+// dangerousShellCommand 返回明显破坏性命令的拒绝原因；args[0] 是命令名。
+func dangerousShellCommand(workspace string, args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	name := strings.ToLower(filepath.Base(args[0]))
+	if shellWrapperCommands[name] {
+		// sudo / env / timeout 等把后面的参数当作命令执行
+		for i := 1; i < len(args); i++ {
+			if reason := dangerousShellCommand(workspace, args[i:]); reason != "" {
+				return reason
+			}
+		}
+		return ""
+	}
+	switch {
+	case name == "shutdown" || name == "reboot" || name == "halt" || name == "poweroff" || name == "fdisk" || name == "format":
+		return name + " is never allowed"
+	case name == "mkfs" || strings.HasPrefix(name, "mkfs."):
+		return name + " is never allowed"
+	case name == "dd":
+		for _, a := range args[1:] {
+			if strings.HasPrefix(a, "of=/dev/") && !shellDeviceTargets[strings.TrimPrefix(a, "of=")] {
+				return "dd writing to a device is never allowed"
+			}
+		}
+	case name == "rm":
+		recursive := false
+		var targets []string
+		for _, a := range args[1:] {
+			switch {
+			case a == "--recursive" || strings.HasPrefix(a, "-") && !strings.HasPrefix(a, "--") && strings.ContainsAny(a, "rR"):
+				recursive = true
+			case !strings.HasPrefix(a, "-"):
+				targets = append(targets, a)
+			}
+		}
+		if !recursive {
+			return ""
+		}
+		for _, t := range targets {
+			clean := strings.TrimRight(t, "/")
+			switch {
+			case clean == "" || clean == "*" || clean == "/*" || clean == "." || clean == ".." || strings.HasPrefix(t, "~"):
+				return fmt.Sprintf("rm -r %s is never allowed", t)
+			case filepath.IsAbs(t) && !pathStaysInWorkspace(workspace, t):
+				return fmt.Sprintf("rm -r %s is outside the workspace", t)
+			}
+		}
+	}
+	return ""
+}
+
+// shellWord 返回词的字面值。static 为 false 表示含有参数展开、命令替换、算术展开、未加引号的通配符或 ~，
+// 实际值要到执行时才能确定；此时 lit 只包含其中的字面部分。
+func shellWord(w *syntax.Word) (lit string, static bool) {
+	if w == nil {
+		return "", false
+	}
+	var b strings.Builder
+	static = true
+	for i, part := range w.Parts {
+		switch x := part.(type) {
+		case *syntax.Lit:
+			if strings.ContainsAny(x.Value, "*?[") || i == 0 && strings.HasPrefix(x.Value, "~") {
+				static = false
+			}
+			b.WriteString(unescapeShellLit(x.Value))
+		case *syntax.SglQuoted:
+			if x.Dollar {
+				static = false
+			}
+			b.WriteString(x.Value)
+		case *syntax.DblQuoted:
+			for _, inner := range x.Parts {
+				l, ok := inner.(*syntax.Lit)
+				if !ok {
+					static = false
+					continue
+				}
+				b.WriteString(unescapeShellLit(l.Value))
+			}
+		default:
+			static = false
+		}
+	}
+	return b.String(), static
+}
+
+func unescapeShellLit(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func shellSource(node syntax.Node) string {
+	var b strings.Builder
+	if err := syntax.NewPrinter().Print(&b, node); err != nil {
+		return "(unknown)"
+	}
+	return b.String()
+}
+
+func isDigitsString(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
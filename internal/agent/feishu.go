@@ -22,9 +22,11 @@ type FeishuConfig struct {
 	VerificationToken string
 	EncryptKey        string
 	WebhookURL        string
-	Timeout           time.Duration
-	MaxConcurrent     int
-	Debug             bool
+	// APIBase 是开放平台地址，审批卡片和回复以应用身份经 im/v1/messages 发送
+	APIBase       string
+	Timeout       time.Duration
+	MaxConcurrent int
+	Debug         bool
 }
 
 type feishuUserSession struct {
@@ -44,6 +46,10 @@ type FeishuBot struct {
 	cancel     context.CancelFunc
 	sem        chan struct{}
 	httpServer *http.Server
+
+	tokenMu      sync.Mutex
+	token        string
+	tokenExpires time.Time
 }
 
 // FeishuMessage 飞书消息结构
@@ -113,11 +119,16 @@ func NewFeishuConfig() *FeishuConfig {
 		VerificationToken: os.Getenv("FEISHU_VERIFICATION_TOKEN"),
 		EncryptKey:        os.Getenv("FEISHU_ENCRYPT_KEY"),
 		WebhookURL:        os.Getenv("FEISHU_WEBHOOK_URL"),
+		APIBase:           "https://open.feishu.cn",
 		Timeout:           30 * time.Second,
 		MaxConcurrent:     10,
 		Debug:             parseBool(os.Getenv("FEISHU_DEBUG"), false),
 	}
 
+	if v := strings.TrimRight(strings.TrimSpace(os.Getenv("FEISHU_API_BASE")), "/"); v != "" {
+		config.APIBase = v
+	}
+
 	// 解析超时设置
 	if timeoutStr := os.Getenv("FEISHU_TIMEOUT"); timeoutStr != "" {
 		if timeout, err := strconv.Atoi(timeoutStr); err == nil {
@@ -187,6 +198,9 @@ func (fb *FeishuBot) Start(ctx context.Context) error {
 	}
 	ctx, cancel := context.WithCancel(ctx)
 	fb.cancel = cancel
+	RegisterScheduleDeliverer("feishu", func(target, text string) error {
+		return fb.sendReply(target, "", text)
+	})
 
 	go func() {
 		<-ctx.Done()
@@ -307,6 +321,8 @@ func (fb *FeishuBot) handleWebhook(w http.ResponseWriter, r *http.Request) {
 	switch event.Header.EventType {
 	case "im.message.receive_v1":
 		fb.handleMessageReceive(event, w)
+	case "card.action.trigger":
+		fb.handleCardAction(body, w)
 	default:
 		fb.handleOtherEvent(event, w)
 	}
@@ -347,6 +363,11 @@ func (fb *FeishuBot) handleMessageReceive(event FeishuMessageEvent, w http.Respo
 		return
 	}
 
+	session := fb.getUserSession(userID)
+	session.client.Origin = "feishu:" + message.ChatID
+	session.client.User = userID
+	session.client.Approver = fb.approver(session, message.ChatID, userID)
+
 	// 处理消息（使用限流器）
 	select {
 	case fb.sem <- struct{}{}:
@@ -371,6 +392,89 @@ func (fb *FeishuBot) handleMessageReceive(event FeishuMessageEvent, w http.Respo
 	}
 }
 
+// feishuOperatorID 按与消息发送者相同的顺序（user_id、open_id、union_id）取用户 ID。
+func feishuOperatorID(id FeishuSenderID) string {
+	return firstNonEmpty(strings.TrimSpace(id.UserID), strings.TrimSpace(id.OpenID), strings.TrimSpace(id.UnionID))
+}
+
+// approver 以应用身份把带按钮的审批卡片发到 chatID，只有 userID 本人可以点按钮。
+// 按钮回调（card.action.trigger）发到事件订阅地址 /feishu/webhook，见 handleCardAction；
+// 卡片发不出去（应用没有发消息权限、网络错误等）时按拒绝处理。
+func (fb *FeishuBot) approver(us *feishuUserSession, chatID, userID string) *RemoteApprover {
+	return &RemoteApprover{
+		Owner:   userID,
+		Session: us.client.ExecSession(),
+		Send: func(req ApprovalRequest) error {
+			return fb.sendChatMessage(chatID, "interactive", feishuApprovalCard(req))
+		},
+	}
+}
+
+// feishuApprovalCard 是审批请求的消息卡片：正文同 Telegram，三个按钮回传审批 ID 和决定。
+func feishuApprovalCard(req ApprovalRequest) map[string]any {
+	button := func(text, kind, decision string) map[string]any {
+		return map[string]any{
+			"tag":   "button",
+			"text":  map[string]any{"tag": "plain_text", "content": text},
+			"type":  kind,
+			"value": map[string]any{"approval": req.ID, "decision": decision},
+		}
+	}
+	return map[string]any{
+		"config": map[string]any{"update_multi": true},
+		"header": map[string]any{
+			"title":    map[string]any{"tag": "plain_text", "content": "需要审批：" + req.Tool},
+			"template": "orange",
+		},
+		"elements": []any{
+			map[string]any{"tag": "div", "text": map[string]any{"tag": "plain_text", "content": truncateRunes(approvalPromptText(req), 3500)}},
+			map[string]any{"tag": "action", "actions": []any{
+				button("✅ 允许", "primary", ApprovalAllow),
+				button("🔁 本次会话允许", "default", ApprovalSession),
+				button("❌ 拒绝", "danger", ApprovalDeny),
+			}},
+		},
+	}
+}
+
+// handleCardAction 处理审批卡片的按钮回调：只有发起对话的用户能做决定，结果用 toast 提示，
+// 并把卡片换成不带按钮的结果，避免重复点击。
+func (fb *FeishuBot) handleCardAction(body []byte, w http.ResponseWriter) {
+	var ev struct {
+		Event struct {
+			Operator FeishuSenderID `json:"operator"`
+			Action   struct {
+				Value struct {
+					Approval string `json:"approval"`
+					Decision string `json:"decision"`
+				} `json:"value"`
+			} `json:"action"`
+		} `json:"event"`
+	}
+	if err := json.Unmarshal(body, &ev); err != nil {
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+	value := ev.Event.Action.Value
+	resp := map[string]any{}
+	req, err := ResolveApproval(value.Approval, value.Decision, feishuOperatorID(ev.Event.Operator))
+	if err != nil {
+		resp["toast"] = map[string]any{"type": "error", "content": err.Error()}
+	} else {
+		label := approvalDecisionLabel(req, value.Decision)
+		resp["toast"] = map[string]any{"type": "success", "content": label}
+		resp["card"] = map[string]any{"type": "raw", "data": map[string]any{
+			"config": map[string]any{"update_multi": true},
+			"header": map[string]any{"title": map[string]any{"tag": "plain_text", "content": "审批：" + req.Tool}, "template": "grey"},
+			"elements": []any{
+				map[string]any{"tag": "div", "text": map[string]any{"tag": "plain_text", "content": label}},
+			},
+		}}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
 func (fb *FeishuBot) handleURLVerification(challenge string, w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(map[string]interface{}{
@@ -428,6 +532,7 @@ func (fb *FeishuBot) handleCommand(userID, command string, session *feishuUserSe
 		fb.mu.Lock()
 		delete(fb.sessions, userID)
 		fb.mu.Unlock()
+		forgetSessionApprovals(session.client.ExecSession())
 		return "✅ 会话已重置", nil
 	case "/reload":
 		return "🔄 配置重载功能开发中", nil
@@ -439,9 +544,23 @@ func (fb *FeishuBot) handleCommand(userID, command string, session *feishuUserSe
 }
 
 func (fb *FeishuBot) handleLLMMessage(userID, text string, session *feishuUserSession) (string, error) {
-	// 使用LLM处理消息（这里需要实现具体的消息处理逻辑）
-	// 暂时返回模拟响应
-	return fmt.Sprintf("🤖 Ni Bot 收到消息：%s\n\n这是模拟响应，实际需要集成LLM处理", text), nil
+	if session == nil || session.client == nil {
+		return "", fmt.Errorf("session not initialized")
+	}
+	if session.sessionManager != nil {
+		session.sessionManager.IncrementMessageCount()
+		session.sessionManager.SetCurrentTask(text)
+		session.sessionManager.RecordMessage("user", text)
+	}
+	// 工具调用和审批都在 Chat 中处理；需要审批时这一轮在此等待卡片回调
+	resp, err := session.client.Chat(text)
+	if err != nil {
+		return "", err
+	}
+	if session.sessionManager != nil {
+		session.sessionManager.RecordMessage("assistant", resp)
+	}
+	return resp, nil
 }
 
 func (fb *FeishuBot) sendReply(chatID, messageID, content string) error {
@@ -452,7 +571,8 @@ func (fb *FeishuBot) sendReply(chatID, messageID, content string) error {
 	}
 	log.Printf("Sending reply to chat %s message %s: %s", chatID, messageID, c)
 	if strings.TrimSpace(fb.config.WebhookURL) == "" {
-		return nil
+		// 没有自定义机器人 webhook 时以应用身份发回会话
+		return fb.sendChatMessage(chatID, "text", map[string]any{"text": c})
 	}
 
 	payload := map[string]any{
@@ -490,6 +610,89 @@ func (fb *FeishuBot) sendReply(chatID, messageID, content string) error {
 	return nil
 }
 
+// tenantToken 返回应用的 tenant_access_token，过期前一分钟重新获取。
+func (fb *FeishuBot) tenantToken() (string, error) {
+	fb.tokenMu.Lock()
+	defer fb.tokenMu.Unlock()
+	if fb.token != "" && time.Now().Before(fb.tokenExpires) {
+		return fb.token, nil
+	}
+	var out struct {
+		Code   int    `json:"code"`
+		Msg    string `json:"msg"`
+		Token  string `json:"tenant_access_token"`
+		Expire int    `json:"expire"`
+	}
+	err := fb.postAPI("/open-apis/auth/v3/tenant_access_token/internal", "", map[string]string{
+		"app_id":     fb.config.AppID,
+		"app_secret": fb.config.AppSecret,
+	}, &out)
+	if err != nil {
+		return "", err
+	}
+	if out.Code != 0 || out.Token == "" {
+		return "", fmt.Errorf("feishu tenant_access_token failed: %d %s", out.Code, out.Msg)
+	}
+	fb.token = out.Token
+	fb.tokenExpires = time.Now().Add(time.Duration(out.Expire)*time.Second - time.Minute)
+	return fb.token, nil
+}
+
+// sendChatMessage 以应用身份向会话发一条消息（im/v1/messages），content 是消息内容对象。
+func (fb *FeishuBot) sendChatMessage(chatID, msgType string, content any) error {
+	token, err := fb.tenantToken()
+	if err != nil {
+		return err
+	}
+	c, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+	var out FeishuAPIResponse
+	err = fb.postAPI("/open-apis/im/v1/messages?receive_id_type=chat_id", token, map[string]any{
+		"receive_id": chatID,
+		"msg_type":   msgType,
+		"content":    string(c),
+	}, &out)
+	if err != nil {
+		return err
+	}
+	if out.Code != 0 {
+		return fmt.Errorf("feishu send %s message failed: %d %s", msgType, out.Code, out.Msg)
+	}
+	return nil
+}
+
+func (fb *FeishuBot) postAPI(path, token string, payload any, out any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, fb.config.APIBase+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	client := &http.Client{Timeout: fb.config.Timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg := strings.TrimSpace(string(b))
+		if msg == "" {
+			msg = resp.Status
+		}
+		return fmt.Errorf("feishu %s failed: %s", path, msg)
+	}
+	return json.Unmarshal(b, out)
+}
+
 func (fb *FeishuBot) handleHealthCheck(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -0,0 +1,87 @@
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFeishuApprovalCard(t *testing.T) {
+	t.Setenv("NIBOT_AUTO_APPROVE", "")
+	cards := make(chan map[string]any, 4)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/open-apis/auth/v3/tenant_access_token/internal":
+			_, _ = w.Write([]byte(`{"code":0,"tenant_access_token":"t-1","expire":7200}`))
+		case "/open-apis/im/v1/messages":
+			if r.Header.Get("Authorization") != "Bearer t-1" || r.URL.Query().Get("receive_id_type") != "chat_id" {
+				t.Errorf("unexpected request: %s %v", r.URL, r.Header)
+			}
+			var msg struct {
+				ReceiveID string `json:"receive_id"`
+				MsgType   string `json:"msg_type"`
+				Content   string `json:"content"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&msg)
+			if msg.ReceiveID != "oc_1" || msg.MsgType != "interactive" {
+				t.Errorf("unexpected message: %+v", msg)
+			}
+			var card map[string]any
+			if err := json.Unmarshal([]byte(msg.Content), &card); err != nil {
+				t.Errorf("card content: %v", err)
+			}
+			cards <- card
+			_, _ = w.Write([]byte(`{"code":0}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer api.Close()
+
+	fb, err := NewFeishuBot(&FeishuConfig{AppID: "a", AppSecret: "s", VerificationToken: "vt", APIBase: api.URL, Timeout: 5 * time.Second}, Config{}, t.TempDir(), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	us := fb.getUserSession("u_1")
+	approver := fb.approver(us, "oc_1", "u_1")
+	approver.Timeout = 5 * time.Second
+	defer forgetSessionApprovals(approver.Session)
+
+	click := func(id, decision, operator string) map[string]any {
+		body := `{"schema":"2.0","header":{"event_type":"card.action.trigger","token":"vt"},"event":{"operator":{"user_id":"` + operator +
+			`"},"action":{"value":{"approval":"` + id + `","decision":"` + decision + `"}}}}`
+		rec := httptest.NewRecorder()
+		fb.handleWebhook(rec, httptest.NewRequest(http.MethodPost, "/feishu/webhook", strings.NewReader(body)))
+		var resp map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Errorf("card callback response %d %q: %v", rec.Code, rec.Body.String(), err)
+		}
+		return resp
+	}
+
+	go func() {
+		card := <-cards
+		b, _ := json.Marshal(card)
+		if !strings.Contains(string(b), "fs.write") || !strings.Contains(string(b), `"decision":"session"`) {
+			t.Errorf("unexpected card: %s", b)
+		}
+		req := PendingApprovals("u_1")
+		if len(req) != 1 {
+			t.Errorf("expected one pending approval, got %v", req)
+			return
+		}
+		if resp := click(req[0].ID, ApprovalAllow, "u_2"); resp["toast"].(map[string]any)["type"] != "error" || resp["card"] != nil {
+			t.Errorf("another user must not answer the approval: %v", resp)
+		}
+		if resp := click(req[0].ID, ApprovalAllow, "u_1"); resp["toast"].(map[string]any)["type"] != "success" || resp["card"] == nil {
+			t.Errorf("expected the approval to be resolved: %v", resp)
+		}
+	}()
+
+	if !approver.Approve(ExecCall{Tool: "fs.write", ArgsRaw: `{"path":"memory/a.md","content":"x"}`}) {
+		t.Fatalf("expected the card click to approve the call")
+	}
+}
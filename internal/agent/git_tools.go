@@ -0,0 +1,465 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// git.status / git.diff / git.log / git.commit：在工作区所在的 git 仓库中执行固定的 git 子命令，
+// 解析输出并限制长度。它们不经过 runtime.exec，因此关闭通用命令执行时也可用；
+// 只作用于工作区目录（pathspec 限定为工作区内的路径），并默认禁用仓库 hooks（NIBOT_GIT_HOOKS=1 开启）。
+
+const gitListLimit = 200
+
+func gitDiffMaxBytes() int {
+	return parseIntEnv("NIBOT_GIT_DIFF_MAX_BYTES", 64*1024, 1024, 4*1024*1024)
+}
+
+// runGit 在工作区中运行 git，返回 stdout；失败时错误里带上 stderr 的第一段。
+func runGit(ctx ExecContext, timeout time.Duration, args ...string) (string, error) {
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		return "", fmt.Errorf("git not found in PATH")
+	}
+	base := []string{"-c", "core.quotepath=off", "-c", "color.ui=false", "-c", "core.pager=cat"}
+	if !parseBool(os.Getenv("NIBOT_GIT_HOOKS"), false) {
+		base = append(base, "-c", "core.hooksPath="+os.DevNull)
+	}
+	cmd := exec.Command(gitPath, append(base, args...)...)
+	cmd.Dir = ctx.Workspace
+	// git 的环境同样经过清理，但不记入调用的审计：git.* 工具的内部命令不是模型要求启动的子进程
+	env, _ := buildChildEnv(ctx.Policy, nil, nil)
+	cmd.Env = append(env, "GIT_TERMINAL_PROMPT=0", "GIT_OPTIONAL_LOCKS=0", "LC_ALL=C")
+	stdout := newCappedBuffer(4 * 1024 * 1024)
+	stderr := newCappedBuffer(16 * 1024)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := runWithTimeout(cmd, timeout); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = strings.TrimSpace(stdout.String())
+		}
+		if msg == "" {
+			msg = err.Error()
+		}
+		if strings.Contains(msg, "not a git repository") {
+			return "", fmt.Errorf("workspace is not inside a git repository")
+		}
+		return stdout.String(), fmt.Errorf("git %s failed: %s", gitSubcommand(args), truncateUTF8(msg, 1000))
+	}
+	return stdout.String(), nil
+}
+
+func gitSubcommand(args []string) string {
+	for i := 0; i < len(args); i++ {
+		if args[i] == "-c" {
+			i++
+			continue
+		}
+		return args[i]
+	}
+	return ""
+}
+
+// gitPrefix 返回工作区相对仓库根目录的路径（例如 "workspace/"），用于把 git 输出的路径换算成工作区相对路径。
+func gitPrefix(ctx ExecContext) (string, error) {
+	out, err := runGit(ctx, 10*time.Second, "rev-parse", "--show-prefix")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// gitPathspecs 校验并返回 pathspec；为空时限定为整个工作区。
+func gitPathspecs(ctx ExecContext, paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		return []string{"--", "."}, nil
+	}
+	out := []string{"--"}
+	for _, p := range paths {
+		p = normalizeWorkspaceRelPath(p)
+		if p == "" || p == "." {
+			out = append(out, ".")
+			continue
+		}
+		if _, err := resolveWorkspacePath(ctx.Workspace, p); err != nil {
+			return nil, fmt.Errorf("invalid path %q: %v", p, err)
+		}
+		out = append(out, ":(literal)"+p)
+	}
+	return out, nil
+}
+
+var gitRefPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/~^@{}-]*$`)
+
+func checkGitRef(ref string) error {
+	if !gitRefPattern.MatchString(ref) || strings.Contains(ref, "..") {
+		return fmt.Errorf("invalid ref %q", ref)
+	}
+	return nil
+}
+
+type gitStatusEntry struct {
+	Code string
+	Path string
+	From string
+}
+
+type gitStatus struct {
+	Branch    string
+	Upstream  string
+	Ahead     int
+	Behind    int
+	Staged    []gitStatusEntry
+	Unstaged  []gitStatusEntry
+	Untracked []string
+	Conflicts []string
+}
+
+// parseGitStatus 解析 git status --porcelain=v2 --branch -z 的输出；路径转换为相对 prefix。
+func parseGitStatus(out, prefix string) gitStatus {
+	var st gitStatus
+	rel := func(p string) string { return strings.TrimPrefix(p, prefix) }
+	recs := strings.Split(out, "\x00")
+	for i := 0; i < len(recs); i++ {
+		r := recs[i]
+		switch {
+		case strings.HasPrefix(r, "# branch.head "):
+			st.Branch = strings.TrimPrefix(r, "# branch.head ")
+		case strings.HasPrefix(r, "# branch.upstream "):
+			st.Upstream = strings.TrimPrefix(r, "# branch.upstream ")
+		case strings.HasPrefix(r, "# branch.ab "):
+			f := strings.Fields(strings.TrimPrefix(r, "# branch.ab "))
+			if len(f) == 2 {
+				st.Ahead, _ = strconv.Atoi(strings.TrimPrefix(f[0], "+"))
+				st.Behind, _ = strconv.Atoi(strings.TrimPrefix(f[1], "-"))
+			}
+		case strings.HasPrefix(r, "1 "), strings.HasPrefix(r, "2 "):
+			n := 9
+			if r[0] == '2' {
+				n = 10
+			}
+			f := strings.SplitN(r, " ", n)
+			if len(f) < n {
+				continue
+			}
+			e := gitStatusEntry{Path: rel(f[n-1])}
+			if r[0] == '2' && i+1 < len(recs) {
+				i++
+				e.From = rel(recs[i])
+			}
+			xy := f[1]
+			if xy[0] != '.' {
+				s := e
+				s.Code = string(xy[0])
+				st.Staged = append(st.Staged, s)
+			}
+			if xy[1] != '.' {
+				u := e
+				u.Code = string(xy[1])
+				st.Unstaged = append(st.Unstaged, u)
+			}
+		case strings.HasPrefix(r, "u "):
+			f := strings.SplitN(r, " ", 11)
+			if len(f) == 11 {
+				st.Conflicts = append(st.Conflicts, rel(f[10]))
+			}
+		case strings.HasPrefix(r, "? "):
+			st.Untracked = append(st.Untracked, rel(r[2:]))
+		}
+	}
+	return st
+}
+
+func (st gitStatus) format() string {
+	var b strings.Builder
+	b.WriteString("branch: " + st.Branch)
+	if st.Upstream != "" {
+		fmt.Fprintf(&b, " (upstream %s, ahead %d, behind %d)", st.Upstream, st.Ahead, st.Behind)
+	}
+	b.WriteString("\n")
+	if len(st.Staged)+len(st.Unstaged)+len(st.Untracked)+len(st.Conflicts) == 0 {
+		b.WriteString("clean: no changes under the workspace\n")
+		return b.String()
+	}
+	entries := func(title string, list []gitStatusEntry) {
+		if len(list) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "%s (%d):\n", title, len(list))
+		for i, e := range list {
+			if i == gitListLimit {
+				fmt.Fprintf(&b, "  ... and %d more\n", len(list)-gitListLimit)
+				break
+			}
+			if e.From != "" {
+				fmt.Fprintf(&b, "  %s %s -> %s\n", e.Code, e.From, e.Path)
+			} else {
+				fmt.Fprintf(&b, "  %s %s\n", e.Code, e.Path)
+			}
+		}
+	}
+	paths := func(title string, list []string) {
+		if len(list) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "%s (%d):\n", title, len(list))
+		for i, p := range list {
+			if i == gitListLimit {
+				fmt.Fprintf(&b, "  ... and %d more\n", len(list)-gitListLimit)
+				break
+			}
+			b.WriteString("  " + p + "\n")
+		}
+	}
+	paths("conflicts", st.Conflicts)
+	entries("staged", st.Staged)
+	entries("unstaged", st.Unstaged)
+	paths("untracked", st.Untracked)
+	return b.String()
+}
+
+func toolGitStatus(ctx ExecContext, argsRaw string) (string, error) {
+	if s := strings.TrimSpace(argsRaw); s != "" && s != "{}" {
+		return "", fmt.Errorf("git.status takes no arguments")
+	}
+	prefix, err := gitPrefix(ctx)
+	if err != nil {
+		return "", err
+	}
+	out, err := runGit(ctx, 30*time.Second, "status", "--porcelain=v2", "--branch", "-z", "--untracked-files=all", "--", ".")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(parseGitStatus(out, prefix).format(), "\n"), nil
+}
+
+type gitDiffArgs struct {
+	Staged bool     `json:"staged"`
+	Ref    string   `json:"ref"`
+	Paths  []string `json:"paths"`
+	Stat   bool     `json:"stat"`
+}
+
+type gitNumstat struct {
+	Path    string
+	Added   int
+	Deleted int
+	Binary  bool
+}
+
+// parseGitNumstat 解析 git diff --numstat -z；重命名记录为 "a\tb\t\0from\0to\0"。
+func parseGitNumstat(out, prefix string) []gitNumstat {
+	var list []gitNumstat
+	recs := strings.Split(out, "\x00")
+	for i := 0; i < len(recs); i++ {
+		f := strings.SplitN(recs[i], "\t", 3)
+		if len(f) != 3 {
+			continue
+		}
+		e := gitNumstat{Path: strings.TrimPrefix(f[2], prefix)}
+		if f[0] == "-" {
+			e.Binary = true
+		} else {
+			e.Added, _ = strconv.Atoi(f[0])
+			e.Deleted, _ = strconv.Atoi(f[1])
+		}
+		if f[2] == "" && i+2 < len(recs) {
+			e.Path = strings.TrimPrefix(recs[i+1], prefix) + " -> " + strings.TrimPrefix(recs[i+2], prefix)
+			i += 2
+		}
+		list = append(list, e)
+	}
+	return list
+}
+
+func formatGitNumstat(list []gitNumstat) string {
+	added, deleted := 0, 0
+	var b strings.Builder
+	for i, e := range list {
+		added += e.Added
+		deleted += e.Deleted
+		if i < gitListLimit {
+			if e.Binary {
+				fmt.Fprintf(&b, "  %s (binary)\n", e.Path)
+			} else {
+				fmt.Fprintf(&b, "  %s +%d -%d\n", e.Path, e.Added, e.Deleted)
+			}
+		}
+	}
+	if len(list) > gitListLimit {
+		fmt.Fprintf(&b, "  ... and %d more\n", len(list)-gitListLimit)
+	}
+	return fmt.Sprintf("%d file(s) changed, +%d -%d\n", len(list), added, deleted) + b.String()
+}
+
+func toolGitDiff(ctx ExecContext, argsRaw string) (string, error) {
+	var a gitDiffArgs
+	if strings.TrimSpace(argsRaw) != "" {
+		if !strings.HasPrefix(strings.TrimSpace(argsRaw), "{") {
+			return "", fmt.Errorf("git.diff requires JSON args: {\"staged\":false,\"paths\":[\"memory/\"]}")
+		}
+		if err := json.Unmarshal([]byte(argsRaw), &a); err != nil {
+			return "", fmt.Errorf("invalid JSON args for git.diff: %w", err)
+		}
+	}
+	base := []string{"diff", "--no-ext-diff", "--no-textconv"}
+	if a.Staged {
+		base = append(base, "--cached")
+	}
+	if a.Ref = strings.TrimSpace(a.Ref); a.Ref != "" {
+		if err := checkGitRef(a.Ref); err != nil {
+			return "", err
+		}
+		base = append(base, a.Ref)
+	}
+	spec, err := gitPathspecs(ctx, a.Paths)
+	if err != nil {
+		return "", err
+	}
+	prefix, err := gitPrefix(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	numstat, err := runGit(ctx, 30*time.Second, append(append(append([]string{}, base...), "--numstat", "-z"), spec...)...)
+	if err != nil {
+		return "", err
+	}
+	files := parseGitNumstat(numstat, prefix)
+	if len(files) == 0 {
+		return "no differences", nil
+	}
+	summary := formatGitNumstat(files)
+	if a.Stat {
+		return strings.TrimRight(summary, "\n"), nil
+	}
+
+	patch, err := runGit(ctx, 30*time.Second, append(append(append([]string{}, base...), "--relative"), spec...)...)
+	if err != nil {
+		return "", err
+	}
+	max := gitDiffMaxBytes()
+	if len(patch) > max {
+		patch = truncateUTF8(patch, max) + fmt.Sprintf("\n[diff truncated at %d bytes; pass paths to narrow it down]", max)
+	}
+	return summary + "\n" + strings.TrimRight(patch, "\n"), nil
+}
+
+type gitLogArgs struct {
+	Limit int    `json:"limit"`
+	Path  string `json:"path"`
+	Ref   string `json:"ref"`
+}
+
+func toolGitLog(ctx ExecContext, argsRaw string) (string, error) {
+	var a gitLogArgs
+	if strings.TrimSpace(argsRaw) != "" {
+		if !strings.HasPrefix(strings.TrimSpace(argsRaw), "{") {
+			return "", fmt.Errorf("git.log requires JSON args: {\"limit\":20,\"path\":\"memory/\"}")
+		}
+		if err := json.Unmarshal([]byte(argsRaw), &a); err != nil {
+			return "", fmt.Errorf("invalid JSON args for git.log: %w", err)
+		}
+	}
+	if a.Limit <= 0 {
+		a.Limit = 20
+	}
+	if a.Limit > gitListLimit {
+		a.Limit = gitListLimit
+	}
+	args := []string{"log", "-n", strconv.Itoa(a.Limit), "--format=%h%x1f%an%x1f%aI%x1f%s%x1e"}
+	if a.Ref = strings.TrimSpace(a.Ref); a.Ref != "" {
+		if err := checkGitRef(a.Ref); err != nil {
+			return "", err
+		}
+		args = append(args, a.Ref)
+	}
+	var paths []string
+	if strings.TrimSpace(a.Path) != "" {
+		paths = []string{a.Path}
+	}
+	spec, err := gitPathspecs(ctx, paths)
+	if err != nil {
+		return "", err
+	}
+	out, err := runGit(ctx, 30*time.Second, append(args, spec...)...)
+	if err != nil {
+		if strings.Contains(err.Error(), "does not have any commits") {
+			return "(no commits yet)", nil
+		}
+		return "", err
+	}
+	var lines []string
+	for _, rec := range strings.Split(out, "\x1e") {
+		f := strings.Split(strings.TrimSpace(rec), "\x1f")
+		if len(f) != 4 {
+			continue
+		}
+		date := f[2]
+		if t, err := time.Parse(time.RFC3339, date); err == nil {
+			date = t.Format("2006-01-02 15:04")
+		}
+		lines = append(lines, fmt.Sprintf("%s %s %s: %s", f[0], date, f[1], truncateUTF8(f[3], 200)))
+	}
+	if len(lines) == 0 {
+		return "(no commits)", nil
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+type gitCommitArgs struct {
+	Message string   `json:"message"`
+	Paths   []string `json:"paths"`
+}
+
+// gitCommitExcludes 是提交整个工作区时排除的目录：data/ 有 config.*（API key）、nibot.db 和审批记录，
+// logs/ 是运行日志。需要提交其中的文件时在 paths 中明确写出。
+var gitCommitExcludes = []string{":(exclude)data", ":(exclude)logs"}
+
+func toolGitCommit(ctx ExecContext, argsRaw string) (string, error) {
+	if !strings.HasPrefix(strings.TrimSpace(argsRaw), "{") {
+		return "", fmt.Errorf("git.commit requires JSON args: {\"message\":\"...\",\"paths\":[\"memory/notes.md\"]}")
+	}
+	var a gitCommitArgs
+	if err := json.Unmarshal([]byte(argsRaw), &a); err != nil {
+		return "", fmt.Errorf("invalid JSON args for git.commit: %w", err)
+	}
+	if strings.HasPrefix(ctx.Origin, "schedule:") {
+		return "", fmt.Errorf("scheduled tasks cannot commit")
+	}
+	a.Message = strings.TrimSpace(a.Message)
+	if a.Message == "" {
+		return "", fmt.Errorf("message is required")
+	}
+	spec, err := gitPathspecs(ctx, a.Paths)
+	if err != nil {
+		return "", err
+	}
+	if containsString(spec, ".") {
+		spec = append(spec, gitCommitExcludes...)
+	}
+	if _, err := runGit(ctx, 60*time.Second, append([]string{"add", "-A"}, spec...)...); err != nil {
+		return "", err
+	}
+
+	args := []string{"commit", "-m", a.Message}
+	if name, _ := runGit(ctx, 10*time.Second, "config", "user.email"); strings.TrimSpace(name) == "" {
+		// 仓库和全局都没有配置身份时使用默认身份，避免提交直接失败
+		args = append([]string{"-c", "user.name=Ni bot", "-c", "user.email=nibot@localhost"}, args...)
+	}
+	out, err := runGit(ctx, 60*time.Second, append(args, spec...)...)
+	if err != nil {
+		if strings.Contains(out, "nothing to commit") || strings.Contains(out, "no changes added") || strings.Contains(err.Error(), "nothing to commit") {
+			return "", fmt.Errorf("nothing to commit under the given paths")
+		}
+		return "", err
+	}
+	return truncateUTF8(strings.TrimSpace(out), 4000), nil
+}
@@ -0,0 +1,181 @@
+package agent
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newGitWorkspace 建一个仓库，工作区是其中的 workspace/ 子目录，仓库根目录另有一个工作区外的文件。
+func newGitWorkspace(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	root := t.TempDir()
+	ws := filepath.Join(root, "workspace")
+	for _, p := range []string{"workspace/memory/notes.md", "outside.txt"} {
+		full := filepath.Join(root, p)
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte("line 1\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "main"},
+		{"config", "user.name", "Test"},
+		{"config", "user.email", "test@example.com"},
+		{"add", "-A"},
+		{"commit", "-q", "-m", "initial"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = root
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	return ws
+}
+
+func TestGitTools_StatusDiffCommitLog(t *testing.T) {
+	ws := newGitWorkspace(t)
+	root := filepath.Dir(ws)
+	if err := os.WriteFile(filepath.Join(ws, "memory", "notes.md"), []byte("line 1\nline 2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(ws, "memory", "新文件.md"), []byte("x\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "outside.txt"), []byte("changed\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := ExecContext{Workspace: ws, Policy: DefaultToolPolicy()}
+	ctx.Policy.AllowRuntimeExec = false
+
+	out, err := toolGitStatus(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "branch: main") || !strings.Contains(out, "unstaged (1):\n  M memory/notes.md") || !strings.Contains(out, "untracked (1):\n  memory/新文件.md") {
+		t.Fatalf("unexpected status:\n%s", out)
+	}
+	if strings.Contains(out, "outside.txt") {
+		t.Fatalf("status should be limited to the workspace:\n%s", out)
+	}
+
+	out, err = toolGitDiff(ctx, `{}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out, "1 file(s) changed, +1 -0\n  memory/notes.md +1 -0") || !strings.Contains(out, "+line 2") || strings.Contains(out, "outside") {
+		t.Fatalf("unexpected diff:\n%s", out)
+	}
+	if out, _ := toolGitDiff(ctx, `{"stat":true}`); strings.Contains(out, "+line 2") {
+		t.Fatalf("stat should omit the patch:\n%s", out)
+	}
+	for _, bad := range []string{`{"paths":["../outside.txt"]}`, `{"ref":"--output=/tmp/x"}`, `{"ref":"HEAD..main"}`} {
+		if _, err := toolGitDiff(ctx, bad); err == nil {
+			t.Errorf("expected error for %s", bad)
+		}
+	}
+
+	// commit 需要审批，且只提交工作区内的改动
+	approver := &countingApprover{}
+	res := ExecuteCalls(ctx, []ExecCall{{Tool: "git.commit", ArgsRaw: `{"message":"Update notes"}`}}, approver)
+	if len(res) != 1 || !res[0].OK || approver.n != 1 {
+		t.Fatalf("commit: %+v approvals=%d", res, approver.n)
+	}
+	out, _ = toolGitStatus(ctx, "")
+	if !strings.Contains(out, "clean: no changes under the workspace") {
+		t.Fatalf("workspace should be clean after commit:\n%s", out)
+	}
+	cmd := exec.Command("git", "status", "--porcelain")
+	cmd.Dir = root
+	if b, _ := cmd.Output(); !strings.Contains(string(b), "outside.txt") {
+		t.Fatalf("changes outside the workspace must not be committed: %q", b)
+	}
+	if _, err := toolGitCommit(ctx, `{"message":"again"}`); err == nil || !strings.Contains(err.Error(), "nothing to commit") {
+		t.Fatalf("expected nothing to commit, got %v", err)
+	}
+
+	out, err = toolGitLog(ctx, `{"limit":5}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(out, "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], "Test: Update notes") || !strings.HasSuffix(lines[1], "Test: initial") {
+		t.Fatalf("unexpected log:\n%s", out)
+	}
+	if out, _ := toolGitLog(ctx, `{"path":"memory/新文件.md"}`); !strings.Contains(out, "Update notes") || strings.Contains(out, "initial") {
+		t.Fatalf("path filter not applied:\n%s", out)
+	}
+}
+
+func TestGitTools_Bounds(t *testing.T) {
+	ws := newGitWorkspace(t)
+	t.Setenv("NIBOT_GIT_DIFF_MAX_BYTES", "1024")
+	if err := os.WriteFile(filepath.Join(ws, "memory", "notes.md"), []byte(strings.Repeat("a long line of text\n", 500)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := ExecContext{Workspace: ws}
+	out, err := toolGitDiff(ctx, ``)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "+500 -1") || !strings.Contains(out, "[diff truncated at 1024 bytes") || len(out) > 2048 {
+		t.Fatalf("diff should be summarized and truncated (%d bytes):\n%s", len(out), out)
+	}
+
+	if _, err := toolGitStatus(ExecContext{Workspace: t.TempDir()}, ""); err == nil || !strings.Contains(err.Error(), "not inside a git repository") {
+		t.Fatalf("expected not-a-repo error, got %v", err)
+	}
+	if _, err := toolGitCommit(ExecContext{Workspace: ws, Origin: "schedule:1"}, `{"message":"x"}`); err == nil {
+		t.Fatalf("scheduled runs must not commit")
+	}
+}
+
+func TestGitCommit_DefaultSkipsDataAndLogs(t *testing.T) {
+	ws := newGitWorkspace(t)
+	ctx := ExecContext{Workspace: ws, Policy: DefaultToolPolicy()}
+	for p, content := range map[string]string{
+		"data/config.toml": "api_key = \"sk-secret\"\n",
+		"logs/nibot.log":   "x\n",
+		"memory/notes.md":  "line 1\nline 2\n",
+		"memory/logs/a.md": "nested logs dir is fine\n",
+	} {
+		full := filepath.Join(ws, p)
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := toolGitCommit(ctx, `{"message":"Update notes","paths":["."]}`); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("git", "ls-files")
+	cmd.Dir = ws
+	b, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := string(b)
+	if strings.Contains(files, "data/config.toml") || strings.Contains(files, "logs/nibot.log") {
+		t.Fatalf("data/ and logs/ must not be committed by default:\n%s", files)
+	}
+	if !strings.Contains(files, "memory/logs/a.md") {
+		t.Fatalf("expected the other changes to be committed:\n%s", files)
+	}
+
+	// 明确写出路径时照常提交
+	if _, err := toolGitCommit(ctx, `{"message":"Add log","paths":["logs/nibot.log"]}`); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,439 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type backgroundJob struct {
+	ID        string
+	Command   string
+	Workspace string
+	LogPath   string
+	StartedAt time.Time
+	EndedAt   time.Time
+	Status    string
+	ExitCode  int
+	Error     string
+
+	cmd    *exec.Cmd
+	done   chan struct{}
+	killed bool
+}
+
+type jobManager struct {
+	mu   sync.Mutex
+	jobs map[string]*backgroundJob
+	seq  int
+}
+
+// 后台任务在进程内全局保存，跨轮次存活；进程退出时不会持久化。
+var defaultJobManager = &jobManager{jobs: map[string]*backgroundJob{}}
+
+func jobMaxRunning() int {
+	return parseIntEnv("NIBOT_JOB_MAX_RUNNING", 4, 1, 32)
+}
+
+func jobMaxDuration() time.Duration {
+	return time.Duration(parseIntEnv("NIBOT_JOB_MAX_SECONDS", 6*3600, 10, 7*24*3600)) * time.Second
+}
+
+// jobMaxLogBytes 是单个任务日志（当前文件加一个轮转文件）占用的上限。
+func jobMaxLogBytes() int64 {
+	return int64(parseIntEnv("NIBOT_JOB_MAX_LOG_KB", 10*1024, 16, 1024*1024)) * 1024
+}
+
+func jobFinishedTTL() time.Duration {
+	return time.Duration(parseIntEnv("NIBOT_JOB_TTL_SECONDS", 3600, 60, 30*24*3600)) * time.Second
+}
+
+func jobMaxFinished() int {
+	return parseIntEnv("NIBOT_JOB_MAX_FINISHED", 50, 1, 1000)
+}
+
+// jobLog 是后台任务的 stdout/stderr：当前文件写满上限的一半时改名为 <id>.log.1（覆盖旧的），
+// 重新开始写，日志总大小不超过 jobMaxLogBytes，且总能看到最新的输出。
+type jobLog struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	size int64
+	max  int64
+}
+
+func openJobLog(path string, max int64) (*jobLog, error) {
+	_ = os.Remove(path + ".1")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &jobLog{path: path, f: f, max: max}, nil
+}
+
+func (l *jobLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := len(p)
+	half := l.max / 2
+	for len(p) > 0 {
+		if l.size >= half {
+			if err := l.rotate(); err != nil {
+				return n - len(p), err
+			}
+		}
+		chunk := p
+		if int64(len(chunk)) > half-l.size {
+			chunk = chunk[:half-l.size]
+		}
+		w, err := l.f.Write(chunk)
+		l.size += int64(w)
+		p = p[w:]
+		if err != nil {
+			return n - len(p), err
+		}
+	}
+	return n, nil
+}
+
+func (l *jobLog) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	l.f = f
+	l.size = 0
+	return nil
+}
+
+func (l *jobLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// readJobLogTail 读取日志末尾最多 maxBytes 字节，当前文件不够时从轮转文件补齐。
+func readJobLogTail(path string, maxBytes int64) ([]byte, error) {
+	cur, err := readFileTail(path, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(cur)) >= maxBytes {
+		return cur, nil
+	}
+	prev, err := readFileTail(path+".1", maxBytes-int64(len(cur)))
+	if err != nil {
+		return cur, nil
+	}
+	return append(prev, cur...), nil
+}
+
+// pruneLocked 删除结束超过 NIBOT_JOB_TTL_SECONDS 的任务，已结束的任务超过 NIBOT_JOB_MAX_FINISHED
+// 时从最早结束的开始删除；日志文件一并删除。调用方持有 m.mu。
+func (m *jobManager) pruneLocked(now time.Time) {
+	var finished []*backgroundJob
+	for _, j := range m.jobs {
+		if !j.EndedAt.IsZero() {
+			finished = append(finished, j)
+		}
+	}
+	sort.Slice(finished, func(i, k int) bool { return finished[i].EndedAt.Before(finished[k].EndedAt) })
+	ttl := jobFinishedTTL()
+	extra := len(finished) - jobMaxFinished()
+	for i, j := range finished {
+		if i >= extra && now.Sub(j.EndedAt) < ttl {
+			continue
+		}
+		delete(m.jobs, j.ID)
+		abs := filepath.Join(j.Workspace, filepath.FromSlash(j.LogPath))
+		_ = os.Remove(abs)
+		_ = os.Remove(abs + ".1")
+	}
+}
+
+func (m *jobManager) start(ctx ExecContext, command string, argv []string) (*backgroundJob, error) {
+	workspace := ctx.Workspace
+	m.mu.Lock()
+	m.pruneLocked(time.Now())
+	running := 0
+	for _, j := range m.jobs {
+		if j.Status == "running" || j.Status == "starting" {
+			running++
+		}
+	}
+	if running >= jobMaxRunning() {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("too many running jobs (max %d, set NIBOT_JOB_MAX_RUNNING)", jobMaxRunning())
+	}
+	m.seq++
+	id := fmt.Sprintf("job_%s_%d", time.Now().Format("20060102_150405"), m.seq)
+	// 先用 starting 占住名额，启动进程期间并发的 job.start 不会超过上限；启动失败时删除
+	j := &backgroundJob{
+		ID:        id,
+		Command:   command,
+		Workspace: workspace,
+		LogPath:   filepath.ToSlash(filepath.Join("logs", "jobs", id+".log")),
+		StartedAt: time.Now(),
+		Status:    "starting",
+		done:      make(chan struct{}),
+	}
+	m.jobs[id] = j
+	m.mu.Unlock()
+	fail := func(err error) (*backgroundJob, error) {
+		m.mu.Lock()
+		delete(m.jobs, id)
+		m.mu.Unlock()
+		return nil, err
+	}
+
+	abs := filepath.Join(workspace, "logs", "jobs", id+".log")
+	if err := os.MkdirAll(filepath.Dir(abs), 0o755); err != nil {
+		return fail(err)
+	}
+	logFile, err := openJobLog(abs, jobMaxLogBytes())
+	if err != nil {
+		return fail(err)
+	}
+
+	cmd, err := sandboxedCommand(ctx, argv, childEnvFor(ctx, "job.start", nil, nil))
+	if err != nil {
+		logFile.Close()
+		return fail(err)
+	}
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	if err := startCommand(cmd, execRlimitsFromEnv()); err != nil {
+		logFile.Close()
+		return fail(err)
+	}
+
+	m.mu.Lock()
+	j.cmd = cmd
+	j.StartedAt = time.Now()
+	j.Status = "running"
+	started := *j
+	m.mu.Unlock()
+
+	go func() {
+		timer := time.AfterFunc(jobMaxDuration(), func() {
+			_ = m.kill(id)
+		})
+		err := cmd.Wait()
+		timer.Stop()
+		logFile.Close()
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		j.EndedAt = time.Now()
+		j.ExitCode = -1
+		if cmd.ProcessState != nil {
+			j.ExitCode = cmd.ProcessState.ExitCode()
+		}
+		switch {
+		case j.killed:
+			j.Status = "killed"
+		case err != nil:
+			j.Status = "failed"
+			j.Error = err.Error()
+		default:
+			j.Status = "exited"
+		}
+		close(j.done)
+	}()
+	return &started, nil
+}
+
+func (m *jobManager) get(id string) (*backgroundJob, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pruneLocked(time.Now())
+	j, ok := m.jobs[strings.TrimSpace(id)]
+	if !ok {
+		return nil, false
+	}
+	cp := *j
+	return &cp, true
+}
+
+func (m *jobManager) list(workspace string) []backgroundJob {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pruneLocked(time.Now())
+	var out []backgroundJob
+	for _, j := range m.jobs {
+		if workspace != "" && j.Workspace != workspace {
+			continue
+		}
+		out = append(out, *j)
+	}
+	sort.Slice(out, func(i, k int) bool { return out[i].StartedAt.Before(out[k].StartedAt) })
+	return out
+}
+
+func (m *jobManager) kill(id string) error {
+	m.mu.Lock()
+	j, ok := m.jobs[strings.TrimSpace(id)]
+	if !ok {
+		m.mu.Unlock()
+		return fmt.Errorf("job not found: %s", id)
+	}
+	if j.Status != "running" {
+		m.mu.Unlock()
+		return fmt.Errorf("job %s is not running (status=%s)", j.ID, j.Status)
+	}
+	j.killed = true
+	cmd := j.cmd
+	done := j.done
+	m.mu.Unlock()
+
+	killProcessTree(cmd)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+	}
+	return nil
+}
+
+func formatJobLine(j backgroundJob) string {
+	dur := time.Since(j.StartedAt)
+	if !j.EndedAt.IsZero() {
+		dur = j.EndedAt.Sub(j.StartedAt)
+	}
+	line := fmt.Sprintf("- id=%s status=%s elapsed=%s log=%s command=%q", j.ID, j.Status, dur.Round(time.Second), j.LogPath, previewText(j.Command, 120))
+	if j.Status != "running" {
+		line += fmt.Sprintf(" exit_code=%d", j.ExitCode)
+	}
+	return line
+}
+
+type jobStartArgs struct {
+	Command string `json:"command"`
+}
+
+func toolJobStart(ctx ExecContext, argsRaw string) (string, error) {
+	if !strings.HasPrefix(strings.TrimSpace(argsRaw), "{") {
+		return "", fmt.Errorf("job.start requires JSON args: {\"command\":\"...\"}")
+	}
+	var a jobStartArgs
+	if err := json.Unmarshal([]byte(argsRaw), &a); err != nil {
+		return "", fmt.Errorf("invalid JSON args for job.start: %w", err)
+	}
+	if strings.TrimSpace(a.Command) == "" {
+		return "", fmt.Errorf("job.start requires command")
+	}
+	if os.Getenv("NIBOT_ENABLE_EXEC") != "1" {
+		return "", fmt.Errorf("job.start disabled (set NIBOT_ENABLE_EXEC=1 to enable)")
+	}
+	if err := ctx.Policy.CheckRuntimeCommand(ctx.Workspace, a.Command); err != nil {
+		return "", fmt.Errorf("job.start command denied by policy: %v", err)
+	}
+	j, err := defaultJobManager.start(ctx, a.Command, runtimeShellArgv(a.Command))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("started job id=%s log=%s", j.ID, j.LogPath), nil
+}
+
+type jobIDArgs struct {
+	ID string `json:"id"`
+}
+
+func toolJobStatus(ctx ExecContext, argsRaw string) (string, error) {
+	var a jobIDArgs
+	if strings.TrimSpace(argsRaw) != "" {
+		if !strings.HasPrefix(strings.TrimSpace(argsRaw), "{") {
+			return "", fmt.Errorf("job.status requires JSON args: {\"id\":\"job_...\"}")
+		}
+		if err := json.Unmarshal([]byte(argsRaw), &a); err != nil {
+			return "", fmt.Errorf("invalid JSON args for job.status: %w", err)
+		}
+	}
+	if strings.TrimSpace(a.ID) == "" {
+		jobs := defaultJobManager.list(ctx.Workspace)
+		if len(jobs) == 0 {
+			return "(no jobs)", nil
+		}
+		var lines []string
+		for _, j := range jobs {
+			lines = append(lines, formatJobLine(j))
+		}
+		return strings.Join(lines, "\n"), nil
+	}
+	j, ok := defaultJobManager.get(a.ID)
+	if !ok || j.Workspace != ctx.Workspace {
+		return "", fmt.Errorf("job not found: %s", a.ID)
+	}
+	out := formatJobLine(*j)
+	if j.Error != "" {
+		out += "\nerror: " + j.Error
+	}
+	return out, nil
+}
+
+type jobLogsArgs struct {
+	ID   string `json:"id"`
+	Tail int    `json:"tail"`
+}
+
+func toolJobLogs(ctx ExecContext, argsRaw string) (string, error) {
+	if !strings.HasPrefix(strings.TrimSpace(argsRaw), "{") {
+		return "", fmt.Errorf("job.logs requires JSON args: {\"id\":\"job_...\",\"tail\":50}")
+	}
+	var a jobLogsArgs
+	if err := json.Unmarshal([]byte(argsRaw), &a); err != nil {
+		return "", fmt.Errorf("invalid JSON args for job.logs: %w", err)
+	}
+	j, ok := defaultJobManager.get(a.ID)
+	if !ok || j.Workspace != ctx.Workspace {
+		return "", fmt.Errorf("job not found: %s", a.ID)
+	}
+	if a.Tail <= 0 {
+		a.Tail = 50
+	}
+	if a.Tail > 500 {
+		a.Tail = 500
+	}
+	b, err := readJobLogTail(filepath.Join(ctx.Workspace, filepath.FromSlash(j.LogPath)), 64*1024)
+	if err != nil {
+		return "", err
+	}
+	lines := strings.Split(strings.TrimRight(strings.ReplaceAll(string(b), "\r\n", "\n"), "\n"), "\n")
+	if len(lines) > a.Tail {
+		lines = lines[len(lines)-a.Tail:]
+	}
+	body := strings.TrimSpace(strings.Join(lines, "\n"))
+	if body == "" {
+		body = "(no output yet)"
+	}
+	return fmt.Sprintf("[job %s status=%s]\n%s", j.ID, j.Status, body), nil
+}
+
+func toolJobKill(ctx ExecContext, argsRaw string) (string, error) {
+	if !strings.HasPrefix(strings.TrimSpace(argsRaw), "{") {
+		return "", fmt.Errorf("job.kill requires JSON args: {\"id\":\"job_...\"}")
+	}
+	var a jobIDArgs
+	if err := json.Unmarshal([]byte(argsRaw), &a); err != nil {
+		return "", fmt.Errorf("invalid JSON args for job.kill: %w", err)
+	}
+	j, ok := defaultJobManager.get(a.ID)
+	if !ok || j.Workspace != ctx.Workspace {
+		return "", fmt.Errorf("job not found: %s", a.ID)
+	}
+	if err := defaultJobManager.kill(j.ID); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("killed job id=%s", j.ID), nil
+}
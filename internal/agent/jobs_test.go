@@ -0,0 +1,256 @@
+package agent
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func waitJobStatus(t *testing.T, id string, want string) backgroundJob {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		j, ok := defaultJobManager.get(id)
+		if !ok {
+			t.Fatalf("job %s not found", id)
+		}
+		if j.Status == want {
+			return *j
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not reach status %s", id, want)
+	return backgroundJob{}
+}
+
+func jobIDFromOutput(t *testing.T, out string) string {
+	t.Helper()
+	for _, f := range strings.Fields(out) {
+		if strings.HasPrefix(f, "id=") {
+			return strings.TrimPrefix(f, "id=")
+		}
+	}
+	t.Fatalf("no job id in output: %q", out)
+	return ""
+}
+
+func TestJobStart_WritesLogAndExits(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	t.Setenv("NIBOT_ENABLE_EXEC", "1")
+	ws := t.TempDir()
+	ctx := ExecContext{Workspace: ws, Policy: DefaultToolPolicy()}
+
+	out, err := toolJobStart(ctx, `{"command":"echo job-hello; echo job-err 1>&2"}`)
+	if err != nil {
+		t.Fatalf("job.start failed: %v", err)
+	}
+	id := jobIDFromOutput(t, out)
+	j := waitJobStatus(t, id, "exited")
+	if j.ExitCode != 0 {
+		t.Fatalf("unexpected exit code: %d", j.ExitCode)
+	}
+
+	b, err := os.ReadFile(filepath.Join(ws, "logs", "jobs", id+".log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "job-hello") || !strings.Contains(string(b), "job-err") {
+		t.Fatalf("unexpected log content: %q", string(b))
+	}
+
+	logs, err := toolJobLogs(ctx, `{"id":"`+id+`","tail":1}`)
+	if err != nil {
+		t.Fatalf("job.logs failed: %v", err)
+	}
+	if !strings.Contains(logs, "status=exited") {
+		t.Fatalf("unexpected logs output: %q", logs)
+	}
+
+	status, err := toolJobStatus(ctx, `{}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(status, id) {
+		t.Fatalf("expected job in list, got %q", status)
+	}
+}
+
+func TestJobKill_StopsRunningJob(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	t.Setenv("NIBOT_ENABLE_EXEC", "1")
+	ws := t.TempDir()
+	ctx := ExecContext{Workspace: ws, Policy: DefaultToolPolicy()}
+
+	out, err := toolJobStart(ctx, `{"command":"sleep 30"}`)
+	if err != nil {
+		t.Fatalf("job.start failed: %v", err)
+	}
+	id := jobIDFromOutput(t, out)
+	if _, err := toolJobKill(ctx, `{"id":"`+id+`"}`); err != nil {
+		t.Fatalf("job.kill failed: %v", err)
+	}
+	waitJobStatus(t, id, "killed")
+
+	if _, err := toolJobKill(ctx, `{"id":"`+id+`"}`); err == nil {
+		t.Fatalf("expected error killing a finished job")
+	}
+}
+
+func TestJobStart_ConcurrentStartsRespectLimit(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	t.Setenv("NIBOT_JOB_MAX_RUNNING", "2")
+	m := &jobManager{jobs: map[string]*backgroundJob{}}
+	ctx := ExecContext{Workspace: t.TempDir(), Policy: DefaultToolPolicy()}
+	defer func() {
+		for _, j := range m.list("") {
+			_ = m.kill(j.ID)
+		}
+	}()
+
+	// 启动失败时释放占住的名额：工作区是普通文件，无法创建日志
+	bad := ctx
+	bad.Workspace = filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(bad.Workspace, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.start(bad, "sleep 30", []string{"sh", "-c", "sleep 30"}); err == nil {
+		t.Fatalf("expected the start to fail")
+	}
+	if len(m.list("")) != 0 {
+		t.Fatalf("a failed start must not keep its slot: %v", m.list(""))
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	started := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := m.start(ctx, "sleep 30", []string{"sh", "-c", "sleep 30"}); err == nil {
+				mu.Lock()
+				started++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if started != 2 {
+		t.Fatalf("expected exactly 2 jobs to start, got %d", started)
+	}
+}
+
+func TestJobLog_RotatesAtLimit(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	t.Setenv("NIBOT_ENABLE_EXEC", "1")
+	t.Setenv("NIBOT_JOB_MAX_LOG_KB", "16")
+	ws := t.TempDir()
+	ctx := ExecContext{Workspace: ws, Policy: DefaultToolPolicy()}
+
+	out, err := toolJobStart(ctx, `{"command":"i=0; while [ $i -lt 2000 ]; do echo line-$i-xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx; i=$((i+1)); done; echo last-line"}`)
+	if err != nil {
+		t.Fatalf("job.start failed: %v", err)
+	}
+	id := jobIDFromOutput(t, out)
+	waitJobStatus(t, id, "exited")
+
+	var total int64
+	for _, name := range []string{id + ".log", id + ".log.1"} {
+		st, err := os.Stat(filepath.Join(ws, "logs", "jobs", name))
+		if err != nil {
+			t.Fatalf("expected %s: %v", name, err)
+		}
+		total += st.Size()
+	}
+	if total > 16*1024 {
+		t.Fatalf("job log should stay under 16 KB, got %d bytes", total)
+	}
+	logs, err := toolJobLogs(ctx, `{"id":"`+id+`","tail":500}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logs, "last-line") || strings.Contains(logs, "line-0-") {
+		t.Fatalf("expected the newest output only, got %q", logs)
+	}
+}
+
+func TestJobManager_PrunesFinishedJobs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	t.Setenv("NIBOT_ENABLE_EXEC", "1")
+	t.Setenv("NIBOT_JOB_MAX_FINISHED", "1")
+	ws := t.TempDir()
+	ctx := ExecContext{Workspace: ws, Policy: DefaultToolPolicy()}
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		out, err := toolJobStart(ctx, `{"command":"echo pruned"}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, jobIDFromOutput(t, out))
+		waitJobStatus(t, ids[i], "exited")
+	}
+	if _, ok := defaultJobManager.get(ids[0]); ok {
+		t.Fatalf("the older finished job should be pruned")
+	}
+	if _, err := os.Stat(filepath.Join(ws, "logs", "jobs", ids[0]+".log")); !os.IsNotExist(err) {
+		t.Fatalf("the pruned job's log should be removed, got %v", err)
+	}
+	if jobs := defaultJobManager.list(ws); len(jobs) != 1 || jobs[0].ID != ids[1] {
+		t.Fatalf("expected only the newest job, got %+v", jobs)
+	}
+
+	// 超过 TTL 的任务同样删除
+	t.Setenv("NIBOT_JOB_MAX_FINISHED", "")
+	defaultJobManager.mu.Lock()
+	defaultJobManager.pruneLocked(time.Now().Add(2 * time.Hour))
+	defaultJobManager.mu.Unlock()
+	if _, ok := defaultJobManager.get(ids[1]); ok {
+		t.Fatalf("jobs finished longer than the TTL should be pruned")
+	}
+}
+
+func TestJobStart_DisabledWithoutExec(t *testing.T) {
+	t.Setenv("NIBOT_ENABLE_EXEC", "")
+	ctx := ExecContext{Workspace: t.TempDir(), Policy: DefaultToolPolicy()}
+	if _, err := toolJobStart(ctx, `{"command":"echo x"}`); err == nil {
+		t.Fatalf("expected job.start to be disabled")
+	}
+}
+
+func TestLoop_JobsCommandListsJobs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	t.Setenv("NIBOT_ENABLE_EXEC", "1")
+	ws := t.TempDir()
+	ctx := ExecContext{Workspace: ws, Policy: DefaultToolPolicy()}
+	out, err := toolJobStart(ctx, `{"command":"echo listed"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := jobIDFromOutput(t, out)
+	waitJobStatus(t, id, "exited")
+
+	client := NewLLMClient(Config{}, ws, "dummy", nil)
+	var buf bytes.Buffer
+	client.Loop(bytes.NewBufferString("jobs\nexit\n"), &buf, nil)
+	if !strings.Contains(buf.String(), id) {
+		t.Fatalf("expected jobs output to contain %s, got: %s", id, buf.String())
+	}
+}
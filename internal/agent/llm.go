@@ -14,6 +14,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unicode"
 )
@@ -31,6 +32,21 @@ type LLMClient struct {
 	SpecMode         bool
 	pendingSpecSlug  string
 	pendingSpecInput string
+	execSession      string
+	// pendingToolCalls 是因达到迭代上限或检测到重复而未执行的调用，用户输入 continue 后执行。
+	pendingToolCalls []ExecCall
+	// Origin 透传到 ExecContext.Origin，由 Telegram / 飞书 / Web 界面按会话设置。
+	Origin string
+	// User 透传到 ExecContext.User。
+	User string
+	// Approver 审批 Chat 中需要审批的调用（Telegram / 飞书 / Web 使用 RemoteApprover）；
+	// 为 nil 时这些调用被拒绝。CLI 的 REPL 使用 cliApprover，不经过这里。
+	Approver Approver
+	// TokenBudget > 0 时限制 Chat 累计消耗的 token（优先使用接口返回的 usage，否则估算），
+	// 用完后和达到迭代上限一样停止执行工具。目前只用于 agent.delegate 的子代理。
+	TokenBudget int
+	tokensUsed  int
+	lastUsage   int
 }
 
 type Config struct {
@@ -88,6 +104,9 @@ type openAIResponse struct {
 	Error struct {
 		Message string `json:"message"`
 	} `json:"error"`
+	Usage struct {
+		TotalTokens int `json:"total_tokens"`
+	} `json:"usage"`
 }
 
 func NewLLMClient(cfg Config, workspace string, systemPrompt string, sessionManager *SessionManager) *LLMClient {
@@ -97,87 +116,145 @@ func NewLLMClient(cfg Config, workspace string, systemPrompt string, sessionMana
 		Workspace:      workspace,
 		SessionManager: sessionManager,
 		History:        []Message{},
-		MaxToolIters:   5,
+		MaxToolIters:   maxToolIters(),
 		SpecMode:       loadSpecModeSetting(workspace),
+		execSession:    nextExecSessionID(),
 	}
 }
 
-func (c *LLMClient) Chat(userInput string) (string, error) {
-	c.History = append(c.History, Message{Role: "user", Content: redactSecrets(userInput)})
+var execSessionSeq atomic.Int64
 
-	c.mu.RLock()
-	systemMsg := c.SystemMsg
-	c.mu.RUnlock()
+func nextExecSessionID() string {
+	return fmt.Sprintf("conv_%d", execSessionSeq.Add(1))
+}
 
-	var finalResponse string
+// ExecSession 返回对话的 ExecContext.Session，供 RemoteApprover 记录“本次对话允许”。
+func (c *LLMClient) ExecSession() string {
+	return c.execSession
+}
+
+func (c *LLMClient) execContext() ExecContext {
+	return ExecContext{Workspace: c.Workspace, Policy: c.Config.Policy, Session: c.execSession, LogLevel: c.Config.LogLevel, Origin: c.Origin, User: c.User, Client: c}
+}
+
+// chatTurn 把当前 History 发给模型并返回回复（无 API Key 时使用 mock）。
+func (c *LLMClient) chatTurn(systemMsg, userInput string) (string, error) {
 	var err error
+	// Prepare messages for this turn
+	messages := []Message{{Role: "system", Content: systemMsg}}
+	if auto := buildAutoRecallBlock(c.Workspace, userInput); strings.TrimSpace(auto) != "" {
+		messages = append(messages, Message{Role: "system", Content: auto})
+	}
+	messages = append(messages, c.History...)
 
-	for i := 0; i < c.MaxToolIters; i++ {
-		// Prepare messages for this turn
-		messages := []Message{{Role: "system", Content: systemMsg}}
-		if auto := buildAutoRecallBlock(c.Workspace, userInput); strings.TrimSpace(auto) != "" {
-			messages = append(messages, Message{Role: "system", Content: auto})
-		}
-		messages = append(messages, c.History...)
+	var responseContent string
 
-		var responseContent string
+	// Determine provider and make call
+	provider := strings.ToLower(strings.TrimSpace(c.Config.Provider))
+	if provider == "" {
+		provider = "openai"
+	}
 
-		// Determine provider and make call
-		provider := strings.ToLower(strings.TrimSpace(c.Config.Provider))
-		if provider == "" {
-			provider = "openai"
-		}
+	// Check if we should use Mock mode
+	useMock := false
+	if provider != "ollama" && strings.TrimSpace(c.Config.APIKey) == "" {
+		useMock = true
+	}
 
-		// Check if we should use Mock mode
-		useMock := false
-		if provider != "ollama" && strings.TrimSpace(c.Config.APIKey) == "" {
-			useMock = true
+	c.lastUsage = 0
+	defer func() {
+		if err == nil {
+			c.countTokens(messages, responseContent)
 		}
+	}()
 
-		if useMock {
-			// In mock mode, we use the last message content as input
+	if useMock {
+		// In mock mode, we use the last message content as input
+		lastMsg := ""
+		if len(c.History) > 0 {
+			lastMsg = c.History[len(c.History)-1].Content
+		}
+		responseContent = c.mockRespond(lastMsg)
+	} else {
+		switch provider {
+		case "openai", "deepseek", "nvidia", "nvidia_nim":
+			responseContent, err = c.callOpenAICompatible(messages)
+		case "ollama":
+			responseContent, err = c.callOllama(messages)
+		default:
+			// Fallback to mock if provider unknown
 			lastMsg := ""
 			if len(c.History) > 0 {
 				lastMsg = c.History[len(c.History)-1].Content
 			}
-			responseContent = c.mockRespond(lastMsg)
-		} else {
-			switch provider {
-			case "openai", "deepseek", "nvidia", "nvidia_nim":
-				responseContent, err = c.callOpenAICompatible(messages)
-			case "ollama":
-				responseContent, err = c.callOllama(messages)
-			default:
-				// Fallback to mock if provider unknown
-				lastMsg := ""
-				if len(c.History) > 0 {
-					lastMsg = c.History[len(c.History)-1].Content
-				}
-				responseContent = fmt.Sprintf("[MOCK] Received: %s", lastMsg)
-			}
+			responseContent = fmt.Sprintf("[MOCK] Received: %s", lastMsg)
 		}
+	}
 
-		if err != nil {
-			return "", err
-		}
+	return responseContent, err
+}
 
-		// Append assistant response
-		c.History = append(c.History, Message{Role: "assistant", Content: redactSecrets(responseContent)})
-		finalResponse = responseContent
+func (c *LLMClient) Chat(userInput string) (string, error) {
+	var pending []ExecCall
+	if isContinueCommand(userInput) && len(c.pendingToolCalls) > 0 {
+		pending, c.pendingToolCalls = c.pendingToolCalls, nil
+	} else {
+		c.pendingToolCalls = nil
+		c.History = append(c.History, Message{Role: "user", Content: redactSecrets(userInput)})
+	}
 
-		// Extract and execute tools
-		calls := ExtractExecCalls(responseContent)
-		if len(calls) == 0 {
-			break
+	c.mu.RLock()
+	systemMsg := c.SystemMsg
+	c.mu.RUnlock()
+
+	var finalResponse string
+	guard := newToolLoopGuard()
+	loopStopped := false
+
+	for i := 0; ; i++ {
+		calls, parseErrs := pending, []ExecParseError(nil)
+		pending = nil
+		if calls == nil {
+			responseContent, err := c.chatTurn(systemMsg, userInput)
+			if err != nil {
+				return "", err
+			}
+
+			// Append assistant response
+			c.History = append(c.History, Message{Role: "assistant", Content: redactSecrets(responseContent)})
+			finalResponse = responseContent
+
+			// Extract tools; stop when the iteration budget is spent or a loop was detected
+			calls, parseErrs = ParseExecCalls(responseContent)
+			if len(calls) == 0 && len(parseErrs) == 0 {
+				break
+			}
+			if loopStopped || i >= c.MaxToolIters {
+				c.pendingToolCalls = calls
+				if len(calls) > 0 {
+					finalResponse += "\n\n" + pendingToolHint(len(calls), loopStopped, c.MaxToolIters)
+				}
+				break
+			}
+			if c.tokenBudgetSpent() {
+				c.pendingToolCalls = calls
+				if len(calls) > 0 {
+					finalResponse += "\n\n" + tokenBudgetHint(len(calls), c.tokensUsed, c.TokenBudget)
+				}
+				break
+			}
 		}
 
 		// Execute tools
-		ctx := ExecContext{
-			Workspace: c.Workspace,
-			Policy:    c.Config.Policy,
+		ctx := c.execContext()
+		results := ExecuteCalls(ctx, calls, c.Approver)
+		results = append(results, parseErrorResults(parseErrs)...)
+		if c.SessionManager != nil {
+			c.SessionManager.RecordToolResults(calls, results)
+			for range calls {
+				c.SessionManager.IncrementToolCalls()
+			}
 		}
-		// Pass nil approver for now (assumes auto-approve or trusted environment)
-		results := ExecuteCalls(ctx, calls, nil)
 
 		// Format results
 		var sb strings.Builder
@@ -190,9 +267,16 @@ func (c *LLMClient) Chat(userInput string) (string, error) {
 				sb.WriteString(fmt.Sprintf("  status: ok\n  output: |\n    %s\n", indented))
 			} else {
 				sb.WriteString(fmt.Sprintf("  status: error\n  error: %s\n", res.Error))
+				if res.Reason != "" && (res.Error == "disabled by policy" || res.Error == "denied by user" || res.Error == "approval unavailable") {
+					sb.WriteString("  policy: " + res.Reason + "\n")
+				}
 			}
 		}
 		toolOutput := sb.String()
+		if reason := guard.observe(calls, results); reason != "" {
+			loopStopped = true
+			toolOutput += loopStopNote(reason)
+		}
 
 		// Append tool output as user message for next iteration
 		c.History = append(c.History, Message{Role: "user", Content: toolOutput})
@@ -932,6 +1016,7 @@ func (c *LLMClient) callOpenAICompatible(messages []Message) (string, error) {
 	if len(openAIResp.Choices) == 0 {
 		return "", fmt.Errorf("empty response from API")
 	}
+	c.lastUsage = openAIResp.Usage.TotalTokens
 	if nativeToolsEnabled() && len(openAIResp.Choices[0].Message.ToolCalls) > 0 {
 		return translateToolCallsToExec(openAIResp.Choices[0].Message.ToolCalls), nil
 	}
@@ -974,199 +1059,22 @@ func (c *LLMClient) openAIToolsForPolicy() []openAITool {
 		p = DefaultToolPolicy()
 	}
 
-	tools := []openAITool{
-		{
-			Type: "function",
-			Function: openAIFunctionDef{
-				Name:        "file_read",
-				Description: "Read a file from Ni bot workspace",
-				Parameters: map[string]any{
-					"type": "object",
-					"properties": map[string]any{
-						"path": map[string]any{"type": "string"},
-					},
-					"required": []string{"path"},
-				},
-			},
-		},
-	}
-
-	if p.AllowsTool("file_write") {
-		tools = append(tools, openAITool{
-			Type: "function",
-			Function: openAIFunctionDef{
-				Name:        "file_write",
-				Description: "Write a file under Ni bot workspace (append or overwrite subject to policy)",
-				Parameters: map[string]any{
-					"type": "object",
-					"properties": map[string]any{
-						"path":    map[string]any{"type": "string"},
-						"content": map[string]any{"type": "string"},
-						"mode":    map[string]any{"type": "string", "enum": []string{"append", "overwrite"}},
-					},
-					"required": []string{"path", "content"},
-				},
-			},
-		})
-	}
-
-	if p.AllowsTool("install_skill") {
-		tools = append(tools, openAITool{
-			Type: "function",
-			Function: openAIFunctionDef{
-				Name:        "install_skill",
-				Description: "Install skills from a https:// git repository into Ni bot workspace skills directory",
-				Parameters: map[string]any{
-					"type": "object",
-					"properties": map[string]any{
-						"name":  map[string]any{"type": "string"},
-						"url":   map[string]any{"type": "string"},
-						"layer": map[string]any{"type": "string", "enum": []string{"upstream", "local"}},
-					},
-					"required": []string{"name", "url"},
-				},
-			},
-		})
-	}
-
-	if p.AllowsTool("memory.store") {
-		tools = append(tools, openAITool{
-			Type: "function",
-			Function: openAIFunctionDef{
-				Name:        "memory.store",
-				Description: "Store a long-term memory item (SQLite memory DB must be enabled)",
-				Parameters: map[string]any{
-					"type": "object",
-					"properties": map[string]any{
-						"scope":   map[string]any{"type": "string"},
-						"tags":    map[string]any{"type": "string"},
-						"content": map[string]any{"type": "string"},
-					},
-					"required": []string{"content"},
-				},
-			},
-		})
-	}
-	if p.AllowsTool("memory.recall") {
-		tools = append(tools, openAITool{
-			Type: "function",
-			Function: openAIFunctionDef{
-				Name:        "memory.recall",
-				Description: "Search long-term memories by keyword match (SQLite memory DB must be enabled)",
-				Parameters: map[string]any{
-					"type": "object",
-					"properties": map[string]any{
-						"scope": map[string]any{"type": "string"},
-						"query": map[string]any{"type": "string"},
-						"limit": map[string]any{"type": "integer"},
-					},
-					"required": []string{"query"},
-				},
-			},
-		})
-	}
-	if p.AllowsTool("memory.forget") {
-		tools = append(tools, openAITool{
-			Type: "function",
-			Function: openAIFunctionDef{
-				Name:        "memory.forget",
-				Description: "Delete a memory item by id (SQLite memory DB must be enabled)",
-				Parameters: map[string]any{
-					"type": "object",
-					"properties": map[string]any{
-						"id": map[string]any{"type": "integer"},
-					},
-					"required": []string{"id"},
-				},
-			},
-		})
-	}
-	if p.AllowsTool("memory.list") {
-		tools = append(tools, openAITool{
-			Type: "function",
-			Function: openAIFunctionDef{
-				Name:        "memory.list",
-				Description: "List recent memory items (SQLite memory DB must be enabled)",
-				Parameters: map[string]any{
-					"type": "object",
-					"properties": map[string]any{
-						"scope": map[string]any{"type": "string"},
-						"limit": map[string]any{"type": "integer"},
-					},
-				},
-			},
-		})
-	}
-	if p.AllowsTool("memory.stats") {
-		tools = append(tools, openAITool{
-			Type: "function",
-			Function: openAIFunctionDef{
-				Name:        "memory.stats",
-				Description: "Show memory database stats (SQLite memory DB must be enabled)",
-				Parameters:  map[string]any{"type": "object", "properties": map[string]any{}},
-			},
-		})
-	}
-	if p.AllowsTool("memory.import") {
-		tools = append(tools, openAITool{
-			Type: "function",
-			Function: openAIFunctionDef{
-				Name:        "memory.import",
-				Description: "Import memory items from a pasted text block (SQLite memory DB must be enabled)",
-				Parameters: map[string]any{
-					"type": "object",
-					"properties": map[string]any{
-						"source": map[string]any{"type": "string"},
-						"scope":  map[string]any{"type": "string"},
-						"tags":   map[string]any{"type": "string"},
-						"text":   map[string]any{"type": "string"},
-						"limit":  map[string]any{"type": "integer"},
-					},
-					"required": []string{"text"},
-				},
-			},
-		})
-	}
-
-	if p.AllowsTool("shell_exec") {
-		tools = append(tools, openAITool{
-			Type: "function",
-			Function: openAIFunctionDef{
-				Name:        "shell_exec",
-				Description: "Execute a shell command with approval and sandbox/policy restrictions",
-				Parameters: map[string]any{
-					"type": "object",
-					"properties": map[string]any{
-						"command":        map[string]any{"type": "string"},
-						"timeoutSeconds": map[string]any{"type": "integer"},
-					},
-					"required": []string{"command"},
-				},
-			},
-		})
-	}
-
-	if p.AllowsTool("skill_exec") {
+	var tools []openAITool
+	for _, spec := range toolSpecs {
+		if !p.AllowsTool(spec.Name) {
+			continue
+		}
 		tools = append(tools, openAITool{
 			Type: "function",
 			Function: openAIFunctionDef{
-				Name:        "skill_exec",
-				Description: "Execute a skill script with approval and sandbox/policy restrictions",
-				Parameters: map[string]any{
-					"type": "object",
-					"properties": map[string]any{
-						"skill":          map[string]any{"type": "string"},
-						"script":         map[string]any{"type": "string"},
-						"args":           map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
-						"timeoutSeconds": map[string]any{"type": "integer"},
-					},
-					"required": []string{"skill", "script"},
-				},
+				Name:        spec.Name,
+				Description: spec.Description,
+				Parameters:  spec.Args.jsonSchema(),
 			},
 		})
 	}
-
-	return tools
+	tools = append(tools, mcpOpenAITools(c.Workspace, p)...)
+	return append(tools, openAPIOpenAITools(c.Workspace, p)...)
 }
 
 func (c *LLMClient) callOllama(messages []Message) (string, error) {
@@ -1201,9 +1109,13 @@ func (c *LLMClient) Loop(inputReader io.Reader, outputWriter io.Writer, logger *
 			continue
 		}
 		cmd := strings.ToLower(tokens[0])
+		resume := false
 		switch cmd {
 		case "exit", "quit":
 			return
+		case "continue", "/continue", "继续":
+			// 只有存在待执行的工具调用时才当作命令，否则作为普通消息发给模型
+			resume = len(tokens) == 1 && len(c.pendingToolCalls) > 0
 		case "version", "/version":
 			fmt.Fprintf(outputWriter, "\n%s\n", v)
 			fmt.Fprint(outputWriter, "\n> ")
@@ -1498,6 +1410,82 @@ func (c *LLMClient) Loop(inputReader io.Reader, outputWriter io.Writer, logger *
 			}
 			fmt.Fprint(outputWriter, "\n> ")
 			continue
+		case "policy", "/policy":
+			// policy：按顺序列出规则；policy explain <tool> [json args]：显示一次调用会得到的决定
+			rest := strings.TrimSpace(strings.TrimSpace(input)[len(tokens[0]):])
+			ctx := c.execContext()
+			if !ctx.Policy.Loaded {
+				ctx.Policy = DefaultToolPolicy()
+			}
+			if sub, args, _ := strings.Cut(rest, " "); strings.EqualFold(sub, "explain") {
+				tool, argsRaw, _ := strings.Cut(strings.TrimSpace(args), " ")
+				if tool == "" {
+					fmt.Fprintln(outputWriter, "\n用法：policy explain <tool> [json args]")
+					fmt.Fprint(outputWriter, "\n> ")
+					continue
+				}
+				d := ctx.Policy.Decide(policyRequestFor(ctx, ExecCall{Tool: tool, ArgsRaw: strings.TrimSpace(argsRaw)}))
+				fmt.Fprintf(outputWriter, "\n%s %s\n  because: %s\n", d.Action, tool, d.Reason)
+				fmt.Fprint(outputWriter, "\n> ")
+				continue
+			}
+			fmt.Fprintln(outputWriter, "\nPolicy rules (first match wins):")
+			for _, line := range ctx.Policy.DescribeRules() {
+				fmt.Fprintln(outputWriter, "- "+line)
+			}
+			fmt.Fprint(outputWriter, "\n> ")
+			continue
+		case "approvals", "/approvals":
+			// approvals [list]：列出保存的审批记录；approvals revoke <id>：删除一条
+			if len(tokens) >= 2 && strings.EqualFold(tokens[1], "revoke") {
+				id, err := 0, error(nil)
+				if len(tokens) >= 3 {
+					id, err = strconv.Atoi(strings.TrimPrefix(tokens[2], "#"))
+				}
+				if len(tokens) < 3 || err != nil {
+					fmt.Fprintln(outputWriter, "\n用法：approvals revoke <id>")
+					fmt.Fprint(outputWriter, "\n> ")
+					continue
+				}
+				g, err := RevokeApprovalGrant(c.Workspace, id)
+				if err != nil {
+					fmt.Fprintf(outputWriter, "\n撤销失败：%v\n", err)
+				} else {
+					fmt.Fprintf(outputWriter, "\n已撤销 %s\n", g.String())
+				}
+				fmt.Fprint(outputWriter, "\n> ")
+				continue
+			}
+			grants, err := LoadApprovalGrants(c.Workspace)
+			if err != nil {
+				fmt.Fprintf(outputWriter, "\n读取审批记录失败：%v\n", err)
+				fmt.Fprint(outputWriter, "\n> ")
+				continue
+			}
+			if len(grants) == 0 {
+				fmt.Fprintf(outputWriter, "\n当前没有保存的审批记录（%s）。\n", ApprovalsFile(c.Workspace))
+				fmt.Fprint(outputWriter, "\n> ")
+				continue
+			}
+			fmt.Fprintf(outputWriter, "\nApprovals (%s, deny first):\n", ApprovalsFile(c.Workspace))
+			for _, g := range grants {
+				fmt.Fprintf(outputWriter, "- %s  (%s)\n", g.String(), g.Created.Format("2006-01-02 15:04"))
+			}
+			fmt.Fprint(outputWriter, "\n> ")
+			continue
+		case "jobs", "/jobs":
+			jobs := defaultJobManager.list(c.Workspace)
+			if len(jobs) == 0 {
+				fmt.Fprintln(outputWriter, "\n当前没有后台任务。")
+				fmt.Fprint(outputWriter, "\n> ")
+				continue
+			}
+			fmt.Fprintln(outputWriter, "\nJobs:")
+			for _, j := range jobs {
+				fmt.Fprintln(outputWriter, formatJobLine(j))
+			}
+			fmt.Fprint(outputWriter, "\n> ")
+			continue
 		case "help", "/help", "?":
 			fmt.Fprintln(outputWriter, "\nCommands:")
 			fmt.Fprintln(outputWriter, "- help / /help / ?: show this help")
@@ -1508,11 +1496,17 @@ func (c *LLMClient) Loop(inputReader io.Reader, outputWriter io.Writer, logger *
 			fmt.Fprintln(outputWriter, "- skills install <path>: install skills from a local folder")
 			fmt.Fprintln(outputWriter, "- skills doctor: validate installed skills")
 			fmt.Fprintln(outputWriter, "- skills test <name>: test a skill without executing")
+			fmt.Fprintln(outputWriter, "- jobs / /jobs: list background jobs (job.start)")
+			fmt.Fprintln(outputWriter, "- policy / /policy: list policy rules in evaluation order")
+			fmt.Fprintln(outputWriter, "- policy explain <tool> [json]: show the decision for a tool call")
+			fmt.Fprintln(outputWriter, "- approvals / /approvals: list saved approval decisions (approvals.json)")
+			fmt.Fprintln(outputWriter, "- approvals revoke <id>: delete a saved approval decision")
 			fmt.Fprintln(outputWriter, "- reload / /reload: reload system prompt (skills/memory)")
 			fmt.Fprintln(outputWriter, "- spec / /spec: spec mode (generate spec/tasks/checklist)")
 			fmt.Fprintln(outputWriter, "- update / /update: git pull + go mod tidy + go build (use: update --yes)")
 			fmt.Fprintln(outputWriter, "- clear / /clear: clear the screen")
 			fmt.Fprintln(outputWriter, "- reset / /reset: clear conversation memory (history)")
+			fmt.Fprintln(outputWriter, "- continue: run the pending tool calls after the tool iteration limit (NIBOT_MAX_TOOL_ITERS) or a loop stop")
 			fmt.Fprintln(outputWriter, "- exit / quit: exit Ni bot")
 			fmt.Fprint(outputWriter, "\n> ")
 			continue
@@ -1525,6 +1519,7 @@ func (c *LLMClient) Loop(inputReader io.Reader, outputWriter io.Writer, logger *
 			continue
 		case "reset", "/reset":
 			c.History = nil
+			forgetSessionApprovals(c.execSession)
 			c.LastSummary = ""
 			c.LastSummaryTitle = ""
 			fmt.Fprintln(outputWriter, "\n已重置会话上下文（history 已清空）。")
@@ -1532,7 +1527,7 @@ func (c *LLMClient) Loop(inputReader io.Reader, outputWriter io.Writer, logger *
 			continue
 		}
 
-		if c.SpecMode && strings.TrimSpace(c.pendingSpecSlug) == "" {
+		if !resume && c.SpecMode && strings.TrimSpace(c.pendingSpecSlug) == "" {
 			slug, err := c.generateSpecDocs(input)
 			if err != nil {
 				fmt.Fprintf(outputWriter, "\n生成 Spec 失败：%v\n", err)
@@ -1547,41 +1542,67 @@ func (c *LLMClient) Loop(inputReader io.Reader, outputWriter io.Writer, logger *
 			continue
 		}
 
-		writeLog(logger, fmt.Sprintf("\n### User:\n%s\n", redactSecrets(input)))
-		if c.SessionManager != nil {
-			c.SessionManager.RecordMessage("user", redactSecrets(input))
-		}
+		var pending []ExecCall
+		if resume {
+			pending, c.pendingToolCalls = c.pendingToolCalls, nil
+			fmt.Fprintf(outputWriter, "\n继续执行 %d 个待执行的工具调用（本轮最多 %d 轮）。\n", len(pending), c.MaxToolIters)
+			writeLog(logger, fmt.Sprintf("\n### User:\ncontinue (%d pending tool calls)\n", len(pending)))
+		} else {
+			c.pendingToolCalls = nil
+			writeLog(logger, fmt.Sprintf("\n### User:\n%s\n", redactSecrets(input)))
+			if c.SessionManager != nil {
+				c.SessionManager.RecordMessage("user", redactSecrets(input))
+			}
 
-		// Update session with user input
-		if c.SessionManager != nil {
-			c.SessionManager.IncrementMessageCount()
-			c.SessionManager.SetCurrentTask(input)
+			// Update session with user input
+			if c.SessionManager != nil {
+				c.SessionManager.IncrementMessageCount()
+				c.SessionManager.SetCurrentTask(input)
+			}
 		}
 
 		nextUserInput := input
 		lastAssistant := ""
-		for iter := 0; iter < c.MaxToolIters; iter++ {
-			resp, err := c.ChatOnce(nextUserInput)
-			if err != nil {
-				fmt.Fprintf(outputWriter, "\nError: %v\n", err)
-				writeLog(logger, fmt.Sprintf("\n**Error**: %v\n", err))
-				break
-			}
-			lastAssistant = resp
+		guard := newToolLoopGuard()
+		loopStopped := false
+		for iter := 0; ; iter++ {
+			calls, parseErrs := pending, []ExecParseError(nil)
+			pending = nil
+			if calls == nil {
+				resp, err := c.ChatOnce(nextUserInput)
+				if err != nil {
+					fmt.Fprintf(outputWriter, "\nError: %v\n", err)
+					writeLog(logger, fmt.Sprintf("\n**Error**: %v\n", err))
+					break
+				}
+				lastAssistant = resp
 
-			fmt.Fprintf(outputWriter, "\n%s\n", redactSecrets(resp))
-			writeLog(logger, fmt.Sprintf("\n### Ni bot:\n%s\n", redactSecrets(resp)))
-			if c.SessionManager != nil {
-				c.SessionManager.RecordMessage("assistant", redactSecrets(resp))
-			}
+				fmt.Fprintf(outputWriter, "\n%s\n", redactSecrets(resp))
+				writeLog(logger, fmt.Sprintf("\n### Ni bot:\n%s\n", redactSecrets(resp)))
+				if c.SessionManager != nil {
+					c.SessionManager.RecordMessage("assistant", redactSecrets(resp))
+				}
 
-			calls := ExtractExecCalls(resp)
-			if len(calls) == 0 {
-				break
+				calls, parseErrs = ParseExecCalls(resp)
+				if len(calls) == 0 && len(parseErrs) == 0 {
+					break
+				}
+				if loopStopped || iter >= c.MaxToolIters {
+					c.pendingToolCalls = calls
+					if len(calls) > 0 {
+						hint := pendingToolHint(len(calls), loopStopped, c.MaxToolIters)
+						fmt.Fprintf(outputWriter, "\n%s\n", hint)
+						writeLog(logger, "\n"+hint+"\n")
+					}
+					break
+				}
 			}
 
-			approver := &cliApprover{scanner: scanner, out: outputWriter, logger: logger, logLevel: c.Config.LogLevel}
-			results := ExecuteCalls(ExecContext{Workspace: c.Workspace, Policy: c.Config.Policy}, calls, approver)
+			approver := &cliApprover{scanner: scanner, out: outputWriter, logger: logger, logLevel: c.Config.LogLevel, workspace: c.Workspace, session: c.execSession}
+			execCtx := c.execContext()
+			execCtx.Logger = logger
+			results := ExecuteCalls(execCtx, calls, approver)
+			results = append(results, parseErrorResults(parseErrs)...)
 			fullToolSummary := formatToolResults(results)
 			toolSummaryForModel := redactSecrets(fullToolSummary)
 			toolSummaryForDisplay := toolSummaryForModel
@@ -1613,6 +1634,13 @@ func (c *LLMClient) Loop(inputReader io.Reader, outputWriter io.Writer, logger *
 				}
 			}
 
+			if reason := guard.observe(calls, results); reason != "" {
+				loopStopped = true
+				fmt.Fprintf(outputWriter, "\n[Loop detected] %s\n", reason)
+				writeLog(logger, fmt.Sprintf("\n### Loop detected\n%s\n", reason))
+				toolSummaryForModel += loopStopNote(reason)
+			}
+
 			nextUserInput = toolSummaryForModel
 		}
 
@@ -1724,8 +1752,10 @@ func (c *LLMClient) maybeAutoExtractMemory(userText, assistantText string, scann
 	fmt.Fprintf(outputWriter, "提取到 %d 条候选记忆，将逐条请求审批。\n", len(calls))
 	writeLog(logger, fmt.Sprintf("\n### Auto Memory Proposals (%d)\n", len(calls)))
 
-	approver := &cliApprover{scanner: scanner, out: outputWriter, logger: logger, logLevel: c.Config.LogLevel}
-	results := ExecuteCalls(ExecContext{Workspace: c.Workspace, Policy: c.Config.Policy}, calls, approver)
+	approver := &cliApprover{scanner: scanner, out: outputWriter, logger: logger, logLevel: c.Config.LogLevel, workspace: c.Workspace, session: c.execSession}
+	execCtx := c.execContext()
+	execCtx.Logger = logger
+	results := ExecuteCalls(execCtx, calls, approver)
 
 	fullToolSummary := formatToolResults(results)
 	toolSummaryForModel := redactSecrets(fullToolSummary)
@@ -1956,26 +1986,76 @@ type cliApprover struct {
 	out      io.Writer
 	logger   *os.File
 	logLevel string
+	// workspace / session 用于保存“总是允许 / 拒绝”（approvals.json）和“本次对话允许”的选择
+	workspace string
+	session   string
 }
 
 func (a *cliApprover) Approve(call ExecCall) bool {
-	fmt.Fprintf(a.out, "\nApprove %s %s ? (y/n): ", call.Tool, redactSecrets(previewArgs(call.ArgsRaw)))
+	key, pattern := suggestApprovalPattern(call)
+	like := fmt.Sprintf("%s calls with %s=%s", call.Tool, key, pattern)
+	fmt.Fprintf(a.out, "\nApprove %s %s ?\n", call.Tool, redactSecrets(previewArgs(call.ArgsRaw)))
+	fmt.Fprintln(a.out, "  y = allow once, n = deny once")
+	fmt.Fprintf(a.out, "  s = allow %s for this session\n", call.Tool)
+	fmt.Fprintln(a.out, "  a = always allow this exact call, d = always deny this exact call")
+	choices := "y/n/s/a/d"
+	if key != "" {
+		fmt.Fprintf(a.out, "  p = always allow %s (you can edit the pattern)\n", like)
+		choices += "/p"
+	}
+	fmt.Fprintf(a.out, "(%s): ", choices)
 	for a.scanner.Scan() {
-		v := strings.ToLower(strings.TrimSpace(a.scanner.Text()))
-		if v == "y" || v == "yes" {
+		switch strings.ToLower(strings.TrimSpace(a.scanner.Text())) {
+		case "y", "yes":
 			writeAuditApproval(a.logger, a.logLevel, call, true)
 			return true
-		}
-		if v == "n" || v == "no" {
+		case "n", "no":
+			writeAuditApproval(a.logger, a.logLevel, call, false)
+			return false
+		case "s", "session":
+			allowToolForSession(a.session, call.Tool)
+			writeAuditApproval(a.logger, a.logLevel, call, true)
+			return true
+		case "a", "always":
+			a.remember(ApprovalGrant{Action: "allow", Tool: call.Tool, Args: call.ArgsRaw})
+			writeAuditApproval(a.logger, a.logLevel, call, true)
+			return true
+		case "d", "never":
+			a.remember(ApprovalGrant{Action: "deny", Tool: call.Tool, Args: call.ArgsRaw})
 			writeAuditApproval(a.logger, a.logLevel, call, false)
 			return false
+		case "p", "pattern":
+			if key == "" {
+				// 参数里没有命令 / 路径 / 技能可以匹配，模式只能是“该工具的任意调用”
+				fmt.Fprintf(a.out, "%s has no command, path or skill to match; use a to always allow this exact call: ", call.Tool)
+				continue
+			}
+			fmt.Fprintf(a.out, "%s pattern [%s]: ", key, pattern)
+			if a.scanner.Scan() {
+				if v := strings.TrimSpace(a.scanner.Text()); v != "" {
+					pattern = v
+				}
+			}
+			a.remember(ApprovalGrant{Action: "allow", Tool: call.Tool, Key: key, Pattern: pattern})
+			writeAuditApproval(a.logger, a.logLevel, call, true)
+			return true
 		}
-		fmt.Fprint(a.out, "Please enter y or n: ")
+		fmt.Fprintf(a.out, "Please enter one of %s: ", choices)
 	}
 	writeAuditApproval(a.logger, a.logLevel, call, false)
 	return false
 }
 
+// remember 保存持久的审批记录；保存失败时只影响之后的调用，本次仍按用户的选择执行。
+func (a *cliApprover) remember(g ApprovalGrant) {
+	g, err := AddApprovalGrant(a.workspace, g)
+	if err != nil {
+		fmt.Fprintf(a.out, "无法保存审批记录：%v\n", err)
+		return
+	}
+	fmt.Fprintf(a.out, "已保存 %s（approvals revoke %d 撤销）\n", g.String(), g.ID)
+}
+
 func previewArgs(args string) string {
 	args = strings.TrimSpace(args)
 	if args == "" {
@@ -1995,14 +2075,18 @@ func formatToolResults(results []ToolResult) string {
 		sb.WriteString("  ok: " + fmt.Sprintf("%v", r.OK) + "\n")
 		if r.Error != "" {
 			sb.WriteString("  error: " + strings.ReplaceAll(r.Error, "\n", "\\n") + "\n")
+			if r.Reason != "" && (r.Error == "disabled by policy" || r.Error == "denied by user" || r.Error == "approval unavailable") {
+				sb.WriteString("  policy: " + r.Reason + "\n")
+			}
 		}
 		if r.Output != "" {
 			out := r.Output
 			out = strings.ReplaceAll(out, "\r\n", "\n")
 			out = strings.ReplaceAll(out, "\r", "\n")
 			out = strings.TrimSpace(out)
-			if len(out) > 2000 {
-				out = out[:2000] + "\n[TRUNCATED]"
+			// 超长输出已在 ExecuteCalls 中转存为 artifact，这里只是兜底。
+			if limit := toolOutputInlineBytes() + 1024; len(out) > limit {
+				out = truncateUTF8(out, limit) + "\n[TRUNCATED]"
 			}
 			sb.WriteString("  output: |\n")
 			for _, line := range strings.Split(out, "\n") {
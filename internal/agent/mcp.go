@@ -0,0 +1,945 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MCP 客户端：按 data/mcp.toml 启动 stdio MCP 服务器或连接 HTTP（Streamable HTTP）服务器，
+// 发现它们提供的工具，并以 mcp.<server>.<tool> 的名字和内置工具一起提供给模型：
+//
+//	[servers.files]
+//	command = "npx"
+//	args = ["-y", "@modelcontextprotocol/server-filesystem", "."]
+//	env = ["NODE_OPTIONS"]        # 传给子进程的普通变量（同 skill manifest 的 env）
+//	secrets = ["GITHUB_TOKEN"]    # 传给子进程的密钥变量
+//	allow = ["read_*", "list_*"]  # 只暴露匹配的工具（path.Match 通配），为空表示全部
+//	deny = ["write_*"]            # 优先于 allow
+//	auto_approve = ["read_*"]     # 这些工具不需要审批
+//
+//	[servers.search]
+//	url = "http://127.0.0.1:8931/mcp"
+//	bearer_token_env = "SEARCH_MCP_TOKEN"
+//
+// MCP 工具受 policy.toml 的 allow_mcp / require_approval_mcp 控制，定时任务和子代理中不可用。
+
+const mcpProtocolVersion = "2025-06-18"
+
+type mcpServerConfig struct {
+	Name           string
+	Command        string
+	Args           []string
+	Env            []string
+	Secrets        []string
+	URL            string
+	BearerTokenEnv string
+	Allow          []string
+	Deny           []string
+	AutoApprove    []string
+	Timeout        time.Duration
+}
+
+var mcpServerName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func mcpConfigPath(workspace string) string {
+	return filepath.Join(workspace, "data", "mcp.toml")
+}
+
+func loadMCPConfig(workspace string) ([]mcpServerConfig, error) {
+	var file struct {
+		Servers map[string]struct {
+			Command        string   `toml:"command"`
+			Args           tomlList `toml:"args"`
+			Env            tomlList `toml:"env"`
+			Secrets        tomlList `toml:"secrets"`
+			URL            string   `toml:"url"`
+			BearerTokenEnv string   `toml:"bearer_token_env"`
+			Allow          tomlList `toml:"allow"`
+			Deny           tomlList `toml:"deny"`
+			AutoApprove    tomlList `toml:"auto_approve"`
+			TimeoutSeconds int      `toml:"timeout_seconds"`
+		} `toml:"servers"`
+	}
+	if err := decodeDataToml(mcpConfigPath(workspace), &file); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var servers []mcpServerConfig
+	for name, raw := range file.Servers {
+		if !mcpServerName.MatchString(name) {
+			continue
+		}
+		s := mcpServerConfig{
+			Name:           name,
+			Command:        strings.TrimSpace(raw.Command),
+			Args:           raw.Args,
+			Env:            raw.Env,
+			Secrets:        raw.Secrets,
+			URL:            strings.TrimSpace(raw.URL),
+			BearerTokenEnv: strings.TrimSpace(raw.BearerTokenEnv),
+			Allow:          raw.Allow,
+			Deny:           raw.Deny,
+			AutoApprove:    raw.AutoApprove,
+			Timeout:        30 * time.Second,
+		}
+		if n := raw.TimeoutSeconds; n > 0 && n <= 600 {
+			s.Timeout = time.Duration(n) * time.Second
+		}
+		if (s.Command == "") == (s.URL == "") {
+			return nil, fmt.Errorf("mcp server %s: set exactly one of command or url", s.Name)
+		}
+		servers = append(servers, s)
+	}
+	sort.Slice(servers, func(i, j int) bool { return servers[i].Name < servers[j].Name })
+	return servers, nil
+}
+
+func matchesAnyPattern(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// exposes 判断服务器上名为 name 的工具是否按 allow / deny 暴露给模型。
+func (c mcpServerConfig) exposes(name string) bool {
+	if matchesAnyPattern(c.Deny, name) {
+		return false
+	}
+	return len(c.Allow) == 0 || matchesAnyPattern(c.Allow, name)
+}
+
+// ---- JSON-RPC ----
+
+type mcpMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  any             `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *mcpRPCError    `json:"error,omitempty"`
+}
+
+type mcpRPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *mcpRPCError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// mcpTransport 发送请求并等待对应 id 的响应；id 为空的消息是通知，不等待响应。
+type mcpTransport interface {
+	roundTrip(msg mcpMessage, timeout time.Duration) (*mcpMessage, error)
+	notify(msg mcpMessage) error
+	alive() bool
+	close()
+}
+
+// mcpStdio 与子进程通过换行分隔的 JSON-RPC 消息通信。
+type mcpStdio struct {
+	name    string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stderr  *cappedBuffer
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[string]chan *mcpMessage
+	done    chan struct{}
+	exitErr error
+}
+
+func startMCPStdio(workspace string, cfg mcpServerConfig) (*mcpStdio, error) {
+	env, names := buildChildEnv(LoadToolPolicy(workspace), cfg.Env, cfg.Secrets)
+	appendEnvAudit(workspace, "mcp", "mcp:"+cfg.Name, names)
+	cmd := exec.Command(cfg.Command, cfg.Args...)
+	cmd.Dir = workspace
+	cmd.Env = env
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	s := &mcpStdio{
+		name:    cfg.Name,
+		cmd:     cmd,
+		stdin:   stdin,
+		stderr:  newCappedBuffer(8 * 1024),
+		pending: map[string]chan *mcpMessage{},
+		done:    make(chan struct{}),
+	}
+	cmd.Stderr = s.stderr
+	if err := startCommand(cmd, execRlimits{}); err != nil {
+		return nil, fmt.Errorf("start %s: %w", cfg.Command, err)
+	}
+	go s.readLoop(stdout)
+	return s, nil
+}
+
+func (s *mcpStdio) readLoop(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 32*1024*1024)
+	for scanner.Scan() {
+		var msg mcpMessage
+		if json.Unmarshal(scanner.Bytes(), &msg) != nil {
+			continue
+		}
+		if msg.Method != "" {
+			// 服务器发来的请求（ping、sampling 等）：只支持 ping
+			if len(msg.ID) > 0 {
+				reply := mcpMessage{JSONRPC: "2.0", ID: msg.ID}
+				if msg.Method == "ping" {
+					reply.Result = json.RawMessage(`{}`)
+				} else {
+					reply.Error = &mcpRPCError{Code: -32601, Message: "method not supported by Ni bot: " + msg.Method}
+				}
+				_ = s.write(reply)
+			}
+			continue
+		}
+		s.mu.Lock()
+		ch := s.pending[string(msg.ID)]
+		delete(s.pending, string(msg.ID))
+		s.mu.Unlock()
+		if ch != nil {
+			ch <- &msg
+		}
+	}
+	waitErr := s.cmd.Wait()
+	s.mu.Lock()
+	s.exitErr = fmt.Errorf("mcp server %s exited", s.name)
+	if waitErr != nil {
+		s.exitErr = fmt.Errorf("mcp server %s exited: %v", s.name, waitErr)
+	}
+	if tail := strings.TrimSpace(s.stderr.String()); tail != "" {
+		s.exitErr = fmt.Errorf("%w; stderr: %s", s.exitErr, truncateUTF8(tail, 500))
+	}
+	s.mu.Unlock()
+	close(s.done)
+}
+
+func (s *mcpStdio) write(msg mcpMessage) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	_, err = s.stdin.Write(append(b, '\n'))
+	return err
+}
+
+func (s *mcpStdio) roundTrip(msg mcpMessage, timeout time.Duration) (*mcpMessage, error) {
+	ch := make(chan *mcpMessage, 1)
+	key := string(msg.ID)
+	s.mu.Lock()
+	s.pending[key] = ch
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, key)
+		s.mu.Unlock()
+	}()
+	if err := s.write(msg); err != nil {
+		select {
+		case <-s.done:
+			return nil, s.exitErr
+		default:
+			return nil, err
+		}
+	}
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-s.done:
+		return nil, s.exitErr
+	case <-time.After(timeout):
+		_ = s.notify(mcpMessage{JSONRPC: "2.0", Method: "notifications/cancelled", Params: map[string]any{"requestId": msg.ID, "reason": "timeout"}})
+		return nil, fmt.Errorf("mcp server %s did not answer %s within %s", s.name, msg.Method, timeout)
+	}
+}
+
+func (s *mcpStdio) notify(msg mcpMessage) error {
+	return s.write(msg)
+}
+
+func (s *mcpStdio) alive() bool {
+	select {
+	case <-s.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (s *mcpStdio) close() {
+	_ = s.stdin.Close()
+	select {
+	case <-s.done:
+	case <-time.After(2 * time.Second):
+		killProcessTree(s.cmd)
+		<-s.done
+	}
+}
+
+// mcpHTTP 实现 Streamable HTTP：每条消息一次 POST，响应可以是 JSON，也可以是 SSE 流。
+type mcpHTTP struct {
+	name   string
+	url    string
+	token  string
+	client *http.Client
+
+	mu        sync.Mutex
+	sessionID string
+}
+
+func (h *mcpHTTP) post(msg mcpMessage, timeout time.Duration) (*mcpMessage, error) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	req.Header.Set("MCP-Protocol-Version", mcpProtocolVersion)
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+	h.mu.Lock()
+	if h.sessionID != "" {
+		req.Header.Set("Mcp-Session-Id", h.sessionID)
+	}
+	h.mu.Unlock()
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("mcp server %s did not answer %s within %s", h.name, msg.Method, timeout)
+		}
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			err = ue.Err
+		}
+		return nil, fmt.Errorf("mcp server %s: request failed: %v", h.name, err)
+	}
+	defer resp.Body.Close()
+	if sid := resp.Header.Get("Mcp-Session-Id"); sid != "" {
+		h.mu.Lock()
+		h.sessionID = sid
+		h.mu.Unlock()
+	}
+	if resp.StatusCode == http.StatusAccepted || len(msg.ID) == 0 {
+		return nil, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("mcp server %s: HTTP %d: %s", h.name, resp.StatusCode, truncateUTF8(strings.TrimSpace(string(body)), 300))
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return readMCPEventStream(resp.Body, msg.ID)
+	}
+	var out mcpMessage
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 32*1024*1024)).Decode(&out); err != nil {
+		return nil, fmt.Errorf("mcp server %s: invalid response: %v", h.name, err)
+	}
+	return &out, nil
+}
+
+// readMCPEventStream 读取 SSE 事件，直到出现与 id 对应的响应。
+func readMCPEventStream(r io.Reader, id json.RawMessage) (*mcpMessage, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 32*1024*1024)
+	var data strings.Builder
+	flush := func() *mcpMessage {
+		defer data.Reset()
+		var msg mcpMessage
+		if data.Len() == 0 || json.Unmarshal([]byte(data.String()), &msg) != nil {
+			return nil
+		}
+		if msg.Method == "" && bytes.Equal(msg.ID, id) {
+			return &msg
+		}
+		return nil
+	}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if msg := flush(); msg != nil {
+				return msg, nil
+			}
+			continue
+		}
+		if v, ok := strings.CutPrefix(line, "data:"); ok {
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(v, " "))
+		}
+	}
+	if msg := flush(); msg != nil {
+		return msg, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("event stream ended without a response")
+}
+
+func (h *mcpHTTP) roundTrip(msg mcpMessage, timeout time.Duration) (*mcpMessage, error) {
+	return h.post(msg, timeout)
+}
+
+func (h *mcpHTTP) notify(msg mcpMessage) error {
+	_, err := h.post(msg, 10*time.Second)
+	return err
+}
+
+func (h *mcpHTTP) alive() bool { return true }
+
+func (h *mcpHTTP) close() {
+	h.mu.Lock()
+	sid := h.sessionID
+	h.mu.Unlock()
+	if sid == "" {
+		return
+	}
+	req, err := http.NewRequest(http.MethodDelete, h.url, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Mcp-Session-Id", sid)
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+	if resp, err := h.client.Do(req); err == nil {
+		resp.Body.Close()
+	}
+}
+
+// ---- 服务器与工具 ----
+
+type mcpTool struct {
+	Server      string
+	Name        string
+	FullName    string
+	Description string
+	Schema      map[string]any
+}
+
+// mcpToolName 把服务器上的工具名转换成 EXEC 标签可用的名字。
+func mcpToolName(server, name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if !isToolNameByte(c) {
+			b[i] = '_'
+		}
+	}
+	return "mcp." + server + "." + string(b)
+}
+
+type mcpServer struct {
+	cfg       mcpServerConfig
+	workspace string
+	ids       atomic.Int64
+
+	mu       sync.Mutex
+	t        mcpTransport
+	tools    []mcpTool
+	lastErr  error
+	failedAt time.Time
+}
+
+// mcpRetryAfter 是连接失败后再次尝试之前的等待时间，避免每轮对话都重启一个坏掉的服务器。
+const mcpRetryAfter = time.Minute
+
+// connect 确保服务器已经连接并完成初始化、列出了工具；调用方需持有 s.mu。
+func (s *mcpServer) connect() error {
+	if s.t != nil && s.t.alive() {
+		return nil
+	}
+	if s.t != nil {
+		s.t.close()
+		s.t = nil
+	}
+	if s.lastErr != nil && time.Since(s.failedAt) < mcpRetryAfter {
+		return s.lastErr
+	}
+	err := s.dial()
+	if err != nil {
+		if s.t != nil {
+			s.t.close()
+			s.t = nil
+		}
+		s.lastErr, s.failedAt = err, time.Now()
+		log.Printf("mcp server %s unavailable: %v", s.cfg.Name, err)
+		return err
+	}
+	s.lastErr = nil
+	return nil
+}
+
+func (s *mcpServer) dial() error {
+	if s.cfg.URL != "" {
+		token := ""
+		if s.cfg.BearerTokenEnv != "" {
+			token = strings.TrimSpace(os.Getenv(s.cfg.BearerTokenEnv))
+		}
+		s.t = &mcpHTTP{name: s.cfg.Name, url: s.cfg.URL, token: token, client: &http.Client{}}
+	} else {
+		t, err := startMCPStdio(s.workspace, s.cfg)
+		if err != nil {
+			return err
+		}
+		s.t = t
+	}
+
+	var initResult struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	err := s.requestLocked("initialize", map[string]any{
+		"protocolVersion": mcpProtocolVersion,
+		"capabilities":    map[string]any{},
+		"clientInfo":      map[string]any{"name": "nibot", "version": "1"},
+	}, &initResult)
+	if err != nil {
+		return fmt.Errorf("initialize: %w", err)
+	}
+	if err := s.t.notify(mcpMessage{JSONRPC: "2.0", Method: "notifications/initialized"}); err != nil {
+		return fmt.Errorf("initialize: %w", err)
+	}
+
+	var tools []mcpTool
+	cursor := ""
+	for page := 0; page < 20; page++ {
+		var params map[string]any
+		if cursor != "" {
+			params = map[string]any{"cursor": cursor}
+		}
+		var res struct {
+			Tools []struct {
+				Name        string         `json:"name"`
+				Description string         `json:"description"`
+				InputSchema map[string]any `json:"inputSchema"`
+			} `json:"tools"`
+			NextCursor string `json:"nextCursor"`
+		}
+		if err := s.requestLocked("tools/list", params, &res); err != nil {
+			return fmt.Errorf("tools/list: %w", err)
+		}
+		for _, t := range res.Tools {
+			if t.Name == "" || !s.cfg.exposes(t.Name) {
+				continue
+			}
+			schema := t.InputSchema
+			if schema == nil {
+				schema = map[string]any{}
+			}
+			schema["type"] = "object"
+			if _, ok := schema["properties"]; !ok {
+				schema["properties"] = map[string]any{}
+			}
+			tools = append(tools, mcpTool{
+				Server:      s.cfg.Name,
+				Name:        t.Name,
+				FullName:    mcpToolName(s.cfg.Name, t.Name),
+				Description: strings.TrimSpace(t.Description),
+				Schema:      schema,
+			})
+		}
+		if res.NextCursor == "" {
+			break
+		}
+		cursor = res.NextCursor
+	}
+	sort.Slice(tools, func(i, j int) bool { return tools[i].FullName < tools[j].FullName })
+	s.tools = tools
+	return nil
+}
+
+func (s *mcpServer) requestLocked(method string, params any, out any) error {
+	return mcpRequest(s.t, s.ids.Add(1), method, params, out, s.cfg.Timeout)
+}
+
+func mcpRequest(t mcpTransport, id int64, method string, params any, out any, timeout time.Duration) error {
+	msg := mcpMessage{JSONRPC: "2.0", ID: json.RawMessage(strconv.FormatInt(id, 10)), Method: method, Params: params}
+	resp, err := t.roundTrip(msg, timeout)
+	if err != nil {
+		return err
+	}
+	if resp == nil {
+		return fmt.Errorf("no response to %s", method)
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(resp.Result, out)
+}
+
+// call 调用服务器上的工具；连接断开时重连一次。
+func (s *mcpServer) call(name string, args map[string]any) (*mcpCallResult, error) {
+	s.mu.Lock()
+	if err := s.connect(); err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	t := s.t
+	s.mu.Unlock()
+
+	var res mcpCallResult
+	err := mcpRequest(t, s.ids.Add(1), "tools/call", map[string]any{"name": name, "arguments": args}, &res, s.cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (s *mcpServer) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.t != nil {
+		s.t.close()
+		s.t = nil
+	}
+}
+
+type mcpCallResult struct {
+	Content []struct {
+		Type     string `json:"type"`
+		Text     string `json:"text"`
+		MimeType string `json:"mimeType"`
+		Data     string `json:"data"`
+		URI      string `json:"uri"`
+		Resource struct {
+			URI      string `json:"uri"`
+			MimeType string `json:"mimeType"`
+			Text     string `json:"text"`
+		} `json:"resource"`
+	} `json:"content"`
+	StructuredContent json.RawMessage `json:"structuredContent"`
+	IsError           bool            `json:"isError"`
+}
+
+// text 把结果内容转换成文本；图片、音频等二进制内容只给出类型和大小。
+func (r *mcpCallResult) text() string {
+	var parts []string
+	for _, c := range r.Content {
+		switch c.Type {
+		case "text":
+			parts = append(parts, c.Text)
+		case "image", "audio":
+			parts = append(parts, fmt.Sprintf("[%s %s, %d bytes base64]", c.Type, c.MimeType, len(c.Data)))
+		case "resource":
+			if c.Resource.Text != "" {
+				parts = append(parts, c.Resource.Text)
+			} else {
+				parts = append(parts, fmt.Sprintf("[resource %s %s]", c.Resource.URI, c.Resource.MimeType))
+			}
+		case "resource_link":
+			parts = append(parts, "[resource link "+c.URI+"]")
+		}
+	}
+	if len(parts) == 0 && len(r.StructuredContent) > 0 {
+		return string(r.StructuredContent)
+	}
+	return strings.Join(parts, "\n")
+}
+
+// ---- 每个工作区一组服务器 ----
+
+type mcpManager struct {
+	workspace string
+	modTime   time.Time
+	servers   []*mcpServer
+	configErr error
+}
+
+var mcpManagers = struct {
+	sync.Mutex
+	m map[string]*mcpManager
+}{m: map[string]*mcpManager{}}
+
+// mcpFor 返回工作区的 MCP 服务器；mcp.toml 修改后关闭旧的服务器并按新配置重建。
+func mcpFor(workspace string) *mcpManager {
+	var modTime time.Time
+	if st, err := os.Stat(mcpConfigPath(workspace)); err == nil {
+		modTime = st.ModTime()
+	}
+	mcpManagers.Lock()
+	defer mcpManagers.Unlock()
+	if m := mcpManagers.m[workspace]; m != nil && m.modTime.Equal(modTime) {
+		return m
+	} else if m != nil {
+		go m.close()
+	}
+	m := &mcpManager{workspace: workspace, modTime: modTime}
+	cfgs, err := loadMCPConfig(workspace)
+	if err != nil {
+		m.configErr = err
+		log.Printf("read data/mcp.toml: %v", err)
+	}
+	for _, c := range cfgs {
+		m.servers = append(m.servers, &mcpServer{cfg: c, workspace: workspace})
+	}
+	mcpManagers.m[workspace] = m
+	return m
+}
+
+// tools 并行连接所有服务器，返回可用的工具；连接失败的服务器被跳过。
+func (m *mcpManager) tools() []mcpTool {
+	if len(m.servers) == 0 {
+		return nil
+	}
+	var wg sync.WaitGroup
+	for _, s := range m.servers {
+		wg.Add(1)
+		go func(s *mcpServer) {
+			defer wg.Done()
+			s.mu.Lock()
+			_ = s.connect()
+			s.mu.Unlock()
+		}(s)
+	}
+	wg.Wait()
+	var out []mcpTool
+	for _, s := range m.servers {
+		s.mu.Lock()
+		if s.lastErr == nil {
+			out = append(out, s.tools...)
+		}
+		s.mu.Unlock()
+	}
+	return out
+}
+
+func (m *mcpManager) lookup(fullName string) (*mcpServer, mcpTool, bool) {
+	for _, s := range m.servers {
+		if !strings.HasPrefix(fullName, "mcp."+s.cfg.Name+".") {
+			continue
+		}
+		s.mu.Lock()
+		_ = s.connect()
+		tools := s.tools
+		s.mu.Unlock()
+		for _, t := range tools {
+			if t.FullName == fullName {
+				return s, t, true
+			}
+		}
+	}
+	return nil, mcpTool{}, false
+}
+
+func (m *mcpManager) close() {
+	for _, s := range m.servers {
+		s.close()
+	}
+}
+
+// CloseMCPServers 结束所有 MCP 子进程并关闭 HTTP 会话，进程退出前调用。
+func CloseMCPServers() {
+	mcpManagers.Lock()
+	managers := mcpManagers.m
+	mcpManagers.m = map[string]*mcpManager{}
+	mcpManagers.Unlock()
+	for _, m := range managers {
+		m.close()
+	}
+}
+
+// mcpAutoApproved 判断 MCP 工具是否被服务器配置的 auto_approve 免除审批。
+func mcpAutoApproved(fullName string) bool {
+	mcpManagers.Lock()
+	managers := make([]*mcpManager, 0, len(mcpManagers.m))
+	for _, m := range mcpManagers.m {
+		managers = append(managers, m)
+	}
+	mcpManagers.Unlock()
+	for _, m := range managers {
+		for _, s := range m.servers {
+			if len(s.cfg.AutoApprove) == 0 || !strings.HasPrefix(fullName, "mcp."+s.cfg.Name+".") {
+				continue
+			}
+			s.mu.Lock()
+			tools := s.tools
+			s.mu.Unlock()
+			for _, t := range tools {
+				if t.FullName == fullName {
+					return matchesAnyPattern(s.cfg.AutoApprove, t.Name)
+				}
+			}
+		}
+	}
+	return false
+}
+
+func toolMCPCall(ctx ExecContext, tool, argsRaw string) (string, error) {
+	m := mcpFor(ctx.Workspace)
+	s, t, ok := m.lookup(tool)
+	if !ok {
+		var names []string
+		for _, t := range m.tools() {
+			names = append(names, t.FullName)
+		}
+		if len(names) == 0 {
+			return "", fmt.Errorf("unknown MCP tool %s: no MCP tools are available (check data/mcp.toml and the server logs)", tool)
+		}
+		return "", fmt.Errorf("unknown MCP tool %s; available: %s", tool, strings.Join(names, ", "))
+	}
+
+	args := map[string]any{}
+	if trimmed := strings.TrimSpace(argsRaw); trimmed != "" {
+		if !strings.HasPrefix(trimmed, "{") {
+			return "", fmt.Errorf("%s requires JSON args: %s", tool, schemaArgsSignature(t.Schema))
+		}
+		if err := json.Unmarshal([]byte(trimmed), &args); err != nil {
+			return "", fmt.Errorf("invalid JSON args for %s: %w", tool, err)
+		}
+	}
+	var missing []string
+	for _, name := range schemaRequired(t.Schema) {
+		if _, ok := args[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("%s: missing required argument(s) %s; expected %s", tool, strings.Join(missing, ", "), schemaArgsSignature(t.Schema))
+	}
+
+	res, err := s.call(t.Name, args)
+	if err != nil {
+		return "", err
+	}
+	out := res.text()
+	if res.IsError {
+		return out, fmt.Errorf("%s returned an error: %s", tool, truncateUTF8(firstNonEmpty(firstLine(out), "(no details)"), 300))
+	}
+	return out, nil
+}
+
+func schemaRequired(schema map[string]any) []string {
+	var out []string
+	if req, ok := schema["required"].([]any); ok {
+		for _, r := range req {
+			if s, ok := r.(string); ok {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+// schemaArgsSignature 把 JSON Schema 压缩成提示词里一行的参数说明，如 {"path": string (required), "tail": integer}。
+func schemaArgsSignature(schema map[string]any) string {
+	props, _ := schema["properties"].(map[string]any)
+	if len(props) == 0 {
+		return "{}"
+	}
+	required := map[string]bool{}
+	for _, r := range schemaRequired(schema) {
+		required[r] = true
+	}
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if required[names[i]] != required[names[j]] {
+			return required[names[i]]
+		}
+		return names[i] < names[j]
+	})
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		typ := "any"
+		if p, ok := props[name].(map[string]any); ok {
+			if s, ok := p["type"].(string); ok {
+				typ = s
+			}
+		}
+		if required[name] {
+			typ += " (required)"
+		}
+		parts = append(parts, fmt.Sprintf("%q: %s", name, typ))
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+// mcpPromptSection 列出可用的 MCP 工具；没有配置或策略禁用时返回空串。
+func mcpPromptSection(workspace string) string {
+	policy := LoadToolPolicy(workspace)
+	if !policy.AllowsTool("mcp.") {
+		return ""
+	}
+	tools := mcpFor(workspace).tools()
+	if len(tools) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("\n=== MCP TOOLS ===\n")
+	sb.WriteString("These tools are provided by external MCP servers. Call them like built-in tools, e.g. [EXEC:" + tools[0].FullName + " {...}]:\n")
+	for _, t := range tools {
+		desc := truncateRunes(strings.Join(strings.Fields(t.Description), " "), 200)
+		sb.WriteString(fmt.Sprintf("- %s %s", t.FullName, schemaArgsSignature(t.Schema)))
+		if desc != "" {
+			sb.WriteString(": " + desc)
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// mcpOpenAITools 把 MCP 工具转换为原生 tool calling 的定义。
+func mcpOpenAITools(workspace string, p ToolPolicy) []openAITool {
+	var out []openAITool
+	for _, t := range mcpFor(workspace).tools() {
+		if !p.AllowsTool(t.FullName) {
+			continue
+		}
+		out = append(out, openAITool{
+			Type: "function",
+			Function: openAIFunctionDef{
+				Name:        t.FullName,
+				Description: t.Description,
+				Parameters:  t.Schema,
+			},
+		})
+	}
+	return out
+}
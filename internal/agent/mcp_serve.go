@@ -0,0 +1,227 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// nibot mcp-serve：以 stdio MCP 服务器运行，把长期记忆工具和 skill.exec 作为 tools、
+// 把 DiscoverSkills 找到的技能文档作为 resources（skill://<name>）提供给其他 agent / 编辑器。
+// 每次调用都经过 ExecuteCalls，因此 policy.toml 的开关、白名单、参数校验和沙箱照常生效，并写入 tool_audits。
+//
+// stdin/stdout 被协议占用，无法在终端里审批：需要审批的调用默认拒绝；
+// NIBOT_MCP_APPROVE=client 表示由 MCP 客户端负责向用户确认（大多数编辑器在调用工具前都会询问）。
+
+var mcpServeTools = []string{"memory.store", "memory.recall", "memory.list", "memory.forget", "skill.exec"}
+
+var mcpServeSessionSeq atomic.Int64
+
+type mcpServeApprover struct{}
+
+func (mcpServeApprover) Approve(ExecCall) bool {
+	return strings.EqualFold(strings.TrimSpace(os.Getenv("NIBOT_MCP_APPROVE")), "client")
+}
+
+// ServeMCP 从 in 读取换行分隔的 JSON-RPC 请求并把响应写到 out，直到 in 结束。
+func ServeMCP(workspace string, policy ToolPolicy, in io.Reader, out io.Writer) error {
+	if !policy.Loaded {
+		policy = DefaultToolPolicy()
+	}
+	srv := &mcpServe{
+		ctx: ExecContext{
+			Workspace: workspace,
+			Policy:    policy,
+			Session:   fmt.Sprintf("mcp_%d", mcpServeSessionSeq.Add(1)),
+			Origin:    "mcp",
+		},
+		out: out,
+	}
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 32*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var msg mcpMessage
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			srv.write(mcpMessage{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &mcpRPCError{Code: -32700, Message: "parse error: " + err.Error()}})
+			continue
+		}
+		if msg.Method == "" || len(msg.ID) == 0 {
+			// 通知（notifications/initialized、cancelled）和客户端发来的响应都不需要回复
+			continue
+		}
+		result, rpcErr := srv.handle(msg)
+		reply := mcpMessage{JSONRPC: "2.0", ID: msg.ID, Error: rpcErr}
+		if rpcErr == nil {
+			b, err := json.Marshal(result)
+			if err != nil {
+				reply.Error = &mcpRPCError{Code: -32603, Message: err.Error()}
+			} else {
+				reply.Result = b
+			}
+		}
+		srv.write(reply)
+	}
+	return scanner.Err()
+}
+
+type mcpServe struct {
+	ctx ExecContext
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func (s *mcpServe) write(msg mcpMessage) {
+	b, _ := json.Marshal(msg)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, _ = s.out.Write(append(b, '\n'))
+}
+
+func (s *mcpServe) handle(msg mcpMessage) (any, *mcpRPCError) {
+	var params map[string]json.RawMessage
+	if raw, err := json.Marshal(msg.Params); err == nil {
+		_ = json.Unmarshal(raw, &params)
+	}
+	str := func(key string) string {
+		var v string
+		_ = json.Unmarshal(params[key], &v)
+		return v
+	}
+
+	switch msg.Method {
+	case "initialize":
+		version := str("protocolVersion")
+		if version == "" {
+			version = mcpProtocolVersion
+		}
+		return map[string]any{
+			"protocolVersion": version,
+			"capabilities":    map[string]any{"tools": map[string]any{}, "resources": map[string]any{}},
+			"serverInfo":      map[string]any{"name": "nibot", "version": firstNonEmpty(os.Getenv("NIBOT_VERSION"), "dev")},
+			"instructions":    "Ni bot long-term memory (memory.*) and skills. Read skill://<name> resources for skill docs before calling skill.exec.",
+		}, nil
+	case "ping":
+		return map[string]any{}, nil
+	case "tools/list":
+		return map[string]any{"tools": s.listTools()}, nil
+	case "tools/call":
+		name := str("name")
+		args := "{}"
+		if raw := params["arguments"]; len(raw) > 0 && string(raw) != "null" {
+			args = string(raw)
+		}
+		return s.callTool(name, args), nil
+	case "resources/list":
+		return map[string]any{"resources": s.listResources()}, nil
+	case "resources/read":
+		res, err := s.readResource(str("uri"))
+		if err != nil {
+			return nil, &mcpRPCError{Code: -32002, Message: err.Error()}
+		}
+		return res, nil
+	case "resources/templates/list":
+		return map[string]any{"resourceTemplates": []any{}}, nil
+	default:
+		return nil, &mcpRPCError{Code: -32601, Message: "method not found: " + msg.Method}
+	}
+}
+
+func (s *mcpServe) listTools() []map[string]any {
+	tools := []map[string]any{}
+	for _, name := range mcpServeTools {
+		if !s.ctx.Policy.AllowsTool(name) {
+			continue
+		}
+		spec := lookupToolSpec(name)
+		if spec == nil {
+			continue
+		}
+		readOnly := name == "memory.recall" || name == "memory.list"
+		tools = append(tools, map[string]any{
+			"name":        name,
+			"description": spec.Description,
+			"inputSchema": spec.Args.jsonSchema(),
+			"annotations": map[string]any{
+				"readOnlyHint":    readOnly,
+				"destructiveHint": name == "memory.forget" || name == "skill.exec",
+			},
+		})
+	}
+	return tools
+}
+
+func (s *mcpServe) callTool(name, args string) map[string]any {
+	text := func(t string, isErr bool) map[string]any {
+		return map[string]any{"content": []any{map[string]any{"type": "text", "text": t}}, "isError": isErr}
+	}
+	if !containsString(mcpServeTools, name) {
+		return text(fmt.Sprintf("unknown tool %q; available: %s", name, strings.Join(mcpServeTools, ", ")), true)
+	}
+	call := ExecCall{Tool: name, ArgsRaw: args}
+	r := ExecuteCalls(s.ctx, []ExecCall{call}, mcpServeApprover{})[0]
+	if err := appendToolAudit(s.ctx.Workspace, s.ctx.Session, call, r); err != nil {
+		fmt.Fprintf(os.Stderr, "tool audit for %s failed: %v\n", name, err)
+	}
+	if r.OK {
+		return text(r.Output, false)
+	}
+	msg := r.Error
+	if msg == "denied by user" {
+		msg = name + " requires approval, which cannot be asked for over MCP; set NIBOT_MCP_APPROVE=client to let the MCP client confirm calls, or turn off the approval for this tool in policy.toml"
+	}
+	if strings.TrimSpace(r.Output) != "" {
+		msg += "\n" + r.Output
+	}
+	return text(msg, true)
+}
+
+func (s *mcpServe) listResources() []map[string]any {
+	out := []map[string]any{}
+	skills, _ := DiscoverSkills(s.ctx.Workspace)
+	for _, sk := range skills {
+		res := map[string]any{
+			"uri":      "skill://" + url.PathEscape(sk.Name),
+			"name":     sk.Name,
+			"mimeType": "text/markdown",
+		}
+		if sk.DisplayName != "" && sk.DisplayName != sk.Name {
+			res["title"] = sk.DisplayName
+		}
+		if sk.Description != "" {
+			res["description"] = sk.Description
+		}
+		out = append(out, res)
+	}
+	return out
+}
+
+func (s *mcpServe) readResource(uri string) (map[string]any, error) {
+	name, ok := strings.CutPrefix(uri, "skill://")
+	if !ok {
+		return nil, fmt.Errorf("resource not found: %s", uri)
+	}
+	name, _ = url.PathUnescape(name)
+	skills, _ := DiscoverSkills(s.ctx.Workspace)
+	for _, sk := range skills {
+		if sk.Name != name {
+			continue
+		}
+		var b strings.Builder
+		b.WriteString(sk.Docs)
+		if len(sk.Scripts) > 0 {
+			fmt.Fprintf(&b, "\n\n---\nScripts (run with skill.exec {\"skill\":%q,\"script\":\"...\"}): %s\n", sk.Name, strings.Join(sk.Scripts, ", "))
+		}
+		return map[string]any{"contents": []any{map[string]any{"uri": uri, "mimeType": "text/markdown", "text": b.String()}}}, nil
+	}
+	return nil, fmt.Errorf("resource not found: %s", uri)
+}
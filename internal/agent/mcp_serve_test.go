@@ -0,0 +1,118 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// serveMCPLines 把 requests 逐行交给 ServeMCP，按 id 返回响应。
+func serveMCPLines(t *testing.T, ws string, policy ToolPolicy, requests ...string) map[string]mcpMessage {
+	t.Helper()
+	var out bytes.Buffer
+	if err := ServeMCP(ws, policy, strings.NewReader(strings.Join(requests, "\n")+"\n"), &out); err != nil {
+		t.Fatal(err)
+	}
+	replies := map[string]mcpMessage{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var msg mcpMessage
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			t.Fatalf("invalid output line %q: %v", line, err)
+		}
+		replies[string(msg.ID)] = msg
+	}
+	return replies
+}
+
+func TestServeMCP_MemoryAndSkills(t *testing.T) {
+	t.Setenv("NIBOT_STORAGE", "sqlite")
+	t.Setenv("NIBOT_MCP_APPROVE", "client")
+	ws := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(ws, "skills", "weather"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(ws, "skills", "weather", "SKILL.md"), []byte("---\nname: weather\ndescription: Look up the weather\n---\n# Weather\nUse wttr.in\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	policy := DefaultToolPolicy()
+	policy.AllowSkillExec = false
+
+	replies := serveMCPLines(t, ws, policy,
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{},"clientInfo":{"name":"test"}}}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`,
+		`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"memory.store","arguments":{"scope":"global","tags":"deploy","content":"Deploys happen on Fridays"}}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"memory.recall","arguments":{"query":"Fridays"}}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"name":"skill.exec","arguments":{"skill":"weather","script":"x.sh"}}}`,
+		`{"jsonrpc":"2.0","id":6,"method":"tools/call","params":{"name":"memory.recall","arguments":{"limit":"ten"}}}`,
+		`{"jsonrpc":"2.0","id":7,"method":"resources/list"}`,
+		`{"jsonrpc":"2.0","id":8,"method":"resources/read","params":{"uri":"skill://weather"}}`,
+		`{"jsonrpc":"2.0","id":9,"method":"resources/read","params":{"uri":"skill://missing"}}`,
+		`{"jsonrpc":"2.0","id":10,"method":"sampling/createMessage"}`,
+	)
+	if len(replies) != 10 {
+		t.Fatalf("expected 10 replies (no reply to notifications), got %d", len(replies))
+	}
+	if !strings.Contains(string(replies["1"].Result), `"protocolVersion":"2025-03-26"`) {
+		t.Fatalf("unexpected initialize result: %s", replies["1"].Result)
+	}
+	var list struct {
+		Tools []struct{ Name string } `json:"tools"`
+	}
+	_ = json.Unmarshal(replies["2"].Result, &list)
+	var names []string
+	for _, tool := range list.Tools {
+		names = append(names, tool.Name)
+	}
+	if strings.Join(names, ",") != "memory.store,memory.recall,memory.list,memory.forget" {
+		t.Fatalf("skill.exec is disabled by policy and must not be listed: %v", names)
+	}
+
+	result := func(id string) (string, bool) {
+		var r struct {
+			Content []struct{ Text string } `json:"content"`
+			IsError bool                    `json:"isError"`
+		}
+		_ = json.Unmarshal(replies[id].Result, &r)
+		if len(r.Content) == 0 {
+			t.Fatalf("reply %s has no content: %+v", id, replies[id])
+		}
+		return r.Content[0].Text, r.IsError
+	}
+	if text, isErr := result("3"); isErr {
+		t.Fatalf("memory.store failed: %s", text)
+	}
+	if text, isErr := result("4"); isErr || !strings.Contains(text, "Deploys happen on Fridays") {
+		t.Fatalf("memory.recall should find the stored item: %q", text)
+	}
+	if text, isErr := result("5"); !isErr || text != "disabled by policy" {
+		t.Fatalf("expected policy denial, got %q", text)
+	}
+	if text, isErr := result("6"); !isErr || !strings.Contains(text, "invalid arguments") {
+		t.Fatalf("expected argument validation error, got %q", text)
+	}
+
+	if !strings.Contains(string(replies["7"].Result), `"uri":"skill://weather"`) || !strings.Contains(string(replies["7"].Result), "Look up the weather") {
+		t.Fatalf("unexpected resources: %s", replies["7"].Result)
+	}
+	if !strings.Contains(string(replies["8"].Result), "Use wttr.in") {
+		t.Fatalf("unexpected resource content: %s", replies["8"].Result)
+	}
+	if replies["9"].Error == nil || replies["10"].Error == nil || replies["10"].Error.Code != -32601 {
+		t.Fatalf("expected errors for missing resource and unknown method: %+v %+v", replies["9"], replies["10"])
+	}
+}
+
+func TestServeMCP_ApprovalRequiredByDefault(t *testing.T) {
+	t.Setenv("NIBOT_STORAGE", "sqlite")
+	ws := t.TempDir()
+	replies := serveMCPLines(t, ws, DefaultToolPolicy(),
+		`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"memory.store","arguments":{"content":"x"}}}`,
+	)
+	if !strings.Contains(string(replies["1"].Result), "NIBOT_MCP_APPROVE=client") || !strings.Contains(string(replies["1"].Result), `"isError":true`) {
+		t.Fatalf("expected approval error, got %s", replies["1"].Result)
+	}
+}
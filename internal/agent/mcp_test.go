@@ -0,0 +1,220 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMCPReply 是测试用 MCP 服务器的全部逻辑：四个工具，其中 delete_all 应被 deny 过滤。
+func fakeMCPReply(msg mcpMessage) any {
+	params, _ := msg.Params.(map[string]any)
+	switch msg.Method {
+	case "initialize":
+		return map[string]any{"protocolVersion": mcpProtocolVersion, "capabilities": map[string]any{"tools": map[string]any{}}, "serverInfo": map[string]any{"name": "fake", "version": "0"}}
+	case "tools/list":
+		return map[string]any{"tools": []any{
+			map[string]any{"name": "echo", "description": "Echo the text back", "inputSchema": map[string]any{"type": "object", "properties": map[string]any{"text": map[string]any{"type": "string"}}, "required": []any{"text"}}},
+			map[string]any{"name": "add", "description": "Add two numbers", "inputSchema": map[string]any{"type": "object", "properties": map[string]any{"a": map[string]any{"type": "number"}, "b": map[string]any{"type": "number"}}}},
+			map[string]any{"name": "fail", "description": "Always fails"},
+			map[string]any{"name": "delete_all", "description": "Dangerous"},
+		}}
+	case "tools/call":
+		args, _ := params["arguments"].(map[string]any)
+		switch params["name"] {
+		case "echo":
+			return map[string]any{"content": []any{map[string]any{"type": "text", "text": fmt.Sprint("echo: ", args["text"])}}}
+		case "add":
+			a, _ := args["a"].(float64)
+			b, _ := args["b"].(float64)
+			return map[string]any{"content": []any{map[string]any{"type": "text", "text": fmt.Sprint(a + b)}}}
+		case "fail":
+			return map[string]any{"isError": true, "content": []any{map[string]any{"type": "text", "text": "quota exceeded"}}}
+		}
+	}
+	return nil
+}
+
+// TestMCPHelperProcess 不是真正的测试：由 NIBOT_MCP_HELPER=1 启动时充当 stdio MCP 服务器。
+func TestMCPHelperProcess(t *testing.T) {
+	if os.Getenv("NIBOT_MCP_HELPER") != "1" {
+		return
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var msg mcpMessage
+		if json.Unmarshal(scanner.Bytes(), &msg) != nil || len(msg.ID) == 0 {
+			continue
+		}
+		b, _ := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": msg.ID, "result": fakeMCPReply(msg)})
+		fmt.Println(string(b))
+	}
+	os.Exit(0)
+}
+
+func writeMCPConfig(t *testing.T, ws, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Join(ws, "data"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(ws, "data", "mcp.toml"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(CloseMCPServers)
+}
+
+func TestMCP_StdioServerTools(t *testing.T) {
+	t.Setenv("NIBOT_MCP_HELPER", "1")
+	ws := t.TempDir()
+	writeMCPConfig(t, ws, fmt.Sprintf(`
+[servers.fake]
+command = %q
+args = ["-test.run=TestMCPHelperProcess"]
+env = ["NIBOT_MCP_HELPER"]
+deny = ["delete_*"]
+auto_approve = ["echo"]
+`, os.Args[0]))
+
+	prompt := mcpPromptSection(ws)
+	if !strings.Contains(prompt, `- mcp.fake.echo {"text": string (required)}: Echo the text back`) || strings.Contains(prompt, "delete_all") {
+		t.Fatalf("unexpected prompt section:\n%s", prompt)
+	}
+	c := NewLLMClient(Config{}, ws, "sys", nil)
+	var native []string
+	for _, tool := range c.openAIToolsForPolicy() {
+		if strings.HasPrefix(tool.Function.Name, "mcp.") {
+			native = append(native, tool.Function.Name)
+		}
+	}
+	if strings.Join(native, ",") != "mcp.fake.add,mcp.fake.echo,mcp.fake.fail" {
+		t.Fatalf("unexpected native MCP tools: %v", native)
+	}
+
+	ctx := ExecContext{Workspace: ws, Policy: DefaultToolPolicy()}
+	approver := &countingApprover{}
+	res := ExecuteCalls(ctx, []ExecCall{
+		{Tool: "mcp.fake.echo", ArgsRaw: `{"text":"hi"}`},
+		{Tool: "mcp.fake.add", ArgsRaw: `{"a":2,"b":3}`},
+		{Tool: "mcp.fake.fail", ArgsRaw: `{}`},
+		{Tool: "mcp.fake.delete_all", ArgsRaw: `{}`},
+		{Tool: "mcp.fake.echo", ArgsRaw: `{}`},
+	}, approver)
+	if !res[0].OK || res[0].Output != "echo: hi" || !res[1].OK || res[1].Output != "5" {
+		t.Fatalf("unexpected results: %+v", res[:2])
+	}
+	if approver.n != 3 {
+		t.Fatalf("echo is auto-approved, the other calls need approval; got %d approvals", approver.n)
+	}
+	if res[2].OK || !strings.Contains(res[2].Error, "quota exceeded") {
+		t.Fatalf("expected tool error: %+v", res[2])
+	}
+	if res[3].OK || !strings.Contains(res[3].Error, "unknown MCP tool") {
+		t.Fatalf("denied tool should not be callable: %+v", res[3])
+	}
+	if res[4].OK || !strings.Contains(res[4].Error, "missing required argument(s) text") {
+		t.Fatalf("expected missing argument error: %+v", res[4])
+	}
+
+	ctx.Policy.AllowMCP = false
+	if res := ExecuteCalls(ctx, []ExecCall{{Tool: "mcp.fake.echo", ArgsRaw: `{"text":"x"}`}}, nil); res[0].Error != "disabled by policy" {
+		t.Fatalf("expected policy denial: %+v", res)
+	}
+	if delegatePolicy(DefaultToolPolicy()).AllowsTool("mcp.fake.echo") || schedulePolicy(DefaultToolPolicy()).AllowsTool("mcp.fake.echo") {
+		t.Fatalf("MCP tools must be disabled for sub-agents and scheduled runs")
+	}
+}
+
+func TestMCP_HTTPServer(t *testing.T) {
+	var mu sync.Mutex
+	var problems []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg mcpMessage
+		_ = json.NewDecoder(r.Body).Decode(&msg)
+		mu.Lock()
+		if r.Header.Get("Authorization") != "Bearer s3cret" {
+			problems = append(problems, msg.Method+": missing token")
+		}
+		if msg.Method != "initialize" && r.Header.Get("Mcp-Session-Id") != "sess-1" {
+			problems = append(problems, msg.Method+": missing session id")
+		}
+		mu.Unlock()
+		if len(msg.ID) == 0 {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		b, _ := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": msg.ID, "result": fakeMCPReply(msg)})
+		if msg.Method == "initialize" {
+			w.Header().Set("Mcp-Session-Id", "sess-1")
+		}
+		if msg.Method == "tools/call" {
+			// 以 SSE 返回，前面先发一条进度通知
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, "event: message\ndata: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/progress\"}\n\n")
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", b)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(b)
+	}))
+	defer srv.Close()
+	t.Setenv("TEST_MCP_TOKEN", "s3cret")
+
+	ws := t.TempDir()
+	writeMCPConfig(t, ws, "[servers.remote]\nurl = \""+srv.URL+"\"\nbearer_token_env = \"TEST_MCP_TOKEN\"\nallow = \"echo, add\"\n")
+
+	out, err := toolMCPCall(ExecContext{Workspace: ws}, "mcp.remote.echo", `{"text":"over http"}`)
+	if err != nil || out != "echo: over http" {
+		t.Fatalf("unexpected result %q %v", out, err)
+	}
+	if _, err := toolMCPCall(ExecContext{Workspace: ws}, "mcp.remote.fail", `{}`); err == nil || !strings.Contains(err.Error(), "available: mcp.remote.add, mcp.remote.echo") {
+		t.Fatalf("tools outside allow should be unknown: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(problems) > 0 {
+		t.Fatalf("protocol problems: %v", problems)
+	}
+}
+
+func TestLoadMCPConfig_RequiresCommandOrURL(t *testing.T) {
+	ws := t.TempDir()
+	writeMCPConfig(t, ws, "[servers.bad]\nallow = \"x\"\n")
+	if _, err := loadMCPConfig(ws); err == nil || !strings.Contains(err.Error(), "exactly one of command or url") {
+		t.Fatalf("expected config error, got %v", err)
+	}
+}
+
+func TestLoadMCPConfig_TomlSyntax(t *testing.T) {
+	ws := t.TempDir()
+	writeMCPConfig(t, ws, `
+[servers.files]
+command = "npx"
+args = [
+  "-y",
+  "server-filesystem", # 多行数组和行尾注释
+]
+allow = "read_*, list_*"
+timeout_seconds = 5
+`)
+	cfgs, err := loadMCPConfig(ws)
+	if err != nil || len(cfgs) != 1 {
+		t.Fatalf("unexpected config: %+v %v", cfgs, err)
+	}
+	c := cfgs[0]
+	if strings.Join(c.Args, " ") != "-y server-filesystem" || strings.Join(c.Allow, " ") != "read_* list_*" || c.Timeout != 5*time.Second {
+		t.Fatalf("unexpected server: %+v", c)
+	}
+
+	writeMCPConfig(t, ws, "[servers.files]\ncommand = \"npx\"\nargs = [\"-y\"\n")
+	if _, err := loadMCPConfig(ws); err == nil || !strings.Contains(err.Error(), "data/mcp.toml:3: error: syntax error") {
+		t.Fatalf("expected a syntax error with the line, got %v", err)
+	}
+}
@@ -0,0 +1,291 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// notify.send 向 data/notify.toml 中配置的具名目标主动发消息：
+//
+//	[targets.ops]
+//	type = "telegram"      # telegram / feishu / webhook / email
+//	chat_id = "123456"     # telegram：使用 TELEGRAM_BOT_TOKEN 发送
+//
+//	[targets.team]
+//	type = "feishu"
+//	url = "https://open.feishu.cn/open-apis/bot/v2/hook/..."
+//
+// webhook 类型把 {"target","title","text","sent_at"} POST 到 url；email 类型暂不连接 SMTP，
+// 而是把邮件写入 data/outbox/，由外部程序投递。每个目标每小时最多发送 NIBOT_NOTIFY_MAX_PER_HOUR 条。
+
+type notifyTarget struct {
+	Name   string
+	Type   string
+	ChatID string
+	URL    string
+	To     string
+}
+
+var notifyTargetName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func loadNotifyTargets(workspace string) (map[string]notifyTarget, error) {
+	var file struct {
+		Targets map[string]struct {
+			Type   string `toml:"type"`
+			ChatID string `toml:"chat_id"`
+			URL    string `toml:"url"`
+			To     string `toml:"to"`
+		} `toml:"targets"`
+	}
+	if err := decodeDataToml(filepath.Join(workspace, "data", "notify.toml"), &file); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return map[string]notifyTarget{}, nil
+		}
+		return nil, err
+	}
+
+	targets := map[string]notifyTarget{}
+	for name, raw := range file.Targets {
+		if !notifyTargetName.MatchString(name) {
+			continue
+		}
+		targets[name] = notifyTarget{
+			Name:   name,
+			Type:   strings.ToLower(strings.TrimSpace(raw.Type)),
+			ChatID: strings.TrimSpace(raw.ChatID),
+			URL:    strings.TrimSpace(raw.URL),
+			To:     strings.TrimSpace(raw.To),
+		}
+	}
+	return targets, nil
+}
+
+func notifyTargetNames(targets map[string]notifyTarget) string {
+	if len(targets) == 0 {
+		return "(none; add [targets.<name>] sections to data/notify.toml)"
+	}
+	names := make([]string, 0, len(targets))
+	for name, t := range targets {
+		names = append(names, name+" ("+t.Type+")")
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func notifyMaxPerHour() int {
+	return parseIntEnv("NIBOT_NOTIFY_MAX_PER_HOUR", 20, 1, 1000)
+}
+
+type notifyLimiter struct {
+	mu   sync.Mutex
+	sent map[string][]time.Time
+}
+
+var defaultNotifyLimiter = &notifyLimiter{sent: map[string][]time.Time{}}
+
+// allow 按一小时的滑动窗口计数；超出时返回还需等待的时间。
+func (l *notifyLimiter) allow(key string, now time.Time, max int) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cutoff := now.Add(-time.Hour)
+	kept := l.sent[key][:0]
+	for _, t := range l.sent[key] {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	if len(kept) >= max {
+		l.sent[key] = kept
+		return false, kept[0].Add(time.Hour).Sub(now)
+	}
+	l.sent[key] = append(kept, now)
+	return true, 0
+}
+
+type notifySendArgs struct {
+	Target string `json:"target"`
+	Text   string `json:"text"`
+	Title  string `json:"title"`
+}
+
+func toolNotifySend(ctx ExecContext, argsRaw string) (string, error) {
+	if !strings.HasPrefix(strings.TrimSpace(argsRaw), "{") {
+		return "", fmt.Errorf("notify.send requires JSON args: {\"target\":\"ops\",\"text\":\"...\"}")
+	}
+	var a notifySendArgs
+	if err := json.Unmarshal([]byte(argsRaw), &a); err != nil {
+		return "", fmt.Errorf("invalid JSON args for notify.send: %w", err)
+	}
+	a.Text = strings.TrimSpace(a.Text)
+	a.Title = strings.TrimSpace(a.Title)
+	if a.Text == "" {
+		return "", fmt.Errorf("text is required")
+	}
+	targets, err := loadNotifyTargets(ctx.Workspace)
+	if err != nil {
+		return "", fmt.Errorf("read data/notify.toml: %w", err)
+	}
+	t, ok := targets[strings.TrimSpace(a.Target)]
+	if !ok {
+		return "", fmt.Errorf("unknown notify target %q; configured targets: %s", a.Target, notifyTargetNames(targets))
+	}
+	if ok, wait := defaultNotifyLimiter.allow(ctx.Workspace+"\x00"+t.Name, time.Now(), notifyMaxPerHour()); !ok {
+		return "", fmt.Errorf("rate limit: target %s already received %d messages in the last hour; retry in %s", t.Name, notifyMaxPerHour(), wait.Round(time.Second))
+	}
+
+	text := truncateRunes(a.Text, 4000)
+	switch t.Type {
+	case "telegram":
+		err = sendTelegramNotify(t, joinNotifyTitle(a.Title, text))
+	case "feishu":
+		err = sendFeishuNotify(t, joinNotifyTitle(a.Title, text))
+	case "webhook":
+		err = sendWebhookNotify(t, a.Title, text)
+	case "email":
+		var p string
+		p, err = writeOutboxMail(ctx.Workspace, t, a.Title, text)
+		if err == nil {
+			return fmt.Sprintf("queued email for %s (%s) at %s", t.Name, t.To, p), nil
+		}
+	default:
+		err = fmt.Errorf("target %s has unsupported type %q (use telegram, feishu, webhook or email)", t.Name, t.Type)
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sent to %s (%s)", t.Name, t.Type), nil
+}
+
+func joinNotifyTitle(title, text string) string {
+	if title == "" {
+		return text
+	}
+	return title + "\n\n" + text
+}
+
+var notifyHTTP = &http.Client{Timeout: 15 * time.Second}
+
+// telegramAPIBase 可在测试中替换为本地服务。
+var telegramAPIBase = "https://api.telegram.org"
+
+func sendTelegramNotify(t notifyTarget, text string) error {
+	token := strings.TrimSpace(os.Getenv("TELEGRAM_BOT_TOKEN"))
+	if token == "" {
+		return fmt.Errorf("target %s: TELEGRAM_BOT_TOKEN is not set", t.Name)
+	}
+	if t.ChatID == "" {
+		return fmt.Errorf("target %s: chat_id is required for telegram", t.Name)
+	}
+	var out struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	body, err := postNotifyJSON(telegramAPIBase+"/bot"+token+"/sendMessage", map[string]any{"chat_id": t.ChatID, "text": text})
+	if err != nil {
+		return fmt.Errorf("target %s: %w", t.Name, err)
+	}
+	if json.Unmarshal(body, &out) != nil || !out.OK {
+		return fmt.Errorf("target %s: telegram rejected the message: %s", t.Name, firstNonEmpty(out.Description, truncateUTF8(string(body), 200)))
+	}
+	return nil
+}
+
+func sendFeishuNotify(t notifyTarget, text string) error {
+	if t.URL == "" {
+		return fmt.Errorf("target %s: url is required for feishu", t.Name)
+	}
+	body, err := postNotifyJSON(t.URL, map[string]any{"msg_type": "text", "content": map[string]any{"text": text}})
+	if err != nil {
+		return fmt.Errorf("target %s: %w", t.Name, err)
+	}
+	var out struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	}
+	if json.Unmarshal(body, &out) == nil && out.Code != 0 {
+		return fmt.Errorf("target %s: feishu rejected the message: code=%d %s", t.Name, out.Code, out.Msg)
+	}
+	return nil
+}
+
+func sendWebhookNotify(t notifyTarget, title, text string) error {
+	if t.URL == "" {
+		return fmt.Errorf("target %s: url is required for webhook", t.Name)
+	}
+	_, err := postNotifyJSON(t.URL, map[string]any{
+		"target":  t.Name,
+		"title":   title,
+		"text":    text,
+		"sent_at": time.Now().Format(time.RFC3339),
+	})
+	if err != nil {
+		return fmt.Errorf("target %s: %w", t.Name, err)
+	}
+	return nil
+}
+
+// postNotifyJSON 发送 JSON 并返回响应体；错误信息不包含 URL，避免泄露其中的 token。
+func postNotifyJSON(u string, payload any) ([]byte, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := notifyHTTP.Post(u, "application/json", bytes.NewReader(b))
+	if err != nil {
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			err = ue.Err
+		}
+		return nil, fmt.Errorf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg := strings.TrimSpace(string(body))
+		if msg == "" {
+			msg = resp.Status
+		}
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, truncateUTF8(msg, 200))
+	}
+	return body, nil
+}
+
+// writeOutboxMail 是 SMTP 的替身：把邮件写成 .eml 文件放进 data/outbox/。
+func writeOutboxMail(workspace string, t notifyTarget, title, text string) (string, error) {
+	if t.To == "" {
+		return "", fmt.Errorf("target %s: to is required for email", t.Name)
+	}
+	if title == "" {
+		title = "Ni bot notification"
+	}
+	dir := filepath.Join(workspace, "data", "outbox")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	name := fmt.Sprintf("%s-%s-%d.eml", now.Format("20060102-150405"), t.Name, now.UnixNano()%1e6)
+	var b strings.Builder
+	fmt.Fprintf(&b, "To: %s\r\n", t.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.ReplaceAll(title, "\n", " ")))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(text, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(b.String()), 0o644); err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(filepath.Join("data", "outbox", name)), nil
+}
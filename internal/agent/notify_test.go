@@ -0,0 +1,176 @@
+package agent
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func writeNotifyConfig(t *testing.T, ws, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Join(ws, "data"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(ws, "data", "notify.toml"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNotifySend_Targets(t *testing.T) {
+	var mu sync.Mutex
+	got := map[string]map[string]any{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		var m map[string]any
+		_ = json.Unmarshal(b, &m)
+		mu.Lock()
+		got[r.URL.Path] = m
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/botsecret-token/sendMessage":
+			_, _ = w.Write([]byte(`{"ok":true}`))
+		case "/feishu-bad":
+			_, _ = w.Write([]byte(`{"code":19001,"msg":"param invalid"}`))
+		default:
+			_, _ = w.Write([]byte(`{"code":0,"msg":"success"}`))
+		}
+	}))
+	defer srv.Close()
+	old := telegramAPIBase
+	telegramAPIBase = srv.URL
+	defer func() { telegramAPIBase = old }()
+	t.Setenv("TELEGRAM_BOT_TOKEN", "secret-token")
+
+	ws := t.TempDir()
+	writeNotifyConfig(t, ws, `
+# comment
+[targets.ops]
+type = "telegram"
+chat_id = "42"
+
+[targets.team]
+type = "feishu"
+url = "`+srv.URL+`/feishu"
+
+[targets.broken]
+type = "feishu"
+url = "`+srv.URL+`/feishu-bad"
+
+[targets.alerts]
+type = "webhook"
+url = "`+srv.URL+`/hook"
+
+[targets.me]
+type = "email"
+to = "me@example.com"
+`)
+	ctx := ExecContext{Workspace: ws}
+
+	if out, err := toolNotifySend(ctx, `{"target":"ops","text":"build done","title":"CI"}`); err != nil || out != "sent to ops (telegram)" {
+		t.Fatalf("telegram: %q %v", out, err)
+	}
+	if m := got["/botsecret-token/sendMessage"]; m["chat_id"] != "42" || m["text"] != "CI\n\nbuild done" {
+		t.Fatalf("unexpected telegram payload: %v", m)
+	}
+	if _, err := toolNotifySend(ctx, `{"target":"team","text":"hi"}`); err != nil {
+		t.Fatal(err)
+	}
+	if m := got["/feishu"]; m["msg_type"] != "text" {
+		t.Fatalf("unexpected feishu payload: %v", m)
+	}
+	if _, err := toolNotifySend(ctx, `{"target":"broken","text":"hi"}`); err == nil || !strings.Contains(err.Error(), "code=19001") {
+		t.Fatalf("expected feishu error, got %v", err)
+	}
+	if _, err := toolNotifySend(ctx, `{"target":"alerts","text":"disk full","title":"host1"}`); err != nil {
+		t.Fatal(err)
+	}
+	if m := got["/hook"]; m["target"] != "alerts" || m["title"] != "host1" || m["text"] != "disk full" {
+		t.Fatalf("unexpected webhook payload: %v", m)
+	}
+
+	out, err := toolNotifySend(ctx, `{"target":"me","text":"周报已生成","title":"周报"}`)
+	if err != nil || !strings.Contains(out, "data/outbox/") {
+		t.Fatalf("email: %q %v", out, err)
+	}
+	files, _ := filepath.Glob(filepath.Join(ws, "data", "outbox", "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("expected one outbox file, got %v", files)
+	}
+	b, _ := os.ReadFile(files[0])
+	if !strings.Contains(string(b), "To: me@example.com\r\n") || !strings.Contains(string(b), "Subject: =?utf-8?q?") {
+		t.Fatalf("unexpected mail: %q", b)
+	}
+
+	_, err = toolNotifySend(ctx, `{"target":"nobody","text":"x"}`)
+	if err == nil || !strings.Contains(err.Error(), "alerts (webhook), broken (feishu), me (email), ops (telegram), team (feishu)") {
+		t.Fatalf("unknown target should list configured targets: %v", err)
+	}
+
+	// 网络错误不能带出 URL 中的 token
+	telegramAPIBase = "http://127.0.0.1:1"
+	if _, err := toolNotifySend(ctx, `{"target":"ops","text":"x"}`); err == nil || strings.Contains(err.Error(), "secret-token") {
+		t.Fatalf("error should not leak the bot token: %v", err)
+	}
+}
+
+func TestNotifySend_RateLimit(t *testing.T) {
+	t.Setenv("NIBOT_NOTIFY_MAX_PER_HOUR", "2")
+	ws := t.TempDir()
+	writeNotifyConfig(t, ws, "[targets.me]\ntype = \"email\"\nto = \"me@example.com\"\n")
+	ctx := ExecContext{Workspace: ws}
+	for i := 0; i < 2; i++ {
+		if _, err := toolNotifySend(ctx, `{"target":"me","text":"x"}`); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := toolNotifySend(ctx, `{"target":"me","text":"x"}`); err == nil || !strings.Contains(err.Error(), "rate limit") {
+		t.Fatalf("expected rate limit error, got %v", err)
+	}
+}
+
+func TestNotifySend_PolicyApprovalAndAudit(t *testing.T) {
+	ws := t.TempDir()
+	writeNotifyConfig(t, ws, "[targets.me]\ntype = \"email\"\nto = \"me@example.com\"\n")
+	ctx := ExecContext{Workspace: ws, Session: "conv_test", Policy: DefaultToolPolicy()}
+	call := ExecCall{Tool: "notify.send", ArgsRaw: `{"target":"me","text":"hello"}`}
+
+	approver := &countingApprover{}
+	if res := ExecuteCalls(ctx, []ExecCall{call}, approver); !res[0].OK || approver.n != 1 {
+		t.Fatalf("expected approved send: %+v approvals=%d", res, approver.n)
+	}
+	ctx.Policy.AllowNotify = false
+	if res := ExecuteCalls(ctx, []ExecCall{call}, approver); res[0].OK || res[0].Error != "disabled by policy" {
+		t.Fatalf("expected policy denial: %+v", res)
+	}
+
+	db, err := sql.Open("sqlite", filepath.Join(ws, "data", "nibot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query(`select session_id, tool, ok, error from tool_audits order by id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var audits []string
+	for rows.Next() {
+		var session, tool, errStr string
+		var ok int
+		if err := rows.Scan(&session, &tool, &ok, &errStr); err != nil {
+			t.Fatal(err)
+		}
+		audits = append(audits, strings.Join([]string{session, tool, map[int]string{0: "fail", 1: "ok"}[ok], errStr}, "|"))
+	}
+	want := []string{"conv_test|notify.send|ok|", "conv_test|notify.send|fail|disabled by policy"}
+	if strings.Join(audits, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected audits:\n%s", strings.Join(audits, "\n"))
+	}
+}
@@ -0,0 +1,809 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// OpenAPI 工具：workspace/tools/<name>/openapi.yaml（或 .yml / .json）中的每个操作注册为工具 <name>.<operationId>，
+// 参数由 path / query / header 参数和 JSON 请求体（参数名 body）生成。认证等配置写在 data/openapi.toml：
+//
+//	[tools.billing]
+//	base_url = "https://billing.internal/api"   # 覆盖 spec 中的 servers[0].url
+//	auth = "bearer"                              # bearer / header / query / basic / none
+//	token_env = "BILLING_TOKEN"                  # 凭据只从环境变量读取
+//	header = "X-API-Key"                         # auth = header 时的请求头
+//	param = "api_key"                            # auth = query 时的查询参数
+//	username_env = "BILLING_USER"                # auth = basic
+//	password_env = "BILLING_PASSWORD"
+//	allow = ["get*", "list*"]                    # 只注册匹配的 operationId，deny 优先
+//	deny = ["delete*"]
+//	auto_approve = ["get*"]                      # 这些操作不需要审批
+//	timeout_seconds = 30
+//
+// 调用受 policy.toml 的 allow_http / require_approval_http / allowed_http_domains 控制。
+
+type openAPIConfig struct {
+	BaseURL     string
+	Auth        string
+	TokenEnv    string
+	Header      string
+	Param       string
+	UsernameEnv string
+	PasswordEnv string
+	Allow       []string
+	Deny        []string
+	AutoApprove []string
+	Timeout     time.Duration
+}
+
+type openAPIParam struct {
+	Name        string
+	In          string
+	Required    bool
+	Description string
+	Schema      map[string]any
+}
+
+type openAPIOperation struct {
+	Tool         string
+	ID           string
+	Method       string
+	Path         string
+	Summary      string
+	Params       []openAPIParam
+	HasBody      bool
+	BodyRequired bool
+	Schema       map[string]any
+}
+
+type openAPIToolset struct {
+	Name     string
+	SpecPath string
+	BaseURL  string
+	Config   openAPIConfig
+	Ops      []openAPIOperation
+	// Problems 是加载 spec 时发现的问题，供 skills doctor 显示；Err 非空时整个 spec 不可用。
+	Problems []SkillIssue
+	Err      error
+}
+
+var openAPIToolsetName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// openAPIReservedNames 是内置工具使用的前缀，OpenAPI 工具集不能与之同名。
+var openAPIReservedNames = map[string]bool{
+	"fs": true, "file": true, "memory": true, "runtime": true, "shell": true, "code": true, "job": true,
+	"skill": true, "skills": true, "artifact": true, "data": true, "schedule": true, "notify": true,
+	"git": true, "agent": true, "health": true, "mcp": true,
+}
+
+func openAPISpecPath(dir string) string {
+	for _, name := range []string{"openapi.yaml", "openapi.yml", "openapi.json"} {
+		p := filepath.Join(dir, name)
+		if fileExists(p) {
+			return p
+		}
+	}
+	return ""
+}
+
+func loadOpenAPIConfig(workspace string) (map[string]openAPIConfig, error) {
+	var file struct {
+		Tools map[string]struct {
+			BaseURL        string   `toml:"base_url"`
+			Auth           string   `toml:"auth"`
+			TokenEnv       string   `toml:"token_env"`
+			Header         string   `toml:"header"`
+			Param          string   `toml:"param"`
+			UsernameEnv    string   `toml:"username_env"`
+			PasswordEnv    string   `toml:"password_env"`
+			Allow          tomlList `toml:"allow"`
+			Deny           tomlList `toml:"deny"`
+			AutoApprove    tomlList `toml:"auto_approve"`
+			TimeoutSeconds int      `toml:"timeout_seconds"`
+		} `toml:"tools"`
+	}
+	if err := decodeDataToml(filepath.Join(workspace, "data", "openapi.toml"), &file); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return map[string]openAPIConfig{}, nil
+		}
+		return nil, err
+	}
+
+	configs := map[string]openAPIConfig{}
+	for name, raw := range file.Tools {
+		if !openAPIToolsetName.MatchString(name) {
+			continue
+		}
+		c := openAPIConfig{
+			BaseURL:     strings.TrimSpace(raw.BaseURL),
+			Auth:        strings.ToLower(strings.TrimSpace(raw.Auth)),
+			TokenEnv:    strings.TrimSpace(raw.TokenEnv),
+			Header:      strings.TrimSpace(raw.Header),
+			Param:       strings.TrimSpace(raw.Param),
+			UsernameEnv: strings.TrimSpace(raw.UsernameEnv),
+			PasswordEnv: strings.TrimSpace(raw.PasswordEnv),
+			Allow:       raw.Allow,
+			Deny:        raw.Deny,
+			AutoApprove: raw.AutoApprove,
+			Timeout:     30 * time.Second,
+		}
+		if n := raw.TimeoutSeconds; n > 0 && n <= 600 {
+			c.Timeout = time.Duration(n) * time.Second
+		}
+		configs[name] = c
+	}
+	return configs, nil
+}
+
+// loadOpenAPIToolsets 读取 workspace/tools/ 下所有带 OpenAPI spec 的目录，按名字排序。
+func loadOpenAPIToolsets(workspace string) []openAPIToolset {
+	entries, err := os.ReadDir(filepath.Join(workspace, "tools"))
+	if err != nil {
+		return nil
+	}
+	configs, cfgErr := loadOpenAPIConfig(workspace)
+	var sets []openAPIToolset
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		spec := openAPISpecPath(filepath.Join(workspace, "tools", e.Name()))
+		if spec == "" {
+			continue
+		}
+		set := openAPIToolset{Name: e.Name(), SpecPath: spec}
+		cfg, ok := configs[e.Name()]
+		if !ok {
+			cfg = openAPIConfig{Timeout: 30 * time.Second}
+		}
+		set.Config = cfg
+		switch {
+		case cfgErr != nil:
+			set.Err = fmt.Errorf("read data/openapi.toml: %w", cfgErr)
+		case !openAPIToolsetName.MatchString(e.Name()):
+			set.Err = fmt.Errorf("directory name must only contain letters, digits, _ and -")
+		case openAPIReservedNames[strings.ToLower(e.Name())]:
+			set.Err = fmt.Errorf("name %q is used by built-in tools; rename the directory", e.Name())
+		default:
+			set.Err = set.load()
+		}
+		sets = append(sets, set)
+	}
+	return sets
+}
+
+func (s *openAPIToolset) load() error {
+	b, err := os.ReadFile(s.SpecPath)
+	if err != nil {
+		return err
+	}
+	var spec map[string]any
+	if err := yaml.Unmarshal(b, &spec); err != nil {
+		return fmt.Errorf("parse %s: %v", filepath.Base(s.SpecPath), err)
+	}
+	if spec == nil {
+		return fmt.Errorf("%s is empty", filepath.Base(s.SpecPath))
+	}
+	problem := func(level, format string, args ...any) {
+		s.Problems = append(s.Problems, SkillIssue{Skill: "tools/" + s.Name, Level: level, Message: fmt.Sprintf(format, args...)})
+	}
+	if v, _ := spec["openapi"].(string); !strings.HasPrefix(v, "3.") {
+		if _, swagger := spec["swagger"]; swagger {
+			return fmt.Errorf("swagger 2.0 specs are not supported; convert to OpenAPI 3")
+		}
+		problem("warn", "missing or unsupported openapi version %q (expected 3.x)", v)
+	}
+
+	s.BaseURL = s.Config.BaseURL
+	if s.BaseURL == "" {
+		if servers, ok := spec["servers"].([]any); ok && len(servers) > 0 {
+			if m, ok := servers[0].(map[string]any); ok {
+				s.BaseURL, _ = m["url"].(string)
+			}
+		}
+	}
+	if s.BaseURL == "" {
+		return fmt.Errorf("no server URL: add servers to the spec or base_url to data/openapi.toml")
+	}
+	if u, err := url.Parse(s.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("server URL %q must be an absolute http(s) URL", s.BaseURL)
+	}
+
+	paths, _ := spec["paths"].(map[string]any)
+	if len(paths) == 0 {
+		return fmt.Errorf("spec has no paths")
+	}
+	seen := map[string]string{}
+	pathKeys := make([]string, 0, len(paths))
+	for p := range paths {
+		pathKeys = append(pathKeys, p)
+	}
+	sort.Strings(pathKeys)
+	for _, p := range pathKeys {
+		item, _ := resolveOpenAPIRef(spec, paths[p]).(map[string]any)
+		shared := openAPIParams(spec, item["parameters"], problem)
+		for _, method := range []string{"get", "post", "put", "patch", "delete", "head"} {
+			raw, ok := item[method].(map[string]any)
+			if !ok {
+				continue
+			}
+			op := openAPIOperation{Method: strings.ToUpper(method), Path: p}
+			op.ID, _ = raw["operationId"].(string)
+			if op.ID == "" {
+				op.ID = method + "_" + slugify(strings.NewReplacer("/", " ", "{", "", "}", "").Replace(p))
+				op.ID = strings.ReplaceAll(op.ID, "-", "_")
+				problem("warn", "%s %s has no operationId; registered as %s", op.Method, p, op.ID)
+			}
+			op.Tool = s.Name + "." + sanitizeOpenAPIName(op.ID)
+			if prev, dup := seen[op.Tool]; dup {
+				problem("error", "duplicate operationId %s (%s and %s %s); the second one is skipped", op.ID, prev, op.Method, p)
+				continue
+			}
+			seen[op.Tool] = op.Method + " " + p
+			if matchesAnyPattern(s.Config.Deny, op.ID) || (len(s.Config.Allow) > 0 && !matchesAnyPattern(s.Config.Allow, op.ID)) {
+				continue
+			}
+			op.Summary = strings.TrimSpace(firstNonEmpty(str(raw["summary"]), firstLine(str(raw["description"]))))
+
+			// 操作级参数覆盖同名的路径级参数
+			params := map[string]openAPIParam{}
+			var order []string
+			for _, prm := range append(shared, openAPIParams(spec, raw["parameters"], problem)...) {
+				key := prm.In + ":" + prm.Name
+				if _, ok := params[key]; !ok {
+					order = append(order, key)
+				}
+				params[key] = prm
+			}
+			for _, key := range order {
+				op.Params = append(op.Params, params[key])
+			}
+			for _, name := range pathTemplateNames(p) {
+				if _, ok := params["path:"+name]; !ok {
+					problem("error", "%s: path parameter {%s} is not declared; the operation is skipped", op.ID, name)
+					op.Method = ""
+				}
+			}
+			if op.Method == "" {
+				continue
+			}
+			if body, ok := resolveOpenAPIRef(spec, raw["requestBody"]).(map[string]any); ok {
+				content, _ := body["content"].(map[string]any)
+				media, ok := content["application/json"].(map[string]any)
+				if !ok {
+					problem("warn", "%s: only application/json request bodies are supported", op.ID)
+				} else {
+					op.HasBody = true
+					op.BodyRequired, _ = body["required"].(bool)
+					schema, _ := inlineOpenAPIRefs(spec, media["schema"], 0).(map[string]any)
+					if schema == nil {
+						schema = map[string]any{"type": "object"}
+					}
+					op.Params = append(op.Params, openAPIParam{Name: "body", In: "body", Required: op.BodyRequired, Description: "JSON request body", Schema: schema})
+				}
+			}
+			op.Schema = openAPIArgsSchema(op.Params)
+			s.Ops = append(s.Ops, op)
+		}
+	}
+	if len(s.Ops) == 0 {
+		problem("warn", "no operations registered (check allow / deny in data/openapi.toml)")
+	}
+	return nil
+}
+
+func str(v any) string {
+	s, _ := v.(string)
+	return s
+}
+
+func sanitizeOpenAPIName(id string) string {
+	b := []byte(id)
+	for i, c := range b {
+		if !isToolNameByte(c) || c == '.' {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+var pathTemplateParam = regexp.MustCompile(`\{([^{}]+)\}`)
+
+func pathTemplateNames(p string) []string {
+	var out []string
+	for _, m := range pathTemplateParam.FindAllStringSubmatch(p, -1) {
+		out = append(out, m[1])
+	}
+	return out
+}
+
+func openAPIParams(spec map[string]any, raw any, problem func(level, format string, args ...any)) []openAPIParam {
+	list, _ := raw.([]any)
+	var out []openAPIParam
+	for _, item := range list {
+		m, ok := resolveOpenAPIRef(spec, item).(map[string]any)
+		if !ok {
+			problem("error", "unresolvable parameter %v", item)
+			continue
+		}
+		prm := openAPIParam{Name: str(m["name"]), In: str(m["in"]), Description: strings.TrimSpace(str(m["description"]))}
+		prm.Required, _ = m["required"].(bool)
+		if prm.In == "path" {
+			prm.Required = true
+		}
+		if prm.Name == "" {
+			problem("error", "parameter without a name")
+			continue
+		}
+		if prm.In != "path" && prm.In != "query" && prm.In != "header" {
+			problem("warn", "parameter %s in %s is not supported and is ignored", prm.Name, prm.In)
+			continue
+		}
+		prm.Schema, _ = inlineOpenAPIRefs(spec, m["schema"], 0).(map[string]any)
+		out = append(out, prm)
+	}
+	return out
+}
+
+// resolveOpenAPIRef 解析本文件内的 $ref（#/components/...），其他值原样返回。
+func resolveOpenAPIRef(spec map[string]any, v any) any {
+	for i := 0; i < 8; i++ {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return v
+		}
+		ref, ok := m["$ref"].(string)
+		if !ok {
+			return v
+		}
+		if !strings.HasPrefix(ref, "#/") {
+			return nil
+		}
+		var cur any = spec
+		for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+			cm, ok := cur.(map[string]any)
+			if !ok {
+				return nil
+			}
+			cur = cm[part]
+		}
+		v = cur
+	}
+	return nil
+}
+
+// inlineOpenAPIRefs 展开 schema 中的 $ref，得到可以直接作为工具参数定义的 JSON Schema；递归引用在一定深度后截断。
+func inlineOpenAPIRefs(spec map[string]any, v any, depth int) any {
+	if depth > 8 {
+		return map[string]any{}
+	}
+	v = resolveOpenAPIRef(spec, v)
+	switch t := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(t))
+		for k, val := range t {
+			switch k {
+			case "example", "examples", "xml", "externalDocs", "nullable", "readOnly", "writeOnly", "deprecated", "discriminator":
+				continue
+			}
+			out[k] = inlineOpenAPIRefs(spec, val, depth+1)
+		}
+		return out
+	case []any:
+		out := make([]any, len(t))
+		for i, val := range t {
+			out[i] = inlineOpenAPIRefs(spec, val, depth+1)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+func openAPIArgsSchema(params []openAPIParam) map[string]any {
+	props := map[string]any{}
+	var required []any
+	for _, p := range params {
+		schema := map[string]any{}
+		for k, v := range p.Schema {
+			schema[k] = v
+		}
+		if len(schema) == 0 {
+			schema["type"] = "string"
+		}
+		if p.Description != "" {
+			schema["description"] = p.Description
+		}
+		props[p.Name] = schema
+		if p.Required {
+			required = append(required, p.Name)
+		}
+	}
+	out := map[string]any{"type": "object", "properties": props}
+	if len(required) > 0 {
+		out["required"] = required
+	}
+	return out
+}
+
+// ---- 缓存 ----
+
+var openAPICache = struct {
+	sync.Mutex
+	m map[string]openAPICacheEntry
+}{m: map[string]openAPICacheEntry{}}
+
+type openAPICacheEntry struct {
+	stamp string
+	sets  []openAPIToolset
+}
+
+// openAPIStamp 由 spec 与配置文件的修改时间组成，任何一个变化都重新加载。
+func openAPIStamp(workspace string) string {
+	var b strings.Builder
+	paths, _ := filepath.Glob(filepath.Join(workspace, "tools", "*", "openapi.*"))
+	paths = append(paths, filepath.Join(workspace, "data", "openapi.toml"))
+	for _, p := range paths {
+		if st, err := os.Stat(p); err == nil {
+			fmt.Fprintf(&b, "%s:%d:%d;", p, st.ModTime().UnixNano(), st.Size())
+		}
+	}
+	return b.String()
+}
+
+func openAPIToolsets(workspace string) []openAPIToolset {
+	stamp := openAPIStamp(workspace)
+	openAPICache.Lock()
+	defer openAPICache.Unlock()
+	if e, ok := openAPICache.m[workspace]; ok && e.stamp == stamp {
+		return e.sets
+	}
+	sets := loadOpenAPIToolsets(workspace)
+	openAPICache.m[workspace] = openAPICacheEntry{stamp: stamp, sets: sets}
+	return sets
+}
+
+func lookupOpenAPIOperation(workspace, tool string) (*openAPIToolset, *openAPIOperation) {
+	name, _, ok := strings.Cut(tool, ".")
+	if !ok || openAPIReservedNames[strings.ToLower(name)] {
+		return nil, nil
+	}
+	sets := openAPIToolsets(workspace)
+	for i := range sets {
+		if sets[i].Name != name || sets[i].Err != nil {
+			continue
+		}
+		for j := range sets[i].Ops {
+			if sets[i].Ops[j].Tool == tool {
+				return &sets[i], &sets[i].Ops[j]
+			}
+		}
+	}
+	return nil, nil
+}
+
+// isOpenAPITool 按名字判断 tool 是否属于 OpenAPI 工具集（<name>.<operationId>，且不是内置工具）。
+// ToolPolicy 不知道工作区，所以只看名字；未加载的工具在执行时报 unknown。
+func isOpenAPITool(tool string) bool {
+	name, _, ok := strings.Cut(tool, ".")
+	return ok && openAPIToolsetName.MatchString(name) && !openAPIReservedNames[strings.ToLower(name)] && lookupToolSpec(tool) == nil
+}
+
+func openAPIToolAnyWorkspace(tool string) (*openAPIToolset, *openAPIOperation) {
+	name, _, ok := strings.Cut(tool, ".")
+	if !ok || openAPIReservedNames[strings.ToLower(name)] {
+		return nil, nil
+	}
+	openAPICache.Lock()
+	defer openAPICache.Unlock()
+	for _, e := range openAPICache.m {
+		for i := range e.sets {
+			if e.sets[i].Name != name {
+				continue
+			}
+			for j := range e.sets[i].Ops {
+				if e.sets[i].Ops[j].Tool == tool {
+					return &e.sets[i], &e.sets[i].Ops[j]
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+func openAPIAutoApproved(tool string) bool {
+	set, op := openAPIToolAnyWorkspace(tool)
+	return op != nil && matchesAnyPattern(set.Config.AutoApprove, op.ID)
+}
+
+// ---- 调用 ----
+
+func toolOpenAPICall(ctx ExecContext, set *openAPIToolset, op *openAPIOperation, argsRaw string) (string, error) {
+	args := map[string]any{}
+	if trimmed := strings.TrimSpace(argsRaw); trimmed != "" {
+		if !strings.HasPrefix(trimmed, "{") {
+			return "", fmt.Errorf("%s requires JSON args: %s", op.Tool, schemaArgsSignature(op.Schema))
+		}
+		if err := json.Unmarshal([]byte(trimmed), &args); err != nil {
+			return "", fmt.Errorf("invalid JSON args for %s: %w", op.Tool, err)
+		}
+	}
+	known := map[string]bool{}
+	var missing []string
+	for _, p := range op.Params {
+		known[p.Name] = true
+		if _, ok := args[p.Name]; p.Required && !ok {
+			missing = append(missing, p.Name)
+		}
+	}
+	var unknown []string
+	for k := range args {
+		if !known[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	sort.Strings(unknown)
+	if len(missing) > 0 || len(unknown) > 0 {
+		var msgs []string
+		if len(missing) > 0 {
+			msgs = append(msgs, "missing required argument(s) "+strings.Join(missing, ", "))
+		}
+		if len(unknown) > 0 {
+			msgs = append(msgs, "unknown argument(s) "+strings.Join(unknown, ", "))
+		}
+		return "", fmt.Errorf("%s: %s; expected %s", op.Tool, strings.Join(msgs, "; "), schemaArgsSignature(op.Schema))
+	}
+
+	path := op.Path
+	query := url.Values{}
+	header := http.Header{}
+	var body io.Reader
+	for _, p := range op.Params {
+		v, ok := args[p.Name]
+		if !ok || v == nil {
+			continue
+		}
+		switch p.In {
+		case "path":
+			path = strings.ReplaceAll(path, "{"+p.Name+"}", url.PathEscape(openAPIParamString(v)))
+		case "query":
+			if list, ok := v.([]any); ok {
+				for _, item := range list {
+					query.Add(p.Name, openAPIParamString(item))
+				}
+			} else {
+				query.Set(p.Name, openAPIParamString(v))
+			}
+		case "header":
+			header.Set(p.Name, openAPIParamString(v))
+		case "body":
+			b, err := json.Marshal(v)
+			if err != nil {
+				return "", err
+			}
+			body = bytes.NewReader(b)
+			header.Set("Content-Type", "application/json")
+		}
+	}
+
+	u, err := url.Parse(strings.TrimRight(set.BaseURL, "/") + path)
+	if err != nil {
+		return "", err
+	}
+	if err := set.applyAuth(header, query); err != nil {
+		return "", err
+	}
+	if len(query) > 0 {
+		q := u.Query()
+		for k, vs := range query {
+			for _, v := range vs {
+				q.Add(k, v)
+			}
+		}
+		u.RawQuery = q.Encode()
+	}
+	if !ctx.Policy.AllowsHTTPHost(u.Hostname()) {
+		return "", fmt.Errorf("host %s is not in allowed_http_domains", u.Hostname())
+	}
+
+	reqCtx, cancel := context.WithTimeout(context.Background(), set.Config.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(reqCtx, op.Method, u.String(), body)
+	if err != nil {
+		return "", err
+	}
+	req.Header = header
+	req.Header.Set("Accept", "application/json, */*;q=0.5")
+	req.Header.Set("User-Agent", "nibot/"+firstNonEmpty(os.Getenv("NIBOT_VERSION"), "dev"))
+
+	client := &http.Client{CheckRedirect: func(r *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return fmt.Errorf("too many redirects")
+		}
+		if !ctx.Policy.AllowsHTTPHost(r.URL.Hostname()) {
+			return fmt.Errorf("redirect to %s is not in allowed_http_domains", r.URL.Hostname())
+		}
+		return nil
+	}}
+	resp, err := client.Do(req)
+	if err != nil {
+		if reqCtx.Err() != nil {
+			return "", fmt.Errorf("%s %s timed out after %s", op.Method, op.Path, set.Config.Timeout)
+		}
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			err = ue.Err
+		}
+		return "", fmt.Errorf("%s %s failed: %v", op.Method, op.Path, err)
+	}
+	defer resp.Body.Close()
+	limit := int64(execMaxOutputBytes())
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	truncated := int64(len(b)) > limit
+	if truncated {
+		b = b[:limit]
+	}
+	out := fmt.Sprintf("HTTP %s", resp.Status)
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		out += " (" + ct + ")"
+	}
+	out += "\n\n" + string(b)
+	if truncated {
+		out += fmt.Sprintf("\n[response truncated at %d bytes]", limit)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return out, fmt.Errorf("%s %s returned HTTP %d", op.Method, op.Path, resp.StatusCode)
+	}
+	return out, nil
+}
+
+func openAPIParamString(v any) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(t)
+	default:
+		b, _ := json.Marshal(t)
+		return string(b)
+	}
+}
+
+func (s *openAPIToolset) applyAuth(header http.Header, query url.Values) error {
+	c := s.Config
+	env := func(name string) (string, error) {
+		v := strings.TrimSpace(os.Getenv(name))
+		if name == "" || v == "" {
+			return "", fmt.Errorf("%s: auth = %q needs the environment variable %s (see data/openapi.toml)", s.Name, c.Auth, firstNonEmpty(name, "token_env"))
+		}
+		return v, nil
+	}
+	switch c.Auth {
+	case "", "none":
+		return nil
+	case "bearer":
+		token, err := env(c.TokenEnv)
+		if err != nil {
+			return err
+		}
+		header.Set("Authorization", "Bearer "+token)
+	case "header":
+		token, err := env(c.TokenEnv)
+		if err != nil {
+			return err
+		}
+		header.Set(firstNonEmpty(c.Header, "X-API-Key"), token)
+	case "query":
+		token, err := env(c.TokenEnv)
+		if err != nil {
+			return err
+		}
+		query.Set(firstNonEmpty(c.Param, "api_key"), token)
+	case "basic":
+		user, err := env(c.UsernameEnv)
+		if err != nil {
+			return err
+		}
+		pass, err := env(c.PasswordEnv)
+		if err != nil {
+			return err
+		}
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user+":"+pass)))
+	default:
+		return fmt.Errorf("%s: unsupported auth %q (use bearer, header, query, basic or none)", s.Name, c.Auth)
+	}
+	return nil
+}
+
+// ---- 提示词与诊断 ----
+
+func openAPIPromptSection(workspace string) string {
+	policy := LoadToolPolicy(workspace)
+	var sb strings.Builder
+	for _, set := range openAPIToolsets(workspace) {
+		if set.Err != nil || len(set.Ops) == 0 || !policy.AllowsTool(set.Ops[0].Tool) {
+			continue
+		}
+		if sb.Len() == 0 {
+			sb.WriteString("\n=== HTTP TOOLS (OpenAPI) ===\n")
+			sb.WriteString("These tools call internal HTTP APIs. Path, query and header parameters are top-level arguments; the JSON request body goes in \"body\":\n")
+		}
+		for _, op := range set.Ops {
+			sb.WriteString(fmt.Sprintf("- %s %s (%s %s)", op.Tool, schemaArgsSignature(op.Schema), op.Method, op.Path))
+			if op.Summary != "" {
+				sb.WriteString(": " + truncateRunes(op.Summary, 200))
+			}
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
+
+func openAPIOpenAITools(workspace string, p ToolPolicy) []openAITool {
+	var out []openAITool
+	for _, set := range openAPIToolsets(workspace) {
+		if set.Err != nil {
+			continue
+		}
+		for _, op := range set.Ops {
+			if !p.AllowsTool(op.Tool) {
+				continue
+			}
+			out = append(out, openAITool{
+				Type: "function",
+				Function: openAIFunctionDef{
+					Name:        op.Tool,
+					Description: strings.TrimSpace(op.Method + " " + op.Path + " " + op.Summary),
+					Parameters:  op.Schema,
+				},
+			})
+		}
+	}
+	return out
+}
+
+// diagnoseOpenAPITools 供 skills doctor 检查 workspace/tools/ 下的 OpenAPI spec。
+func diagnoseOpenAPITools(workspace string) []SkillIssue {
+	var issues []SkillIssue
+	for _, set := range loadOpenAPIToolsets(workspace) {
+		if set.Err != nil {
+			issues = append(issues, SkillIssue{Skill: "tools/" + set.Name, Level: "error", Message: set.Err.Error()})
+			continue
+		}
+		issues = append(issues, set.Problems...)
+		c := set.Config
+		for _, name := range []string{c.TokenEnv, c.UsernameEnv, c.PasswordEnv} {
+			if name != "" && strings.TrimSpace(os.Getenv(name)) == "" {
+				issues = append(issues, SkillIssue{Skill: "tools/" + set.Name, Level: "warn", Message: fmt.Sprintf("environment variable %s (auth) is not set", name)})
+			}
+		}
+		if c.Auth != "" && c.Auth != "none" && c.TokenEnv == "" && c.UsernameEnv == "" {
+			issues = append(issues, SkillIssue{Skill: "tools/" + set.Name, Level: "error", Message: fmt.Sprintf("auth = %q but no token_env / username_env in data/openapi.toml", c.Auth)})
+		}
+		if u, err := url.Parse(set.BaseURL); err == nil && !LoadToolPolicy(workspace).AllowsHTTPHost(u.Hostname()) {
+			issues = append(issues, SkillIssue{Skill: "tools/" + set.Name, Level: "warn", Message: fmt.Sprintf("server host %s is not in allowed_http_domains; calls will be refused", u.Hostname())})
+		}
+	}
+	return issues
+}
@@ -0,0 +1,224 @@
+package agent
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testOpenAPISpec = `openapi: 3.0.3
+info:
+  title: Billing
+  version: "1"
+servers:
+  - url: %SERVER%/api
+paths:
+  /invoices/{id}:
+    parameters:
+      - $ref: '#/components/parameters/InvoiceID'
+    get:
+      operationId: getInvoice
+      summary: Fetch one invoice
+      parameters:
+        - name: expand
+          in: query
+          schema: {type: array, items: {type: string}}
+    delete:
+      operationId: deleteInvoice
+  /invoices:
+    post:
+      operationId: createInvoice
+      summary: Create an invoice
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema:
+              $ref: '#/components/schemas/Invoice'
+components:
+  parameters:
+    InvoiceID:
+      name: id
+      in: path
+      required: true
+      schema: {type: string}
+  schemas:
+    Invoice:
+      type: object
+      required: [amount]
+      properties:
+        amount: {type: number}
+        memo: {type: string}
+`
+
+func writeOpenAPIWorkspace(t *testing.T, spec, config string) string {
+	t.Helper()
+	ws := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(ws, "tools", "billing"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(ws, "data"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(ws, "tools", "billing", "openapi.yaml"), []byte(spec), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(ws, "data", "openapi.toml"), []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return ws
+}
+
+func TestOpenAPITools_CallsOperations(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got = append(got, r.Method+" "+r.URL.RequestURI()+" "+r.Header.Get("Authorization")+" "+string(body))
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/missing") {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":"not found"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	defer srv.Close()
+	t.Setenv("TEST_BILLING_TOKEN", "tok")
+
+	ws := writeOpenAPIWorkspace(t, strings.ReplaceAll(testOpenAPISpec, "%SERVER%", srv.URL),
+		"[tools.billing]\nauth = \"bearer\"\ntoken_env = \"TEST_BILLING_TOKEN\"\ndeny = [\"delete*\"]\nauto_approve = [\"get*\"]\n")
+
+	prompt := openAPIPromptSection(ws)
+	if !strings.Contains(prompt, "- billing.getInvoice ") || !strings.Contains(prompt, "(GET /invoices/{id}): Fetch one invoice") || strings.Contains(prompt, "deleteInvoice") {
+		t.Fatalf("unexpected prompt section:\n%s", prompt)
+	}
+	var native []string
+	for _, tool := range openAPIOpenAITools(ws, DefaultToolPolicy()) {
+		native = append(native, tool.Function.Name)
+		if tool.Function.Name == "billing.createInvoice" {
+			b, _ := json.Marshal(tool.Function.Parameters)
+			if !strings.Contains(string(b), `"required":["body"]`) || !strings.Contains(string(b), `"amount":{"type":"number"}`) {
+				t.Fatalf("request body schema should be inlined: %s", b)
+			}
+		}
+	}
+	if strings.Join(native, ",") != "billing.createInvoice,billing.getInvoice" {
+		t.Fatalf("unexpected native tools: %v", native)
+	}
+
+	ctx := ExecContext{Workspace: ws, Policy: DefaultToolPolicy()}
+	approver := &countingApprover{}
+	res := ExecuteCalls(ctx, []ExecCall{
+		{Tool: "billing.getInvoice", ArgsRaw: `{"id":"a/1","expand":["lines","customer"]}`},
+		{Tool: "billing.createInvoice", ArgsRaw: `{"body":{"amount":12.5}}`},
+		{Tool: "billing.getInvoice", ArgsRaw: `{"id":"missing"}`},
+		{Tool: "billing.getInvoice", ArgsRaw: `{"expand":["x"],"page":2}`},
+		{Tool: "billing.deleteInvoice", ArgsRaw: `{"id":"1"}`},
+	}, approver)
+	if !res[0].OK || !strings.HasPrefix(res[0].Output, "HTTP 200 OK (application/json)\n\n{\"ok\":true}") || !res[1].OK {
+		t.Fatalf("unexpected results: %+v", res[:2])
+	}
+	want := []string{
+		"GET /api/invoices/a%2F1?expand=lines&expand=customer Bearer tok ",
+		`POST /api/invoices Bearer tok {"amount":12.5}`,
+		"GET /api/invoices/missing Bearer tok ",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected requests:\n%s", strings.Join(got, "\n"))
+	}
+	if res[2].OK || !strings.Contains(res[2].Error, "returned HTTP 404") || !strings.Contains(res[2].Output, "not found") {
+		t.Fatalf("expected HTTP error with body: %+v", res[2])
+	}
+	if res[3].OK || !strings.Contains(res[3].Error, "missing required argument(s) id; unknown argument(s) page") {
+		t.Fatalf("expected argument errors: %+v", res[3])
+	}
+	if res[4].OK || res[4].Error != "unknown tool" {
+		t.Fatalf("denied operation should not be registered: %+v", res[4])
+	}
+	// getInvoice 在 auto_approve 中；未注册的 deleteInvoice 仍按 HTTP 工具请求审批，执行时才报 unknown
+	if approver.n != 2 {
+		t.Fatalf("expected 2 approvals (createInvoice, deleteInvoice), got %d", approver.n)
+	}
+}
+
+func TestOpenAPITools_PolicyAndDomains(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	ws := writeOpenAPIWorkspace(t, strings.ReplaceAll(testOpenAPISpec, "%SERVER%", srv.URL), "")
+
+	p := DefaultToolPolicy()
+	p.AllowedHTTPDomains = []string{"*.example.com"}
+	// 没有审批方时需要审批的调用直接被拒绝，这里给一个同意所有调用的审批方，只检查域名限制
+	res := ExecuteCalls(ExecContext{Workspace: ws, Policy: p}, []ExecCall{{Tool: "billing.getInvoice", ArgsRaw: `{"id":"1"}`}}, &countingApprover{})
+	if res[0].OK || !strings.Contains(res[0].Error, "not in allowed_http_domains") {
+		t.Fatalf("expected domain denial: %+v", res[0])
+	}
+	if !p.AllowsHTTPHost("api.example.com") || p.AllowsHTTPHost("example.com") || p.AllowsHTTPHost("example.com.evil.io") {
+		t.Fatalf("unexpected domain matching")
+	}
+
+	p = DefaultToolPolicy()
+	p.AllowHTTP = false
+	if res := ExecuteCalls(ExecContext{Workspace: ws, Policy: p}, []ExecCall{{Tool: "billing.getInvoice", ArgsRaw: `{"id":"1"}`}}, nil); res[0].Error != "disabled by policy" {
+		t.Fatalf("expected policy denial: %+v", res[0])
+	}
+	if delegatePolicy(DefaultToolPolicy()).AllowsTool("billing.getInvoice") || schedulePolicy(DefaultToolPolicy()).AllowsTool("billing.getInvoice") {
+		t.Fatalf("HTTP tools must be disabled for sub-agents and scheduled runs")
+	}
+	if isOpenAPITool("memory.store") || isOpenAPITool("fs.read") || isOpenAPITool("mcp.x.y") || !isOpenAPITool("billing.getInvoice") {
+		t.Fatalf("unexpected isOpenAPITool results")
+	}
+}
+
+func TestDiagnoseSkills_OpenAPISpecs(t *testing.T) {
+	t.Setenv("TEST_MISSING_TOKEN", "")
+	spec := `openapi: 3.1.0
+paths:
+  /users/{id}:
+    get:
+      summary: no operation id and an undeclared path parameter
+  /users:
+    get:
+      operationId: listUsers
+      parameters:
+        - $ref: '#/components/parameters/Nope'
+    post:
+      operationId: listUsers
+`
+	ws := writeOpenAPIWorkspace(t, spec, "[tools.billing]\nbase_url = \"https://billing.example.com\"\nauth = \"bearer\"\ntoken_env = \"TEST_MISSING_TOKEN\"\n")
+	if err := os.MkdirAll(filepath.Join(ws, "tools", "git"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(ws, "tools", "git", "openapi.json"), []byte(`{"openapi":"3.0.0"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	issues, err := DiagnoseSkills(ws)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, is := range issues {
+		lines = append(lines, is.Level+" "+is.Skill+": "+is.Message)
+	}
+	all := strings.Join(lines, "\n")
+	for _, want := range []string{
+		"warn tools/billing: GET /users/{id} has no operationId; registered as get_users_id",
+		"error tools/billing: get_users_id: path parameter {id} is not declared",
+		"error tools/billing: unresolvable parameter",
+		"error tools/billing: duplicate operationId listUsers",
+		"warn tools/billing: environment variable TEST_MISSING_TOKEN (auth) is not set",
+		`error tools/git: name "git" is used by built-in tools`,
+	} {
+		if !strings.Contains(all, want) {
+			t.Fatalf("missing issue %q in:\n%s", want, all)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// readRange 是 fs.read / artifact.read 共用的分页参数：按字节（offset/limit）或按行（startLine/endLine）。
+// offset 为负数时从文件末尾倒数，便于查看日志结尾。
+type readRange struct {
+	Offset    *int64 `json:"offset"`
+	Limit     int    `json:"limit"`
+	StartLine int    `json:"startLine"`
+	EndLine   int    `json:"endLine"`
+}
+
+const (
+	maxReadBytes     = 256 * 1024
+	defaultPageLines = 200
+	maxPageLines     = 5000
+)
+
+func (r readRange) active() bool {
+	return r.Offset != nil || r.Limit > 0 || r.StartLine > 0 || r.EndLine > 0
+}
+
+func (r readRange) byLines() bool {
+	return r.StartLine > 0 || r.EndLine > 0
+}
+
+func jsonQuote(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+type readerAtSeeker interface {
+	io.ReaderAt
+	io.ReadSeeker
+}
+
+// readTextFile 读取工作区文件并转换为 UTF-8 文本：文档走文本抽取，普通文件做编码检测；
+// 指定了分页参数时只返回对应片段，并在末尾附上继续读取所需的参数（argsPrefix 为调用方的定位参数，例如 "path":"x"）。
+func readTextFile(abs string, display string, docOpt docExtractOptions, rng readRange, argsPrefix string) (string, error) {
+	f, err := os.Open(abs)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	head := make([]byte, 8)
+	n, _ := io.ReadFull(f, head)
+	if kind := documentKind(abs, head[:n]); kind != "" {
+		text, err := extractDocumentText(abs, kind, docOpt)
+		if err != nil {
+			return "", fmt.Errorf("could not extract text from %s: %w", display, err)
+		}
+		if rng.active() {
+			return pageReader(strings.NewReader(text), int64(len(text)), rng, argsPrefix)
+		}
+		if len(text) > maxReadBytes {
+			cut := truncateUTF8(text, maxReadBytes)
+			return cut + fmt.Sprintf("\n\n[TRUNCATED: extracted text is %d bytes; continue with {%s,\"offset\":%d}]", len(text), argsPrefix, len(cut)), nil
+		}
+		return text, nil
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if rng.active() {
+		return pageReader(f, st.Size(), rng, argsPrefix)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	b, err := io.ReadAll(io.LimitReader(f, maxReadBytes+1))
+	if err != nil {
+		return "", err
+	}
+	truncated := len(b) > maxReadBytes
+	if truncated {
+		b = b[:maxReadBytes]
+	}
+	text, enc := decodeText(b, truncated)
+	if enc != "" && enc != charsetUTF8 {
+		text = fmt.Sprintf("[encoding: %s, converted to UTF-8]\n", enc) + text
+	}
+	if truncated {
+		text += fmt.Sprintf("\n\n[TRUNCATED: file is %d bytes; continue with {%s,\"offset\":%d}]", st.Size(), argsPrefix, maxReadBytes)
+	}
+	return text, nil
+}
+
+func pageReader(r readerAtSeeker, size int64, rng readRange, argsPrefix string) (string, error) {
+	if rng.byLines() {
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		return pageLines(r, rng, argsPrefix)
+	}
+	return pageBytes(r, size, rng, argsPrefix)
+}
+
+func pageBytes(r io.ReaderAt, size int64, rng readRange, argsPrefix string) (string, error) {
+	var off int64
+	if rng.Offset != nil {
+		off = *rng.Offset
+	}
+	if off < 0 {
+		off += size
+		if off < 0 {
+			off = 0
+		}
+	}
+	if off > size {
+		return "", fmt.Errorf("offset %d is beyond end of file (%d bytes)", off, size)
+	}
+	limit := rng.Limit
+	if limit <= 0 {
+		limit = toolOutputInlineBytes()
+	}
+	limit = min(limit, maxReadBytes)
+
+	buf := make([]byte, limit)
+	n, err := r.ReadAt(buf, off)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	chunk := buf[:n]
+	// 起止位置对齐到 UTF-8 字符边界
+	for i := 0; i < 3 && len(chunk) > 0 && !utf8.RuneStart(chunk[0]); i++ {
+		chunk = chunk[1:]
+		off++
+	}
+	end := off + int64(len(chunk))
+	partial := end < size
+	if t := trimPartialUTF8(chunk, partial); utf8.Valid(t) {
+		chunk = t
+		end = off + int64(len(chunk))
+	}
+	text, enc := decodeText(chunk, partial)
+
+	footer := fmt.Sprintf("[bytes %d-%d of %d", off, end, size)
+	if enc != "" && enc != charsetUTF8 {
+		footer += ", converted from " + enc
+	}
+	if end < size {
+		footer += fmt.Sprintf("; next: {%s,\"offset\":%d}]", argsPrefix, end)
+	} else {
+		footer += "; end of file]"
+	}
+	return strings.TrimRight(text, "\n") + "\n\n" + footer, nil
+}
+
+func pageLines(r io.Reader, rng readRange, argsPrefix string) (string, error) {
+	start := max(rng.StartLine, 1)
+	end := rng.EndLine
+	if end < start {
+		end = start + defaultPageLines - 1
+	}
+	end = min(end, start+maxPageLines-1)
+
+	br := bufio.NewReaderSize(r, 64*1024)
+	var out []byte
+	line, last := 0, 0
+	cut := false
+	for {
+		l, err := br.ReadBytes('\n')
+		if len(l) > 0 {
+			line++
+			if line >= start && line <= end && !cut {
+				if len(out)+len(l) > maxReadBytes {
+					cut = true
+				} else {
+					out = append(out, l...)
+					last = line
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	if start > line {
+		return "", fmt.Errorf("startLine %d is beyond end of file (%d lines)", start, line)
+	}
+	if last == 0 {
+		return "", fmt.Errorf("line %d is longer than %d bytes; use offset/limit instead", start, maxReadBytes)
+	}
+	text, enc := decodeText(out, false)
+	footer := fmt.Sprintf("[lines %d-%d of %d", start, last, line)
+	if enc != "" && enc != charsetUTF8 {
+		footer += ", converted from " + enc
+	}
+	if last < line {
+		footer += fmt.Sprintf("; next: {%s,\"startLine\":%d}]", argsPrefix, last+1)
+	} else {
+		footer += "; end of file]"
+	}
+	return strings.TrimRight(text, "\n") + "\n\n" + footer, nil
+}
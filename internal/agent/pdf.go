@@ -0,0 +1,822 @@
+package agent
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// 简易 PDF 文本抽取：扫描文件中的 "N G obj" 对象（含对象流），沿页面树取出每页的内容流，
+// 解释 Tj/TJ 等文本操作符，并用字体的 ToUnicode CMap 还原字符。不处理加密与 OCR。
+
+type pdfName string
+type pdfString string
+type pdfOp string
+type pdfDict map[string]any
+type pdfArray []any
+
+type pdfRef struct {
+	Num int
+	Gen int
+}
+
+type pdfStream struct {
+	Dict pdfDict
+	Raw  []byte
+}
+
+type pdfLexer struct {
+	b   []byte
+	pos int
+}
+
+func isPDFSpace(c byte) bool {
+	return c == 0 || c == '\t' || c == '\n' || c == '\f' || c == '\r' || c == ' '
+}
+
+func isPDFDelim(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+func (l *pdfLexer) skipSpace() {
+	for l.pos < len(l.b) {
+		c := l.b[l.pos]
+		if isPDFSpace(c) {
+			l.pos++
+			continue
+		}
+		if c == '%' {
+			for l.pos < len(l.b) && l.b[l.pos] != '\n' && l.b[l.pos] != '\r' {
+				l.pos++
+			}
+			continue
+		}
+		return
+	}
+}
+
+// token 返回下一个基本记号：数字为 float64，其余为 pdfName / pdfString / pdfOp。
+func (l *pdfLexer) token() (any, bool) {
+	l.skipSpace()
+	if l.pos >= len(l.b) {
+		return nil, false
+	}
+	c := l.b[l.pos]
+	switch {
+	case c == '(':
+		return l.literalString(), true
+	case c == '<':
+		if l.pos+1 < len(l.b) && l.b[l.pos+1] == '<' {
+			l.pos += 2
+			return pdfOp("<<"), true
+		}
+		return l.hexString(), true
+	case c == '>':
+		if l.pos+1 < len(l.b) && l.b[l.pos+1] == '>' {
+			l.pos += 2
+			return pdfOp(">>"), true
+		}
+		l.pos++
+		return pdfOp(">"), true
+	case c == '[' || c == ']' || c == '{' || c == '}' || c == ')':
+		l.pos++
+		return pdfOp(string(c)), true
+	case c == '/':
+		l.pos++
+		start := l.pos
+		for l.pos < len(l.b) && !isPDFSpace(l.b[l.pos]) && !isPDFDelim(l.b[l.pos]) {
+			l.pos++
+		}
+		return pdfName(decodePDFName(string(l.b[start:l.pos]))), true
+	}
+	start := l.pos
+	for l.pos < len(l.b) && !isPDFSpace(l.b[l.pos]) && !isPDFDelim(l.b[l.pos]) {
+		l.pos++
+	}
+	if l.pos == start {
+		l.pos++
+		return pdfOp(string(c)), true
+	}
+	word := string(l.b[start:l.pos])
+	if strings.IndexByte("+-.0123456789", word[0]) >= 0 {
+		if f, err := strconv.ParseFloat(word, 64); err == nil {
+			return f, true
+		}
+	}
+	return pdfOp(word), true
+}
+
+func decodePDFName(s string) string {
+	if !strings.Contains(s, "#") {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '#' && i+2 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				sb.WriteByte(byte(v))
+				i += 2
+				continue
+			}
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+func (l *pdfLexer) literalString() pdfString {
+	l.pos++ // (
+	var out []byte
+	depth := 1
+	for l.pos < len(l.b) {
+		c := l.b[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return pdfString(out)
+			}
+		case '\\':
+			if l.pos >= len(l.b) {
+				return pdfString(out)
+			}
+			e := l.b[l.pos]
+			l.pos++
+			switch e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				if l.pos < len(l.b) && l.b[l.pos] == '\n' {
+					l.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for i := 0; i < 2 && l.pos < len(l.b) && l.b[l.pos] >= '0' && l.b[l.pos] <= '7'; i++ {
+						v = v*8 + int(l.b[l.pos]-'0')
+						l.pos++
+					}
+					c = byte(v)
+				} else {
+					c = e
+				}
+			}
+		}
+		out = append(out, c)
+	}
+	return pdfString(out)
+}
+
+func (l *pdfLexer) hexString() pdfString {
+	l.pos++ // <
+	var digits []byte
+	for l.pos < len(l.b) && l.b[l.pos] != '>' {
+		c := l.b[l.pos]
+		if (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F') {
+			digits = append(digits, c)
+		}
+		l.pos++
+	}
+	l.pos++ // >
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	out := make([]byte, len(digits)/2)
+	for i := range out {
+		v, _ := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+		out[i] = byte(v)
+	}
+	return pdfString(out)
+}
+
+// value 解析一个完整的值（字典、数组、间接引用等）；遇到操作符时原样返回 pdfOp。
+func (l *pdfLexer) value() (any, bool) {
+	tok, ok := l.token()
+	if !ok {
+		return nil, false
+	}
+	switch t := tok.(type) {
+	case pdfOp:
+		switch t {
+		case "<<":
+			d := pdfDict{}
+			for {
+				save := l.pos
+				k, ok := l.token()
+				if !ok || k == pdfOp(">>") {
+					return d, true
+				}
+				name, isName := k.(pdfName)
+				if !isName {
+					l.pos = save
+					if _, ok := l.value(); !ok {
+						return d, true
+					}
+					continue
+				}
+				v, ok := l.value()
+				if !ok {
+					return d, true
+				}
+				d[string(name)] = v
+			}
+		case "[":
+			var arr pdfArray
+			for {
+				save := l.pos
+				k, ok := l.token()
+				if !ok || k == pdfOp("]") {
+					return arr, true
+				}
+				l.pos = save
+				v, ok := l.value()
+				if !ok {
+					return arr, true
+				}
+				arr = append(arr, v)
+			}
+		case "true":
+			return true, true
+		case "false":
+			return false, true
+		case "null":
+			return nil, true
+		}
+		return t, true
+	case float64:
+		if t == float64(int(t)) && t >= 0 {
+			save := l.pos
+			if gen, ok := l.token(); ok {
+				if g, isNum := gen.(float64); isNum && g >= 0 {
+					if r, ok := l.token(); ok && r == pdfOp("R") {
+						return pdfRef{Num: int(t), Gen: int(g)}, true
+					}
+				}
+			}
+			l.pos = save
+		}
+		return t, true
+	}
+	return tok, true
+}
+
+type pdfDoc struct {
+	objs     map[int]any
+	trailers []pdfDict
+}
+
+var pdfObjHeader = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+var pdfTrailerRe = regexp.MustCompile(`trailer\s*<<`)
+
+func parsePDF(b []byte) *pdfDoc {
+	doc := &pdfDoc{objs: map[int]any{}}
+	end := 0
+	for _, m := range pdfObjHeader.FindAllSubmatchIndex(b, -1) {
+		if m[0] < end {
+			continue // 位于上一个对象（通常是流数据）内部
+		}
+		num, _ := strconv.Atoi(string(b[m[2]:m[3]]))
+		l := &pdfLexer{b: b, pos: m[1]}
+		v, ok := l.value()
+		if !ok {
+			continue
+		}
+		end = l.pos
+		if d, isDict := v.(pdfDict); isDict {
+			save := l.pos
+			if kw, ok := l.token(); ok && kw == pdfOp("stream") {
+				raw, next := pdfStreamData(b, l.pos, d)
+				doc.objs[num] = &pdfStream{Dict: d, Raw: raw}
+				end = next
+				if d["Type"] == pdfName("XRef") {
+					doc.trailers = append(doc.trailers, d)
+				}
+				continue
+			}
+			l.pos = save
+		}
+		doc.objs[num] = v
+	}
+	for _, m := range pdfTrailerRe.FindAllIndex(b, -1) {
+		l := &pdfLexer{b: b, pos: m[1] - 2}
+		if v, ok := l.value(); ok {
+			if d, isDict := v.(pdfDict); isDict {
+				doc.trailers = append(doc.trailers, d)
+			}
+		}
+	}
+	doc.expandObjectStreams()
+	return doc
+}
+
+func pdfStreamData(b []byte, pos int, d pdfDict) ([]byte, int) {
+	if pos < len(b) && b[pos] == '\r' {
+		pos++
+	}
+	if pos < len(b) && b[pos] == '\n' {
+		pos++
+	}
+	if n, ok := d["Length"].(float64); ok && n >= 0 {
+		stop := pos + int(n)
+		if stop <= len(b) {
+			rest := bytes.TrimLeft(b[stop:min(len(b), stop+32)], "\r\n \t")
+			if bytes.HasPrefix(rest, []byte("endstream")) {
+				return b[pos:stop], stop
+			}
+		}
+	}
+	// Length 为间接引用或与实际不符时，直接查找 endstream。
+	idx := bytes.Index(b[pos:], []byte("endstream"))
+	if idx < 0 {
+		return b[pos:], len(b)
+	}
+	raw := bytes.TrimSuffix(b[pos:pos+idx], []byte("\n"))
+	raw = bytes.TrimSuffix(raw, []byte("\r"))
+	return raw, pos + idx + len("endstream")
+}
+
+func (d *pdfDoc) expandObjectStreams() {
+	var nums []int
+	for n, o := range d.objs {
+		if s, ok := o.(*pdfStream); ok && s.Dict["Type"] == pdfName("ObjStm") {
+			nums = append(nums, n)
+		}
+	}
+	sort.Ints(nums)
+	for _, n := range nums {
+		s := d.objs[n].(*pdfStream)
+		data, err := d.streamData(s)
+		if err != nil {
+			continue
+		}
+		count, _ := d.resolve(s.Dict["N"]).(float64)
+		first, _ := d.resolve(s.Dict["First"]).(float64)
+		if int(first) > len(data) {
+			continue
+		}
+		hdr := &pdfLexer{b: data[:int(first)]}
+		for i := 0; i < int(count); i++ {
+			numTok, ok1 := hdr.token()
+			offTok, ok2 := hdr.token()
+			if !ok1 || !ok2 {
+				break
+			}
+			num, _ := numTok.(float64)
+			off, _ := offTok.(float64)
+			if _, exists := d.objs[int(num)]; exists {
+				continue
+			}
+			l := &pdfLexer{b: data, pos: int(first) + int(off)}
+			if l.pos > len(data) {
+				continue
+			}
+			if v, ok := l.value(); ok {
+				d.objs[int(num)] = v
+			}
+		}
+	}
+}
+
+func (d *pdfDoc) resolve(v any) any {
+	for i := 0; i < 32; i++ {
+		r, ok := v.(pdfRef)
+		if !ok {
+			return v
+		}
+		v = d.objs[r.Num]
+	}
+	return nil
+}
+
+func (d *pdfDoc) dict(v any) pdfDict {
+	switch x := d.resolve(v).(type) {
+	case pdfDict:
+		return x
+	case *pdfStream:
+		return x.Dict
+	}
+	return nil
+}
+
+func (d *pdfDoc) streamData(s *pdfStream) ([]byte, error) {
+	var filters []string
+	switch f := d.resolve(s.Dict["Filter"]).(type) {
+	case pdfName:
+		filters = []string{string(f)}
+	case pdfArray:
+		for _, x := range f {
+			if n, ok := d.resolve(x).(pdfName); ok {
+				filters = append(filters, string(n))
+			}
+		}
+	}
+	data := s.Raw
+	for _, f := range filters {
+		switch f {
+		case "FlateDecode", "Fl":
+			out, err := pdfInflate(data)
+			if err != nil {
+				return nil, err
+			}
+			data = out
+		default:
+			return nil, fmt.Errorf("unsupported PDF filter: %s", f)
+		}
+	}
+	return data, nil
+}
+
+func pdfInflate(data []byte) ([]byte, error) {
+	var r io.Reader
+	if zr, err := zlib.NewReader(bytes.NewReader(data)); err == nil {
+		defer zr.Close()
+		r = zr
+	} else {
+		r = flate.NewReader(bytes.NewReader(data))
+	}
+	out, err := io.ReadAll(io.LimitReader(r, docMaxEntryBytes))
+	// 不少 PDF 的压缩流结尾不完整，只要解出了内容就使用。
+	if err != nil && len(out) == 0 {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (d *pdfDoc) encrypted() bool {
+	for _, t := range d.trailers {
+		if _, ok := t["Encrypt"]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+type pdfPage struct {
+	Dict      pdfDict
+	Resources pdfDict
+}
+
+func (d *pdfDoc) pages() []pdfPage {
+	var catalog pdfDict
+	for i := len(d.trailers) - 1; i >= 0 && catalog == nil; i-- {
+		catalog = d.dict(d.trailers[i]["Root"])
+	}
+	if catalog == nil {
+		var nums []int
+		for n := range d.objs {
+			nums = append(nums, n)
+		}
+		sort.Ints(nums)
+		for _, n := range nums {
+			if dd := d.dict(d.objs[n]); dd != nil && dd["Type"] == pdfName("Catalog") {
+				catalog = dd
+			}
+		}
+	}
+
+	var pages []pdfPage
+	if catalog != nil {
+		visited := map[int]bool{}
+		var walk func(node any, res pdfDict)
+		walk = func(node any, res pdfDict) {
+			if r, ok := node.(pdfRef); ok {
+				if visited[r.Num] {
+					return
+				}
+				visited[r.Num] = true
+			}
+			nd := d.dict(node)
+			if nd == nil {
+				return
+			}
+			if r := d.dict(nd["Resources"]); r != nil {
+				res = r
+			}
+			if kids, ok := d.resolve(nd["Kids"]).(pdfArray); ok {
+				for _, k := range kids {
+					walk(k, res)
+				}
+				return
+			}
+			if nd["Type"] == pdfName("Page") || nd["Contents"] != nil {
+				pages = append(pages, pdfPage{Dict: nd, Resources: res})
+			}
+		}
+		walk(catalog["Pages"], nil)
+	}
+	if len(pages) == 0 {
+		var nums []int
+		for n := range d.objs {
+			nums = append(nums, n)
+		}
+		sort.Ints(nums)
+		for _, n := range nums {
+			if dd := d.dict(d.objs[n]); dd != nil && dd["Type"] == pdfName("Page") {
+				pages = append(pages, pdfPage{Dict: dd, Resources: d.dict(dd["Resources"])})
+			}
+		}
+	}
+	return pages
+}
+
+type pdfFont struct {
+	toUnicode map[string]string
+	codeLen   int
+}
+
+func (d *pdfDoc) loadFont(v any) *pdfFont {
+	fd := d.dict(v)
+	f := &pdfFont{codeLen: 1, toUnicode: map[string]string{}}
+	if fd == nil {
+		return f
+	}
+	if fd["Subtype"] == pdfName("Type0") {
+		f.codeLen = 2
+	}
+	if s, ok := d.resolve(fd["ToUnicode"]).(*pdfStream); ok {
+		if data, err := d.streamData(s); err == nil {
+			parseToUnicodeCMap(data, f)
+		}
+	}
+	return f
+}
+
+func utf16BytesToString(b []byte) string {
+	if len(b)%2 == 1 {
+		b = append(b, 0)
+	}
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+	}
+	return string(utf16.Decode(u))
+}
+
+func parseToUnicodeCMap(data []byte, f *pdfFont) {
+	l := &pdfLexer{b: data}
+	var operands []any
+	for {
+		v, ok := l.value()
+		if !ok {
+			return
+		}
+		op, isOp := v.(pdfOp)
+		if !isOp {
+			operands = append(operands, v)
+			continue
+		}
+		switch op {
+		case "endcodespacerange":
+			if len(operands) >= 1 {
+				if lo, ok := operands[0].(pdfString); ok && len(lo) > 0 {
+					f.codeLen = len(lo)
+				}
+			}
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				src, ok1 := operands[i].(pdfString)
+				dst, ok2 := operands[i+1].(pdfString)
+				if ok1 && ok2 {
+					f.toUnicode[string(src)] = utf16BytesToString([]byte(dst))
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				lo, ok1 := operands[i].(pdfString)
+				hi, ok2 := operands[i+1].(pdfString)
+				if !ok1 || !ok2 || len(lo) != len(hi) || len(lo) == 0 || len(lo) > 4 {
+					continue
+				}
+				from, to := pdfCodeValue([]byte(lo)), pdfCodeValue([]byte(hi))
+				if to < from || to-from > 0xFFFF {
+					continue
+				}
+				for c := from; c <= to; c++ {
+					code := string(pdfCodeBytes(c, len(lo)))
+					switch dst := operands[i+2].(type) {
+					case pdfString:
+						b := []byte(dst)
+						if len(b) == 0 {
+							continue
+						}
+						b = append([]byte{}, b...)
+						b[len(b)-1] += byte(c - from)
+						f.toUnicode[code] = utf16BytesToString(b)
+					case pdfArray:
+						if idx := int(c - from); idx < len(dst) {
+							if s, ok := dst[idx].(pdfString); ok {
+								f.toUnicode[code] = utf16BytesToString([]byte(s))
+							}
+						}
+					}
+				}
+			}
+		}
+		operands = operands[:0]
+	}
+}
+
+func pdfCodeValue(b []byte) uint32 {
+	var v uint32
+	for _, c := range b {
+		v = v<<8 | uint32(c)
+	}
+	return v
+}
+
+func pdfCodeBytes(v uint32, n int) []byte {
+	out := make([]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		out[i] = byte(v)
+		v >>= 8
+	}
+	return out
+}
+
+// WinAnsiEncoding 中与 Latin-1 不同的常见字符。
+var winAnsiExtras = map[byte]rune{
+	0x80: '€', 0x85: '…', 0x91: '‘', 0x92: '’', 0x93: '“', 0x94: '”', 0x95: '•', 0x96: '–', 0x97: '—', 0x99: '™',
+}
+
+func (f *pdfFont) decode(s pdfString) string {
+	var sb strings.Builder
+	b := []byte(s)
+	n := 1
+	if f != nil && f.codeLen > 0 {
+		n = f.codeLen
+	}
+	for i := 0; i+n <= len(b); i += n {
+		code := b[i : i+n]
+		if f != nil {
+			if u, ok := f.toUnicode[string(code)]; ok {
+				sb.WriteString(u)
+				continue
+			}
+		}
+		if n != 1 {
+			continue // 多字节编码且没有 ToUnicode，无法还原
+		}
+		c := code[0]
+		if r, ok := winAnsiExtras[c]; ok {
+			sb.WriteRune(r)
+		} else if c >= 0x20 && c != 0x7f && (c < 0x80 || c >= 0xa0) {
+			sb.WriteRune(rune(c))
+		}
+	}
+	return sb.String()
+}
+
+func (d *pdfDoc) pageText(p pdfPage) string {
+	var data []byte
+	switch c := d.resolve(p.Dict["Contents"]).(type) {
+	case *pdfStream:
+		data, _ = d.streamData(c)
+	case pdfArray:
+		for _, part := range c {
+			if s, ok := d.resolve(part).(*pdfStream); ok {
+				if b, err := d.streamData(s); err == nil {
+					data = append(data, b...)
+					data = append(data, '\n')
+				}
+			}
+		}
+	}
+	if len(data) == 0 {
+		return ""
+	}
+
+	fonts := map[string]*pdfFont{}
+	if fd := d.dict(p.Resources["Font"]); fd != nil {
+		for name, ref := range fd {
+			fonts[name] = d.loadFont(ref)
+		}
+	}
+
+	var sb strings.Builder
+	lastByte := func() byte {
+		s := sb.String()
+		if s == "" {
+			return '\n'
+		}
+		return s[len(s)-1]
+	}
+	newline := func() {
+		if lastByte() != '\n' {
+			sb.WriteByte('\n')
+		}
+	}
+	space := func() {
+		if c := lastByte(); c != ' ' && c != '\n' {
+			sb.WriteByte(' ')
+		}
+	}
+	num := func(v any) float64 {
+		f, _ := v.(float64)
+		return f
+	}
+
+	var font *pdfFont
+	var operands []any
+	lastY, haveY := 0.0, false
+	l := &pdfLexer{b: data}
+	for {
+		v, ok := l.value()
+		if !ok {
+			break
+		}
+		op, isOp := v.(pdfOp)
+		if !isOp {
+			operands = append(operands, v)
+			continue
+		}
+		switch op {
+		case "BT":
+			space()
+		case "Tf":
+			if len(operands) >= 2 {
+				if name, ok := operands[len(operands)-2].(pdfName); ok {
+					font = fonts[string(name)]
+				}
+			}
+		case "Tj":
+			if len(operands) >= 1 {
+				if s, ok := operands[len(operands)-1].(pdfString); ok {
+					sb.WriteString(font.decode(s))
+				}
+			}
+		case "'", "\"":
+			newline()
+			if len(operands) >= 1 {
+				if s, ok := operands[len(operands)-1].(pdfString); ok {
+					sb.WriteString(font.decode(s))
+				}
+			}
+		case "TJ":
+			if len(operands) >= 1 {
+				if arr, ok := operands[len(operands)-1].(pdfArray); ok {
+					for _, x := range arr {
+						switch t := x.(type) {
+						case pdfString:
+							sb.WriteString(font.decode(t))
+						case float64:
+							if t < -200 {
+								space()
+							}
+						}
+					}
+				}
+			}
+		case "Td", "TD":
+			if len(operands) >= 2 {
+				tx, ty := num(operands[len(operands)-2]), num(operands[len(operands)-1])
+				if ty != 0 {
+					newline()
+				} else if tx > 0 {
+					space()
+				}
+			}
+		case "T*":
+			newline()
+		case "Tm":
+			if len(operands) >= 6 {
+				y := num(operands[5])
+				if haveY && y != lastY {
+					newline()
+				} else {
+					space()
+				}
+				lastY, haveY = y, true
+			}
+		case "ID":
+			// 跳过内联图片的二进制数据
+			if idx := bytes.Index(l.b[l.pos:], []byte("EI")); idx >= 0 {
+				l.pos += idx + 2
+			} else {
+				l.pos = len(l.b)
+			}
+		}
+		operands = operands[:0]
+	}
+	return cleanExtractedText(sb.String())
+}
@@ -1,7 +1,6 @@
 package agent
 
 import (
-	"bufio"
 	"os"
 	"path/filepath"
 	"strings"
@@ -14,16 +13,43 @@ type ToolPolicy struct {
 	AllowSkillExec      bool
 	AllowSkillInstall   bool
 	AllowMemory         bool
+	AllowNotify         bool
+	AllowGit            bool
+	AllowDelegate       bool
+	AllowMCP            bool
+	AllowHTTP           bool
 	RequireFSWrite      bool
 	RequireRuntimeExec  bool
 	RequireSkillExec    bool
 	RequireSkillInstall bool
 	RequireMemory       bool
+	RequireNotify       bool
+	RequireGitCommit    bool
+	RequireMCP          bool
+	RequireHTTP         bool
 
 	AllowedRuntimePrefixes []string
 	AllowedWritePrefixes   []string
 	AllowedSkillNames      []string
 	AllowedSkillScripts    []string
+	// 额外传给子进程的环境变量名（支持 `PREFIX_*`）；密钥类变量不受此列表影响。
+	ExecEnvPassthrough []string
+	// 技能可以拿到的密钥变量，格式为 `技能名:变量名`（变量名支持 `PREFIX_*`，技能名可以是 `*`）。
+	// 技能 manifest 在 skills/ 下，模型可以改写，只在 manifest 的 secrets 中声明不会注入任何密钥。
+	SkillSecrets []string
+
+	// 仅对 NIBOT_EXEC_SANDBOX=native 生效：是否保留网络、除工作区外额外可写的路径。
+	SandboxAllowNetwork  bool
+	SandboxWritablePaths []string
+
+	// Rules 是 policy.toml 中按顺序匹配的 [[rules]]，优先于上面的开关（见 policy_rules.go）。
+	Rules []PolicyRule
+
+	// OpenAPI 工具允许访问的主机，支持 `*.example.com`；为空表示不限制。
+	AllowedHTTPDomains []string
+
+	// ReadOnly 只在代码中设置（agent.delegate 的子代理），禁用所有会修改工作区或对外发送的工具。
+	ReadOnly bool
 }
 
 func DefaultToolPolicy() ToolPolicy {
@@ -34,12 +60,22 @@ func DefaultToolPolicy() ToolPolicy {
 		AllowSkillExec:       true,
 		AllowSkillInstall:    true,
 		AllowMemory:          true,
+		AllowNotify:          true,
+		AllowGit:             true,
+		AllowDelegate:        true,
+		AllowMCP:             true,
+		AllowHTTP:            true,
 		RequireFSWrite:       true,
 		RequireRuntimeExec:   true,
 		RequireSkillExec:     true,
 		RequireSkillInstall:  true,
 		RequireMemory:        true,
+		RequireNotify:        true,
+		RequireGitCommit:     true,
+		RequireMCP:           true,
+		RequireHTTP:          true,
 		AllowedWritePrefixes: []string{"memory/", "skills/", "logs/", ".learnings/"},
+		SandboxAllowNetwork:  true,
 	}
 }
 
@@ -47,48 +83,15 @@ func LoadToolPolicy(workspace string) ToolPolicy {
 	p := DefaultToolPolicy()
 	filePolicy, ok := readPolicyToml(filepath.Join(workspace, "data", "policy.toml"))
 	if ok {
-		if filePolicy.AllowFSWrite != nil {
-			p.AllowFSWrite = *filePolicy.AllowFSWrite
-		}
-		if filePolicy.AllowRuntimeExec != nil {
-			p.AllowRuntimeExec = *filePolicy.AllowRuntimeExec
-		}
-		if filePolicy.AllowSkillExec != nil {
-			p.AllowSkillExec = *filePolicy.AllowSkillExec
-		}
-		if filePolicy.AllowSkillInstall != nil {
-			p.AllowSkillInstall = *filePolicy.AllowSkillInstall
-		}
-		if filePolicy.AllowMemory != nil {
-			p.AllowMemory = *filePolicy.AllowMemory
-		}
-		if filePolicy.RequireFSWrite != nil {
-			p.RequireFSWrite = *filePolicy.RequireFSWrite
-		}
-		if filePolicy.RequireRuntimeExec != nil {
-			p.RequireRuntimeExec = *filePolicy.RequireRuntimeExec
-		}
-		if filePolicy.RequireSkillExec != nil {
-			p.RequireSkillExec = *filePolicy.RequireSkillExec
-		}
-		if filePolicy.RequireSkillInstall != nil {
-			p.RequireSkillInstall = *filePolicy.RequireSkillInstall
-		}
-		if filePolicy.RequireMemory != nil {
-			p.RequireMemory = *filePolicy.RequireMemory
-		}
-		if len(filePolicy.AllowedRuntimePrefixes) > 0 {
-			p.AllowedRuntimePrefixes = filePolicy.AllowedRuntimePrefixes
-		}
-		if len(filePolicy.AllowedWritePrefixes) > 0 {
-			p.AllowedWritePrefixes = filePolicy.AllowedWritePrefixes
-		}
-		if len(filePolicy.AllowedSkillNames) > 0 {
-			p.AllowedSkillNames = filePolicy.AllowedSkillNames
-		}
-		if len(filePolicy.AllowedSkillScripts) > 0 {
-			p.AllowedSkillScripts = filePolicy.AllowedSkillScripts
+		for _, f := range policyFields {
+			if b, set := filePolicy.Bools[f.Key]; set && f.Bool != nil {
+				*f.Bool(&p) = b
+			}
+			if list := filePolicy.Lists[f.Key]; len(list) > 0 && f.List != nil {
+				*f.List(&p) = list
+			}
 		}
+		p.Rules = filePolicy.Rules
 	}
 
 	if v, ok := os.LookupEnv("NIBOT_POLICY_ALLOW_RUNTIME_EXEC"); ok && strings.TrimSpace(v) != "" {
@@ -106,120 +109,92 @@ func LoadToolPolicy(workspace string) ToolPolicy {
 	if v, ok := os.LookupEnv("NIBOT_POLICY_ALLOW_MEMORY"); ok && strings.TrimSpace(v) != "" {
 		p.AllowMemory = parseBool(v, p.AllowMemory)
 	}
-	return p
-}
-
-func (p ToolPolicy) AllowsTool(tool string) bool {
-	switch tool {
-	case "fs.write", "file_write":
-		return p.AllowFSWrite
-	case "runtime.exec", "shell_exec":
-		return p.AllowRuntimeExec
-	case "skill.exec", "skill_exec":
-		return p.AllowSkillExec
-	case "skills.install", "install_skill", "skill_store_install":
-		return p.AllowSkillInstall
-	case "memory.store", "memory.recall", "memory.forget", "memory.list", "memory.stats", "memory.import":
-		return p.AllowMemory
-	default:
-		return true
+	if v, ok := os.LookupEnv("NIBOT_POLICY_ALLOW_NOTIFY"); ok && strings.TrimSpace(v) != "" {
+		p.AllowNotify = parseBool(v, p.AllowNotify)
 	}
-}
-
-func (p ToolPolicy) RequiresApproval(tool string) bool {
-	switch tool {
-	case "fs.write", "file_write":
-		return p.RequireFSWrite
-	case "runtime.exec", "shell_exec":
-		return p.RequireRuntimeExec
-	case "skill.exec", "skill_exec":
-		return p.RequireSkillExec
-	case "skills.install", "install_skill", "skill_store_install":
-		return p.RequireSkillInstall
-	case "memory.store", "memory.recall", "memory.forget", "memory.list", "memory.stats", "memory.import":
-		return p.RequireMemory
-	default:
-		return false
+	if v, ok := os.LookupEnv("NIBOT_POLICY_ALLOW_GIT"); ok && strings.TrimSpace(v) != "" {
+		p.AllowGit = parseBool(v, p.AllowGit)
+	}
+	if v, ok := os.LookupEnv("NIBOT_POLICY_ALLOW_DELEGATE"); ok && strings.TrimSpace(v) != "" {
+		p.AllowDelegate = parseBool(v, p.AllowDelegate)
+	}
+	if v, ok := os.LookupEnv("NIBOT_POLICY_ALLOW_MCP"); ok && strings.TrimSpace(v) != "" {
+		p.AllowMCP = parseBool(v, p.AllowMCP)
 	}
+	if v, ok := os.LookupEnv("NIBOT_POLICY_ALLOW_HTTP"); ok && strings.TrimSpace(v) != "" {
+		p.AllowHTTP = parseBool(v, p.AllowHTTP)
+	}
+	if v, ok := os.LookupEnv("NIBOT_POLICY_SANDBOX_ALLOW_NETWORK"); ok && strings.TrimSpace(v) != "" {
+		p.SandboxAllowNetwork = parseBool(v, p.SandboxAllowNetwork)
+	}
+	return p
 }
 
-func (p ToolPolicy) AllowsRuntimeCommand(command string) bool {
-	if len(p.AllowedRuntimePrefixes) == 0 {
-		return true
-	}
-	tokens := splitCommandLine(command)
-	if len(tokens) == 0 {
+// AllowsTool 判断工具是否可能被允许，用于决定提示词和原生工具列表中是否出现该工具；
+// 带参数 / 渠道条件的规则要到调用时才能确定，这里按可能允许处理。单次调用的决定见 Decide。
+func (p ToolPolicy) AllowsTool(tool string) bool {
+	if p.ReadOnly && readOnlyDenied(tool) {
 		return false
 	}
-	first := strings.ToLower(strings.TrimSpace(tokens[0]))
-
-	// 白名单命令检查 - 优先检查白名单
-	for _, pref := range p.AllowedRuntimePrefixes {
-		pref = strings.ToLower(strings.TrimSpace(pref))
-		if pref != "" && first == pref {
-			return true
+	maybe := false
+	for _, r := range p.Rules {
+		if r.validate() != nil || !r.matchesTool(tool) {
+			continue
 		}
+		if r.conditional() {
+			maybe = maybe || r.Action != "deny"
+			continue
+		}
+		return maybe || r.Action != "deny"
 	}
-
-	// 黑名单命令检查 - 拒绝危险命令
-	if isDangerousCommand(command) {
-		return false
+	if sw := findPolicySwitch(allowSwitches, tool); sw != nil {
+		// MCP / OpenAPI 工具另外受 data/mcp.toml、data/openapi.toml 中的 allow / deny 约束
+		return maybe || sw.Get(p)
 	}
+	return true
+}
 
-	return false
+func readOnlyDenied(tool string) bool {
+	return mutatingTools[tool] || strings.HasPrefix(tool, "mcp.") || isOpenAPITool(tool)
 }
 
-// 危险命令黑名单检查
-func isDangerousCommand(command string) bool {
-	command = strings.ToLower(strings.TrimSpace(command))
+// mutatingTools 是 ReadOnly 策略下禁用的工具：写文件、执行命令、修改记忆 / 定时任务、发消息、提交以及再次委派。
+var mutatingTools = map[string]bool{
+	"fs.write": true, "file_write": true,
+	"runtime.exec": true, "shell_exec": true, "shell.session": true, "code.run": true,
+	"job.start": true, "job.kill": true,
+	"skill.exec": true, "skill_exec": true,
+	"skills.install": true, "install_skill": true, "skill_store_install": true,
+	"memory.store": true, "memory.forget": true, "memory.import": true,
+	"schedule.create": true, "schedule.cancel": true,
+	"notify.send": true, "git.commit": true, "agent.delegate": true,
+}
 
-	// 拒绝危险系统命令
-	dangerousPatterns := []string{
-		"rm -rf /",
-		"rm -rf *",
-		"rm -rf .",
-		"rm -rf",
-		"format ",
-		"del /f /s /q",
-		"rd /s /q",
-		"chmod 777",
-		"chown ",
-		"dd if=/dev/random",
-		":(){ :|:& };:", // fork炸弹
-		"mkfs",
-		"fdisk",
-		"shutdown",
-		"reboot",
-		"halt",
-		"poweroff",
-	}
+// RequiresApproval 只看 require_approval_* 开关（含 auto_approve），不考虑 [[rules]]。
+func (p ToolPolicy) RequiresApproval(tool string) bool {
+	sw := findPolicySwitch(approvalSwitches, tool)
+	return sw != nil && sw.Get(p) && (sw.Except == nil || !sw.Except(tool))
+}
 
-	for _, pattern := range dangerousPatterns {
-		if strings.Contains(command, pattern) {
-			return true
-		}
+// AllowsHTTPHost 检查 OpenAPI 工具的目标主机：allowed_http_domains 为空时不限制，
+// `*.example.com` 匹配 example.com 的所有子域名（不含 example.com 本身）。
+func (p ToolPolicy) AllowsHTTPHost(host string) bool {
+	if len(p.AllowedHTTPDomains) == 0 {
+		return true
 	}
-
-	// 拒绝包含敏感路径的命令
-	sensitivePaths := []string{
-		"/etc/",
-		"/bin/",
-		"/sbin/",
-		"/usr/bin/",
-		"/usr/sbin/",
-		"/var/",
-		"/root/",
-		"c:\\windows\\",
-		"c:\\program files\\",
-		"c:\\programdata\\",
+	host = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(host), "."))
+	if host == "" {
+		return false
 	}
-
-	for _, path := range sensitivePaths {
-		if strings.Contains(command, path) {
+	for _, d := range p.AllowedHTTPDomains {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if d == "*" || d == host {
+			return true
+		}
+		if suffix, ok := strings.CutPrefix(d, "*."); ok && strings.HasSuffix(host, "."+suffix) {
 			return true
 		}
 	}
-
 	return false
 }
 
@@ -281,98 +256,70 @@ func (p ToolPolicy) AllowsSkillExec(skill, script string) bool {
 	return false
 }
 
-type policyFile struct {
-	AllowFSWrite           *bool
-	AllowRuntimeExec       *bool
-	AllowSkillExec         *bool
-	AllowSkillInstall      *bool
-	AllowMemory            *bool
-	RequireFSWrite         *bool
-	RequireRuntimeExec     *bool
-	RequireSkillExec       *bool
-	RequireSkillInstall    *bool
-	RequireMemory          *bool
-	AllowedRuntimePrefixes []string
-	AllowedWritePrefixes   []string
-	AllowedSkillNames      []string
-	AllowedSkillScripts    []string
+// policyField 描述 policy.toml 的一个顶层键；读取、检查和保存都按这张表进行。
+type policyField struct {
+	Key string
+	// Section 是新建文件时写在该键之前的分组注释
+	Section string
+	Bool    func(*ToolPolicy) *bool
+	List    func(*ToolPolicy) *[]string
 }
 
-func readPolicyToml(path string) (policyFile, bool) {
-	f, err := os.Open(path)
-	if err != nil {
-		return policyFile{}, false
-	}
-	defer f.Close()
-
-	var pf policyFile
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		if strings.HasPrefix(line, "[") {
-			continue
-		}
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) != 2 {
-			continue
-		}
-		key := strings.TrimSpace(parts[0])
-		val := strings.TrimSpace(parts[1])
-		val = strings.Trim(val, "\"")
-		val = strings.Trim(val, "'")
+var policyFields = []policyField{
+	{Key: "allow_fs_write", Section: "Global Switches", Bool: func(p *ToolPolicy) *bool { return &p.AllowFSWrite }},
+	{Key: "allow_runtime_exec", Bool: func(p *ToolPolicy) *bool { return &p.AllowRuntimeExec }},
+	{Key: "allow_skill_exec", Bool: func(p *ToolPolicy) *bool { return &p.AllowSkillExec }},
+	{Key: "allow_skill_install", Bool: func(p *ToolPolicy) *bool { return &p.AllowSkillInstall }},
+	{Key: "allow_memory", Bool: func(p *ToolPolicy) *bool { return &p.AllowMemory }},
+	{Key: "allow_notify", Bool: func(p *ToolPolicy) *bool { return &p.AllowNotify }},
+	{Key: "allow_git", Bool: func(p *ToolPolicy) *bool { return &p.AllowGit }},
+	{Key: "allow_delegate", Bool: func(p *ToolPolicy) *bool { return &p.AllowDelegate }},
+	{Key: "allow_mcp", Bool: func(p *ToolPolicy) *bool { return &p.AllowMCP }},
+	{Key: "allow_http", Bool: func(p *ToolPolicy) *bool { return &p.AllowHTTP }},
+	{Key: "require_approval_fs_write", Section: "Approval Requirements", Bool: func(p *ToolPolicy) *bool { return &p.RequireFSWrite }},
+	{Key: "require_approval_runtime_exec", Bool: func(p *ToolPolicy) *bool { return &p.RequireRuntimeExec }},
+	{Key: "require_approval_skill_exec", Bool: func(p *ToolPolicy) *bool { return &p.RequireSkillExec }},
+	{Key: "require_approval_skill_install", Bool: func(p *ToolPolicy) *bool { return &p.RequireSkillInstall }},
+	{Key: "require_approval_memory", Bool: func(p *ToolPolicy) *bool { return &p.RequireMemory }},
+	{Key: "require_approval_notify", Bool: func(p *ToolPolicy) *bool { return &p.RequireNotify }},
+	{Key: "require_approval_git_commit", Bool: func(p *ToolPolicy) *bool { return &p.RequireGitCommit }},
+	{Key: "require_approval_mcp", Bool: func(p *ToolPolicy) *bool { return &p.RequireMCP }},
+	{Key: "require_approval_http", Bool: func(p *ToolPolicy) *bool { return &p.RequireHTTP }},
+	{Key: "allowed_runtime_prefixes", Section: "Allow Lists", List: func(p *ToolPolicy) *[]string { return &p.AllowedRuntimePrefixes }},
+	{Key: "allowed_write_prefixes", List: func(p *ToolPolicy) *[]string { return &p.AllowedWritePrefixes }},
+	{Key: "allowed_skill_names", List: func(p *ToolPolicy) *[]string { return &p.AllowedSkillNames }},
+	{Key: "allowed_skill_scripts", List: func(p *ToolPolicy) *[]string { return &p.AllowedSkillScripts }},
+	{Key: "exec_env_passthrough", List: func(p *ToolPolicy) *[]string { return &p.ExecEnvPassthrough }},
+	{Key: "skill_secrets", List: func(p *ToolPolicy) *[]string { return &p.SkillSecrets }},
+	{Key: "allowed_http_domains", List: func(p *ToolPolicy) *[]string { return &p.AllowedHTTPDomains }},
+	{Key: "sandbox_allow_network", Section: "Native Sandbox (NIBOT_EXEC_SANDBOX=native)", Bool: func(p *ToolPolicy) *bool { return &p.SandboxAllowNetwork }},
+	{Key: "sandbox_writable_paths", List: func(p *ToolPolicy) *[]string { return &p.SandboxWritablePaths }},
+}
 
-		switch key {
-		case "allow_fs_write":
-			b := parseBool(val, true)
-			pf.AllowFSWrite = &b
-		case "allow_runtime_exec":
-			b := parseBool(val, true)
-			pf.AllowRuntimeExec = &b
-		case "allow_skill_exec":
-			b := parseBool(val, true)
-			pf.AllowSkillExec = &b
-		case "allow_skill_install":
-			b := parseBool(val, true)
-			pf.AllowSkillInstall = &b
-		case "allow_memory":
-			b := parseBool(val, true)
-			pf.AllowMemory = &b
-		case "require_approval_fs_write":
-			b := parseBool(val, true)
-			pf.RequireFSWrite = &b
-		case "require_approval_runtime_exec":
-			b := parseBool(val, true)
-			pf.RequireRuntimeExec = &b
-		case "require_approval_skill_exec":
-			b := parseBool(val, true)
-			pf.RequireSkillExec = &b
-		case "require_approval_skill_install":
-			b := parseBool(val, true)
-			pf.RequireSkillInstall = &b
-		case "require_approval_memory":
-			b := parseBool(val, true)
-			pf.RequireMemory = &b
-		case "allowed_runtime_prefixes":
-			pf.AllowedRuntimePrefixes = splitCSV(val)
-		case "allowed_write_prefixes":
-			pf.AllowedWritePrefixes = splitCSV(val)
-		case "allowed_skill_names":
-			pf.AllowedSkillNames = splitCSV(val)
-		case "allowed_skill_scripts":
-			pf.AllowedSkillScripts = splitCSV(val)
+func findPolicyField(key string) *policyField {
+	for i := range policyFields {
+		if policyFields[i].Key == key {
+			return &policyFields[i]
 		}
 	}
+	return nil
+}
 
-	if pf.AllowFSWrite == nil && pf.AllowRuntimeExec == nil && pf.AllowSkillExec == nil && pf.AllowSkillInstall == nil && pf.AllowMemory == nil &&
-		pf.RequireFSWrite == nil && pf.RequireRuntimeExec == nil && pf.RequireSkillExec == nil && pf.RequireSkillInstall == nil && pf.RequireMemory == nil &&
-		len(pf.AllowedRuntimePrefixes) == 0 && len(pf.AllowedWritePrefixes) == 0 &&
-		len(pf.AllowedSkillNames) == 0 && len(pf.AllowedSkillScripts) == 0 {
-		return policyFile{}, false
-	}
-	return pf, true
+// policyFile 是 policy.toml 中实际写出的键（未写出的键保持默认值）。
+type policyFile struct {
+	Bools map[string]bool
+	Lists map[string][]string
+	Rules []PolicyRule
+}
+
+func (pf policyFile) empty() bool {
+	return len(pf.Bools) == 0 && len(pf.Lists) == 0 && len(pf.Rules) == 0
+}
+
+// readPolicyToml 读取 policy.toml；有语法错误时沿用上一次成功读取的策略（见 keepLastGood）。
+func readPolicyToml(path string) (policyFile, bool) {
+	pf, issues, ok := parsePolicyFile(path)
+	return keepLastGood(path, pf, issues, ok)
 }
 
 func parseBool(v string, def bool) bool {
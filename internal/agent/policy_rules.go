@@ -0,0 +1,462 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 有序策略规则：policy.toml 中的 [[rules]] 按顺序匹配，第一条命中的规则决定 allow / deny / ask；
+// 都不命中时再按 allow_* / require_approval_* 开关（见 allowSwitches / approvalSwitches）决定。
+//
+//	[[rules]]
+//	action = "allow"                 # allow / deny / ask
+//	tool = "runtime.exec"            # 工具名（也匹配别名）；可以写成列表
+//	command = "git status*"          # 参数条件：path / command / skill / script
+//	channel = "cli"                  # cli / web / telegram / feishu / schedule / mcp
+//	user = ["12345"]                 # Telegram / 飞书用户 ID
+//	reason = "只读的 git 命令不需要审批"
+//
+// 模式默认是通配符（* 匹配任意字符，包括 /），以 re: 开头时是正则表达式。
+// 规则只决定是否执行、是否需要审批；写入前缀、命令白名单和沙箱等限制仍然生效。
+
+type PolicyRule struct {
+	Action   string
+	Tools    []string
+	Paths    []string
+	Commands []string
+	Skills   []string
+	Scripts  []string
+	Channels []string
+	Users    []string
+	Reason   string
+	// Unknown 保存无法识别的行（原样写回）；非空时规则无效，按 deny 处理。
+	Unknown []string
+}
+
+// PolicyRequest 是一次待决策的工具调用。
+type PolicyRequest struct {
+	Tool    string
+	ArgsRaw string
+	Channel string
+	User    string
+}
+
+// PolicyDecision 是策略对一次调用的决定；Reason 说明是哪条规则或哪个开关做出的决定。
+type PolicyDecision struct {
+	Action string
+	Reason string
+}
+
+func policyRequestFor(ctx ExecContext, call ExecCall) PolicyRequest {
+	return PolicyRequest{Tool: call.Tool, ArgsRaw: call.ArgsRaw, Channel: originChannel(ctx.Origin), User: ctx.User}
+}
+
+// originChannel 把 ExecContext.Origin（如 "telegram:123"）转换为规则中的渠道名，空 Origin 表示 CLI。
+func originChannel(origin string) string {
+	origin = strings.TrimSpace(origin)
+	if origin == "" {
+		return "cli"
+	}
+	ch, _, _ := strings.Cut(origin, ":")
+	return strings.ToLower(ch)
+}
+
+// Decide 按规则和开关决定一次调用是否执行。
+func (p ToolPolicy) Decide(req PolicyRequest) PolicyDecision {
+	if p.ReadOnly && readOnlyDenied(req.Tool) {
+		return PolicyDecision{Action: "deny", Reason: "read-only sub-agent: tools that modify the workspace or send messages are disabled"}
+	}
+	for i, r := range p.Rules {
+		if err := r.validate(); err != nil {
+			// 写错的规则按 deny 处理（只看 tool 条件），避免本想禁止的调用被放行
+			if r.matchesTool(req.Tool) {
+				return PolicyDecision{Action: "deny", Reason: fmt.Sprintf("policy.toml rule #%d is invalid (%v); matching tools are denied until it is fixed", i+1, err)}
+			}
+			continue
+		}
+		if r.matches(req) {
+			reason := fmt.Sprintf("policy.toml rule #%d (%s)", i+1, r.String())
+			if strings.TrimSpace(r.Reason) != "" {
+				reason += ": " + strings.TrimSpace(r.Reason)
+			}
+			return PolicyDecision{Action: r.Action, Reason: reason}
+		}
+	}
+	return p.switchDecision(req.Tool)
+}
+
+func (p ToolPolicy) switchDecision(tool string) PolicyDecision {
+	allow := findPolicySwitch(allowSwitches, tool)
+	if allow != nil && !allow.Get(p) {
+		return PolicyDecision{Action: "deny", Reason: allow.Key + " = false"}
+	}
+	if sw := findPolicySwitch(approvalSwitches, tool); sw != nil && sw.Get(p) {
+		if sw.Except != nil && sw.Except(tool) {
+			return PolicyDecision{Action: "allow", Reason: sw.Key + " = true, but the tool is listed in auto_approve"}
+		}
+		return PolicyDecision{Action: "ask", Reason: sw.Key + " = true"}
+	}
+	if allow != nil {
+		return PolicyDecision{Action: "allow", Reason: allow.Key + " = true"}
+	}
+	return PolicyDecision{Action: "allow", Reason: "no rule or switch restricts " + tool}
+}
+
+// policySwitch 把一个 allow_* / require_approval_* 开关映射为作用于一组工具的规则。
+type policySwitch struct {
+	Key    string
+	Tools  []string
+	Prefix string
+	Match  func(tool string) bool
+	Get    func(ToolPolicy) bool
+	// Except 返回 true 的工具不受该开关约束（MCP / OpenAPI 的 auto_approve）。
+	Except func(tool string) bool
+}
+
+func (s *policySwitch) matches(tool string) bool {
+	return containsString(s.Tools, tool) || (s.Prefix != "" && strings.HasPrefix(tool, s.Prefix)) || (s.Match != nil && s.Match(tool))
+}
+
+func (s *policySwitch) describe() string {
+	switch {
+	case s.Prefix != "":
+		return s.Prefix + "*"
+	case s.Match != nil:
+		return "<name>.<operationId>"
+	default:
+		return strings.Join(s.Tools, ",")
+	}
+}
+
+func findPolicySwitch(switches []policySwitch, tool string) *policySwitch {
+	for i := range switches {
+		if switches[i].matches(tool) {
+			return &switches[i]
+		}
+	}
+	return nil
+}
+
+var allowSwitches = []policySwitch{
+	{Key: "allow_mcp", Prefix: "mcp.", Get: func(p ToolPolicy) bool { return p.AllowMCP }},
+	{Key: "allow_http", Match: isOpenAPITool, Get: func(p ToolPolicy) bool { return p.AllowHTTP }},
+	{Key: "allow_fs_write", Tools: []string{"fs.write", "file_write"}, Get: func(p ToolPolicy) bool { return p.AllowFSWrite }},
+	{Key: "allow_runtime_exec", Tools: []string{"runtime.exec", "shell_exec", "shell.session", "code.run", "job.start", "job.status", "job.logs", "job.kill"}, Get: func(p ToolPolicy) bool { return p.AllowRuntimeExec }},
+	{Key: "allow_skill_exec", Tools: []string{"skill.exec", "skill_exec"}, Get: func(p ToolPolicy) bool { return p.AllowSkillExec }},
+	{Key: "allow_skill_install", Tools: []string{"skills.install", "install_skill", "skill_store_install"}, Get: func(p ToolPolicy) bool { return p.AllowSkillInstall }},
+	{Key: "allow_memory", Tools: []string{"memory.store", "memory.recall", "memory.forget", "memory.list", "memory.stats", "memory.import"}, Get: func(p ToolPolicy) bool { return p.AllowMemory }},
+	{Key: "allow_notify", Tools: []string{"notify.send"}, Get: func(p ToolPolicy) bool { return p.AllowNotify }},
+	{Key: "allow_git", Tools: []string{"git.status", "git.diff", "git.log", "git.commit"}, Get: func(p ToolPolicy) bool { return p.AllowGit }},
+	{Key: "allow_delegate", Tools: []string{"agent.delegate"}, Get: func(p ToolPolicy) bool { return p.AllowDelegate }},
+}
+
+var approvalSwitches = []policySwitch{
+	{Key: "require_approval_mcp", Prefix: "mcp.", Get: func(p ToolPolicy) bool { return p.RequireMCP }, Except: mcpAutoApproved},
+	{Key: "require_approval_http", Match: isOpenAPITool, Get: func(p ToolPolicy) bool { return p.RequireHTTP }, Except: openAPIAutoApproved},
+	{Key: "require_approval_fs_write", Tools: []string{"fs.write", "file_write"}, Get: func(p ToolPolicy) bool { return p.RequireFSWrite }},
+	{Key: "require_approval_runtime_exec", Tools: []string{"runtime.exec", "shell_exec", "shell.session", "code.run", "job.start"}, Get: func(p ToolPolicy) bool { return p.RequireRuntimeExec }},
+	{Key: "require_approval_skill_exec", Tools: []string{"skill.exec", "skill_exec"}, Get: func(p ToolPolicy) bool { return p.RequireSkillExec }},
+	{Key: "require_approval_skill_install", Tools: []string{"skills.install", "install_skill", "skill_store_install"}, Get: func(p ToolPolicy) bool { return p.RequireSkillInstall }},
+	{Key: "require_approval_memory", Tools: []string{"memory.store", "memory.recall", "memory.forget", "memory.list", "memory.stats", "memory.import"}, Get: func(p ToolPolicy) bool { return p.RequireMemory }},
+	{Key: "require_approval_notify", Tools: []string{"notify.send"}, Get: func(p ToolPolicy) bool { return p.RequireNotify }},
+	{Key: "require_approval_git_commit", Tools: []string{"git.commit"}, Get: func(p ToolPolicy) bool { return p.RequireGitCommit }},
+}
+
+// denyRulesOnly 返回只含 deny 规则（以及按 deny 处理的无效规则）的副本。
+func denyRulesOnly(rules []PolicyRule) []PolicyRule {
+	var out []PolicyRule
+	for _, r := range rules {
+		if r.Action == "deny" || r.validate() != nil {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// DescribeRules 按求值顺序列出规则：先是 policy.toml 的 [[rules]]，然后是由开关得到的规则。
+func (p ToolPolicy) DescribeRules() []string {
+	var out []string
+	for i, r := range p.Rules {
+		line := fmt.Sprintf("#%d %s", i+1, r.String())
+		if err := r.validate(); err != nil {
+			line += fmt.Sprintf("  [invalid: %v; treated as deny]", err)
+		} else if strings.TrimSpace(r.Reason) != "" {
+			line += "  # " + strings.TrimSpace(r.Reason)
+		}
+		out = append(out, line)
+	}
+	for _, sw := range allowSwitches {
+		if !sw.Get(p) {
+			out = append(out, fmt.Sprintf("deny tool=%s  (%s = false)", sw.describe(), sw.Key))
+		}
+	}
+	for _, sw := range approvalSwitches {
+		if sw.Get(p) {
+			line := fmt.Sprintf("ask tool=%s  (%s = true)", sw.describe(), sw.Key)
+			if sw.Except != nil {
+				line += ", except auto_approve"
+			}
+			out = append(out, line)
+		}
+	}
+	return append(out, "allow  (default)")
+}
+
+func (r PolicyRule) String() string {
+	var b strings.Builder
+	b.WriteString(r.Action)
+	for _, c := range []struct {
+		key      string
+		patterns []string
+	}{{"tool", r.Tools}, {"path", r.Paths}, {"command", r.Commands}, {"skill", r.Skills}, {"script", r.Scripts}, {"channel", r.Channels}, {"user", r.Users}} {
+		if len(c.patterns) > 0 {
+			fmt.Fprintf(&b, " %s=%s", c.key, strings.Join(c.patterns, ","))
+		}
+	}
+	return b.String()
+}
+
+func (r PolicyRule) validate() error {
+	if len(r.Unknown) > 0 {
+		return fmt.Errorf("unrecognized line %q", r.Unknown[0])
+	}
+	switch r.Action {
+	case "allow", "deny", "ask":
+	default:
+		return fmt.Errorf("action must be allow, deny or ask, got %q", r.Action)
+	}
+	for _, list := range [][]string{r.Tools, r.Paths, r.Commands, r.Skills, r.Scripts, r.Channels, r.Users} {
+		for _, p := range list {
+			if _, err := compilePolicyPattern(p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// conditional 表示规则除工具名外还有其他条件，是否命中要看具体调用。
+func (r PolicyRule) conditional() bool {
+	return len(r.Paths)+len(r.Commands)+len(r.Skills)+len(r.Scripts)+len(r.Channels)+len(r.Users) > 0
+}
+
+func (r PolicyRule) matchesTool(tool string) bool {
+	if len(r.Tools) == 0 {
+		return true
+	}
+	names := []string{tool}
+	if spec := lookupToolSpec(tool); spec != nil {
+		names = append(append(names, spec.Name), spec.Aliases...)
+	}
+	for _, n := range names {
+		if matchPolicyPatterns(r.Tools, n) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r PolicyRule) matches(req PolicyRequest) bool {
+	if !r.matchesTool(req.Tool) {
+		return false
+	}
+	if len(r.Channels) > 0 && !matchPolicyPatterns(r.Channels, req.Channel) {
+		return false
+	}
+	if len(r.Users) > 0 && (req.User == "" || !matchPolicyPatterns(r.Users, req.User)) {
+		return false
+	}
+	if len(r.Paths)+len(r.Commands)+len(r.Skills)+len(r.Scripts) == 0 {
+		return true
+	}
+	args, opaque := policyArgsFor(req.ArgsRaw)
+	for _, c := range []struct {
+		key      string
+		patterns []string
+	}{{"path", r.Paths}, {"command", r.Commands}, {"skill", r.Skills}, {"script", r.Scripts}} {
+		if len(c.patterns) == 0 {
+			continue
+		}
+		values := args[c.key]
+		if len(values) == 0 {
+			// 参数无法解析时，deny / ask 规则按命中处理，allow 规则不命中
+			return opaque && r.Action != "allow"
+		}
+		if r.Action == "allow" {
+			// allow 要求所有值都匹配；带控制符的命令可能拼接了其他命令，不能靠前缀放行
+			for _, v := range values {
+				if !matchPolicyPatterns(c.patterns, v) || (c.key == "command" && shellControlChars.MatchString(v)) {
+					return false
+				}
+			}
+			continue
+		}
+		// deny / ask 只要有一个值匹配
+		hit := false
+		for _, v := range values {
+			if matchPolicyPatterns(c.patterns, v) {
+				hit = true
+				break
+			}
+		}
+		if !hit {
+			return false
+		}
+	}
+	return true
+}
+
+var shellControlChars = regexp.MustCompile("[;&|<>`\n]|\\$\\(")
+
+// policyArgsFor 从 JSON 参数中取出规则可以匹配的值；opaque 表示参数不是 JSON 对象。
+func policyArgsFor(argsRaw string) (map[string][]string, bool) {
+	out := map[string][]string{}
+	trimmed := strings.TrimSpace(argsRaw)
+	if trimmed == "" {
+		return out, false
+	}
+	var obj map[string]any
+	if err := json.Unmarshal([]byte(trimmed), &obj); err != nil {
+		return out, true
+	}
+	add := func(key string, v any) {
+		switch t := v.(type) {
+		case string:
+			if strings.TrimSpace(t) != "" {
+				out[key] = append(out[key], t)
+			}
+		case []any:
+			for _, it := range t {
+				if s, ok := it.(string); ok && strings.TrimSpace(s) != "" {
+					out[key] = append(out[key], s)
+				}
+			}
+		}
+	}
+	add("path", obj["path"])
+	add("path", obj["paths"])
+	add("command", obj["command"])
+	add("skill", obj["skill"])
+	add("script", obj["script"])
+	for i, p := range out["path"] {
+		if p = normalizeWorkspaceRelPath(p); !strings.HasPrefix(p, "/") {
+			p = path.Clean(p)
+		}
+		out["path"][i] = p
+	}
+	for i, c := range out["command"] {
+		out["command"][i] = strings.TrimSpace(c)
+	}
+	return out, false
+}
+
+var policyPatternCache sync.Map
+
+// compilePolicyPattern 把通配符（* 和 ?）或 re: 开头的正则表达式编译为完整匹配的正则。
+func compilePolicyPattern(p string) (*regexp.Regexp, error) {
+	if v, ok := policyPatternCache.Load(p); ok {
+		return v.(*regexp.Regexp), nil
+	}
+	var expr string
+	if re, ok := strings.CutPrefix(p, "re:"); ok {
+		expr = "^(?:" + re + ")$"
+	} else {
+		var b strings.Builder
+		b.WriteString("^")
+		for _, c := range p {
+			switch c {
+			case '*':
+				b.WriteString(".*")
+			case '?':
+				b.WriteString(".")
+			default:
+				b.WriteString(regexp.QuoteMeta(string(c)))
+			}
+		}
+		b.WriteString("$")
+		expr = b.String()
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %v", p, err)
+	}
+	policyPatternCache.Store(p, re)
+	return re, nil
+}
+
+func matchPolicyPatterns(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if re, err := compilePolicyPattern(p); err == nil && re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *PolicyRule) setRuleValues(key string, values []string) error {
+	switch key {
+	case "action":
+		if len(values) != 1 {
+			return fmt.Errorf("action must be a single value")
+		}
+		r.Action = strings.ToLower(values[0])
+	case "reason":
+		r.Reason = strings.Join(values, ", ")
+	case "tool", "tools":
+		r.Tools = append(r.Tools, values...)
+	case "path", "paths":
+		r.Paths = append(r.Paths, values...)
+	case "command", "commands":
+		r.Commands = append(r.Commands, values...)
+	case "skill", "skills":
+		r.Skills = append(r.Skills, values...)
+	case "script", "scripts":
+		r.Scripts = append(r.Scripts, values...)
+	case "channel", "channels":
+		for _, v := range values {
+			r.Channels = append(r.Channels, strings.ToLower(v))
+		}
+	case "user", "users":
+		r.Users = append(r.Users, values...)
+	default:
+		return fmt.Errorf("unknown key %q", key)
+	}
+	return nil
+}
+
+// formatPolicyRules 把规则写回 policy.toml 的 [[rules]] 格式。
+func formatPolicyRules(rules []PolicyRule) string {
+	var b strings.Builder
+	for _, r := range rules {
+		b.WriteString("\n[[rules]]\n")
+		fmt.Fprintf(&b, "action = %s\n", strconv.Quote(r.Action))
+		for _, c := range []struct {
+			key    string
+			values []string
+		}{{"tool", r.Tools}, {"path", r.Paths}, {"command", r.Commands}, {"skill", r.Skills}, {"script", r.Scripts}, {"channel", r.Channels}, {"user", r.Users}} {
+			switch len(c.values) {
+			case 0:
+			case 1:
+				fmt.Fprintf(&b, "%s = %s\n", c.key, strconv.Quote(c.values[0]))
+			default:
+				quoted := make([]string, len(c.values))
+				for i, v := range c.values {
+					quoted[i] = strconv.Quote(v)
+				}
+				fmt.Fprintf(&b, "%s = [%s]\n", c.key, strings.Join(quoted, ", "))
+			}
+		}
+		if strings.TrimSpace(r.Reason) != "" {
+			fmt.Fprintf(&b, "reason = %s\n", strconv.Quote(r.Reason))
+		}
+		for _, line := range r.Unknown {
+			b.WriteString(line + "\n")
+		}
+	}
+	return b.String()
+}
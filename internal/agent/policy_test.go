@@ -120,3 +120,135 @@ func TestToolSkillExec_DeniedByPolicy(t *testing.T) {
 	}
 }
 
+func TestPolicyRules_OrderedDecisions(t *testing.T) {
+	ws := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(ws, "data"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	content := `allow_runtime_exec = true
+require_approval_runtime_exec = true
+
+[[rules]]
+action = "allow"
+tool = "runtime.exec"
+command = ["git status*", 're:^git (log|diff)( .*)?$']
+reason = "read-only git"
+
+[[rules]]
+action = "deny"
+tool = "fs.write"
+path = "memory/facts.md"
+reason = "facts are curated by hand"
+
+[[rules]]
+action = "allow"
+tool = "file_write"   # 别名同样匹配
+path = "memory/notes.md"
+
+[[rules]]
+action = "deny"
+tool = "runtime.exec"
+channel = "telegram"
+user = "42"
+`
+	if err := os.WriteFile(filepath.Join(ws, "data", "policy.toml"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p := LoadToolPolicy(ws)
+	if len(p.Rules) != 4 || !p.AllowRuntimeExec || !p.RequireRuntimeExec {
+		t.Fatalf("rules must not leak into top-level keys: %+v", p)
+	}
+
+	cases := []struct {
+		req    PolicyRequest
+		action string
+		reason string
+	}{
+		{PolicyRequest{Tool: "runtime.exec", ArgsRaw: `{"command":"git status --short"}`, Channel: "cli"}, "allow", "rule #1 (allow tool=runtime.exec command=git status*,re:^git (log|diff)( .*)?$): read-only git"},
+		{PolicyRequest{Tool: "runtime.exec", ArgsRaw: `{"command":"git log -3"}`, Channel: "cli"}, "allow", "rule #1"},
+		{PolicyRequest{Tool: "runtime.exec", ArgsRaw: `{"command":"git status && git push"}`, Channel: "cli"}, "ask", "require_approval_runtime_exec = true"},
+		{PolicyRequest{Tool: "runtime.exec", ArgsRaw: `{"command":"git push origin main"}`, Channel: "cli"}, "ask", "require_approval_runtime_exec = true"},
+		{PolicyRequest{Tool: "fs.write", ArgsRaw: `{"path":"workspace/memory/../memory/facts.md","content":"x"}`}, "deny", "rule #2 (deny tool=fs.write path=memory/facts.md): facts are curated by hand"},
+		{PolicyRequest{Tool: "fs.write", ArgsRaw: `{"path":"memory/notes.md","content":"x"}`}, "allow", "rule #3"},
+		{PolicyRequest{Tool: "fs.write", ArgsRaw: `{"path":"memory/other.md","content":"x"}`}, "ask", "require_approval_fs_write = true"},
+		{PolicyRequest{Tool: "runtime.exec", ArgsRaw: `{"command":"ls"}`, Channel: "telegram", User: "42"}, "deny", "rule #4"},
+		{PolicyRequest{Tool: "runtime.exec", ArgsRaw: `{"command":"ls"}`, Channel: "telegram", User: "7"}, "ask", "require_approval_runtime_exec"},
+		{PolicyRequest{Tool: "memory.recall", ArgsRaw: `{}`}, "ask", "require_approval_memory = true"},
+		{PolicyRequest{Tool: "fs.read", ArgsRaw: `{"path":"x"}`}, "allow", "no rule or switch restricts fs.read"},
+	}
+	for _, tc := range cases {
+		d := p.Decide(tc.req)
+		if d.Action != tc.action || !strings.Contains(d.Reason, tc.reason) {
+			t.Fatalf("%s %s: got %s (%s), want %s (%s)", tc.req.Tool, tc.req.ArgsRaw, d.Action, d.Reason, tc.action, tc.reason)
+		}
+	}
+
+	// 规则优先于开关，开关关闭后仍可按规则放行；定时任务只保留 deny 规则
+	p.AllowRuntimeExec = false
+	if d := p.Decide(cases[0].req); d.Action != "allow" || !p.AllowsTool("runtime.exec") {
+		t.Fatalf("allow rule should override allow_runtime_exec = false: %+v", d)
+	}
+	if d := p.Decide(cases[3].req); d.Action != "deny" || d.Reason != "allow_runtime_exec = false" {
+		t.Fatalf("unexpected decision: %+v", d)
+	}
+	if sp := schedulePolicy(p); len(sp.Rules) != 2 || sp.Decide(cases[0].req).Action != "deny" {
+		t.Fatalf("scheduled runs must only keep deny rules: %+v", sp.Rules)
+	}
+
+	// 保存后重新加载，规则保持不变
+	if err := SaveToolPolicy(ws, p); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := LoadToolPolicy(ws)
+	if len(reloaded.Rules) != 4 || reloaded.Rules[0].String() != p.Rules[0].String() || reloaded.Rules[3].String() != p.Rules[3].String() || reloaded.Rules[1].Reason != p.Rules[1].Reason {
+		t.Fatalf("rules changed after save:\n%v\n%v", reloaded.DescribeRules(), p.DescribeRules())
+	}
+}
+
+func TestPolicyRules_InvalidRuleFailsClosed(t *testing.T) {
+	ws := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(ws, "data"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	content := "[[rules]]\naction = \"allow\"\ntool = \"runtime.exec\"\ncomand = \"git status*\"\n\n[[rules]]\naction = \"deny\"\ntool = \"fs.write\"\npath = \"re:([\"\n"
+	if err := os.WriteFile(filepath.Join(ws, "data", "policy.toml"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p := LoadToolPolicy(ws)
+	if d := p.Decide(PolicyRequest{Tool: "runtime.exec", ArgsRaw: `{"command":"rm -rf x"}`}); d.Action != "deny" || !strings.Contains(d.Reason, "rule #1 is invalid") {
+		t.Fatalf("a misspelled key must not turn the rule into allow-all: %+v", d)
+	}
+	if d := p.Decide(PolicyRequest{Tool: "fs.write", ArgsRaw: `{"path":"memory/a.md"}`}); d.Action != "deny" || !strings.Contains(d.Reason, "invalid pattern") {
+		t.Fatalf("expected invalid pattern to deny: %+v", d)
+	}
+	if d := p.Decide(PolicyRequest{Tool: "fs.read", ArgsRaw: `{"path":"x"}`}); d.Action != "allow" {
+		t.Fatalf("other tools are unaffected: %+v", d)
+	}
+
+	res := ExecuteCalls(ExecContext{Workspace: ws, Policy: p}, []ExecCall{{Tool: "runtime.exec", ArgsRaw: `{"command":"ls"}`}}, nil)
+	if res[0].Error != "disabled by policy" || !strings.Contains(res[0].Reason, "rule #1 is invalid") {
+		t.Fatalf("expected explained denial: %+v", res[0])
+	}
+	if out := formatToolResults(res); !strings.Contains(out, "  policy: policy.toml rule #1 is invalid") {
+		t.Fatalf("the model should see why the call was denied:\n%s", out)
+	}
+}
+
+func TestPolicyRules_WebIsItsOwnChannel(t *testing.T) {
+	p := DefaultToolPolicy()
+	p.AllowRuntimeExec = true
+	p.RequireRuntimeExec = true
+	p.Rules = []PolicyRule{{Action: "allow", Tools: []string{"runtime.exec"}, Channels: []string{"cli"}}}
+	call := ExecCall{Tool: "runtime.exec", ArgsRaw: `{"command":"ls"}`}
+
+	if d := p.Decide(policyRequestFor(ExecContext{}, call)); d.Action != "allow" {
+		t.Fatalf("the cli rule should apply to the CLI: %+v", d)
+	}
+	req := policyRequestFor(ExecContext{Origin: "web:0123abcd"}, call)
+	if req.Channel != "web" {
+		t.Fatalf("web callers must not be classified as %q", req.Channel)
+	}
+	if d := p.Decide(req); d.Action != "ask" {
+		t.Fatalf("a channel = \"cli\" rule must not open up the web UI: %+v", d)
+	}
+}
@@ -13,6 +13,10 @@ func sandboxEnabled() bool {
 	return strings.TrimSpace(os.Getenv("NIBOT_EXEC_SANDBOX")) == "1"
 }
 
+func nativeSandboxEnabled() bool {
+	return strings.EqualFold(strings.TrimSpace(os.Getenv("NIBOT_EXEC_SANDBOX")), "native")
+}
+
 func sandboxBin() string {
 	if v := strings.TrimSpace(os.Getenv("NIBOT_SANDBOX_BIN")); v != "" {
 		return v
@@ -43,3 +47,45 @@ func wrapWithSandbox(argv []string) ([]string, error) {
 	return append([]string{bin}, argv...), nil
 }
 
+// sandboxedCommand 按 NIBOT_EXEC_SANDBOX 构造子进程：1 走外部 sandbox 程序，
+// native 走内置的 Linux 隔离（bubblewrap 或 namespaces + Landlock），否则直接在宿主机执行。
+// env 为子进程环境（见 childEnvFor）。
+func sandboxedCommand(ctx ExecContext, argv []string, env []string) (*exec.Cmd, error) {
+	if len(argv) == 0 {
+		return nil, fmt.Errorf("empty command argv")
+	}
+	if nativeSandboxEnabled() {
+		return nativeSandboxCommand(ctx, argv, env)
+	}
+	argv, err := wrapWithSandbox(argv)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Dir = ctx.Workspace
+	cmd.Env = env
+	return cmd, nil
+}
+
+// sandboxWritablePaths 返回 native sandbox 中可写的绝对路径：工作区本身加上 policy 里的额外路径（相对路径按工作区解析）。
+func sandboxWritablePaths(ctx ExecContext) ([]string, error) {
+	ws, err := filepath.Abs(ctx.Workspace)
+	if err != nil {
+		return nil, err
+	}
+	out := []string{ws}
+	for _, p := range ctx.Policy.SandboxWritablePaths {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(ws, p)
+		}
+		if _, err := os.Stat(p); err != nil {
+			return nil, fmt.Errorf("sandbox writable path not found: %s", p)
+		}
+		out = append(out, filepath.Clean(p))
+	}
+	return out, nil
+}
@@ -0,0 +1,232 @@
+//go:build linux
+
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// 内置 sandbox 通过重新执行自身实现：子进程在新的 user/mount/PID(/net) namespace 中启动，
+// init() 里检测到该环境变量后完成挂载与 Landlock 限制，再 exec 真正的命令。
+const nativeSandboxInitEnv = "NIBOT_SANDBOX_INIT"
+
+type nativeSandboxSpec struct {
+	Dir      string   `json:"dir"`
+	Writable []string `json:"writable"`
+}
+
+func init() {
+	if raw := os.Getenv(nativeSandboxInitEnv); raw != "" {
+		// Landlock 与 no_new_privs 只作用于当前线程，必须在同一线程上完成限制并 exec。
+		runtime.LockOSThread()
+		if err := runNativeSandboxInit(raw, os.Args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "nibot sandbox: %v\n", err)
+		}
+		os.Exit(126)
+	}
+}
+
+// bwrapBin 返回 bubblewrap 路径；NIBOT_SANDBOX_BWRAP=0 强制使用内置实现。
+func bwrapBin() string {
+	v := strings.TrimSpace(os.Getenv("NIBOT_SANDBOX_BWRAP"))
+	if v == "0" || strings.EqualFold(v, "off") {
+		return ""
+	}
+	if v != "" && v != "1" {
+		return v
+	}
+	p, err := exec.LookPath("bwrap")
+	if err != nil {
+		return ""
+	}
+	return p
+}
+
+func nativeSandboxCommand(ctx ExecContext, argv []string, env []string) (*exec.Cmd, error) {
+	writable, err := sandboxWritablePaths(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dir := writable[0]
+
+	if bwrap := bwrapBin(); bwrap != "" {
+		args := []string{"--ro-bind", "/", "/", "--dev", "/dev", "--proc", "/proc", "--tmpfs", "/tmp"}
+		for _, w := range writable {
+			args = append(args, "--bind", w, w)
+		}
+		args = append(args, "--unshare-user", "--unshare-pid", "--unshare-ipc", "--unshare-uts", "--die-with-parent", "--chdir", dir)
+		if !ctx.Policy.SandboxAllowNetwork {
+			args = append(args, "--unshare-net")
+		}
+		args = append(args, "--")
+		args = append(args, argv...)
+		cmd := exec.Command(bwrap, args...)
+		cmd.Dir = dir
+		cmd.Env = env
+		return cmd, nil
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("native sandbox: %w", err)
+	}
+	spec, err := json.Marshal(nativeSandboxSpec{Dir: dir, Writable: writable})
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(self, argv...)
+	cmd.Dir = dir
+	cmd.Env = append(append([]string{}, env...), nativeSandboxInitEnv+"="+string(spec))
+	flags := uintptr(syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS)
+	if !ctx.Policy.SandboxAllowNetwork {
+		flags |= syscall.CLONE_NEWNET
+	}
+	uid, gid := os.Getuid(), os.Getgid()
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:                 flags,
+		UidMappings:                []syscall.SysProcIDMap{{ContainerID: uid, HostID: uid, Size: 1}},
+		GidMappings:                []syscall.SysProcIDMap{{ContainerID: gid, HostID: gid, Size: 1}},
+		GidMappingsEnableSetgroups: false,
+	}
+	return cmd, nil
+}
+
+func runNativeSandboxInit(raw string, argv []string) error {
+	var spec nativeSandboxSpec
+	if err := json.Unmarshal([]byte(raw), &spec); err != nil {
+		return fmt.Errorf("invalid spec: %w", err)
+	}
+	if len(argv) == 0 {
+		return fmt.Errorf("empty command argv")
+	}
+
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("make mounts private: %w", err)
+	}
+	// 私有的 /tmp 会盖住位于 /tmp 下的工作区，所以先持有这些目录的 fd，挂载后再 bind 回来。
+	fds := make([]int, 0, len(spec.Writable))
+	for _, w := range spec.Writable {
+		fd, err := unix.Open(w, unix.O_PATH|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return fmt.Errorf("open %s: %w", w, err)
+		}
+		fds = append(fds, fd)
+	}
+	if err := unix.Mount("tmpfs", "/tmp", "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=1777"); err != nil {
+		return fmt.Errorf("mount /tmp: %w", err)
+	}
+	for i, w := range spec.Writable {
+		if w != "/tmp" && !strings.HasPrefix(w, "/tmp/") {
+			continue
+		}
+		if err := os.MkdirAll(w, 0o755); err != nil {
+			return err
+		}
+		if err := unix.Mount(fmt.Sprintf("/proc/self/fd/%d", fds[i]), w, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+			return fmt.Errorf("bind %s: %w", w, err)
+		}
+	}
+	// 新 PID namespace 需要新的 /proc；容器里 /proc 被部分遮盖时内核会拒绝，此时沿用原 /proc。
+	_ = unix.Mount("proc", "/proc", "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, "")
+
+	if err := os.Chdir(spec.Dir); err != nil {
+		return err
+	}
+	if err := landlockRestrict(append(spec.Writable, "/tmp")); err != nil {
+		return err
+	}
+
+	path, err := exec.LookPath(argv[0])
+	if err != nil {
+		return err
+	}
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, nativeSandboxInitEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+	return syscall.Exec(path, argv, env)
+}
+
+// landlockRestrict 让整个文件系统只读（可读、可执行），仅 writable 下的路径可写。
+// 内核不支持 Landlock 时直接失败，不会退化为无隔离执行。
+func landlockRestrict(writable []string) error {
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		return fmt.Errorf("landlock not available: %w", errno)
+	}
+	handled := uint64(unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_DIR | unix.LANDLOCK_ACCESS_FS_REMOVE_DIR | unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_CHAR | unix.LANDLOCK_ACCESS_FS_MAKE_DIR | unix.LANDLOCK_ACCESS_FS_MAKE_REG |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SOCK | unix.LANDLOCK_ACCESS_FS_MAKE_FIFO | unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SYM)
+	if abi >= 2 {
+		handled |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= 3 {
+		handled |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+	if abi >= 5 {
+		handled |= unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+	}
+	readOnly := uint64(unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR)
+	fileRW := uint64(unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE)
+	if abi >= 3 {
+		fileRW |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+
+	attr := unix.LandlockRulesetAttr{Access_fs: handled}
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("landlock create ruleset: %w", errno)
+	}
+	ruleset := int(fd)
+	defer unix.Close(ruleset)
+
+	addRule := func(path string, access uint64) error {
+		pfd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return err
+		}
+		defer unix.Close(pfd)
+		rule := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(pfd)}
+		if _, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset), unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&rule)), 0, 0, 0); errno != 0 {
+			return fmt.Errorf("landlock add rule %s: %w", path, errno)
+		}
+		return nil
+	}
+
+	if err := addRule("/", readOnly); err != nil {
+		return err
+	}
+	for _, w := range writable {
+		if err := addRule(filepath.Clean(w), handled); err != nil {
+			return err
+		}
+	}
+	// 只放开常用的字符设备，不给整个 /dev 写权限。
+	for _, dev := range []string{"/dev/null", "/dev/zero", "/dev/full", "/dev/random", "/dev/urandom", "/dev/tty"} {
+		if _, err := os.Stat(dev); err == nil {
+			_ = addRule(dev, fileRW)
+		}
+	}
+
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("set no_new_privs: %w", err)
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_LANDLOCK_RESTRICT_SELF, uintptr(ruleset), 0, 0); errno != 0 {
+		return fmt.Errorf("landlock restrict self: %w", errno)
+	}
+	return nil
+}
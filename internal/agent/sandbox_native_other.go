@@ -0,0 +1,12 @@
+//go:build !linux
+
+package agent
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+func nativeSandboxCommand(ctx ExecContext, argv []string, env []string) (*exec.Cmd, error) {
+	return nil, fmt.Errorf("NIBOT_EXEC_SANDBOX=native is only supported on linux")
+}
@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func nativeSandboxTestContext(t *testing.T) ExecContext {
+	t.Helper()
+	if runtime.GOOS != "linux" {
+		t.Skip("native sandbox is linux only")
+	}
+	t.Setenv("NIBOT_ENABLE_EXEC", "1")
+	t.Setenv("NIBOT_EXEC_SANDBOX", "native")
+	t.Setenv("NIBOT_SANDBOX_BWRAP", "0")
+	ctx := ExecContext{Workspace: t.TempDir(), Policy: DefaultToolPolicy()}
+	if out, err := toolRuntimeExec(ctx, `{"command":"true","timeoutSeconds":10}`); err != nil {
+		t.Skipf("native sandbox unavailable here: %v (%s)", err, out)
+	}
+	return ctx
+}
+
+func TestNativeSandbox_WorkspaceWritableOnly(t *testing.T) {
+	ctx := nativeSandboxTestContext(t)
+	outside := filepath.Join(t.TempDir(), "escape.txt")
+
+	out, err := toolRuntimeExec(ctx, `{"command":"echo inside > a.txt && cat a.txt","timeoutSeconds":10}`)
+	if err != nil || !strings.Contains(out, "inside") {
+		t.Fatalf("expected workspace write to succeed, got out=%q err=%v", out, err)
+	}
+	if _, err := os.Stat(filepath.Join(ctx.Workspace, "a.txt")); err != nil {
+		t.Fatalf("expected file in workspace: %v", err)
+	}
+
+	_, _ = toolRuntimeExec(ctx, `{"command":"echo x > `+outside+`","timeoutSeconds":10}`)
+	if _, err := os.Stat(outside); err == nil {
+		t.Fatalf("sandboxed command wrote outside the workspace: %s", outside)
+	}
+
+	out, err = toolRuntimeExec(ctx, `{"command":"touch /usr/nibot_sandbox_probe","timeoutSeconds":10}`)
+	if err == nil {
+		_ = os.Remove("/usr/nibot_sandbox_probe")
+		t.Fatalf("expected system paths to be read-only, got %q", out)
+	}
+}
+
+func TestNativeSandbox_NetworkOff(t *testing.T) {
+	ctx := nativeSandboxTestContext(t)
+	ctx.Policy.SandboxAllowNetwork = false
+
+	out, err := toolRuntimeExec(ctx, `{"command":"cat /proc/net/dev","timeoutSeconds":10}`)
+	if err != nil {
+		t.Fatalf("runtime.exec failed: %v (%s)", err, out)
+	}
+	for _, line := range strings.Split(out, "\n") {
+		name, _, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if ok && name != "lo" && !strings.Contains(name, "|") {
+			t.Fatalf("expected only loopback without network, got %q", out)
+		}
+	}
+}
+
+func TestSandboxWritablePaths_ResolvesRelativeToWorkspace(t *testing.T) {
+	ws := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(ws, "cache"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	p := DefaultToolPolicy()
+	p.SandboxWritablePaths = []string{"cache"}
+	got, err := sandboxWritablePaths(ExecContext{Workspace: ws, Policy: p})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[1] != filepath.Join(ws, "cache") {
+		t.Fatalf("unexpected writable paths: %#v", got)
+	}
+
+	p.SandboxWritablePaths = []string{"missing"}
+	if _, err := sandboxWritablePaths(ExecContext{Workspace: ws, Policy: p}); err == nil {
+		t.Fatalf("expected error for missing writable path")
+	}
+}
@@ -0,0 +1,538 @@
+package agent
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	_ "time/tzdata"
+)
+
+// 定时任务：schedule.create 把一段提示词和 cron 表达式（或一次性的 at 时间）存进
+// data/nibot.db 的 schedules 表，并记住创建它的渠道（CLI / Telegram 会话 / 飞书会话）。
+// StartScheduler 定期检查到期的任务，用受限策略（不能写文件、执行命令、运行或安装技能、
+// 发通知、再创建定时任务）让模型处理提示词，然后把回复发回原渠道。错过的运行（例如进程未启动）只补跑一次。
+
+type scheduleEntry struct {
+	ID         int64
+	Cron       string
+	At         string
+	Timezone   string
+	Prompt     string
+	Channel    string
+	Target     string
+	NextRun    time.Time
+	LastRun    time.Time
+	LastStatus string
+	RunCount   int
+	Enabled    bool
+	CreatedAt  string
+}
+
+type scheduleStore struct {
+	db *sql.DB
+}
+
+func openScheduleStore(workspace string) (*scheduleStore, error) {
+	db, err := openWorkspaceDB(workspace)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`create table if not exists schedules (
+		id integer primary key autoincrement,
+		cron text,
+		at text,
+		timezone text,
+		prompt text,
+		channel text,
+		target text,
+		next_run integer,
+		last_run integer,
+		last_status text,
+		run_count integer default 0,
+		enabled integer default 1,
+		created_at text
+	);`)
+	if err == nil {
+		_, err = db.Exec(`create index if not exists idx_schedules_due on schedules(enabled, next_run);`)
+	}
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &scheduleStore{db: db}, nil
+}
+
+func (s *scheduleStore) Close() {
+	if s != nil && s.db != nil {
+		_ = s.db.Close()
+	}
+}
+
+const scheduleColumns = `id,cron,at,timezone,prompt,channel,target,next_run,last_run,last_status,run_count,enabled,created_at`
+
+func scanSchedules(rows *sql.Rows) ([]scheduleEntry, error) {
+	defer rows.Close()
+	var out []scheduleEntry
+	for rows.Next() {
+		var e scheduleEntry
+		var cron, at, tz, target, status sql.NullString
+		var next, last sql.NullInt64
+		var enabled int
+		if err := rows.Scan(&e.ID, &cron, &at, &tz, &e.Prompt, &e.Channel, &target, &next, &last, &status, &e.RunCount, &enabled, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.Cron, e.At, e.Timezone, e.Target, e.LastStatus = cron.String, at.String, tz.String, target.String, status.String
+		if next.Valid && next.Int64 > 0 {
+			e.NextRun = time.Unix(next.Int64, 0)
+		}
+		if last.Valid && last.Int64 > 0 {
+			e.LastRun = time.Unix(last.Int64, 0)
+		}
+		e.Enabled = enabled != 0
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+func (s *scheduleStore) insert(e scheduleEntry) (int64, error) {
+	res, err := s.db.Exec(
+		`insert into schedules(cron,at,timezone,prompt,channel,target,next_run,run_count,enabled,created_at) values(?,?,?,?,?,?,?,0,1,?)`,
+		e.Cron, e.At, e.Timezone, e.Prompt, e.Channel, e.Target, e.NextRun.Unix(), time.Now().Format(time.RFC3339),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (s *scheduleStore) get(id int64) (*scheduleEntry, error) {
+	rows, err := s.db.Query(`select `+scheduleColumns+` from schedules where id=?`, id)
+	if err != nil {
+		return nil, err
+	}
+	list, err := scanSchedules(rows)
+	if err != nil || len(list) == 0 {
+		return nil, err
+	}
+	return &list[0], nil
+}
+
+func (s *scheduleStore) list(includeDone bool) ([]scheduleEntry, error) {
+	q := `select ` + scheduleColumns + ` from schedules`
+	if !includeDone {
+		q += ` where enabled=1`
+	}
+	rows, err := s.db.Query(q + ` order by id`)
+	if err != nil {
+		return nil, err
+	}
+	return scanSchedules(rows)
+}
+
+func (s *scheduleStore) due(now time.Time) ([]scheduleEntry, error) {
+	rows, err := s.db.Query(`select `+scheduleColumns+` from schedules where enabled=1 and next_run<=? order by next_run, id`, now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	return scanSchedules(rows)
+}
+
+// advance 在执行前写入下一次运行时间；next 为零值时任务结束（一次性任务或不再有匹配时间）。
+func (s *scheduleStore) advance(id int64, ran, next time.Time) error {
+	enabled := 1
+	var nextUnix int64
+	if next.IsZero() {
+		enabled = 0
+	} else {
+		nextUnix = next.Unix()
+	}
+	_, err := s.db.Exec(`update schedules set next_run=?, last_run=?, run_count=run_count+1, enabled=? where id=?`, nextUnix, ran.Unix(), enabled, id)
+	return err
+}
+
+func (s *scheduleStore) setStatus(id int64, status string) error {
+	_, err := s.db.Exec(`update schedules set last_status=? where id=?`, truncateUTF8(status, 500), id)
+	return err
+}
+
+func (s *scheduleStore) disable(id int64) error {
+	_, err := s.db.Exec(`update schedules set enabled=0 where id=?`, id)
+	return err
+}
+
+// scheduleOrigin 把 ExecContext.Origin（如 "telegram:123"）拆成渠道和目标；为空表示 CLI。
+func scheduleOrigin(origin string) (string, string) {
+	origin = strings.TrimSpace(origin)
+	if origin == "" {
+		return "cli", ""
+	}
+	channel, target, _ := strings.Cut(origin, ":")
+	return channel, target
+}
+
+func scheduleLocation(name string) (*time.Location, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = strings.TrimSpace(os.Getenv("NIBOT_TIMEZONE"))
+	}
+	if name == "" || strings.EqualFold(name, "local") {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q (use an IANA name such as Asia/Shanghai)", name)
+	}
+	return loc, nil
+}
+
+var scheduleAtLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04"}
+
+func parseScheduleAt(s string, loc *time.Location) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range scheduleAtLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid at time %q (use \"2006-01-02 15:04\" or RFC3339)", s)
+}
+
+// nextScheduleRun 计算 after 之后的下一次运行时间；一次性任务运行后返回零值。
+func nextScheduleRun(e scheduleEntry, after time.Time) (time.Time, error) {
+	loc, err := scheduleLocation(e.Timezone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if e.Cron == "" {
+		return time.Time{}, nil
+	}
+	cs, err := parseCron(e.Cron)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cs.next(after, loc), nil
+}
+
+func (e scheduleEntry) describe() string {
+	when := "cron " + e.Cron
+	if e.Cron == "" {
+		when = "once at " + e.At
+	}
+	if e.Timezone != "" {
+		when += " (" + e.Timezone + ")"
+	}
+	next := "none"
+	if e.Enabled && !e.NextRun.IsZero() {
+		next = e.NextRun.In(e.location()).Format("2006-01-02 15:04 MST")
+	}
+	dest := e.Channel
+	if e.Target != "" {
+		dest += ":" + e.Target
+	}
+	line := fmt.Sprintf("#%d %s next=%s to=%s runs=%d", e.ID, when, next, dest, e.RunCount)
+	if !e.Enabled {
+		line += " [done]"
+	}
+	if e.LastStatus != "" {
+		line += " last=" + firstLine(e.LastStatus)
+	}
+	return line + "\n  prompt: " + truncateUTF8(firstLine(e.Prompt), 200)
+}
+
+func (e scheduleEntry) location() *time.Location {
+	if loc, err := scheduleLocation(e.Timezone); err == nil {
+		return loc
+	}
+	return time.Local
+}
+
+// ownedBy 判断调用方能否查看/取消该任务：CLI 可以管理全部，聊天渠道和 Web 界面只能管理自己会话创建的任务。
+func (e scheduleEntry) ownedBy(origin string) bool {
+	channel, target := scheduleOrigin(origin)
+	return channel == "cli" || (e.Channel == channel && e.Target == target)
+}
+
+type scheduleCreateArgs struct {
+	Prompt   string `json:"prompt"`
+	Cron     string `json:"cron"`
+	At       string `json:"at"`
+	Timezone string `json:"timezone"`
+}
+
+func toolScheduleCreate(ctx ExecContext, argsRaw string) (string, error) {
+	if !strings.HasPrefix(strings.TrimSpace(argsRaw), "{") {
+		return "", fmt.Errorf("schedule.create requires JSON args: {\"prompt\":\"...\",\"cron\":\"0 9 * * 1\",\"timezone\":\"Asia/Shanghai\"}")
+	}
+	var a scheduleCreateArgs
+	if err := json.Unmarshal([]byte(argsRaw), &a); err != nil {
+		return "", fmt.Errorf("invalid JSON args for schedule.create: %w", err)
+	}
+	channel, target := scheduleOrigin(ctx.Origin)
+	if channel == "schedule" {
+		return "", fmt.Errorf("scheduled tasks cannot create other scheduled tasks")
+	}
+	a.Prompt = strings.TrimSpace(a.Prompt)
+	a.Cron = strings.TrimSpace(a.Cron)
+	a.At = strings.TrimSpace(a.At)
+	if a.Prompt == "" {
+		return "", fmt.Errorf("prompt is required")
+	}
+	if (a.Cron == "") == (a.At == "") {
+		return "", fmt.Errorf("exactly one of cron (recurring) or at (one-time) is required")
+	}
+	loc, err := scheduleLocation(a.Timezone)
+	if err != nil {
+		return "", err
+	}
+	tzName := strings.TrimSpace(a.Timezone)
+	if tzName == "" {
+		tzName = loc.String()
+	}
+
+	e := scheduleEntry{Cron: a.Cron, At: a.At, Timezone: tzName, Prompt: a.Prompt, Channel: channel, Target: target, Enabled: true}
+	now := time.Now()
+	if a.Cron != "" {
+		cs, err := parseCron(a.Cron)
+		if err != nil {
+			return "", err
+		}
+		e.NextRun = cs.next(now, loc)
+		if e.NextRun.IsZero() {
+			return "", fmt.Errorf("cron expression %q never matches", a.Cron)
+		}
+	} else {
+		t, err := parseScheduleAt(a.At, loc)
+		if err != nil {
+			return "", err
+		}
+		if !t.After(now) {
+			return "", fmt.Errorf("at time %s is in the past (now %s)", t.Format("2006-01-02 15:04 MST"), now.In(loc).Format("2006-01-02 15:04 MST"))
+		}
+		e.NextRun = t
+	}
+
+	store, err := openScheduleStore(ctx.Workspace)
+	if err != nil {
+		return "", err
+	}
+	defer store.Close()
+	if e.ID, err = store.insert(e); err != nil {
+		return "", err
+	}
+	return "scheduled " + e.describe(), nil
+}
+
+type scheduleListArgs struct {
+	All bool `json:"all"`
+}
+
+func toolScheduleList(ctx ExecContext, argsRaw string) (string, error) {
+	var a scheduleListArgs
+	if strings.TrimSpace(argsRaw) != "" {
+		if !strings.HasPrefix(strings.TrimSpace(argsRaw), "{") {
+			return "", fmt.Errorf("schedule.list requires JSON args: {\"all\":false}")
+		}
+		if err := json.Unmarshal([]byte(argsRaw), &a); err != nil {
+			return "", fmt.Errorf("invalid JSON args for schedule.list: %w", err)
+		}
+	}
+	store, err := openScheduleStore(ctx.Workspace)
+	if err != nil {
+		return "", err
+	}
+	defer store.Close()
+	list, err := store.list(a.All)
+	if err != nil {
+		return "", err
+	}
+	var lines []string
+	for _, e := range list {
+		if e.ownedBy(ctx.Origin) {
+			lines = append(lines, e.describe())
+		}
+	}
+	if len(lines) == 0 {
+		return "(no scheduled tasks)", nil
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+type scheduleCancelArgs struct {
+	ID int64 `json:"id"`
+}
+
+func toolScheduleCancel(ctx ExecContext, argsRaw string) (string, error) {
+	if !strings.HasPrefix(strings.TrimSpace(argsRaw), "{") {
+		return "", fmt.Errorf("schedule.cancel requires JSON args: {\"id\":1}")
+	}
+	var a scheduleCancelArgs
+	if err := json.Unmarshal([]byte(argsRaw), &a); err != nil {
+		return "", fmt.Errorf("invalid JSON args for schedule.cancel: %w", err)
+	}
+	if channel, _ := scheduleOrigin(ctx.Origin); channel == "schedule" {
+		return "", fmt.Errorf("scheduled tasks cannot cancel scheduled tasks")
+	}
+	store, err := openScheduleStore(ctx.Workspace)
+	if err != nil {
+		return "", err
+	}
+	defer store.Close()
+	e, err := store.get(a.ID)
+	if err != nil {
+		return "", err
+	}
+	if e == nil || !e.ownedBy(ctx.Origin) {
+		return "", fmt.Errorf("scheduled task not found: %d", a.ID)
+	}
+	if !e.Enabled {
+		return fmt.Sprintf("scheduled task #%d already finished", a.ID), nil
+	}
+	if err := store.disable(a.ID); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("cancelled scheduled task #%d", a.ID), nil
+}
+
+// ScheduleDeliverer 把定时任务的结果发送到某个渠道的目标（例如 Telegram chat id）。
+type ScheduleDeliverer func(target, text string) error
+
+var (
+	scheduleDeliverersMu sync.RWMutex
+	scheduleDeliverers   = map[string]ScheduleDeliverer{}
+)
+
+// RegisterScheduleDeliverer 由各渠道在启动时注册；CLI 渠道默认写入 logs/schedule.log。
+func RegisterScheduleDeliverer(channel string, d ScheduleDeliverer) {
+	scheduleDeliverersMu.Lock()
+	defer scheduleDeliverersMu.Unlock()
+	if d == nil {
+		delete(scheduleDeliverers, channel)
+		return
+	}
+	scheduleDeliverers[channel] = d
+}
+
+func deliverScheduleResult(workspace string, e scheduleEntry, text string) error {
+	scheduleDeliverersMu.RLock()
+	d := scheduleDeliverers[e.Channel]
+	scheduleDeliverersMu.RUnlock()
+	if d != nil {
+		return d(e.Target, text)
+	}
+	if e.Channel != "cli" {
+		text = fmt.Sprintf("(channel %s:%s is not running in this process)\n%s", e.Channel, e.Target, text)
+	}
+	log.Printf("Scheduled task #%d:\n%s", e.ID, text)
+	return appendScheduleLog(workspace, text)
+}
+
+func appendScheduleLog(workspace, text string) error {
+	p := filepath.Join(workspace, "logs", "schedule.log")
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintf(f, "## %s\n\n%s\n\n", time.Now().Format(time.RFC3339), text)
+	return err
+}
+
+// schedulePolicy 是定时任务运行时使用的策略：无人值守、无人审批，因此只保留只读类工具和记忆；
+// 结果本身会发回原渠道，不需要 notify.send。[[rules]] 中只保留 deny 规则，allow / ask 不能重新打开上面的开关。
+func schedulePolicy(p ToolPolicy) ToolPolicy {
+	if !p.Loaded {
+		p = DefaultToolPolicy()
+	}
+	p.AllowFSWrite = false
+	p.AllowRuntimeExec = false
+	p.AllowSkillExec = false
+	p.AllowSkillInstall = false
+	p.AllowNotify = false
+	p.AllowMCP = false
+	p.AllowHTTP = false
+	p.Rules = denyRulesOnly(p.Rules)
+	return p
+}
+
+func scheduleTick() time.Duration {
+	return time.Duration(parseIntEnv("NIBOT_SCHEDULER_TICK_SECONDS", 30, 1, 3600)) * time.Second
+}
+
+var schedulerMu sync.Mutex
+
+// StartScheduler 启动定时任务循环；NIBOT_SCHEDULER=0 时关闭。
+func StartScheduler(workspace string, cfg Config, systemPrompt string) {
+	if !parseBool(os.Getenv("NIBOT_SCHEDULER"), true) {
+		return
+	}
+	go func() {
+		t := time.NewTicker(scheduleTick())
+		defer t.Stop()
+		for {
+			if err := runDueSchedules(workspace, cfg, systemPrompt, time.Now()); err != nil {
+				log.Printf("Scheduler error: %v", err)
+			}
+			<-t.C
+		}
+	}()
+}
+
+// runDueSchedules 执行到 now 为止到期的任务。先写入下一次运行时间再执行，避免慢任务被重复触发。
+func runDueSchedules(workspace string, cfg Config, systemPrompt string, now time.Time) error {
+	schedulerMu.Lock()
+	defer schedulerMu.Unlock()
+
+	store, err := openScheduleStore(workspace)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	due, err := store.due(now)
+	if err != nil {
+		return err
+	}
+	for _, e := range due {
+		next, err := nextScheduleRun(e, now)
+		if err != nil {
+			_ = store.disable(e.ID)
+			_ = store.setStatus(e.ID, "disabled: "+err.Error())
+			continue
+		}
+		if err := store.advance(e.ID, now, next); err != nil {
+			return err
+		}
+
+		status := "ok"
+		reply, err := runScheduledPrompt(workspace, cfg, systemPrompt, e, now)
+		if err != nil {
+			status = "error: " + err.Error()
+			reply = "定时任务执行失败：" + err.Error()
+		}
+		msg := fmt.Sprintf("⏰ 定时任务 #%d：%s\n\n%s", e.ID, truncateUTF8(firstLine(e.Prompt), 100), strings.TrimSpace(reply))
+		if err := deliverScheduleResult(workspace, e, msg); err != nil {
+			status = "delivery failed: " + err.Error()
+		}
+		_ = store.setStatus(e.ID, status)
+	}
+	return nil
+}
+
+func runScheduledPrompt(workspace string, cfg Config, systemPrompt string, e scheduleEntry, now time.Time) (string, error) {
+	cfg.Policy = schedulePolicy(cfg.Policy)
+	client := NewLLMClient(cfg, workspace, systemPrompt, nil)
+	client.Origin = "schedule:" + strconv.FormatInt(e.ID, 10)
+	prompt := fmt.Sprintf("[Scheduled task #%d, running at %s. No user is watching this run: do the task below with read-only tools and reply with the message that should be sent to the user.]\n\n%s",
+		e.ID, now.In(e.location()).Format("2006-01-02 15:04 MST Mon"), e.Prompt)
+	return client.Chat(prompt)
+}
@@ -0,0 +1,220 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCron_Next(t *testing.T) {
+	sh, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2026-10-18 是周日
+	base := time.Date(2026, 10, 18, 10, 30, 0, 0, sh)
+	cases := []struct {
+		expr  string
+		after time.Time
+		loc   *time.Location
+		want  string
+	}{
+		{"0 9 * * 1", base, sh, "2026-10-19 09:00"},
+		{"0 9 * * mon-fri", time.Date(2026, 10, 23, 9, 0, 0, 0, sh), sh, "2026-10-26 09:00"},
+		{"*/15 * * * *", base, sh, "2026-10-18 10:45"},
+		{"30 8 1,15 * *", base, sh, "2026-11-01 08:30"},
+		{"0 0 29 2 *", base, sh, "2028-02-29 00:00"},
+		{"@monthly", base, sh, "2026-11-01 00:00"},
+		{"0 12 * * 7", base, sh, "2026-10-18 12:00"},
+		// 日和周都受限时满足任一即可：13 号或周五
+		{"0 0 13 * fri", time.Date(2026, 10, 18, 0, 0, 0, 0, sh), sh, "2026-10-23 00:00"},
+		// 夏令时开始当天 2:30 不存在
+		{"30 2 * * *", time.Date(2026, 3, 7, 12, 0, 0, 0, ny), ny, "2026-03-09 02:30"},
+		{"0 9 * * *", base, ny, "2026-10-18 09:00"},
+	}
+	for _, c := range cases {
+		cs, err := parseCron(c.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", c.expr, err)
+		}
+		got := cs.next(c.after, c.loc)
+		if got.In(c.loc).Format("2006-01-02 15:04") != c.want {
+			t.Errorf("%s after %s: got %s, want %s", c.expr, c.after, got.In(c.loc), c.want)
+		}
+	}
+
+	for _, bad := range []string{"0 9 * *", "61 * * * *", "* * * * funday", "*/0 * * * *", "5-1 * * * *", "@often"} {
+		if _, err := parseCron(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestScheduleTools_CreateListCancel(t *testing.T) {
+	ws := t.TempDir()
+	tg := ExecContext{Workspace: ws, Origin: "telegram:42"}
+	other := ExecContext{Workspace: ws, Origin: "telegram:7"}
+	cli := ExecContext{Workspace: ws}
+
+	out, err := toolScheduleCreate(tg, `{"prompt":"check the weekly report","cron":"0 9 * * 1","timezone":"Asia/Shanghai"}`)
+	if err != nil || !strings.Contains(out, "#1 cron 0 9 * * 1 (Asia/Shanghai)") || !strings.Contains(out, "to=telegram:42") {
+		t.Fatalf("create: %q %v", out, err)
+	}
+	if _, err := toolScheduleCreate(other, `{"prompt":"standup","at":"2099-01-02 09:00"}`); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range []string{
+		`{"prompt":"x"}`,
+		`{"prompt":"x","cron":"0 9 * * 1","at":"2099-01-01 09:00"}`,
+		`{"prompt":"x","at":"2001-01-01 09:00"}`,
+		`{"prompt":"x","cron":"0 9 * * 1","timezone":"Mars/Olympus"}`,
+	} {
+		if _, err := toolScheduleCreate(tg, args); err == nil {
+			t.Errorf("expected error for %s", args)
+		}
+	}
+	if _, err := toolScheduleCreate(ExecContext{Workspace: ws, Origin: "schedule:1"}, `{"prompt":"x","cron":"* * * * *"}`); err == nil {
+		t.Fatalf("scheduled runs must not create schedules")
+	}
+
+	out, err = toolScheduleList(tg, `{}`)
+	if err != nil || !strings.Contains(out, "weekly report") || strings.Contains(out, "standup") {
+		t.Fatalf("telegram user should only see own tasks: %q %v", out, err)
+	}
+	out, _ = toolScheduleList(cli, ``)
+	if !strings.Contains(out, "weekly report") || !strings.Contains(out, "standup") {
+		t.Fatalf("CLI should see all tasks: %q", out)
+	}
+
+	if _, err := toolScheduleCancel(other, `{"id":1}`); err == nil {
+		t.Fatalf("cancelling another chat's task should fail")
+	}
+	if out, err := toolScheduleCancel(tg, `{"id":1}`); err != nil || out != "cancelled scheduled task #1" {
+		t.Fatalf("cancel: %q %v", out, err)
+	}
+	if out, _ := toolScheduleList(tg, `{}`); out != "(no scheduled tasks)" {
+		t.Fatalf("cancelled task still listed: %q", out)
+	}
+	if out, _ := toolScheduleList(tg, `{"all":true}`); !strings.Contains(out, "[done]") {
+		t.Fatalf("all should include cancelled tasks: %q", out)
+	}
+}
+
+func TestRunDueSchedules_DeliversWithRestrictedPolicy(t *testing.T) {
+	ws := t.TempDir()
+	var mu sync.Mutex
+	srv, _ := fakeChatServer(t, func(n int) string {
+		if n == 1 {
+			return `[EXEC:fs.write {"path":"memory/x.md","content":"x"}]`
+		}
+		return "该看周报了"
+	})
+	cfg := Config{Provider: "openai", BaseURL: srv.URL, APIKey: "x", ModelName: "m", Policy: DefaultToolPolicy()}
+
+	var delivered []string
+	RegisterScheduleDeliverer("test", func(target, text string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		delivered = append(delivered, target+"|"+text)
+		return nil
+	})
+	t.Cleanup(func() { RegisterScheduleDeliverer("test", nil) })
+
+	if _, err := toolScheduleCreate(ExecContext{Workspace: ws, Origin: "test:chat1"}, `{"prompt":"remind me of the weekly report","cron":"0 9 * * 1","timezone":"UTC"}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := toolScheduleCreate(ExecContext{Workspace: ws}, `{"prompt":"one-off","at":"2099-01-05 09:00","timezone":"UTC"}`); err != nil {
+		t.Fatal(err)
+	}
+
+	// 还没到期时什么都不做
+	if err := runDueSchedules(ws, cfg, "sys", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if len(delivered) != 0 {
+		t.Fatalf("nothing should be due yet: %q", delivered)
+	}
+
+	now := time.Date(2099, 1, 5, 9, 0, 30, 0, time.UTC) // 周一
+	if err := runDueSchedules(ws, cfg, "sys", now); err != nil {
+		t.Fatal(err)
+	}
+	if len(delivered) != 1 || !strings.HasPrefix(delivered[0], "chat1|⏰ 定时任务 #1") || !strings.Contains(delivered[0], "该看周报了") {
+		t.Fatalf("unexpected delivery: %q", delivered)
+	}
+	if _, err := os.Stat(filepath.Join(ws, "memory", "x.md")); err == nil {
+		t.Fatalf("scheduled run must not be allowed to write files")
+	}
+	b, err := os.ReadFile(filepath.Join(ws, "logs", "schedule.log"))
+	if err != nil || !strings.Contains(string(b), "定时任务 #2") {
+		t.Fatalf("CLI task should be written to logs/schedule.log: %q %v", b, err)
+	}
+
+	store, err := openScheduleStore(ws)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	weekly, _ := store.get(1)
+	once, _ := store.get(2)
+	if !weekly.Enabled || weekly.RunCount != 1 || weekly.LastStatus != "ok" || !weekly.NextRun.Equal(time.Date(2099, 1, 12, 9, 0, 0, 0, time.UTC)) {
+		t.Fatalf("weekly task not advanced: %+v", weekly)
+	}
+	if once.Enabled || once.RunCount != 1 {
+		t.Fatalf("one-time task should be finished: %+v", once)
+	}
+
+	// 同一时刻再检查不会重复执行
+	if err := runDueSchedules(ws, cfg, "sys", now); err != nil {
+		t.Fatal(err)
+	}
+	if len(delivered) != 1 {
+		t.Fatalf("task ran twice: %q", delivered)
+	}
+}
+
+func TestScheduleTools_WebOwnershipAndDelivery(t *testing.T) {
+	ws := t.TempDir()
+	web := ExecContext{Workspace: ws, Origin: "web:aaaa"}
+	otherWeb := ExecContext{Workspace: ws, Origin: "web:bbbb"}
+	if _, err := toolScheduleCreate(ExecContext{Workspace: ws, Origin: "telegram:42"}, `{"prompt":"telegram task","cron":"0 9 * * 1"}`); err != nil {
+		t.Fatal(err)
+	}
+	out, err := toolScheduleCreate(web, `{"prompt":"web task","cron":"0 9 * * 1"}`)
+	if err != nil || !strings.Contains(out, "to=web:aaaa") {
+		t.Fatalf("create: %q %v", out, err)
+	}
+	if out, _ := toolScheduleList(web, `{}`); !strings.Contains(out, "web task") || strings.Contains(out, "telegram task") {
+		t.Fatalf("a browser should only see its own tasks: %q", out)
+	}
+	if out, _ := toolScheduleList(otherWeb, `{}`); out != "(no scheduled tasks)" {
+		t.Fatalf("another browser must not see these tasks: %q", out)
+	}
+	if _, err := toolScheduleCancel(otherWeb, `{"id":2}`); err == nil {
+		t.Fatalf("another browser must not cancel the task")
+	}
+
+	var got []string
+	RegisterScheduleDeliverer("web", func(target, text string) error {
+		got = append(got, target+"|"+text)
+		return nil
+	})
+	defer RegisterScheduleDeliverer("web", nil)
+	if err := deliverScheduleResult(ws, scheduleEntry{ID: 2, Channel: "web", Target: "aaaa"}, "done"); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != "aaaa|done" {
+		t.Fatalf("web results must go to the browser: %v", got)
+	}
+	if _, err := os.Stat(filepath.Join(ws, "logs", "schedule.log")); err == nil {
+		t.Fatalf("web results must not only be written to logs/schedule.log")
+	}
+}
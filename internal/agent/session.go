@@ -275,5 +275,18 @@ func (sm *SessionManager) RecordToolResults(calls []ExecCall, results []ToolResu
 	if s == nil {
 		return
 	}
-	_ = sm.store.InsertToolAudit(s.SessionID, calls, results)
+	// selfAuditedTools 在 ExecuteCalls 中已经写过审计，这里跳过以免重复
+	var auditCalls []ExecCall
+	var auditResults []ToolResult
+	for i, call := range calls {
+		if selfAuditedTools[call.Tool] || i >= len(results) {
+			continue
+		}
+		auditCalls = append(auditCalls, call)
+		auditResults = append(auditResults, results[i])
+	}
+	if len(auditCalls) == 0 {
+		return
+	}
+	_ = sm.store.InsertToolAudit(s.SessionID, auditCalls, auditResults)
 }
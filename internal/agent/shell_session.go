@@ -0,0 +1,363 @@
+package agent
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// shellSession 是一个长驻的 sh 进程：cwd 与 export 的变量在多次调用之间保留。
+// 每条命令执行后打印带随机 nonce 的哨兵行，用来切分输出并取回退出码与当前目录。
+type shellSession struct {
+	// mu 串行化命令，cwd 只在持有 mu 时访问
+	mu        sync.Mutex
+	key       string
+	workspace string
+	cwd       string
+	nonce     string
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	lines     chan string
+	done      chan struct{}
+
+	// state 保护下面的字段；清理空闲会话时不能等 mu（命令可能要跑好几分钟）
+	state    sync.Mutex
+	lastUsed time.Time
+	closed   bool
+	// users 是已经取到会话、还没执行完的调用数，大于 0 时不会被当作空闲会话清理
+	users int
+}
+
+type shellSessionManager struct {
+	mu       sync.Mutex
+	sessions map[string]*shellSession
+}
+
+var defaultShellSessions = &shellSessionManager{sessions: map[string]*shellSession{}}
+
+func shellSessionIdleTimeout() time.Duration {
+	return time.Duration(parseIntEnv("NIBOT_SHELL_IDLE_SECONDS", 1800, 60, 24*3600)) * time.Second
+}
+
+func shellSessionMax() int {
+	return parseIntEnv("NIBOT_SHELL_MAX_SESSIONS", 8, 1, 64)
+}
+
+func shellSessionKey(ctx ExecContext) string {
+	id := strings.TrimSpace(ctx.Session)
+	if id == "" {
+		id = "default"
+	}
+	return ctx.Workspace + "\x00" + id
+}
+
+func (m *shellSessionManager) get(ctx ExecContext) (*shellSession, error) {
+	key := shellSessionKey(ctx)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	idle := shellSessionIdleTimeout()
+	for k, s := range m.sessions {
+		if k != key && s.idle(idle) {
+			s.close()
+			delete(m.sessions, k)
+		}
+	}
+	if s, ok := m.sessions[key]; ok && s.acquire() {
+		return s, nil
+	}
+	if len(m.sessions) >= shellSessionMax() {
+		return nil, fmt.Errorf("too many shell sessions (max %d, set NIBOT_SHELL_MAX_SESSIONS)", shellSessionMax())
+	}
+	s, err := startShellSession(key, ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.acquire()
+	m.sessions[key] = s
+	return s, nil
+}
+
+func (m *shellSessionManager) drop(ctx ExecContext) bool {
+	key := shellSessionKey(ctx)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[key]
+	if !ok {
+		return false
+	}
+	s.close()
+	delete(m.sessions, key)
+	return true
+}
+
+func startShellSession(key string, ctx ExecContext) (*shellSession, error) {
+	ws, err := filepath.Abs(ctx.Workspace)
+	if err != nil {
+		return nil, err
+	}
+	if real, err := filepath.EvalSymlinks(ws); err == nil {
+		ws = real
+	}
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	cmd, err := sandboxedCommand(ctx, []string{"sh"}, childEnvFor(ctx, "shell.session", nil, nil))
+	if err != nil {
+		return nil, err
+	}
+	cmd.Dir = ws
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		pr.Close()
+		pw.Close()
+		return nil, err
+	}
+	if err := startCommand(cmd, execRlimitsFromEnv()); err != nil {
+		pr.Close()
+		pw.Close()
+		return nil, err
+	}
+	pw.Close()
+
+	s := &shellSession{
+		key:       key,
+		workspace: ws,
+		cwd:       ws,
+		nonce:     "__NIBOT_" + hex.EncodeToString(nonce) + "__",
+		cmd:       cmd,
+		stdin:     stdin,
+		lines:     make(chan string, 256),
+		done:      make(chan struct{}),
+		lastUsed:  time.Now(),
+	}
+	go func() {
+		defer pr.Close()
+		defer close(s.lines)
+		r := bufio.NewReaderSize(pr, 64*1024)
+		for {
+			line, err := r.ReadString('\n')
+			if line != "" {
+				select {
+				case s.lines <- strings.TrimRight(line, "\r\n"):
+				case <-s.done:
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	go func() { _ = cmd.Wait() }()
+	return s, nil
+}
+
+// acquire 登记一个使用者；会话已关闭时返回 false。使用完后调用 release。
+func (s *shellSession) acquire() bool {
+	s.state.Lock()
+	defer s.state.Unlock()
+	if s.closed {
+		return false
+	}
+	s.users++
+	return true
+}
+
+func (s *shellSession) release() {
+	s.state.Lock()
+	defer s.state.Unlock()
+	s.users--
+	s.lastUsed = time.Now()
+}
+
+// idle 判断会话是否可以清理：没有正在执行的调用，且空闲超过 timeout。
+func (s *shellSession) idle(timeout time.Duration) bool {
+	s.state.Lock()
+	defer s.state.Unlock()
+	return s.closed || (s.users == 0 && time.Since(s.lastUsed) > timeout)
+}
+
+func (s *shellSession) close() {
+	s.state.Lock()
+	if s.closed {
+		s.state.Unlock()
+		return
+	}
+	s.closed = true
+	s.state.Unlock()
+	close(s.done)
+	_ = s.stdin.Close()
+	killProcessTree(s.cmd)
+}
+
+// run 执行一条命令并等待哨兵行。命令通过 eval 包裹，语法错误只会让这一条失败，
+// 不会让 shell 卡在未闭合的引号上；stdin 重定向到 /dev/null，避免吞掉后续输入。
+func (s *shellSession) run(command string, timeout time.Duration) (output string, exitCode int, err error) {
+	script := fmt.Sprintf("eval %s </dev/null\n__nibot_rc=$?\nprintf '\\n%%s %%d %%s\\n' '%s' \"$__nibot_rc\" \"$PWD\"\n", shellQuote(command), s.nonce)
+	if _, err := io.WriteString(s.stdin, script); err != nil {
+		s.close()
+		return "", -1, fmt.Errorf("shell session is gone: %w", err)
+	}
+
+	out := newCappedBuffer(execMaxOutputBytes())
+	deadline := time.After(timeout)
+	for {
+		select {
+		case line, ok := <-s.lines:
+			if !ok {
+				s.close()
+				return strings.TrimSpace(out.String()), -1, fmt.Errorf("shell exited; session reset")
+			}
+			if strings.HasPrefix(line, s.nonce+" ") {
+				rest := strings.TrimPrefix(line, s.nonce+" ")
+				rcText, pwd, _ := strings.Cut(rest, " ")
+				rc, _ := strconv.Atoi(rcText)
+				if pwd != "" {
+					s.cwd = pwd
+				}
+				return strings.TrimSpace(out.String()), rc, nil
+			}
+			out.Write([]byte(line + "\n"))
+		case <-deadline:
+			s.close()
+			return strings.TrimSpace(out.String()), -1, fmt.Errorf("timeout after %s; session reset", timeout)
+		}
+	}
+}
+
+func (s *shellSession) cwdInWorkspace() bool {
+	cwd := s.cwd
+	if real, err := filepath.EvalSymlinks(cwd); err == nil {
+		cwd = real
+	}
+	rel, err := filepath.Rel(s.workspace, cwd)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (s *shellSession) relCwd() string {
+	rel, err := filepath.Rel(s.workspace, s.cwd)
+	if err != nil || rel == "." {
+		return "."
+	}
+	return filepath.ToSlash(rel)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+type shellSessionArgs struct {
+	Command        string `json:"command"`
+	TimeoutSeconds int    `json:"timeoutSeconds"`
+	Reset          bool   `json:"reset"`
+}
+
+func toolShellSession(ctx ExecContext, argsRaw string) (string, error) {
+	if !strings.HasPrefix(strings.TrimSpace(argsRaw), "{") {
+		return "", fmt.Errorf("shell.session requires JSON args: {\"command\":\"...\",\"timeoutSeconds\":30}")
+	}
+	var a shellSessionArgs
+	if err := json.Unmarshal([]byte(argsRaw), &a); err != nil {
+		return "", fmt.Errorf("invalid JSON args for shell.session: %w", err)
+	}
+	if runtime.GOOS == "windows" {
+		return "", fmt.Errorf("shell.session is not supported on windows; use runtime.exec")
+	}
+	if os.Getenv("NIBOT_ENABLE_EXEC") != "1" {
+		return "", fmt.Errorf("shell.session disabled (set NIBOT_ENABLE_EXEC=1 to enable)")
+	}
+	if a.Reset {
+		dropped := defaultShellSessions.drop(ctx)
+		if strings.TrimSpace(a.Command) == "" {
+			if dropped {
+				return "shell session reset", nil
+			}
+			return "no shell session to reset", nil
+		}
+	}
+	if strings.TrimSpace(a.Command) == "" {
+		return "", fmt.Errorf("shell.session requires command")
+	}
+	if err := ctx.Policy.CheckRuntimeCommand(ctx.Workspace, a.Command); err != nil {
+		return "", fmt.Errorf("shell.session command denied by policy: %v", err)
+	}
+	timeout := time.Duration(a.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	if timeout > 10*time.Minute {
+		timeout = 10 * time.Minute
+	}
+
+	s, err := defaultShellSessions.get(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer s.release()
+	release := acquireExecSlot()
+	defer release()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 执行前确认 cwd 仍在工作区内：上一条命令结束后，cwd 经过的符号链接可能被改指到工作区外
+	note := ""
+	if !s.cwdInWorkspace() {
+		if _, _, err := s.run("cd -- "+shellQuote(s.workspace), 10*time.Second); err != nil || !s.cwdInWorkspace() {
+			defaultShellSessions.drop(ctx)
+			return formatShellSessionOutput(".", -1, "", "cwd is outside workspace and could not be reset; session reset"), fmt.Errorf("shell.session failed")
+		}
+		note = "cwd was outside workspace; reset to . before running"
+	}
+	prev := s.cwd
+	out, rc, err := s.run(a.Command, timeout)
+	if err != nil {
+		defaultShellSessions.drop(ctx)
+		return formatShellSessionOutput(".", -1, out, err.Error()), fmt.Errorf("shell.session failed")
+	}
+	if !s.cwdInWorkspace() {
+		if _, _, err := s.run("cd -- "+shellQuote(prev), 10*time.Second); err != nil {
+			defaultShellSessions.drop(ctx)
+			return formatShellSessionOutput(".", rc, out, "cwd left workspace and could not be restored; session reset"), fmt.Errorf("shell.session failed")
+		}
+		note = strings.TrimPrefix(note+"; cwd left workspace; reset to "+s.relCwd(), "; ")
+	}
+	res := formatShellSessionOutput(s.relCwd(), rc, out, note)
+	if rc != 0 {
+		return res, fmt.Errorf("shell.session command exited with code %d", rc)
+	}
+	return res, nil
+}
+
+func formatShellSessionOutput(cwd string, rc int, out string, note string) string {
+	if out == "" {
+		out = "(no output)"
+	}
+	header := fmt.Sprintf("[cwd=%s exit_code=%d]", cwd, rc)
+	if note != "" {
+		header += "\n[" + note + "]"
+	}
+	return header + "\n" + out
+}
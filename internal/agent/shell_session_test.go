@@ -0,0 +1,200 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShellSession_KeepsCwdAndEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell.session is unix only")
+	}
+	t.Setenv("NIBOT_ENABLE_EXEC", "1")
+	ws := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(ws, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	ctx := ExecContext{Workspace: ws, Policy: DefaultToolPolicy(), Session: "t1"}
+	defer defaultShellSessions.drop(ctx)
+
+	if _, err := toolShellSession(ctx, `{"command":"cd sub && export NIBOT_T=hello"}`); err != nil {
+		t.Fatalf("first command failed: %v", err)
+	}
+	out, err := toolShellSession(ctx, `{"command":"echo $NIBOT_T; basename \"$PWD\""}`)
+	if err != nil {
+		t.Fatalf("second command failed: %v", err)
+	}
+	if !strings.Contains(out, "[cwd=sub exit_code=0]") || !strings.Contains(out, "hello") {
+		t.Fatalf("unexpected output: %q", out)
+	}
+
+	other := ExecContext{Workspace: ws, Policy: DefaultToolPolicy(), Session: "t2"}
+	defer defaultShellSessions.drop(other)
+	out, err = toolShellSession(other, `{"command":"echo \"[$NIBOT_T]\""}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "[cwd=. exit_code=0]") || !strings.Contains(out, "[]") {
+		t.Fatalf("sessions should be isolated, got %q", out)
+	}
+}
+
+func TestShellSession_ExitCodeAndSyntaxError(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell.session is unix only")
+	}
+	t.Setenv("NIBOT_ENABLE_EXEC", "1")
+	ctx := ExecContext{Workspace: t.TempDir(), Policy: DefaultToolPolicy(), Session: "t3"}
+	defer defaultShellSessions.drop(ctx)
+
+	out, err := toolShellSession(ctx, `{"command":"echo oops 1>&2; false"}`)
+	if err == nil || !strings.Contains(out, "exit_code=1") || !strings.Contains(out, "oops") {
+		t.Fatalf("expected exit code 1, got out=%q err=%v", out, err)
+	}
+	if _, err := toolShellSession(ctx, `{"command":"echo 'unterminated"}`); err == nil {
+		t.Fatalf("expected syntax error")
+	}
+	out, err = toolShellSession(ctx, `{"command":"echo still-alive"}`)
+	if err != nil || !strings.Contains(out, "still-alive") {
+		t.Fatalf("session should survive syntax errors, got out=%q err=%v", out, err)
+	}
+}
+
+func TestShellSession_ConfinedToWorkspace(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell.session is unix only")
+	}
+	t.Setenv("NIBOT_ENABLE_EXEC", "1")
+	ctx := ExecContext{Workspace: t.TempDir(), Policy: DefaultToolPolicy(), Session: "t4"}
+	defer defaultShellSessions.drop(ctx)
+
+	out, err := toolShellSession(ctx, `{"command":"cd /"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "cwd left workspace") || !strings.Contains(out, "[cwd=.") {
+		t.Fatalf("expected cwd reset, got %q", out)
+	}
+}
+
+func TestShellSession_ExitResetsSession(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell.session is unix only")
+	}
+	t.Setenv("NIBOT_ENABLE_EXEC", "1")
+	ctx := ExecContext{Workspace: t.TempDir(), Policy: DefaultToolPolicy(), Session: "t5"}
+	defer defaultShellSessions.drop(ctx)
+
+	if _, err := toolShellSession(ctx, `{"command":"export A=1; exit 3"}`); err == nil {
+		t.Fatalf("expected error after shell exit")
+	}
+	out, err := toolShellSession(ctx, `{"command":"echo \"a=$A\""}`)
+	if err != nil || !strings.Contains(out, "a=") || strings.Contains(out, "a=1") {
+		t.Fatalf("expected fresh session, got out=%q err=%v", out, err)
+	}
+}
+
+func TestShellSession_PolicyAndExecGate(t *testing.T) {
+	t.Setenv("NIBOT_ENABLE_EXEC", "")
+	ctx := ExecContext{Workspace: t.TempDir(), Policy: DefaultToolPolicy(), Session: "t6"}
+	if _, err := toolShellSession(ctx, `{"command":"echo x"}`); err == nil {
+		t.Fatalf("expected shell.session to be disabled")
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	t.Setenv("NIBOT_ENABLE_EXEC", "1")
+	p := DefaultToolPolicy()
+	p.AllowedRuntimePrefixes = []string{"echo"}
+	ctx.Policy = p
+	defer defaultShellSessions.drop(ctx)
+	if _, err := toolShellSession(ctx, `{"command":"rm -rf x"}`); err == nil || !strings.Contains(err.Error(), "denied by policy") {
+		t.Fatalf("expected policy denial, got %v", err)
+	}
+}
+
+func TestShellSession_CwdCheckedBeforeEachCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell.session is unix only")
+	}
+	t.Setenv("NIBOT_ENABLE_EXEC", "1")
+	ws := t.TempDir()
+	outside := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(ws, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(ws, "sub"), filepath.Join(ws, "link")); err != nil {
+		t.Fatal(err)
+	}
+	ctx := ExecContext{Workspace: ws, Policy: DefaultToolPolicy(), Session: "t7"}
+	defer defaultShellSessions.drop(ctx)
+
+	if out, err := toolShellSession(ctx, `{"command":"cd link"}`); err != nil || !strings.Contains(out, "[cwd=link") {
+		t.Fatalf("cd link failed: out=%q err=%v", out, err)
+	}
+	// 两次调用之间把链接改指到工作区外，下一条命令不能在工作区外执行
+	if err := os.Remove(filepath.Join(ws, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(ws, "link")); err != nil {
+		t.Fatal(err)
+	}
+	out, err := toolShellSession(ctx, `{"command":"touch marker"}`)
+	if err != nil || !strings.Contains(out, "cwd was outside workspace") {
+		t.Fatalf("expected a reset before running, got out=%q err=%v", out, err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "marker")); err == nil {
+		t.Fatalf("the command ran outside the workspace")
+	}
+	if _, err := os.Stat(filepath.Join(ws, "marker")); err != nil {
+		t.Fatalf("expected the command to run in the workspace root: %v", err)
+	}
+}
+
+func TestShellSession_IdleReaperSkipsBusySessions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell.session is unix only")
+	}
+	m := &shellSessionManager{sessions: map[string]*shellSession{}}
+	busyCtx := ExecContext{Workspace: t.TempDir(), Policy: DefaultToolPolicy(), Session: "busy"}
+	otherCtx := ExecContext{Workspace: busyCtx.Workspace, Policy: DefaultToolPolicy(), Session: "other"}
+	defer m.drop(busyCtx)
+	defer m.drop(otherCtx)
+
+	busy, err := m.get(busyCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	busy.state.Lock()
+	busy.lastUsed = time.Now().Add(-48 * time.Hour)
+	busy.state.Unlock()
+
+	// 会话仍在执行命令（未 release），即使很久没有结束过命令也不能被清理
+	other, err := m.get(otherCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.release()
+	if busy.idle(time.Hour) {
+		t.Fatalf("a session in use must not count as idle")
+	}
+	if _, ok := m.sessions[shellSessionKey(busyCtx)]; !ok {
+		t.Fatalf("a busy session was reaped")
+	}
+
+	busy.release()
+	busy.state.Lock()
+	busy.lastUsed = time.Now().Add(-48 * time.Hour)
+	busy.state.Unlock()
+	if _, err := m.get(otherCtx); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.sessions[shellSessionKey(busyCtx)]; ok {
+		t.Fatalf("an idle session should be reaped")
+	}
+}
@@ -44,6 +44,7 @@ func DiagnoseSkills(workspace string) ([]SkillIssue, error) {
 			}
 		}
 	}
+	issues = append(issues, diagnoseOpenAPITools(workspace)...)
 	return issues, nil
 }
 
@@ -19,8 +19,13 @@ type SQLiteStore struct {
 	mu sync.Mutex
 }
 
+// sqliteStorageEnabled 判断是否启用了 SQLite 会话存储（NIBOT_STORAGE=sqlite 或 NIBOT_MEMORY_DB=sqlite）。
+func sqliteStorageEnabled() bool {
+	return stringsTrimLower(os.Getenv("NIBOT_STORAGE")) == "sqlite" || stringsTrimLower(os.Getenv("NIBOT_MEMORY_DB")) == "sqlite"
+}
+
 func OpenSQLiteStore(workspace string) (*SQLiteStore, error) {
-	if stringsTrimLower(os.Getenv("NIBOT_STORAGE")) != "sqlite" && stringsTrimLower(os.Getenv("NIBOT_MEMORY_DB")) != "sqlite" {
+	if !sqliteStorageEnabled() {
 		return nil, nil
 	}
 	p := filepath.Join(workspace, "data", "nibot.db")
@@ -39,6 +44,49 @@ func OpenSQLiteStore(workspace string) (*SQLiteStore, error) {
 	return s, nil
 }
 
+// openWorkspaceDB 打开 data/nibot.db（不依赖 NIBOT_STORAGE），供定时任务、通知审计等始终需要落库的功能使用。
+func openWorkspaceDB(workspace string) (*sql.DB, error) {
+	p := filepath.Join(workspace, "data", "nibot.db")
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return nil, err
+	}
+	return sql.Open("sqlite", p+"?_pragma=busy_timeout(5000)")
+}
+
+// appendToolAudit 直接写入一条 tool_audits 记录，用于必须留痕的工具（见 selfAuditedTools）。
+func appendToolAudit(workspace, sessionID string, call ExecCall, r ToolResult) error {
+	db, err := openWorkspaceDB(workspace)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.Exec(`create table if not exists tool_audits (
+		id integer primary key autoincrement,
+		session_id text,
+		tool text,
+		args text,
+		ok integer,
+		error text,
+		output text,
+		created_at text
+	);`)
+	if err != nil {
+		return err
+	}
+	if err := ensureToolAuditEnvColumn(db); err != nil {
+		return err
+	}
+	ok := 0
+	if r.OK {
+		ok = 1
+	}
+	_, err = db.Exec(
+		`insert into tool_audits(session_id,tool,args,ok,error,output,env,created_at) values(?,?,?,?,?,?,?,?)`,
+		sessionID, call.Tool, redactSecrets(call.ArgsRaw), ok, redactSecrets(r.Error), redactSecrets(r.Output), strings.Join(r.Env, ","), time.Now().Format(time.RFC3339Nano),
+	)
+	return err
+}
+
 func (s *SQLiteStore) Close() {
 	if s == nil || s.db == nil {
 		return
@@ -99,6 +147,9 @@ func (s *SQLiteStore) ensureSchema() error {
 	if err := s.ensureMemoryColumnsLocked(); err != nil {
 		return err
 	}
+	if err := ensureToolAuditEnvColumn(s.db); err != nil {
+		return err
+	}
 	if err := s.ensureMemoryIndexesLocked(); err != nil {
 		return err
 	}
@@ -126,6 +177,19 @@ func (s *SQLiteStore) ensureMemoryColumnsLocked() error {
 	return nil
 }
 
+// ensureToolAuditEnvColumn 为旧数据库的 tool_audits 补上 env 列（传给子进程的变量名）。
+func ensureToolAuditEnvColumn(db *sql.DB) error {
+	var n int
+	if err := db.QueryRow(`select count(*) from pragma_table_info('tool_audits') where name = 'env'`).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	_, err := db.Exec(`alter table tool_audits add column env text;`)
+	return err
+}
+
 func (s *SQLiteStore) ensureMemoryIndexesLocked() error {
 	stmts := []string{
 		`create index if not exists idx_memories_scope_id on memories(scope, id);`,
@@ -230,13 +294,14 @@ func (s *SQLiteStore) InsertToolAudit(sessionID string, calls []ExecCall, result
 			ok = 1
 		}
 		_, err := s.db.Exec(
-			`insert into tool_audits(session_id,tool,args,ok,error,output,created_at) values(?,?,?,?,?,?,?)`,
+			`insert into tool_audits(session_id,tool,args,ok,error,output,env,created_at) values(?,?,?,?,?,?,?,?)`,
 			sessionID,
 			call.Tool,
 			call.ArgsRaw,
 			ok,
 			r.Error,
 			r.Output,
+			strings.Join(r.Env, ","),
 			now,
 		)
 		if err != nil {
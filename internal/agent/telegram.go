@@ -175,6 +175,14 @@ func (tb *TelegramBot) Start(ctx context.Context) error {
 	tb.mu.Unlock()
 
 	log.Printf("Starting Telegram bot @%s", tb.bot.Self.UserName)
+	RegisterScheduleDeliverer("telegram", func(target, text string) error {
+		chatID, err := strconv.ParseInt(target, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid telegram chat id %q", target)
+		}
+		tb.sendMessage(chatID, text)
+		return nil
+	})
 
 	updateConfig := tgbotapi.NewUpdate(0)
 	updateConfig.Timeout = tb.config.LongPollingTimeout
@@ -191,6 +199,11 @@ func (tb *TelegramBot) Start(ctx context.Context) error {
 				log.Println("Telegram updates channel closed")
 				return nil
 			}
+			// 审批按钮不占并发名额：等待审批的对话本身就占着名额
+			if update.CallbackQuery != nil {
+				tb.handleCallback(update.CallbackQuery)
+				continue
+			}
 			select {
 			case tb.sem <- struct{}{}:
 			case <-ctx.Done():
@@ -236,12 +249,15 @@ func (tb *TelegramBot) handleUpdate(update tgbotapi.Update) {
 		return
 	}
 
-	if strings.HasPrefix(text, "/") {
+	if strings.HasPrefix(text, "/") && !isContinueCommand(text) {
 		tb.handleCommand(userID, chatID, text)
 		return
 	}
 
 	session := tb.getUserSession(userID)
+	session.client.Origin = fmt.Sprintf("telegram:%d", chatID)
+	session.client.User = strconv.FormatInt(userID, 10)
+	session.client.Approver = tb.approver(session, chatID, userID)
 	response, err := tb.chatWithTools(session, text)
 	if err != nil {
 		log.Printf("Error processing message: %v", err)
@@ -252,6 +268,54 @@ func (tb *TelegramBot) handleUpdate(update tgbotapi.Update) {
 	tb.sendMessage(chatID, response)
 }
 
+// approver 把审批请求作为带按钮的消息发到 chatID，只有 userID 本人可以点按钮。
+func (tb *TelegramBot) approver(us *telegramUserSession, chatID, userID int64) *RemoteApprover {
+	return &RemoteApprover{
+		Owner:   strconv.FormatInt(userID, 10),
+		Session: us.client.ExecSession(),
+		Send: func(req ApprovalRequest) error {
+			msg := tgbotapi.NewMessage(chatID, truncateRunes(approvalPromptText(req), 3500))
+			msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("✅ 允许", approvalCallbackData(req.ID, ApprovalAllow)),
+				tgbotapi.NewInlineKeyboardButtonData("🔁 本次会话允许", approvalCallbackData(req.ID, ApprovalSession)),
+				tgbotapi.NewInlineKeyboardButtonData("❌ 拒绝", approvalCallbackData(req.ID, ApprovalDeny)),
+			))
+			_, err := tb.bot.Send(msg)
+			return err
+		},
+	}
+}
+
+func (tb *TelegramBot) handleCallback(cq *tgbotapi.CallbackQuery) {
+	id, decision, ok := parseApprovalCallback(cq.Data)
+	if !ok || cq.From == nil {
+		return
+	}
+	answer := func(text string) {
+		if _, err := tb.bot.Request(tgbotapi.NewCallback(cq.ID, text)); err != nil {
+			log.Printf("Error answering callback: %v", err)
+		}
+	}
+	if !tb.isUserAllowed(cq.From.ID) {
+		answer("抱歉，您没有权限使用此机器人")
+		return
+	}
+	req, err := ResolveApproval(id, decision, strconv.FormatInt(cq.From.ID, 10))
+	if err != nil {
+		answer(err.Error())
+		return
+	}
+	label := approvalDecisionLabel(req, decision)
+	answer(label)
+	if cq.Message != nil && cq.Message.Chat != nil {
+		// 编辑消息时不带按钮，避免重复点击
+		edit := tgbotapi.NewEditMessageText(cq.Message.Chat.ID, cq.Message.MessageID, truncateRunes(cq.Message.Text+"\n\n"+label, 3500))
+		if _, err := tb.bot.Request(edit); err != nil {
+			log.Printf("Error editing approval message: %v", err)
+		}
+	}
+}
+
 func (tb *TelegramBot) isUserAllowed(userID int64) bool {
 	if len(tb.config.AllowedUserIDs) == 0 {
 		return true
@@ -319,7 +383,7 @@ func (tb *TelegramBot) handleCommand(userID int64, chatID int64, text string) {
 	case "/start":
 		tb.sendMessage(chatID, "欢迎使用 Ni Bot！\n\n直接发送消息即可开始对话。\n\n可用命令：\n/help\n/skills\n/reset\n/clear\n/reload")
 	case "/help":
-		tb.sendMessage(chatID, "用法：\n- 直接发送消息与 Ni Bot 对话\n- /skills 查看技能\n- /reset 重置该用户会话\n- /reload 重新加载 System Prompt\n- /clear 清屏")
+		tb.sendMessage(chatID, "用法：\n- 直接发送消息与 Ni Bot 对话\n- /skills 查看技能\n- /reset 重置该用户会话\n- /reload 重新加载 System Prompt\n- /clear 清屏\n- continue 工具调用达到上限后继续执行\n- 需要审批的操作会发送带按钮的消息，超时未确认自动拒绝")
 	case "/clear":
 		tb.sendMessage(chatID, strings.Repeat("\n", 40))
 	case "/reset":
@@ -346,6 +410,7 @@ func (tb *TelegramBot) resetUserSession(userID int64) {
 		if us.sessionManager != nil {
 			us.sessionManager.SessionEnded()
 		}
+		forgetSessionApprovals(us.client.ExecSession())
 		delete(tb.sessions, userID)
 	}
 }
@@ -402,34 +467,12 @@ func (tb *TelegramBot) chatWithTools(us *telegramUserSession, text string) (stri
 		us.sessionManager.RecordMessage("user", text)
 	}
 
+	// 工具调用、迭代上限和重复检测都在 Chat 中处理；continue 会执行上次未执行的调用
 	resp, err := us.client.Chat(text)
 	if err != nil {
 		return "", err
 	}
 
-	for i := 0; i < us.client.MaxToolIters; i++ {
-		calls := ExtractExecCalls(resp)
-		if len(calls) == 0 {
-			break
-		}
-		if us.sessionManager != nil {
-			for range calls {
-				us.sessionManager.IncrementToolCalls()
-			}
-		}
-
-		results := ExecuteCalls(ExecContext{Workspace: tb.workspace, Policy: tb.cfg.Policy}, calls, nil)
-		if us.sessionManager != nil {
-			us.sessionManager.RecordToolResults(calls, results)
-		}
-
-		toolMsg := formatToolResults(results)
-		resp, err = us.client.Chat(toolMsg)
-		if err != nil {
-			return "", err
-		}
-	}
-
 	if us.sessionManager != nil {
 		us.sessionManager.RecordMessage("assistant", resp)
 	}
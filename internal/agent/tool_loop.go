@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// 工具迭代上限与重复检测：每轮对话最多执行 NIBOT_MAX_TOOL_ITERS 轮工具调用；
+// 同一调用连续返回相同结果、或同一工具连续报同样的错误达到 NIBOT_TOOL_REPEAT_LIMIT 次时，
+// 停止执行并告诉模型原因。剩余未执行的调用保留下来，用户输入 continue 后继续。
+
+func maxToolIters() int {
+	return parseIntEnv("NIBOT_MAX_TOOL_ITERS", 5, 1, 100)
+}
+
+func toolRepeatLimit() int {
+	return parseIntEnv("NIBOT_TOOL_REPEAT_LIMIT", 3, 2, 20)
+}
+
+// isContinueCommand 判断用户输入是否是继续执行工具调用的指令。
+func isContinueCommand(input string) bool {
+	switch strings.ToLower(strings.TrimSpace(input)) {
+	case "continue", "/continue", "继续":
+		return true
+	}
+	return false
+}
+
+type toolLoopGuard struct {
+	limit    int
+	iter     int
+	streak   map[string]int
+	lastSeen map[string]int
+	labels   map[string]string
+}
+
+func newToolLoopGuard() *toolLoopGuard {
+	return &toolLoopGuard{
+		limit:    toolRepeatLimit(),
+		streak:   map[string]int{},
+		lastSeen: map[string]int{},
+		labels:   map[string]string{},
+	}
+}
+
+// observe 记录一轮工具调用的结果；检测到重复时返回给模型和用户看的说明，否则返回空串。
+func (g *toolLoopGuard) observe(calls []ExecCall, results []ToolResult) string {
+	g.iter++
+	keys := map[string]bool{}
+	for i, call := range calls {
+		if i >= len(results) {
+			break
+		}
+		r := results[i]
+		args := canonicalArgs(call.ArgsRaw)
+		callKey := "call\x00" + call.Tool + "\x00" + args + "\x00" + fmt.Sprint(r.OK) + "\x00" + r.Error + "\x00" + r.Output
+		keys[callKey] = true
+		g.labels[callKey] = fmt.Sprintf("the same call %s %s returned the same result %%d times in a row", call.Tool, truncateUTF8(args, 200))
+		if !r.OK && r.Error != "" {
+			errKey := "err\x00" + call.Tool + "\x00" + r.Error
+			keys[errKey] = true
+			g.labels[errKey] = fmt.Sprintf("%s failed %%d times in a row with the same error: %s", call.Tool, truncateUTF8(firstLine(r.Error), 300))
+		}
+	}
+
+	var hits []string
+	for k := range keys {
+		if g.lastSeen[k] == g.iter-1 {
+			g.streak[k]++
+		} else {
+			g.streak[k] = 1
+		}
+		g.lastSeen[k] = g.iter
+		if g.streak[k] >= g.limit {
+			hits = append(hits, fmt.Sprintf(g.labels[k], g.streak[k]))
+		}
+	}
+	sort.Strings(hits)
+	return strings.Join(hits, "; ")
+}
+
+// canonicalArgs 把 JSON 参数压缩成统一格式，避免仅空白不同的调用被当成不同调用。
+func canonicalArgs(argsRaw string) string {
+	s := strings.TrimSpace(argsRaw)
+	var buf bytes.Buffer
+	if json.Compact(&buf, []byte(s)) == nil {
+		return buf.String()
+	}
+	return s
+}
+
+func loopStopNote(reason string) string {
+	return "\nLOOP DETECTED: " + reason + ". Tool execution is paused for this turn. " +
+		"Do not repeat the same call; explain to the user what is blocking progress and what you need from them. " +
+		"The user can type continue to allow more tool calls.\n"
+}
+
+// tokenBudgetHint 和 pendingToolHint 一样告诉用户还有调用未执行，原因是 token 预算用完。
+func tokenBudgetHint(pending, used, budget int) string {
+	return fmt.Sprintf("[已用完本轮 token 预算（约 %d / %d）。还有 %d 个工具调用未执行，输入 continue 继续]", used, budget, pending)
+}
+
+// pendingToolHint 是给用户看的提示：还有多少调用没有执行，以及如何继续。
+func pendingToolHint(pending int, loopStopped bool, iters int) string {
+	reason := fmt.Sprintf("已达到本轮工具调用上限（%d 轮，可用 NIBOT_MAX_TOOL_ITERS 调整）", iters)
+	if loopStopped {
+		reason = "检测到重复的工具调用，已暂停执行"
+	}
+	return fmt.Sprintf("[%s。还有 %d 个工具调用未执行，输入 continue 继续]", reason, pending)
+}
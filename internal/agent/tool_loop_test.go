@@ -0,0 +1,154 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestToolLoopGuard_DetectsRepetition(t *testing.T) {
+	g := newToolLoopGuard()
+	call := []ExecCall{{Tool: "fs.read", ArgsRaw: `{"path": "a.md"}`}}
+	ok := []ToolResult{{Tool: "fs.read", OK: true, Output: "same"}}
+	if g.observe(call, ok) != "" || g.observe([]ExecCall{{Tool: "fs.read", ArgsRaw: `{"path":"a.md"}`}}, ok) != "" {
+		t.Fatalf("two repetitions should not trigger")
+	}
+	if msg := g.observe(call, ok); !strings.Contains(msg, "same result 3 times") {
+		t.Fatalf("expected repeated call detection, got %q", msg)
+	}
+
+	// 输出在变化（例如轮询任务状态）时不算重复
+	g = newToolLoopGuard()
+	for i := 0; i < 5; i++ {
+		if msg := g.observe(call, []ToolResult{{Tool: "fs.read", OK: true, Output: fmt.Sprint(i)}}); msg != "" {
+			t.Fatalf("changing output flagged as loop: %q", msg)
+		}
+	}
+
+	// 参数不同但错误相同
+	g = newToolLoopGuard()
+	var msg string
+	for i := 0; i < 3; i++ {
+		msg = g.observe([]ExecCall{{Tool: "runtime.exec", ArgsRaw: fmt.Sprintf(`{"command":"x%d"}`, i)}},
+			[]ToolResult{{Tool: "runtime.exec", Error: "disabled by policy"}})
+	}
+	if !strings.Contains(msg, "runtime.exec failed 3 times in a row with the same error: disabled by policy") {
+		t.Fatalf("expected repeated error detection, got %q", msg)
+	}
+}
+
+// fakeChatServer 是一个 OpenAI 兼容接口，每次请求都用 reply 生成回复内容。
+func fakeChatServer(t *testing.T, reply func(n int) string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var n atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content := reply(int(n.Add(1)))
+		b, _ := json.Marshal(map[string]any{"choices": []any{map[string]any{"message": map[string]any{"role": "assistant", "content": content}}}})
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(b)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &n
+}
+
+func TestChat_StopsRepeatedFailingCall(t *testing.T) {
+	srv, requests := fakeChatServer(t, func(int) string {
+		return `[EXEC:fs.read {"path":"memory/missing.md"}]`
+	})
+	c := NewLLMClient(Config{Provider: "openai", BaseURL: srv.URL, APIKey: "x", ModelName: "m"}, t.TempDir(), "sys", nil)
+
+	out, err := c.Chat("read the missing file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests.Load() != 4 {
+		t.Fatalf("expected 3 executions and a final reply (4 requests), got %d", requests.Load())
+	}
+	if !strings.Contains(out, "检测到重复的工具调用") || len(c.pendingToolCalls) != 1 {
+		t.Fatalf("expected loop stop with a pending call, got %q pending=%d", out, len(c.pendingToolCalls))
+	}
+	var sawNote bool
+	for _, m := range c.History {
+		if m.Role == "user" && strings.Contains(m.Content, "LOOP DETECTED: fs.read failed 3 times") {
+			sawNote = true
+		}
+	}
+	if !sawNote {
+		t.Fatalf("model was not told about the loop: %+v", c.History)
+	}
+}
+
+func TestChat_IterationLimitAndContinue(t *testing.T) {
+	t.Setenv("NIBOT_MAX_TOOL_ITERS", "2")
+	ws := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(ws, "memory"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 4; i++ {
+		if err := os.WriteFile(filepath.Join(ws, "memory", fmt.Sprintf("%d.md", i)), []byte(fmt.Sprint("part ", i)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv, requests := fakeChatServer(t, func(n int) string {
+		if n > 4 {
+			return "done"
+		}
+		return fmt.Sprintf(`[EXEC:fs.read {"path":"memory/%d.md"}]`, n)
+	})
+	c := NewLLMClient(Config{Provider: "openai", BaseURL: srv.URL, APIKey: "x", ModelName: "m"}, ws, "sys", nil)
+	if c.MaxToolIters != 2 {
+		t.Fatalf("NIBOT_MAX_TOOL_ITERS not applied: %d", c.MaxToolIters)
+	}
+
+	out, err := c.Chat("read all parts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests.Load() != 3 || !strings.Contains(out, "上限（2 轮") || len(c.pendingToolCalls) != 1 {
+		t.Fatalf("expected stop after 2 iterations: requests=%d out=%q pending=%+v", requests.Load(), out, c.pendingToolCalls)
+	}
+
+	out, err = c.Chat("continue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "done" || len(c.pendingToolCalls) != 0 {
+		t.Fatalf("expected continue to finish the task, got %q pending=%+v", out, c.pendingToolCalls)
+	}
+	var read []string
+	for _, m := range c.History {
+		if m.Role == "user" && strings.HasPrefix(m.Content, "TOOL_RESULTS") {
+			read = append(read, strings.TrimSpace(m.Content[strings.Index(m.Content, "part"):]))
+		}
+	}
+	if len(read) != 4 || read[2] != "part 3" {
+		t.Fatalf("expected all four parts read in order, got %q", read)
+	}
+}
+
+func TestLoop_ContinueRunsPendingCalls(t *testing.T) {
+	t.Setenv("NIBOT_MAX_TOOL_ITERS", "1")
+	srv, _ := fakeChatServer(t, func(n int) string {
+		if n > 2 {
+			return "all done"
+		}
+		return fmt.Sprintf(`[EXEC:fs.read {"path":"memory/%d.md"}] step %d`, n, n)
+	})
+	c := NewLLMClient(Config{Provider: "openai", BaseURL: srv.URL, APIKey: "x", ModelName: "m"}, t.TempDir(), "sys", nil)
+	var out strings.Builder
+	c.Loop(strings.NewReader("check memory\ncontinue\nexit\n"), &out, nil)
+
+	s := out.String()
+	if !strings.Contains(s, "还有 1 个工具调用未执行，输入 continue 继续") {
+		t.Fatalf("expected pending hint, got: %s", s)
+	}
+	if !strings.Contains(s, "继续执行 1 个待执行的工具调用") || !strings.Contains(s, "all done") {
+		t.Fatalf("expected continue to resume the tool loop, got: %s", s)
+	}
+}
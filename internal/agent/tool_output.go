@@ -0,0 +1,197 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// 工具输出超过 NIBOT_TOOL_OUTPUT_INLINE_BYTES 时，完整内容保存为 artifact，模型只看到首尾预览和 artifact id，
+// 需要时再用 artifact.read 分页读取。
+
+func toolOutputInlineBytes() int {
+	return parseIntEnv("NIBOT_TOOL_OUTPUT_INLINE_BYTES", 4000, 500, 64*1024)
+}
+
+func artifactMaxBytes() int64 {
+	return int64(parseIntEnv("NIBOT_ARTIFACT_MAX_MB", 50, 1, 1024)) * 1024 * 1024
+}
+
+// execOverflowMarker 标记 exec 输出已由 saveExecOverflow 完整保存，offloadLargeOutput 不会再存一份。
+const execOverflowMarker = "[FULL OUTPUT SAVED:"
+
+// isPagedReadTool 这些工具本身支持分页，输出过长时只提示继续读取，不另存 artifact。
+func isPagedReadTool(tool string) bool {
+	switch tool {
+	case "fs.read", "file_read", "artifact.read":
+		return true
+	}
+	return false
+}
+
+func offloadLargeOutput(ctx ExecContext, res ToolResult) ToolResult {
+	inline := toolOutputInlineBytes()
+	out := res.Output
+	if len(out) <= inline {
+		return res
+	}
+	if isPagedReadTool(res.Tool) {
+		head := cutAtLine(truncateUTF8(out, inline))
+		res.Output = head + fmt.Sprintf("\n\n[TRUNCATED: showing %d of %d bytes; call again with offset/limit or startLine/endLine to read the rest]", len(head), len(out))
+		return res
+	}
+	if strings.Contains(out, execOverflowMarker) {
+		res.Output = outputPreview(out, inline) + "\n\n[OUTPUT TRUNCATED: see the artifact noted above for the full output]"
+		return res
+	}
+
+	id := newArtifactID("out")
+	dir := artifactDir(ctx.Workspace, id)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		res.Output = outputPreview(out, inline) + "\n\n[TRUNCATED]"
+		return res
+	}
+	if err := os.WriteFile(filepath.Join(dir, "output.txt"), []byte(out), 0o644); err != nil {
+		res.Output = outputPreview(out, inline) + "\n\n[TRUNCATED]"
+		return res
+	}
+	lines := strings.Count(out, "\n") + 1
+	res.Output = outputPreview(out, inline) + fmt.Sprintf(
+		"\n\n[OUTPUT TRUNCATED: %d bytes, %d lines; full output saved as artifact %s (%s/%s/output.txt). Page through it with [EXEC:artifact.read {\"id\":\"%s\",\"startLine\":1,\"endLine\":200}] or {\"id\":\"%s\",\"offset\":-%d} for the end]",
+		len(out), lines, id, artifactsDirName, id, id, id, inline)
+	return res
+}
+
+// outputPreview 保留开头约 3/4 与结尾约 1/4，中间标注省略的字节数。
+func outputPreview(out string, budget int) string {
+	headBudget := budget * 3 / 4
+	tailBudget := budget - headBudget
+	head := cutAtLine(truncateUTF8(out, headBudget))
+	tail := out[len(out)-tailBudget:]
+	for len(tail) > 0 && !utf8.RuneStart(tail[0]) {
+		tail = tail[1:]
+	}
+	if i := strings.Index(tail, "\n"); i >= 0 && i < len(tail)-1 {
+		tail = tail[i+1:]
+	}
+	omitted := len(out) - len(head) - len(tail)
+	if omitted <= 0 {
+		return out
+	}
+	return strings.TrimRight(head, "\n") + fmt.Sprintf("\n[... %d bytes omitted ...]\n", omitted) + tail
+}
+
+// cutAtLine 在不丢失太多内容的前提下把截断点挪到最近的换行处。
+func cutAtLine(s string) string {
+	if i := strings.LastIndex(s, "\n"); i > len(s)/2 {
+		return s[:i]
+	}
+	return s
+}
+
+// saveExecOverflow 在 stdout/stderr 超出内存上限时，把完整输出保存为 artifact 并返回提示行。
+func saveExecOverflow(ctx ExecContext, stdout, stderr *cappedBuffer) string {
+	if !stdout.truncated && !stderr.truncated {
+		return ""
+	}
+	id := newArtifactID("exec")
+	dir := artifactDir(ctx.Workspace, id)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return ""
+	}
+	var parts []string
+	for _, f := range []struct {
+		name string
+		buf  *cappedBuffer
+	}{{"stdout.txt", stdout}, {"stderr.txt", stderr}} {
+		if f.buf.Len() == 0 {
+			continue
+		}
+		if err := f.buf.saveTo(filepath.Join(dir, f.name)); err != nil {
+			return ""
+		}
+		parts = append(parts, fmt.Sprintf("%s %d bytes", f.name, f.buf.Len()))
+	}
+	return fmt.Sprintf("%s artifact %s (%s); read it with [EXEC:artifact.read {\"id\":\"%s\",\"file\":\"stdout.txt\",\"offset\":-%d}]]",
+		execOverflowMarker, id, strings.Join(parts, ", "), id, toolOutputInlineBytes())
+}
+
+func appendNote(out, note string) string {
+	if note == "" {
+		return out
+	}
+	return out + "\n\n" + note
+}
+
+type artifactReadArgs struct {
+	ID   string `json:"id"`
+	File string `json:"file"`
+	readRange
+}
+
+func toolArtifactRead(ctx ExecContext, argsRaw string) (string, error) {
+	if !strings.HasPrefix(strings.TrimSpace(argsRaw), "{") {
+		return "", fmt.Errorf("artifact.read requires JSON args: {\"id\":\"out_...\",\"offset\":0,\"limit\":4000}")
+	}
+	var a artifactReadArgs
+	if err := json.Unmarshal([]byte(argsRaw), &a); err != nil {
+		return "", fmt.Errorf("invalid JSON args for artifact.read: %w", err)
+	}
+	a.ID = strings.TrimSpace(a.ID)
+	if !isValidArtifactID(a.ID) {
+		return "", fmt.Errorf("artifact.read requires a valid id")
+	}
+	files, err := listArtifactFiles(ctx.Workspace, a.ID)
+	if err != nil || len(files) == 0 {
+		return "", fmt.Errorf("artifact not found: %s", a.ID)
+	}
+	prefix := artifactsDirName + "/" + a.ID + "/"
+	var names []string
+	for _, f := range files {
+		names = append(names, strings.TrimPrefix(f.Rel, prefix))
+	}
+
+	name := strings.TrimSpace(a.File)
+	if name == "" {
+		switch {
+		case len(names) == 1:
+			name = names[0]
+		case containsString(names, "output.txt"):
+			name = "output.txt"
+		case containsString(names, "stdout.txt"):
+			name = "stdout.txt"
+		default:
+			return "", fmt.Errorf("artifact %s has several files, pass \"file\": %s", a.ID, strings.Join(names, ", "))
+		}
+	}
+	abs, err := ArtifactPath(ctx.Workspace, a.ID, name)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(abs); err != nil {
+		return "", fmt.Errorf("artifact %s has no file %s (files: %s)", a.ID, name, strings.Join(names, ", "))
+	}
+
+	rng := a.readRange
+	if !rng.active() {
+		zero := int64(0)
+		rng.Offset = &zero
+	}
+	argsPrefix := `"id":` + jsonQuote(a.ID)
+	if a.File != "" || len(names) > 1 {
+		argsPrefix += `,"file":` + jsonQuote(name)
+	}
+	return readTextFile(abs, prefix+name, docExtractOptions{}, rng, argsPrefix)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
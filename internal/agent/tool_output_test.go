@@ -0,0 +1,128 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestFSRead_PagingByBytesAndLines(t *testing.T) {
+	ws := t.TempDir()
+	var sb strings.Builder
+	for i := 1; i <= 1000; i++ {
+		fmt.Fprintf(&sb, "line %04d\n", i)
+	}
+	if err := os.WriteFile(filepath.Join(ws, "big.log"), []byte(sb.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := ExecContext{Workspace: ws, Policy: DefaultToolPolicy()}
+
+	out, err := toolFSRead(ctx, `{"path":"big.log","offset":20,"limit":20}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out, "line 0003\nline 0004") || !strings.Contains(out, `[bytes 20-40 of 10000; next: {"path":"big.log","offset":40}]`) {
+		t.Fatalf("unexpected byte page: %q", out)
+	}
+
+	out, err = toolFSRead(ctx, `{"path":"big.log","offset":-10}`)
+	if err != nil || !strings.HasPrefix(out, "line 1000") || !strings.Contains(out, "end of file") {
+		t.Fatalf("unexpected tail page: out=%q err=%v", out, err)
+	}
+
+	out, err = toolFSRead(ctx, `{"path":"big.log","startLine":998}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out, "line 0998\nline 0999\nline 1000") || !strings.Contains(out, "[lines 998-1000 of 1000; end of file]") {
+		t.Fatalf("unexpected line page: %q", out)
+	}
+	out, err = toolFSRead(ctx, `{"path":"big.log","startLine":10,"endLine":11}`)
+	if err != nil || !strings.Contains(out, `next: {"path":"big.log","startLine":12}`) {
+		t.Fatalf("unexpected next hint: out=%q err=%v", out, err)
+	}
+	if _, err := toolFSRead(ctx, `{"path":"big.log","startLine":2000}`); err == nil {
+		t.Fatalf("expected error past end of file")
+	}
+}
+
+func TestExecuteCalls_OffloadsLargeOutputToArtifact(t *testing.T) {
+	t.Setenv("NIBOT_TOOL_OUTPUT_INLINE_BYTES", "1000")
+	ws := t.TempDir()
+	var sb strings.Builder
+	for i := 1; i <= 500; i++ {
+		fmt.Fprintf(&sb, "row %03d\n", i)
+	}
+	if err := os.MkdirAll(filepath.Join(ws, "memory"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	ctx := ExecContext{Workspace: ws, Policy: DefaultToolPolicy()}
+
+	// fs.read 自带分页，只提示继续读取，不另存 artifact
+	os.WriteFile(filepath.Join(ws, "memory", "big.md"), []byte(sb.String()), 0o644)
+	res := ExecuteCalls(ctx, []ExecCall{{Tool: "fs.read", ArgsRaw: `{"path":"memory/big.md"}`}}, nil)
+	if len(res) != 1 || !strings.Contains(res[0].Output, "call again with offset/limit") || len(res[0].Output) > 1200 {
+		t.Fatalf("unexpected fs.read result: %+v", res)
+	}
+	if _, err := os.Stat(filepath.Join(ws, artifactsDirName)); !os.IsNotExist(err) {
+		t.Fatalf("fs.read should not create artifacts")
+	}
+
+	big := ToolResult{Tool: "data.query", OK: true, Output: sb.String()}
+	got := offloadLargeOutput(ctx, big)
+	m := regexp.MustCompile(`artifact (out_[0-9_a-f]+)`).FindStringSubmatch(got.Output)
+	if m == nil {
+		t.Fatalf("expected artifact handle, got %q", got.Output)
+	}
+	if !strings.HasPrefix(got.Output, "row 001\n") || !strings.Contains(got.Output, "row 500") || !strings.Contains(got.Output, "bytes omitted") {
+		t.Fatalf("expected head and tail preview, got %q", got.Output)
+	}
+
+	page, err := toolArtifactRead(ctx, `{"id":"`+m[1]+`","startLine":250,"endLine":251}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(page, "row 250\nrow 251") || !strings.Contains(page, `next: {"id":"`+m[1]+`","startLine":252}`) {
+		t.Fatalf("unexpected artifact page: %q", page)
+	}
+	if _, err := toolArtifactRead(ctx, `{"id":"../memory"}`); err == nil {
+		t.Fatalf("expected invalid id error")
+	}
+	if _, err := toolArtifactRead(ctx, `{"id":"out_missing"}`); err == nil {
+		t.Fatalf("expected missing artifact error")
+	}
+}
+
+func TestRuntimeExec_SavesOverflowAsArtifact(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	t.Setenv("NIBOT_ENABLE_EXEC", "1")
+	t.Setenv("NIBOT_EXEC_MAX_OUTPUT_BYTES", "1024")
+	ws := t.TempDir()
+	ctx := ExecContext{Workspace: ws, Policy: DefaultToolPolicy()}
+
+	out, err := toolRuntimeExec(ctx, `{"command":"seq 1 5000"}`)
+	if err != nil {
+		t.Fatalf("runtime.exec failed: %v", err)
+	}
+	m := regexp.MustCompile(`artifact (exec_[0-9_a-f]+)`).FindStringSubmatch(out)
+	if !strings.Contains(out, "[TRUNCATED]") || m == nil {
+		t.Fatalf("expected overflow artifact note, got %q", out)
+	}
+	b, err := os.ReadFile(filepath.Join(ws, artifactsDirName, m[1], "stdout.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(b)), "\n"); len(lines) != 5000 || lines[4999] != "5000" {
+		t.Fatalf("full output not preserved: %d lines", len(lines))
+	}
+	tail, err := toolArtifactRead(ctx, `{"id":"`+m[1]+`","offset":-5}`)
+	if err != nil || !strings.HasPrefix(tail, "5000") {
+		t.Fatalf("unexpected tail: out=%q err=%v", tail, err)
+	}
+}
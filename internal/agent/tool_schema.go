@@ -0,0 +1,656 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// argSchema 是工具参数所用的 JSON Schema 子集：object / string / integer / number / boolean / array，
+// 以及 properties、required、items、enum。对象一律不允许未声明的字段。
+type argSchema struct {
+	Type        string
+	Description string
+	Properties  map[string]*argSchema
+	Required    []string
+	Items       *argSchema
+	Enum        []string
+}
+
+func strArg(desc string) *argSchema  { return &argSchema{Type: "string", Description: desc} }
+func intArg(desc string) *argSchema  { return &argSchema{Type: "integer", Description: desc} }
+func boolArg(desc string) *argSchema { return &argSchema{Type: "boolean", Description: desc} }
+
+func strListArg(desc string) *argSchema {
+	return &argSchema{Type: "array", Description: desc, Items: &argSchema{Type: "string"}}
+}
+
+func enumArg(desc string, values ...string) *argSchema {
+	return &argSchema{Type: "string", Description: desc, Enum: values}
+}
+
+func objectArgs(required []string, props map[string]*argSchema) *argSchema {
+	return &argSchema{Type: "object", Properties: props, Required: required}
+}
+
+// jsonSchema 转换为 OpenAI tool calling 使用的 parameters。
+func (s *argSchema) jsonSchema() map[string]any {
+	m := map[string]any{"type": s.Type}
+	if s.Description != "" {
+		m["description"] = s.Description
+	}
+	if len(s.Enum) > 0 {
+		m["enum"] = s.Enum
+	}
+	if s.Items != nil {
+		m["items"] = s.Items.jsonSchema()
+	}
+	if s.Type == "object" {
+		props := map[string]any{}
+		for name, p := range s.Properties {
+			props[name] = p.jsonSchema()
+		}
+		m["properties"] = props
+		m["additionalProperties"] = false
+		if len(s.Required) > 0 {
+			m["required"] = s.Required
+		}
+	}
+	return m
+}
+
+// toolSpec 登记一个工具的参数定义。Name 是原生 tool calling 中暴露的名字，Aliases 是 [EXEC:] 中也可使用的其它名字。
+type toolSpec struct {
+	Name        string
+	Aliases     []string
+	Description string
+	Args        *argSchema
+}
+
+var (
+	readRangeProps = map[string]*argSchema{
+		"offset":    intArg("Byte offset to start from; negative counts from the end"),
+		"limit":     intArg("Bytes to return"),
+		"startLine": intArg(""),
+		"endLine":   intArg(""),
+	}
+	codeLanguages = []string{"python", "python3", "py", "sh", "shell", "bash"}
+)
+
+func withReadRange(props map[string]*argSchema) map[string]*argSchema {
+	for k, v := range readRangeProps {
+		props[k] = v
+	}
+	return props
+}
+
+// toolSpecs 按原生 tool calling 中的顺序列出所有工具；新增工具时在这里登记参数。
+var toolSpecs = []toolSpec{
+	{
+		Name: "file_read", Aliases: []string{"fs.read"},
+		Description: "Read a file from Ni bot workspace; PDF/DOCX/XLSX are returned as extracted text",
+		Args: objectArgs([]string{"path"}, withReadRange(map[string]*argSchema{
+			"path":  strArg(""),
+			"pages": strArg("PDF pages, e.g. \"1-3,5\""),
+			"sheet": strArg("XLSX sheet names or 1-based indexes, comma separated"),
+		})),
+	},
+	{
+		Name: "file_write", Aliases: []string{"fs.write"},
+		Description: "Write a file under Ni bot workspace (append or overwrite subject to policy)",
+		Args: objectArgs([]string{"path", "content"}, map[string]*argSchema{
+			"path":     strArg(""),
+			"content":  strArg(""),
+			"mode":     enumArg("", "append", "overwrite"),
+			"encoding": strArg("utf-8 (default), gbk, gb18030, utf-16le, utf-16be, utf-8-bom, or keep to reuse the existing file's encoding"),
+		}),
+	},
+	{
+		Name: "install_skill", Aliases: []string{"skills.install", "skill_store_install"},
+		Description: "Install skills from a https:// git repository into Ni bot workspace skills directory",
+		Args: objectArgs([]string{"name", "url"}, map[string]*argSchema{
+			"name":  strArg(""),
+			"url":   strArg(""),
+			"layer": enumArg("", "upstream", "local", "override", "overrides"),
+		}),
+	},
+	{
+		Name:        "memory.store",
+		Description: "Store a long-term memory item (SQLite memory DB must be enabled)",
+		Args: objectArgs([]string{"content"}, map[string]*argSchema{
+			"scope":   strArg(""),
+			"tags":    strArg(""),
+			"content": strArg(""),
+		}),
+	},
+	{
+		Name:        "memory.recall",
+		Description: "Search long-term memories by keyword match (SQLite memory DB must be enabled)",
+		Args: objectArgs([]string{"query"}, map[string]*argSchema{
+			"scope": strArg(""),
+			"query": strArg(""),
+			"limit": intArg(""),
+		}),
+	},
+	{
+		Name:        "memory.forget",
+		Description: "Delete a memory item by id (SQLite memory DB must be enabled)",
+		Args:        objectArgs([]string{"id"}, map[string]*argSchema{"id": intArg("")}),
+	},
+	{
+		Name:        "memory.list",
+		Description: "List recent memory items (SQLite memory DB must be enabled)",
+		Args: objectArgs(nil, map[string]*argSchema{
+			"scope": strArg(""),
+			"limit": intArg(""),
+		}),
+	},
+	{
+		Name:        "memory.stats",
+		Description: "Show memory database stats (SQLite memory DB must be enabled)",
+		Args:        objectArgs(nil, map[string]*argSchema{}),
+	},
+	{
+		Name:        "artifact.read",
+		Description: "Page through a saved artifact (e.g. a truncated tool output) by byte offset/limit or line range",
+		Args: objectArgs([]string{"id"}, withReadRange(map[string]*argSchema{
+			"id":   strArg(""),
+			"file": strArg(""),
+		})),
+	},
+	{
+		Name:        "data.query",
+		Description: "Load workspace CSV/TSV/JSONL files into a temporary SQLite database (one table per file) and run a read-only SELECT; omit sql to list table schemas",
+		Args: objectArgs([]string{"files"}, map[string]*argSchema{
+			"files": strListArg(""),
+			"sql":   strArg(""),
+			"limit": intArg(""),
+		}),
+	},
+	{
+		Name:        "memory.import",
+		Description: "Import memory items from a pasted text block (SQLite memory DB must be enabled)",
+		Args: objectArgs([]string{"text"}, map[string]*argSchema{
+			"source": strArg(""),
+			"scope":  strArg(""),
+			"tags":   strArg(""),
+			"text":   strArg(""),
+			"limit":  intArg(""),
+		}),
+	},
+	{
+		Name: "shell_exec", Aliases: []string{"runtime.exec"},
+		Description: "Execute a shell command with approval and sandbox/policy restrictions",
+		Args: objectArgs([]string{"command"}, map[string]*argSchema{
+			"command":        strArg(""),
+			"timeoutSeconds": intArg(""),
+		}),
+	},
+	{
+		Name:        "code.run",
+		Description: "Run a Python or shell snippet in an isolated temp dir; created files are saved as artifacts",
+		Args: objectArgs([]string{"language", "code"}, map[string]*argSchema{
+			"language":       enumArg("", codeLanguages...),
+			"code":           strArg(""),
+			"files":          strListArg(""),
+			"timeoutSeconds": intArg(""),
+		}),
+	},
+	{
+		Name:        "shell.session",
+		Description: "Run a command in a persistent per-conversation shell (cwd and exported variables are kept between calls)",
+		Args: objectArgs(nil, map[string]*argSchema{
+			"command":        strArg(""),
+			"timeoutSeconds": intArg(""),
+			"reset":          boolArg(""),
+		}),
+	},
+	{
+		Name:        "job.start",
+		Description: "Start a long-running shell command as a background job; output goes to logs/jobs/<id>.log",
+		Args:        objectArgs([]string{"command"}, map[string]*argSchema{"command": strArg("")}),
+	},
+	{
+		Name:        "job.status",
+		Description: "Show the status of a background job (omit id to list all jobs)",
+		Args:        objectArgs(nil, map[string]*argSchema{"id": strArg("")}),
+	},
+	{
+		Name:        "job.logs",
+		Description: "Tail the log output of a background job",
+		Args: objectArgs([]string{"id"}, map[string]*argSchema{
+			"id":   strArg(""),
+			"tail": intArg(""),
+		}),
+	},
+	{
+		Name:        "job.kill",
+		Description: "Kill a running background job",
+		Args:        objectArgs([]string{"id"}, map[string]*argSchema{"id": strArg("")}),
+	},
+	{
+		Name:        "schedule.create",
+		Description: "Schedule a prompt to run later (cron for recurring, at for one-time); the reply is sent back to this chat",
+		Args: objectArgs([]string{"prompt"}, map[string]*argSchema{
+			"prompt":   strArg("What to do or remind the user of when the task runs"),
+			"cron":     strArg("5-field cron expression: minute hour day-of-month month day-of-week, e.g. 0 9 * * 1"),
+			"at":       strArg("One-time run, e.g. 2026-01-02 09:00"),
+			"timezone": strArg("IANA timezone, e.g. Asia/Shanghai"),
+		}),
+	},
+	{
+		Name:        "schedule.list",
+		Description: "List scheduled tasks created from this chat",
+		Args:        objectArgs(nil, map[string]*argSchema{"all": boolArg("Include finished and cancelled tasks")}),
+	},
+	{
+		Name:        "schedule.cancel",
+		Description: "Cancel a scheduled task",
+		Args:        objectArgs([]string{"id"}, map[string]*argSchema{"id": intArg("")}),
+	},
+	{
+		Name:        "git.status",
+		Description: "Show branch and changed files under the workspace",
+		Args:        objectArgs(nil, map[string]*argSchema{}),
+	},
+	{
+		Name:        "git.diff",
+		Description: "Show changes under the workspace: per-file line counts and a size-limited patch",
+		Args: objectArgs(nil, map[string]*argSchema{
+			"staged": boolArg("Diff staged changes instead of the working tree"),
+			"ref":    strArg("Compare against this commit/branch, e.g. HEAD~1"),
+			"paths":  strListArg("Limit to these workspace-relative paths"),
+			"stat":   boolArg("Only return the per-file summary"),
+		}),
+	},
+	{
+		Name:        "git.log",
+		Description: "List recent commits touching the workspace",
+		Args: objectArgs(nil, map[string]*argSchema{
+			"limit": intArg("Number of commits (default 20, max 200)"),
+			"path":  strArg("Only commits touching this workspace-relative path"),
+			"ref":   strArg("Start from this branch/commit"),
+		}),
+	},
+	{
+		Name:        "git.commit",
+		Description: "Stage and commit changes under the workspace (requires approval)",
+		Args: objectArgs([]string{"message"}, map[string]*argSchema{
+			"message": strArg("Commit message"),
+			"paths":   strListArg("Workspace-relative paths to commit (default: all changes under the workspace except data/ and logs/)"),
+		}),
+	},
+	{
+		Name:        "agent.delegate",
+		Description: "Hand a self-contained sub-task to a sub-agent with read-only tools and a small budget; returns only its final summary",
+		Args: objectArgs([]string{"task"}, map[string]*argSchema{
+			"task":       strArg("What the sub-agent should find out or do, written so it can be done without this conversation"),
+			"context":    strArg("Optional facts from this conversation the sub-agent needs"),
+			"model":      strArg("Optional model name for the sub-agent (same provider)"),
+			"max_iters":  intArg("Tool iterations for the sub-agent; capped by NIBOT_DELEGATE_MAX_ITERS"),
+			"max_tokens": intArg("Token budget for the sub-agent; capped by NIBOT_DELEGATE_MAX_TOKENS"),
+		}),
+	},
+	{
+		Name:        "notify.send",
+		Description: "Send a message to a named target configured in data/notify.toml (Telegram chat, Feishu webhook, generic webhook or email outbox)",
+		Args: objectArgs([]string{"target", "text"}, map[string]*argSchema{
+			"target": strArg("Target name from data/notify.toml"),
+			"text":   strArg("Message text"),
+			"title":  strArg("Optional title / email subject"),
+		}),
+	},
+	{
+		Name: "skill_exec", Aliases: []string{"skill.exec"},
+		Description: "Execute a skill script with approval and sandbox/policy restrictions",
+		Args: objectArgs([]string{"skill", "script"}, map[string]*argSchema{
+			"skill":          strArg(""),
+			"script":         strArg(""),
+			"args":           strListArg(""),
+			"timeoutSeconds": intArg(""),
+		}),
+	},
+}
+
+func lookupToolSpec(tool string) *toolSpec {
+	for i := range toolSpecs {
+		if toolSpecs[i].Name == tool || containsString(toolSpecs[i].Aliases, tool) {
+			return &toolSpecs[i]
+		}
+	}
+	return nil
+}
+
+// argProblem 是一个参数错误，Field 为 JSON 路径（如 args[1]），整体参数错误时为空。
+type argProblem struct {
+	Field string
+	Msg   string
+}
+
+// ToolArgsError 列出一次调用的全部参数错误，并附上期望的参数格式，方便模型下一轮直接改正。
+type ToolArgsError struct {
+	Tool     string
+	Problems []argProblem
+	Expected string
+}
+
+func (e *ToolArgsError) Error() string {
+	parts := make([]string, 0, len(e.Problems))
+	for _, p := range e.Problems {
+		if p.Field == "" {
+			parts = append(parts, p.Msg)
+		} else {
+			parts = append(parts, p.Field+": "+p.Msg)
+		}
+	}
+	return fmt.Sprintf("invalid arguments for %s: %s. Expected args: %s", e.Tool, strings.Join(parts, "; "), e.Expected)
+}
+
+// validateToolArgs 按登记的 schema 检查 JSON 参数，并做以下宽松转换（转换后重新序列化）：
+//   - integer / number 字段接受数字字符串，如 "10"；integer 接受 10.0
+//   - boolean 字段接受 "true" / "false"
+//   - string 字段接受数字和布尔值，转为其文本
+//   - array 字段接受单个元素，视为只有一个元素的数组
+//   - 值为 null 的字段视为未提供
+//
+// enum 比较忽略大小写和首尾空白，匹配后改为登记的写法，空字符串视为未提供。未登记的工具和非 JSON 的纯文本参数不做检查。
+func validateToolArgs(tool, argsRaw string) (string, error) {
+	spec := lookupToolSpec(tool)
+	if spec == nil || spec.Args == nil {
+		return argsRaw, nil
+	}
+	trimmed := strings.TrimSpace(argsRaw)
+	fail := func(problems []argProblem) error {
+		return &ToolArgsError{Tool: tool, Problems: problems, Expected: spec.Args.signature()}
+	}
+	switch {
+	case trimmed == "":
+		// 空参数只需检查必填字段
+		var problems []argProblem
+		spec.Args.coerce("", map[string]any{}, &problems)
+		if len(problems) > 0 {
+			return "", fail(problems)
+		}
+		return argsRaw, nil
+	case strings.HasPrefix(trimmed, "["):
+		return "", fail([]argProblem{{Msg: "arguments must be a JSON object, not an array"}})
+	case !strings.HasPrefix(trimmed, "{"):
+		return argsRaw, nil
+	}
+
+	dec := json.NewDecoder(strings.NewReader(trimmed))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return "", fail([]argProblem{{Msg: "invalid JSON: " + err.Error()}})
+	}
+	obj, _ := v.(map[string]any)
+	var problems []argProblem
+	coerced, changed := spec.Args.coerce("", obj, &problems)
+	if len(problems) > 0 {
+		return "", fail(problems)
+	}
+	if !changed {
+		return argsRaw, nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(coerced); err != nil {
+		return "", fail([]argProblem{{Msg: err.Error()}})
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+func joinArgPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+func describeJSONValue(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		if len(x) > 40 {
+			x = truncateUTF8(x, 40) + "..."
+		}
+		return "string " + strconv.Quote(x)
+	case json.Number:
+		return "number " + x.String()
+	case bool:
+		return "boolean " + strconv.FormatBool(x)
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// coerce 校验 v 并返回转换后的值；changed 表示与输入不同，需要重新序列化。
+func (s *argSchema) coerce(path string, v any, problems *[]argProblem) (any, bool) {
+	add := func(msg string) {
+		*problems = append(*problems, argProblem{Field: path, Msg: msg})
+	}
+	mismatch := func() (any, bool) {
+		add(fmt.Sprintf("expected %s, got %s", s.Type, describeJSONValue(v)))
+		return v, false
+	}
+
+	switch s.Type {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return mismatch()
+		}
+		changed := false
+		out := make(map[string]any, len(obj))
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			val := obj[k]
+			prop, ok := s.Properties[k]
+			if !ok {
+				msg := "unknown field"
+				if guess := s.closestField(k); guess != "" {
+					msg += fmt.Sprintf(" (did you mean %q?)", guess)
+				} else if names := s.fieldNames(); len(names) > 0 {
+					msg += " (allowed: " + strings.Join(names, ", ") + ")"
+				} else {
+					msg += " (this tool takes no arguments)"
+				}
+				*problems = append(*problems, argProblem{Field: joinArgPath(path, k), Msg: msg})
+				continue
+			}
+			if val == nil {
+				changed = true
+				continue
+			}
+			nv, c := prop.coerce(joinArgPath(path, k), val, problems)
+			if c {
+				changed = true
+			}
+			if nv == nil {
+				continue
+			}
+			out[k] = nv
+		}
+		for _, r := range s.Required {
+			if _, ok := out[r]; !ok && !hasProblemAt(*problems, joinArgPath(path, r)) {
+				*problems = append(*problems, argProblem{Field: joinArgPath(path, r), Msg: "required field missing"})
+			}
+		}
+		return out, changed
+
+	case "array":
+		arr, ok := v.([]any)
+		if !ok {
+			if _, isObj := v.(map[string]any); isObj || s.Items == nil {
+				return mismatch()
+			}
+			// 单个元素按一个元素的数组处理
+			nv, _ := s.Items.coerce(path+"[0]", v, problems)
+			return []any{nv}, true
+		}
+		if s.Items == nil {
+			return arr, false
+		}
+		changed := false
+		out := make([]any, len(arr))
+		for i, item := range arr {
+			nv, c := s.Items.coerce(fmt.Sprintf("%s[%d]", path, i), item, problems)
+			out[i] = nv
+			if c {
+				changed = true
+			}
+		}
+		return out, changed
+
+	case "string":
+		var str string
+		changed := false
+		switch x := v.(type) {
+		case string:
+			str = x
+		case json.Number:
+			str, changed = x.String(), true
+		case bool:
+			str, changed = strconv.FormatBool(x), true
+		default:
+			return mismatch()
+		}
+		if len(s.Enum) > 0 {
+			norm := strings.TrimSpace(str)
+			if norm == "" {
+				return nil, true
+			}
+			found := false
+			for _, e := range s.Enum {
+				if strings.EqualFold(e, norm) {
+					// 工具实现按原样比较，统一为登记的写法
+					if e != str {
+						str, changed = e, true
+					}
+					found = true
+					break
+				}
+			}
+			if !found {
+				add(fmt.Sprintf("must be one of %s, got %q", strings.Join(s.Enum, ", "), str))
+				return v, false
+			}
+		}
+		return str, changed
+
+	case "integer", "number":
+		var num json.Number
+		changed := false
+		switch x := v.(type) {
+		case json.Number:
+			num = x
+		case string:
+			num, changed = json.Number(strings.TrimSpace(x)), true
+		default:
+			return mismatch()
+		}
+		f, err := num.Float64()
+		if err != nil {
+			return mismatch()
+		}
+		if s.Type == "number" {
+			return num, changed
+		}
+		if _, err := num.Int64(); err == nil {
+			return num, changed
+		}
+		if f != float64(int64(f)) {
+			add(fmt.Sprintf("expected integer, got %s", describeJSONValue(v)))
+			return v, false
+		}
+		return json.Number(strconv.FormatInt(int64(f), 10)), true
+
+	case "boolean":
+		switch x := v.(type) {
+		case bool:
+			return x, false
+		case string:
+			switch strings.ToLower(strings.TrimSpace(x)) {
+			case "true":
+				return true, true
+			case "false":
+				return false, true
+			}
+		}
+		return mismatch()
+	}
+	return v, false
+}
+
+func hasProblemAt(problems []argProblem, field string) bool {
+	for _, p := range problems {
+		if p.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *argSchema) fieldNames() []string {
+	names := make([]string, 0, len(s.Properties))
+	for k := range s.Properties {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// closestField 忽略大小写、下划线和连字符后查找同名字段，例如 timeout_seconds -> timeoutSeconds。
+func (s *argSchema) closestField(name string) string {
+	norm := func(x string) string {
+		return strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(x))
+	}
+	for _, k := range s.fieldNames() {
+		if norm(k) == norm(name) {
+			return k
+		}
+	}
+	return ""
+}
+
+// signature 生成紧凑的参数说明，例如 {"path": string (required), "limit": integer}。
+func (s *argSchema) signature() string {
+	switch s.Type {
+	case "object":
+		var parts []string
+		req := map[string]bool{}
+		for _, r := range s.Required {
+			req[r] = true
+		}
+		for _, k := range s.fieldNames() {
+			p := fmt.Sprintf("%q: %s", k, s.Properties[k].signature())
+			if req[k] {
+				p += " (required)"
+			}
+			parts = append(parts, p)
+		}
+		return "{" + strings.Join(parts, ", ") + "}"
+	case "array":
+		if s.Items != nil {
+			return "[" + s.Items.signature() + ", ...]"
+		}
+		return "array"
+	}
+	if len(s.Enum) > 0 {
+		return strings.Join(s.Enum, "|")
+	}
+	return s.Type
+}
@@ -0,0 +1,127 @@
+package agent
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateToolArgs_CoercesLooseTypes(t *testing.T) {
+	cases := []struct {
+		tool, in, want string
+	}{
+		{"fs.read", `{"path":"a.md","limit":"10","pages":3,"sheet":null}`, `{"limit":10,"pages":"3","path":"a.md"}`},
+		{"data.query", `{"files":"a.csv","limit":20.0}`, `{"files":["a.csv"],"limit":20}`},
+		{"shell.session", `{"reset":"true"}`, `{"reset":true}`},
+		{"skill.exec", `{"skill":"s","script":"x.sh","args":["a",2]}`, `{"args":["a","2"],"script":"x.sh","skill":"s"}`},
+		{"fs.write", `{"path":"memory/a.md","content":"x","mode":"Append"}`, `{"content":"x","mode":"append","path":"memory/a.md"}`},
+		{"code.run", `{"language":" Python ","code":"print(1)"}`, `{"code":"print(1)","language":"python"}`},
+		{"fs.write", `{"path":"memory/a.md","content":"x","mode":"append"}`, `{"path":"memory/a.md","content":"x","mode":"append"}`},
+		{"memory.stats", ``, ``},
+		{"fs.read", `memory/plain.md`, `memory/plain.md`},
+		{"no.such.tool", `{"anything":1}`, `{"anything":1}`},
+	}
+	for _, c := range cases {
+		got, err := validateToolArgs(c.tool, c.in)
+		if err != nil || got != c.want {
+			t.Errorf("%s %s: got %q (%v), want %q", c.tool, c.in, got, err, c.want)
+		}
+	}
+}
+
+func TestValidateToolArgs_ReportsProblems(t *testing.T) {
+	cases := []struct {
+		tool, in string
+		want     []string
+	}{
+		{"fs.read", `{"path":"a.md","limt":5}`, []string{"limt: unknown field (allowed: endLine, limit, offset, pages, path, sheet, startLine)"}},
+		{"runtime.exec", `{"command":"ls","timeout_seconds":5}`, []string{`timeout_seconds: unknown field (did you mean "timeoutSeconds"?)`}},
+		{"memory.recall", `{"limit":"ten"}`, []string{`limit: expected integer, got string "ten"`, "query: required field missing"}},
+		{"memory.forget", `{"id":1.5}`, []string{"id: expected integer, got number 1.5"}},
+		{"fs.write", `{"path":"a","content":"x","mode":"replace"}`, []string{`mode: must be one of append, overwrite, got "replace"`}},
+		{"skill.exec", `{"skill":"s","script":"x","args":[{"k":1}]}`, []string{"args[0]: expected string, got object"}},
+		{"job.kill", ``, []string{"id: required field missing"}},
+		{"job.kill", `["job_1"]`, []string{"arguments must be a JSON object"}},
+		{"memory.stats", `{"verbose":true}`, []string{"verbose: unknown field (this tool takes no arguments)"}},
+	}
+	for _, c := range cases {
+		_, err := validateToolArgs(c.tool, c.in)
+		var ae *ToolArgsError
+		if !errors.As(err, &ae) {
+			t.Errorf("%s %s: expected ToolArgsError, got %v", c.tool, c.in, err)
+			continue
+		}
+		for _, w := range c.want {
+			if !strings.Contains(err.Error(), w) {
+				t.Errorf("%s %s: error %q should contain %q", c.tool, c.in, err, w)
+			}
+		}
+		if !strings.Contains(err.Error(), "Expected args: {") {
+			t.Errorf("%s: error should include the expected signature: %q", c.tool, err)
+		}
+	}
+}
+
+func TestExecuteCalls_RejectsInvalidArgsBeforeApproval(t *testing.T) {
+	ctx := ExecContext{Workspace: t.TempDir(), Policy: DefaultToolPolicy()}
+	ctx.Policy.RequireFSWrite = true
+	approver := &countingApprover{}
+	res := ExecuteCalls(ctx, []ExecCall{{Tool: "fs.write", ArgsRaw: `{"path":"memory/a.md","text":"x"}`}}, approver)
+	if len(res) != 1 || res[0].OK || !strings.Contains(res[0].Error, "text: unknown field") || !strings.Contains(res[0].Error, "content: required field missing") {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	if approver.n != 0 {
+		t.Fatalf("approver should not be asked for invalid args")
+	}
+}
+
+func TestExecuteCalls_DecidesOnNormalizedArgs(t *testing.T) {
+	ctx := ExecContext{Workspace: t.TempDir(), Policy: DefaultToolPolicy()}
+	ctx.Policy.Rules = []PolicyRule{{Action: "deny", Tools: []string{"fs.write"}, Paths: []string{"1*"}}}
+	approver := &countingApprover{}
+	// path 是数字时原始参数里没有可匹配的字符串，规范化为 "123" 后 deny 规则必须生效
+	res := ExecuteCalls(ctx, []ExecCall{{Tool: "fs.write", ArgsRaw: `{"path":123,"content":"x"}`}}, approver)
+	if len(res) != 1 || res[0].OK || res[0].Error != "disabled by policy" || !strings.Contains(res[0].Reason, "deny") {
+		t.Fatalf("expected the rule to match the normalized path: %+v", res)
+	}
+	if approver.n != 0 {
+		t.Fatalf("approver should not be asked for denied calls")
+	}
+}
+
+type countingApprover struct{ n int }
+
+func (a *countingApprover) Approve(ExecCall) bool {
+	a.n++
+	return true
+}
+
+func TestOpenAIToolsForPolicy_UsesRegistry(t *testing.T) {
+	c := &LLMClient{Config: Config{Policy: DefaultToolPolicy()}}
+	c.Config.Policy.AllowFSWrite = false
+	tools := c.openAIToolsForPolicy()
+	names := map[string]openAITool{}
+	for _, tl := range tools {
+		names[tl.Function.Name] = tl
+	}
+	if _, ok := names["file_write"]; ok {
+		t.Fatalf("file_write should be filtered out by policy")
+	}
+	fr, ok := names["file_read"]
+	if !ok {
+		t.Fatalf("file_read missing: %+v", tools)
+	}
+	if fr.Function.Parameters["additionalProperties"] != false {
+		t.Fatalf("parameters should reject unknown fields: %+v", fr.Function.Parameters)
+	}
+	// executeOne 能执行的每个名字都应登记了参数
+	for _, name := range []string{"fs.read", "fs.write", "skills.install", "skill_store_install", "runtime.exec", "skill.exec",
+		"memory.store", "memory.recall", "memory.forget", "memory.list", "memory.stats", "memory.import",
+		"artifact.read", "data.query", "code.run", "shell.session", "job.start", "job.status", "job.logs", "job.kill",
+		"schedule.create", "schedule.list", "schedule.cancel", "notify.send",
+		"git.status", "git.diff", "git.log", "git.commit", "agent.delegate"} {
+		if spec := lookupToolSpec(name); spec == nil || spec.Args == nil || spec.Args.Type != "object" {
+			t.Errorf("no argument schema registered for %s", name)
+		}
+	}
+}
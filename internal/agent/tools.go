@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -22,101 +23,38 @@ type ToolResult struct {
 	OK     bool
 	Output string
 	Error  string
+	// Reason 说明策略的决定（哪条规则或哪个开关），写入审计日志；被拒绝时也会告诉模型。
+	Reason string
+	// Env 是本次调用传给子进程的环境变量名（不含值），随调用一起写入 tool_audits 的 env 列。
+	Env []string
 }
 
 type ExecContext struct {
 	Workspace string
 	Policy    ToolPolicy
+	// Session 标识一次对话（CLI / 某个 Web 会话 / 某个 Telegram 用户），用于 shell.session 复用同一个 shell。
+	Session string
+	// Logger 非空时，子进程环境等审计信息会写入该日志。
+	Logger   *os.File
+	LogLevel string
+	// Origin 标识调用来自哪个渠道（"telegram:<chat id>"、"feishu:<chat id>"、"web:<浏览器>"；为空表示 CLI），
+	// schedule.* 用它决定结果发回哪里。
+	Origin string
+	// User 是发起对话的用户（Telegram / 飞书用户 ID），供 policy.toml 的 [[rules]] 按用户匹配；CLI 为空。
+	User string
+	// Client 是发起调用的对话，agent.delegate 用它的模型配置和系统提示创建子代理；可以为空。
+	Client *LLMClient
+	// envNames 非空时 childEnvFor 把传给子进程的变量名记在这里，由 ExecuteCalls 放进该次调用的 ToolResult.Env。
+	envNames *[]string
 }
 
 type Approver interface {
 	Approve(call ExecCall) bool
 }
 
+// ExtractExecCalls 返回文本中可执行的工具调用，忽略格式错误的标签（语法见 exec_parser.go）。
 func ExtractExecCalls(text string) []ExecCall {
-	const prefix = "[EXEC:"
-	var calls []ExecCall
-
-	for i := 0; i < len(text); {
-		idx := strings.Index(text[i:], prefix)
-		if idx == -1 {
-			break
-		}
-		start := i + idx
-		j := start + len(prefix)
-
-		toolStart := j
-		for j < len(text) {
-			ch := text[j]
-			if ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n' || ch == ']' {
-				break
-			}
-			j++
-		}
-		tool := strings.TrimSpace(text[toolStart:j])
-		for j < len(text) && (text[j] == ' ' || text[j] == '\t') {
-			j++
-		}
-		argsStart := j
-
-		inString := false
-		escape := false
-		braceDepth := 0
-		bracketDepth := 0
-
-		for j < len(text) {
-			ch := text[j]
-
-			if inString {
-				if escape {
-					escape = false
-					j++
-					continue
-				}
-				if ch == '\\' {
-					escape = true
-					j++
-					continue
-				}
-				if ch == '"' {
-					inString = false
-				}
-				j++
-				continue
-			}
-
-			switch ch {
-			case '"':
-				inString = true
-			case '{':
-				braceDepth++
-			case '}':
-				if braceDepth > 0 {
-					braceDepth--
-				}
-			case '[':
-				bracketDepth++
-			case ']':
-				if braceDepth == 0 && bracketDepth == 0 {
-					args := strings.TrimSpace(text[argsStart:j])
-					if tool != "" {
-						calls = append(calls, ExecCall{Tool: tool, ArgsRaw: args})
-					}
-					j++
-					i = j
-					goto next
-				}
-				if bracketDepth > 0 {
-					bracketDepth--
-				}
-			}
-			j++
-		}
-
-		break
-	next:
-	}
-
+	calls, _ := ParseExecCalls(text)
 	if len(calls) == 0 {
 		return nil
 	}
@@ -129,36 +67,91 @@ func ExecuteCalls(ctx ExecContext, calls []ExecCall, approver Approver) []ToolRe
 	}
 
 	results := make([]ToolResult, 0, len(calls))
+	add := func(call ExecCall, r ToolResult) {
+		if selfAuditedTools[call.Tool] {
+			if err := appendToolAudit(ctx.Workspace, ctx.Session, call, r); err != nil {
+				log.Printf("tool audit for %s failed: %v", call.Tool, err)
+			}
+		}
+		results = append(results, r)
+	}
 	for _, call := range calls {
-		if !ctx.Policy.AllowsTool(call.Tool) {
-			results = append(results, ToolResult{
+		// 先按 schema 校验并规范化参数：策略规则、审批记录和审批提示都看规范化后的参数，
+		// 与实际执行的参数一致（例如 {"path":123} 转为 "123" 之后才能被 paths 规则匹配）。
+		// 参数不符合 schema 时直接返回结构化错误，不必再请求审批。
+		args, err := validateToolArgs(call.Tool, call.ArgsRaw)
+		if err != nil {
+			add(call, ToolResult{Tool: call.Tool, OK: false, Error: err.Error()})
+			continue
+		}
+		call.ArgsRaw = args
+
+		decision := ctx.Policy.Decide(policyRequestFor(ctx, call))
+		if decision.Action == "deny" {
+			add(call, ToolResult{
 				Tool:   call.Tool,
 				OK:     false,
 				Error:  "disabled by policy",
 				Output: "",
+				Reason: decision.Reason,
 			})
 			continue
 		}
 
 		// 检查是否需要审批 - 支持静默授权模式
-		if ctx.Policy.RequiresApproval(call.Tool) && approver != nil && os.Getenv("NIBOT_AUTO_APPROVE") != "true" {
-			if !approver.Approve(call) {
-				results = append(results, ToolResult{
+		if decision.Action == "ask" {
+			// 先看 approvals.json 和本次对话中已经做过的决定，命中时不再询问
+			approved, source, remembered := rememberedApproval(ctx, call)
+			if !remembered && os.Getenv("NIBOT_AUTO_APPROVE") == "true" {
+				approved, source, remembered = true, "NIBOT_AUTO_APPROVE=true", true
+			}
+			if remembered {
+				writeAuditAutoApproval(ctx.Logger, ctx.LogLevel, call, approved, source)
+			} else if approver == nil {
+				// 没有人能审批（渠道未配置审批方式、定时任务等）时默认拒绝
+				writeAuditAutoApproval(ctx.Logger, ctx.LogLevel, call, false, "no approver on this channel")
+				add(call, ToolResult{
+					Tool:   call.Tool,
+					OK:     false,
+					Error:  "approval unavailable",
+					Reason: decision.Reason + "; nobody can approve calls on this channel (allow it with an approvals.json entry or set NIBOT_AUTO_APPROVE=true)",
+				})
+				continue
+			} else {
+				approved = approver.Approve(call)
+			}
+			if !approved {
+				reason := decision.Reason
+				if remembered {
+					reason = source
+				}
+				add(call, ToolResult{
 					Tool:   call.Tool,
 					OK:     false,
 					Error:  "denied by user",
 					Output: "",
+					Reason: reason,
 				})
 				continue
 			}
 		}
 
-		res := executeOne(ctx, call)
-		results = append(results, res)
+		var envNames []string
+		callCtx := ctx
+		callCtx.envNames = &envNames
+		res := executeOne(callCtx, call)
+		res.Reason = decision.Reason
+		res.Env = envNames
+		add(call, offloadLargeOutput(ctx, res))
 	}
 	return results
 }
 
+// selfAuditedTools 无论是否启用 SQLite 会话存储，每次调用（包括被策略或用户拒绝的）都写入 tool_audits。
+var selfAuditedTools = map[string]bool{
+	"notify.send": true,
+}
+
 func executeOne(ctx ExecContext, call ExecCall) ToolResult {
 	switch call.Tool {
 	case "fs.read", "file_read":
@@ -224,6 +217,108 @@ func executeOne(ctx ExecContext, call ExecCall) ToolResult {
 			return ToolResult{Tool: call.Tool, OK: false, Error: err.Error(), Output: out}
 		}
 		return ToolResult{Tool: call.Tool, OK: true, Output: out}
+	case "artifact.read":
+		out, err := toolArtifactRead(ctx, call.ArgsRaw)
+		if err != nil {
+			return ToolResult{Tool: call.Tool, OK: false, Error: err.Error(), Output: out}
+		}
+		return ToolResult{Tool: call.Tool, OK: true, Output: out}
+	case "data.query":
+		out, err := toolDataQuery(ctx, call.ArgsRaw)
+		if err != nil {
+			return ToolResult{Tool: call.Tool, OK: false, Error: err.Error(), Output: out}
+		}
+		return ToolResult{Tool: call.Tool, OK: true, Output: out}
+	case "code.run":
+		out, err := toolCodeRun(ctx, call.ArgsRaw)
+		if err != nil {
+			return ToolResult{Tool: call.Tool, OK: false, Error: err.Error(), Output: out}
+		}
+		return ToolResult{Tool: call.Tool, OK: true, Output: out}
+	case "shell.session":
+		out, err := toolShellSession(ctx, call.ArgsRaw)
+		if err != nil {
+			return ToolResult{Tool: call.Tool, OK: false, Error: err.Error(), Output: out}
+		}
+		return ToolResult{Tool: call.Tool, OK: true, Output: out}
+	case "job.start":
+		out, err := toolJobStart(ctx, call.ArgsRaw)
+		if err != nil {
+			return ToolResult{Tool: call.Tool, OK: false, Error: err.Error(), Output: out}
+		}
+		return ToolResult{Tool: call.Tool, OK: true, Output: out}
+	case "job.status":
+		out, err := toolJobStatus(ctx, call.ArgsRaw)
+		if err != nil {
+			return ToolResult{Tool: call.Tool, OK: false, Error: err.Error(), Output: out}
+		}
+		return ToolResult{Tool: call.Tool, OK: true, Output: out}
+	case "job.logs":
+		out, err := toolJobLogs(ctx, call.ArgsRaw)
+		if err != nil {
+			return ToolResult{Tool: call.Tool, OK: false, Error: err.Error(), Output: out}
+		}
+		return ToolResult{Tool: call.Tool, OK: true, Output: out}
+	case "job.kill":
+		out, err := toolJobKill(ctx, call.ArgsRaw)
+		if err != nil {
+			return ToolResult{Tool: call.Tool, OK: false, Error: err.Error(), Output: out}
+		}
+		return ToolResult{Tool: call.Tool, OK: true, Output: out}
+	case "schedule.create":
+		out, err := toolScheduleCreate(ctx, call.ArgsRaw)
+		if err != nil {
+			return ToolResult{Tool: call.Tool, OK: false, Error: err.Error(), Output: out}
+		}
+		return ToolResult{Tool: call.Tool, OK: true, Output: out}
+	case "schedule.list":
+		out, err := toolScheduleList(ctx, call.ArgsRaw)
+		if err != nil {
+			return ToolResult{Tool: call.Tool, OK: false, Error: err.Error(), Output: out}
+		}
+		return ToolResult{Tool: call.Tool, OK: true, Output: out}
+	case "schedule.cancel":
+		out, err := toolScheduleCancel(ctx, call.ArgsRaw)
+		if err != nil {
+			return ToolResult{Tool: call.Tool, OK: false, Error: err.Error(), Output: out}
+		}
+		return ToolResult{Tool: call.Tool, OK: true, Output: out}
+	case "git.status":
+		out, err := toolGitStatus(ctx, call.ArgsRaw)
+		if err != nil {
+			return ToolResult{Tool: call.Tool, OK: false, Error: err.Error(), Output: out}
+		}
+		return ToolResult{Tool: call.Tool, OK: true, Output: out}
+	case "git.diff":
+		out, err := toolGitDiff(ctx, call.ArgsRaw)
+		if err != nil {
+			return ToolResult{Tool: call.Tool, OK: false, Error: err.Error(), Output: out}
+		}
+		return ToolResult{Tool: call.Tool, OK: true, Output: out}
+	case "git.log":
+		out, err := toolGitLog(ctx, call.ArgsRaw)
+		if err != nil {
+			return ToolResult{Tool: call.Tool, OK: false, Error: err.Error(), Output: out}
+		}
+		return ToolResult{Tool: call.Tool, OK: true, Output: out}
+	case "git.commit":
+		out, err := toolGitCommit(ctx, call.ArgsRaw)
+		if err != nil {
+			return ToolResult{Tool: call.Tool, OK: false, Error: err.Error(), Output: out}
+		}
+		return ToolResult{Tool: call.Tool, OK: true, Output: out}
+	case "agent.delegate":
+		out, err := toolAgentDelegate(ctx, call.ArgsRaw)
+		if err != nil {
+			return ToolResult{Tool: call.Tool, OK: false, Error: err.Error(), Output: out}
+		}
+		return ToolResult{Tool: call.Tool, OK: true, Output: out}
+	case "notify.send":
+		out, err := toolNotifySend(ctx, call.ArgsRaw)
+		if err != nil {
+			return ToolResult{Tool: call.Tool, OK: false, Error: err.Error(), Output: out}
+		}
+		return ToolResult{Tool: call.Tool, OK: true, Output: out}
 	case "skill.exec":
 		if !ctx.Policy.AllowsTool(call.Tool) {
 			return ToolResult{Tool: call.Tool, OK: false, Error: "disabled by policy"}
@@ -243,22 +338,47 @@ func executeOne(ctx ExecContext, call ExecCall) ToolResult {
 		}
 		return ToolResult{Tool: call.Tool, OK: true, Output: out}
 	default:
+		if strings.HasPrefix(call.Tool, "mcp.") {
+			out, err := toolMCPCall(ctx, call.Tool, call.ArgsRaw)
+			if err != nil {
+				return ToolResult{Tool: call.Tool, OK: false, Error: err.Error(), Output: out}
+			}
+			return ToolResult{Tool: call.Tool, OK: true, Output: out}
+		}
+		if isOpenAPITool(call.Tool) {
+			set, op := lookupOpenAPIOperation(ctx.Workspace, call.Tool)
+			if op == nil {
+				return ToolResult{Tool: call.Tool, OK: false, Error: "unknown tool"}
+			}
+			out, err := toolOpenAPICall(ctx, set, op, call.ArgsRaw)
+			if err != nil {
+				return ToolResult{Tool: call.Tool, OK: false, Error: err.Error(), Output: out}
+			}
+			return ToolResult{Tool: call.Tool, OK: true, Output: out}
+		}
 		return ToolResult{Tool: call.Tool, OK: false, Error: "unknown tool"}
 	}
 }
 
 type fsReadArgs struct {
-	Path string `json:"path"`
+	Path  string          `json:"path"`
+	Pages json.RawMessage `json:"pages"`
+	Sheet json.RawMessage `json:"sheet"`
+	readRange
 }
 
 func toolFSRead(ctx ExecContext, argsRaw string) (string, error) {
 	var path string
+	var docOpt docExtractOptions
+	var rng readRange
 	if strings.HasPrefix(strings.TrimSpace(argsRaw), "{") {
 		var a fsReadArgs
 		if err := json.Unmarshal([]byte(argsRaw), &a); err != nil {
 			return "", fmt.Errorf("invalid JSON args for fs.read: %w", err)
 		}
 		path = a.Path
+		docOpt = docExtractOptions{Pages: rawArgString(a.Pages), Sheet: rawArgString(a.Sheet)}
+		rng = a.readRange
 	} else {
 		path = strings.TrimSpace(argsRaw)
 	}
@@ -272,35 +392,19 @@ func toolFSRead(ctx ExecContext, argsRaw string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-
-	f, err := os.Open(abs)
-	if err != nil {
-		return "", err
-	}
-	defer f.Close()
-
-	const maxBytes = 256 * 1024
-	limited := io.LimitReader(f, maxBytes+1)
-	b, err := io.ReadAll(limited)
-	if err != nil {
-		return "", err
-	}
-	if len(b) > maxBytes {
-		b = b[:maxBytes]
-		return string(b) + "\n\n[TRUNCATED]", nil
-	}
-	return string(b), nil
+	return readTextFile(abs, path, docOpt, rng, `"path":`+jsonQuote(path))
 }
 
 type fsWriteArgs struct {
-	Path    string `json:"path"`
-	Content string `json:"content"`
-	Mode    string `json:"mode"`
+	Path     string `json:"path"`
+	Content  string `json:"content"`
+	Mode     string `json:"mode"`
+	Encoding string `json:"encoding"`
 }
 
 func toolFSWrite(ctx ExecContext, argsRaw string) (string, error) {
 	if !strings.HasPrefix(strings.TrimSpace(argsRaw), "{") {
-		return "", fmt.Errorf("fs.write requires JSON args: {\"path\":\"...\",\"content\":\"...\",\"mode\":\"append|overwrite\"}")
+		return "", fmt.Errorf("fs.write requires JSON args: {\"path\":\"...\",\"content\":\"...\",\"mode\":\"append|overwrite\",\"encoding\":\"utf-8|gbk|keep\"}")
 	}
 	var a fsWriteArgs
 	if err := json.Unmarshal([]byte(argsRaw), &a); err != nil {
@@ -339,22 +443,42 @@ func toolFSWrite(ctx ExecContext, argsRaw string) (string, error) {
 	relPath := normalizeWorkspacePath(a.Path, absWorkspace)
 	abs := filepath.Join(absWorkspace, relPath)
 
+	enc, err := fsWriteEncoding(abs, a.Encoding)
+	if err != nil {
+		return "", err
+	}
+	encNote := ""
+	if enc != charsetUTF8 {
+		encNote = " (encoding " + enc + ")"
+	}
+
 	if err := os.MkdirAll(filepath.Dir(abs), 0o755); err != nil {
 		return "", err
 	}
 	if mode == "overwrite" {
-		if err := os.WriteFile(abs, []byte(a.Content), 0o644); err != nil {
+		data, err := encodeText(a.Content, enc, true)
+		if err != nil {
+			return "", fmt.Errorf("fs.write %w", err)
+		}
+		if err := os.WriteFile(abs, data, 0o644); err != nil {
 			return "", err
 		}
-		return fmt.Sprintf("overwrote %d bytes to %s", len(a.Content), a.Path), nil
+		return fmt.Sprintf("overwrote %d bytes to %s%s", len(data), a.Path, encNote), nil
 	}
 
 	var prefix string
+	empty := true
 	if st, err := os.Stat(abs); err == nil && st.Size() > 0 {
+		empty = false
 		if !strings.HasPrefix(a.Content, "\n") {
 			prefix = "\n"
 		}
 	}
+	// 只有新文件才写 BOM，追加时沿用已有文件的字节序标记。
+	data, err := encodeText(prefix+a.Content, enc, empty)
+	if err != nil {
+		return "", fmt.Errorf("fs.write %w", err)
+	}
 
 	f, err := os.OpenFile(abs, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
 	if err != nil {
@@ -362,15 +486,43 @@ func toolFSWrite(ctx ExecContext, argsRaw string) (string, error) {
 	}
 	defer f.Close()
 
-	n1, err := f.WriteString(prefix)
+	n, err := f.Write(data)
 	if err != nil {
 		return "", err
 	}
-	n2, err := f.WriteString(a.Content)
+	return fmt.Sprintf("appended %d bytes to %s%s", n, a.Path, encNote), nil
+}
+
+// fsWriteEncoding 解析 fs.write 的 encoding 参数；"keep" 表示沿用已有文件检测到的编码（新文件为 UTF-8）。
+func fsWriteEncoding(abs string, requested string) (string, error) {
+	if !strings.EqualFold(strings.TrimSpace(requested), "keep") {
+		return normalizeCharsetName(requested)
+	}
+	f, err := os.Open(abs)
+	if os.IsNotExist(err) {
+		return charsetUTF8, nil
+	}
 	if err != nil {
 		return "", err
 	}
-	return fmt.Sprintf("appended %d bytes to %s", n1+n2, a.Path), nil
+	defer f.Close()
+	const sampleBytes = 256 * 1024
+	b, err := io.ReadAll(io.LimitReader(f, sampleBytes+1))
+	if err != nil {
+		return "", err
+	}
+	if len(b) == 0 {
+		return charsetUTF8, nil
+	}
+	partial := len(b) > sampleBytes
+	if partial {
+		b = b[:sampleBytes]
+	}
+	_, enc := decodeText(b, partial)
+	if enc == "" {
+		return "", fmt.Errorf("fs.write cannot detect the encoding of existing file; pass an explicit encoding")
+	}
+	return enc, nil
 }
 
 func isAllowedWritePath(p string) bool {
@@ -433,8 +585,8 @@ func toolRuntimeExec(ctx ExecContext, argsRaw string) (string, error) {
 	if os.Getenv("NIBOT_ENABLE_EXEC") != "1" && !isSafeRuntimeCommandWhenExecDisabled(a.Command) {
 		return "", fmt.Errorf("runtime.exec disabled (set NIBOT_ENABLE_EXEC=1 to enable)")
 	}
-	if !ctx.Policy.AllowsRuntimeCommand(a.Command) {
-		return "", fmt.Errorf("runtime.exec command denied by policy")
+	if err := ctx.Policy.CheckRuntimeCommand(ctx.Workspace, a.Command); err != nil {
+		return "", fmt.Errorf("runtime.exec command denied by policy: %v", err)
 	}
 	timeout := time.Duration(a.TimeoutSeconds) * time.Second
 	if timeout <= 0 {
@@ -444,28 +596,22 @@ func toolRuntimeExec(ctx ExecContext, argsRaw string) (string, error) {
 		timeout = 10 * time.Minute
 	}
 
-	var argv []string
-	if runtime.GOOS == "windows" {
-		argv = []string{"powershell", "-NoProfile", "-Command", a.Command}
-	} else {
-		argv = []string{"sh", "-lc", a.Command}
-	}
-	argv, err := wrapWithSandbox(argv)
+	cmd, err := sandboxedCommand(ctx, runtimeShellArgv(a.Command), childEnvFor(ctx, "runtime.exec", nil, nil))
 	if err != nil {
 		return "", err
 	}
 	release := acquireExecSlot()
 	defer release()
-	cmd := exec.Command(argv[0], argv[1:]...)
-	cmd.Dir = ctx.Workspace
 
 	maxOut := execMaxOutputBytes()
-	stdout := newCappedBuffer(maxOut)
-	stderr := newCappedBuffer(maxOut)
+	stdout := newSpillBuffer(maxOut)
+	stderr := newSpillBuffer(maxOut)
+	defer stdout.Close()
+	defer stderr.Close()
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
 
-	if err := runWithTimeout(cmd, timeout); err != nil {
+	if err := runWithLimits(cmd, timeout, execRlimitsFromEnv()); err != nil {
 		out := strings.TrimSpace(stdout.String())
 		er := strings.TrimSpace(stderr.String())
 		if er == "" {
@@ -473,10 +619,17 @@ func toolRuntimeExec(ctx ExecContext, argsRaw string) (string, error) {
 		} else {
 			er = er + "\n" + err.Error()
 		}
-		return formatExecOutput(out, er), fmt.Errorf("runtime.exec failed")
+		return appendNote(formatExecOutput(out, er), saveExecOverflow(ctx, stdout, stderr)), fmt.Errorf("runtime.exec failed")
 	}
 
-	return formatExecOutput(strings.TrimSpace(stdout.String()), strings.TrimSpace(stderr.String())), nil
+	return appendNote(formatExecOutput(strings.TrimSpace(stdout.String()), strings.TrimSpace(stderr.String())), saveExecOverflow(ctx, stdout, stderr)), nil
+}
+
+func runtimeShellArgv(command string) []string {
+	if runtime.GOOS == "windows" {
+		return []string{"powershell", "-NoProfile", "-Command", command}
+	}
+	return []string{"sh", "-lc", command}
 }
 
 func formatExecOutput(out string, err string) string {
@@ -493,7 +646,12 @@ func formatExecOutput(out string, err string) string {
 }
 
 func runWithTimeout(cmd *exec.Cmd, timeout time.Duration) error {
-	if err := cmd.Start(); err != nil {
+	return runWithLimits(cmd, timeout, execRlimits{})
+}
+
+// runWithLimits 在独立进程组中运行 cmd；超时后整组 kill，避免 sh 派生的孙进程残留。
+func runWithLimits(cmd *exec.Cmd, timeout time.Duration, limits execRlimits) error {
+	if err := startCommand(cmd, limits); err != nil {
 		return err
 	}
 	done := make(chan error, 1)
@@ -502,7 +660,7 @@ func runWithTimeout(cmd *exec.Cmd, timeout time.Duration) error {
 	case err := <-done:
 		return err
 	case <-time.After(timeout):
-		_ = cmd.Process.Kill()
+		killProcessTree(cmd)
 		<-done
 		return fmt.Errorf("timeout after %s", timeout)
 	}
@@ -1151,23 +1309,32 @@ func toolSkillExec(ctx ExecContext, argsRaw string) (string, error) {
 			argv = append([]string{abs}, a.Args...)
 		}
 	}
-	argv, err = wrapWithSandbox(argv)
+	envDecl, secrets := loadSkillEnvDecl(filepath.Dir(filepath.Dir(abs)))
+	envDecl, badEnv := exactEnvNames(envDecl)
+	secrets, badSecrets := exactEnvNames(secrets)
+	if bad := append(badEnv, badSecrets...); len(bad) > 0 {
+		log.Printf("skill.exec %s: manifest env / secrets must be exact variable names, ignoring %s", a.Skill, strings.Join(bad, ","))
+	}
+	secrets, refused := grantedSkillSecrets(ctx.Policy, a.Skill, secrets)
+	if len(refused) > 0 {
+		log.Printf("skill.exec %s: secrets %s are not granted by skill_secrets in policy.toml", a.Skill, strings.Join(refused, ","))
+	}
+	cmd, err := sandboxedCommand(ctx, argv, childEnvFor(ctx, "skill.exec", envDecl, secrets))
 	if err != nil {
 		return "", err
 	}
 	release := acquireExecSlot()
 	defer release()
-	cmd := exec.Command(argv[0], argv[1:]...)
-
-	cmd.Dir = ctx.Workspace
 
 	maxOut := execMaxOutputBytes()
-	stdout := newCappedBuffer(maxOut)
-	stderr := newCappedBuffer(maxOut)
+	stdout := newSpillBuffer(maxOut)
+	stderr := newSpillBuffer(maxOut)
+	defer stdout.Close()
+	defer stderr.Close()
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
 
-	if err := runWithTimeout(cmd, timeout); err != nil {
+	if err := runWithLimits(cmd, timeout, execRlimitsFromEnv()); err != nil {
 		out := strings.TrimSpace(stdout.String())
 		er := strings.TrimSpace(stderr.String())
 		if er == "" {
@@ -1175,10 +1342,10 @@ func toolSkillExec(ctx ExecContext, argsRaw string) (string, error) {
 		} else {
 			er = er + "\n" + err.Error()
 		}
-		return formatExecOutput(out, er), fmt.Errorf("skill.exec failed: %w", err)
+		return appendNote(formatExecOutput(out, er), saveExecOverflow(ctx, stdout, stderr)), fmt.Errorf("skill.exec failed: %w", err)
 	}
 
-	return formatExecOutput(strings.TrimSpace(stdout.String()), strings.TrimSpace(stderr.String())), nil
+	return appendNote(formatExecOutput(strings.TrimSpace(stdout.String()), strings.TrimSpace(stderr.String())), saveExecOverflow(ctx, stdout, stderr)), nil
 }
 
 func resolveSkillScriptPath(workspace, skill, script string) (string, error) {
@@ -29,6 +29,13 @@ class ChatApp {
         this.tabContents = document.querySelectorAll('.tab-content');
         this.configForm = document.getElementById('configForm');
         this.skillsList = document.getElementById('skillsList');
+
+        // Approval elements
+        this.approvalModal = document.getElementById('approvalModal');
+        this.approvalQueue = [];
+        this.currentApproval = null;
+        this.seenApprovals = new Set();
+        this.approvalTimer = null;
     }
 
     bindEvents() {
@@ -103,6 +110,12 @@ class ChatApp {
                 this.saveSettings();
             });
         }
+
+        if (this.approvalModal) {
+            this.approvalModal.querySelectorAll('[data-decision]').forEach(btn => {
+                btn.addEventListener('click', () => this.answerApproval(btn.getAttribute('data-decision')));
+            });
+        }
     }
 
     generateSessionId() {
@@ -178,6 +191,7 @@ class ChatApp {
         
         // 显示正在输入指示器
         this.showTypingIndicator();
+        this.startApprovalPolling();
 
         try {
             if (this.isConnected && this.socket) {
@@ -210,11 +224,25 @@ class ChatApp {
             console.error('Failed to send message:', error);
             this.addMessage('error', '发送消息失败，请检查网络连接');
             this.hideTypingIndicator();
+            this.stopApprovalPolling();
         }
     }
 
     handleMessage(data) {
+        if (data.type === 'approval') {
+            this.queueApproval(data.approval);
+            return;
+        }
+        // 定时任务的结果随时可能到达，不影响正在进行的对话
+        if (data.type === 'schedule') {
+            this.addMessage('assistant', data.content);
+            return;
+        }
         this.hideTypingIndicator();
+        this.stopApprovalPolling();
+        // 回复已到达：剩下的审批已超时或不再需要
+        this.approvalQueue = [];
+        this.showNextApproval();
         
         if (data.type === 'assistant') {
             this.addMessage('assistant', data.content);
@@ -258,6 +286,7 @@ class ChatApp {
             .replace(/\`([^`]+)\`/g, '<code>$1</code>')
             .replace(/\*\*(.+?)\*\*/g, '<strong>$1</strong>')
             .replace(/\*(.+?)\*/g, '<em>$1</em>')
+            .replace(/(^|[\s(>])artifacts\/([A-Za-z0-9_-]+)\/([^\s<)"']+)/g, '$1<a href="/api/artifacts/$2/$3" download>artifacts/$2/$3</a>')
             .replace(/\n/g, '<br>');
     }
 
@@ -271,6 +300,80 @@ class ChatApp {
         });
     }
 
+    // 等待回复期间轮询待审批的调用（HTTP 模式收不到推送；WebSocket 模式下作为兜底）
+    startApprovalPolling() {
+        this.stopApprovalPolling();
+        this.approvalTimer = setInterval(() => this.pollApprovals(), 2000);
+    }
+
+    stopApprovalPolling() {
+        if (this.approvalTimer) {
+            clearInterval(this.approvalTimer);
+            this.approvalTimer = null;
+        }
+    }
+
+    async pollApprovals() {
+        try {
+            // 审批只认服务端签发的 Cookie，不需要传 session_id
+            const response = await fetch('/api/approvals');
+            if (response.ok) {
+                const pending = await response.json();
+                pending.forEach(req => this.queueApproval(req));
+            }
+        } catch (error) {
+            console.error('Failed to poll approvals:', error);
+        }
+    }
+
+    queueApproval(req) {
+        if (!req || this.seenApprovals.has(req.id)) return;
+        this.seenApprovals.add(req.id);
+        this.approvalQueue.push(req);
+        if (!this.currentApproval) {
+            this.showNextApproval();
+        }
+    }
+
+    showNextApproval() {
+        this.currentApproval = this.approvalQueue.shift() || null;
+        if (!this.currentApproval) {
+            this.approvalModal.classList.remove('show');
+            return;
+        }
+        const req = this.currentApproval;
+        document.getElementById('approvalTool').textContent = req.tool;
+        document.getElementById('approvalArgs').textContent = req.args || '';
+        const expires = new Date(req.expires).toLocaleTimeString();
+        document.getElementById('approvalExpires').textContent = this.currentLanguage === 'zh'
+            ? `${expires} 前未确认将自动拒绝`
+            : `Denied automatically if not answered by ${expires}`;
+        this.approvalModal.classList.add('show');
+    }
+
+    async answerApproval(decision) {
+        const req = this.currentApproval;
+        if (!req) return;
+        try {
+            const response = await fetch('/api/approvals', {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json',
+                },
+                body: JSON.stringify({
+                    id: req.id,
+                    decision: decision
+                })
+            });
+            if (!response.ok) {
+                this.addMessage('error', await response.text());
+            }
+        } catch (error) {
+            console.error('Failed to answer approval:', error);
+        }
+        this.showNextApproval();
+    }
+
     showTypingIndicator() {
         const indicator = document.createElement('div');
         indicator.className = 'message assistant';